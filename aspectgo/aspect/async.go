@@ -0,0 +1,127 @@
+package aspect
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// AsyncAspect is optionally implemented by an aspect for handling the advised calls
+// asynchronously, e.g. for writing traces without slowing down the calls.
+//
+// The woven code does not call Advice of an AsyncAspect.
+// Instead, it calls the function, and enqueues an Event into the ring buffer
+// of the dispatcher of the aspect. The events are handled by HandleEvent
+// on a background goroutine, in the order of the enqueueing.
+//
+// An AsyncAspect is instantiated only once, regardless of its Instantiation.
+type AsyncAspect interface {
+	Aspect
+
+	// AsyncOptions returns the options for the dispatcher.
+	// AsyncOptions is executed on runtime, once for the aspect.
+	AsyncOptions() AsyncOptions
+
+	// HandleEvent handles the event on the background goroutine.
+	// HandleEvent should not block the goroutine for long,
+	// as the dispatcher cannot handle the other events meanwhile.
+	HandleEvent(Event)
+}
+
+// Event is the record of an advised call.
+type Event struct {
+	// ID is the sequence number of the event, which is unique in the program.
+	ID uint64
+
+	// JoinPoint is the joinpoint.
+	JoinPoint *JoinPoint
+
+	// Args is the snapshot of the args, taken before the call.
+	// The slice is copied, but the values are not.
+	Args []interface{}
+
+	// Results is the snapshot of the results.
+	// It is nil if the call panicked.
+	Results []interface{}
+
+	// Begin is the time when the call began.
+	Begin time.Time
+
+	// Duration is the duration of the call.
+	Duration time.Duration
+
+	// Panicked is true if the call panicked.
+	// The panic is propagated to the caller as usual.
+	Panicked bool
+
+	// Dropped is the number of the events dropped after the previous event was handled.
+	Dropped uint64
+}
+
+// DropPolicy is the type for what the dispatcher does when its buffer is full.
+type DropPolicy int
+
+const (
+	// DropNewest drops the event being enqueued.
+	// This is the default policy.
+	DropNewest DropPolicy = iota
+
+	// DropOldest drops the oldest event in the buffer to make room.
+	DropOldest
+
+	// Block blocks the caller until the buffer has room.
+	// HandleEvent should not call the functions advised by the same aspect
+	// with Block, as the dispatcher waits for itself when the buffer is full.
+	Block
+)
+
+func (p DropPolicy) String() string {
+	switch p {
+	case DropNewest:
+		return "dropnewest"
+	case DropOldest:
+		return "dropoldest"
+	case Block:
+		return "block"
+	}
+	return fmt.Sprintf("DropPolicy(%d)", int(p))
+}
+
+// DefaultBufferSize is the default AsyncOptions.BufferSize.
+const DefaultBufferSize = 1024
+
+// AsyncOptions is the type for the options of the dispatcher of an AsyncAspect.
+type AsyncOptions struct {
+	// BufferSize is the number of the events that the buffer can hold.
+	// It is rounded up to a power of two. If zero, DefaultBufferSize is used.
+	BufferSize int
+
+	// Drop is the policy for the events enqueued when the buffer is full.
+	Drop DropPolicy
+}
+
+var asyncFlushers struct {
+	sync.Mutex
+	fs []func()
+}
+
+// FlushAsync blocks until the events enqueued so far are handled by all the AsyncAspects.
+// As the events are handled in the background, a program should call FlushAsync
+// before exiting if its last events matter.
+// FlushAsync returns immediately if no AsyncAspect is woven.
+func FlushAsync() {
+	asyncFlushers.Lock()
+	fs := asyncFlushers.fs
+	asyncFlushers.Unlock()
+	for _, f := range fs {
+		f()
+	}
+}
+
+// OnFlushAsync should NOT be called manually.
+// It registers f to be called by FlushAsync.
+func OnFlushAsync(f func()) {
+	asyncFlushers.Lock()
+	defer asyncFlushers.Unlock()
+	asyncFlushers.fs = append(asyncFlushers.fs, f)
+}
@@ -0,0 +1,80 @@
+package aspect
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// TypeError is the error for the args passed to Context.Call, or the results
+// returned from Aspect.Advice, that do not match the joinpoint.
+type TypeError struct {
+	// JoinPoint is the joinpoint.
+	JoinPoint *JoinPoint
+
+	// Results is true if the error is in the results.
+	Results bool
+
+	// Index is the index of the arg (or the result) of the wrong type.
+	// Index is -1 if the number of the args (or the results) is wrong.
+	Index int
+
+	// Expected and Actual are the expected and the actual types,
+	// or the expected and the actual numbers if Index is -1.
+	Expected, Actual string
+}
+
+func (e *TypeError) Error() string {
+	what := "arg"
+	if e.Results {
+		what = "result"
+	}
+	if e.Index < 0 {
+		return fmt.Sprintf("%s: wrong number of %ss: expected %s, got %s",
+			e.JoinPoint, what, e.Expected, e.Actual)
+	}
+	return fmt.Sprintf("%s: wrong type of %s #%d: expected %s, got %s",
+		e.JoinPoint, what, e.Index, e.Expected, e.Actual)
+}
+
+// CheckArgs returns *TypeError if args do not match ParamTypes.
+// A nil arg is accepted as the zero value of any type.
+func (jp *JoinPoint) CheckArgs(args []interface{}) error {
+	return jp.check(args, jp.ParamTypes, false)
+}
+
+// CheckResults returns *TypeError if results do not match ResultTypes.
+// A nil result is accepted as the zero value of any type.
+func (jp *JoinPoint) CheckResults(results []interface{}) error {
+	return jp.check(results, jp.ResultTypes, true)
+}
+
+func (jp *JoinPoint) check(values []interface{}, types []reflect.Type, results bool) error {
+	if types == nil {
+		return nil
+	}
+	if len(values) != len(types) {
+		return &TypeError{
+			JoinPoint: jp,
+			Results:   results,
+			Index:     -1,
+			Expected:  fmt.Sprintf("%d", len(types)),
+			Actual:    fmt.Sprintf("%d", len(values)),
+		}
+	}
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+		vt := reflect.TypeOf(v)
+		if t := types[i]; vt != t && (t.Kind() != reflect.Interface || !vt.Implements(t)) {
+			return &TypeError{
+				JoinPoint: jp,
+				Results:   results,
+				Index:     i,
+				Expected:  t.String(),
+				Actual:    vt.String(),
+			}
+		}
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package aspect
 
 import (
 	"fmt"
+	"reflect"
 )
 
 // Context is the type for joinpoint context definition.
@@ -15,16 +16,229 @@ type Context interface {
 	// User must be careful about the length and the type of
 	// the []interface{} slices.
 	// The slices can be empty []interface{}{}, but cannot be nil.
+	// Call panics with *TypeError if the args do not match JoinPoint().ParamTypes.
 	Call([]interface{}) []interface{}
 
 	// Receiver returns the receiver for methods.
 	// For non-method function, it just returns nil.
 	Receiver() interface{}
+
+	// JoinPoint returns the static information about the joinpoint.
+	JoinPoint() *JoinPoint
+
+	// ParamNames returns JoinPoint().ParamNames.
+	// e.g. []string{"name", "flag", "perm"} for os.OpenFile.
+	ParamNames() []string
+
+	// ParamTypes returns JoinPoint().ParamTypes.
+	ParamTypes() []reflect.Type
+
+	// ResultNames returns JoinPoint().ResultNames.
+	ResultNames() []string
+
+	// ResultTypes returns JoinPoint().ResultTypes.
+	ResultTypes() []reflect.Type
+}
+
+// CheckedContext is implemented by all the contexts.
+// A checked advice uses CallChecked instead of Call, and checks its results with
+// JoinPoint().CheckResults, so that it can handle *TypeError by itself.
+type CheckedContext interface {
+	Context
+
+	// CallChecked is like Call, but returns *TypeError instead of panicking
+	// if the args do not match JoinPoint().ParamTypes.
+	CallChecked([]interface{}) ([]interface{}, error)
+}
+
+// FieldContext is the type for "get" and "set" joinpoint context definition.
+// For "get" joinpoints, Args() returns []interface{}{}, and
+// Call() reads the field and returns []interface{}{value}.
+// For "set" joinpoints, Args() returns []interface{}{newValue}, and
+// Call() writes args[0] to the field and returns []interface{}{}.
+// Receiver() returns the structure (or the pointer to it).
+type FieldContext interface {
+	Context
+
+	// OldValue returns the value of the field before the access.
+	OldValue() interface{}
+
+	// NewValue returns the value to be written for "set" joinpoints.
+	// For "get" joinpoints, it returns the same value as OldValue().
+	NewValue() interface{}
+}
+
+// HandlerContext is the type for "handler" joinpoint context definition.
+// The advice of a "handler" aspect is executed only when the function
+// returns a non-nil error as the last result, or panics.
+//
+// The function has been already called when the advice is executed.
+// Call() returns the results of the function (or re-panics),
+// without calling the function again.
+type HandlerContext interface {
+	Context
+
+	// Err returns the non-nil error returned by the function.
+	// If the function panicked, Err returns nil.
+	Err() error
+
+	// Panicked returns true if the function panicked.
+	Panicked() bool
+
+	// Recovered returns the value recovered from the panic.
+	Recovered() interface{}
+
+	// Results returns the results of the function.
+	// If the function panicked, Results returns nil.
+	Results() []interface{}
+
+	// Return returns the results of the function, with the error replaced with err.
+	// If the function panicked, the panic is recovered, and the other results are zero values.
+	// If the function does not return an error, err is ignored.
+	//
+	// Unless the advice returns the value of Return, the panic is propagated.
+	Return(err error) []interface{}
+}
+
+// JoinPointKind is the type for the kind of joinpoints.
+type JoinPointKind string
+
+const (
+	// KindCall is the kind for function and method calls.
+	KindCall JoinPointKind = "call"
+
+	// KindGet is the kind for field reads.
+	KindGet JoinPointKind = "get"
+
+	// KindSet is the kind for field writes.
+	KindSet JoinPointKind = "set"
+
+	// KindGo is the kind for `go` statements.
+	// Args() returns []interface{}{fn}, where fn is the func() that runs
+	// the new goroutine, and Call() starts the goroutine for args[0].
+	KindGo JoinPointKind = "go"
+
+	// KindSend is the kind for channel sends.
+	// Args() returns []interface{}{value}, and Receiver() returns the channel.
+	KindSend JoinPointKind = "send"
+
+	// KindRecv is the kind for channel receives.
+	// Receiver() returns the channel, and Call() returns []interface{}{value}
+	// (or []interface{}{value, ok} for `v, ok := <-ch`).
+	KindRecv JoinPointKind = "recv"
+
+	// KindSelect is the kind for `select` statements.
+	// Call() returns []interface{}{chosen, recv, recvOK}, as in reflect.Select().
+	// The sends and receives in the select cases are not "send" and "recv" joinpoints.
+	KindSelect JoinPointKind = "select"
+
+	// KindClose is the kind for close(ch).
+	// Receiver() returns the channel.
+	KindClose JoinPointKind = "close"
+
+	// KindHandler is the kind for function and method calls that
+	// return a non-nil error or panic.
+	KindHandler JoinPointKind = "handler"
+)
+
+// JoinPoint is the type for the static information about a joinpoint.
+// JoinPoint should NOT be modified.
+type JoinPoint struct {
+	// Kind is the kind of the joinpoint.
+	Kind JoinPointKind
+
+	// Name is the full name of the function,
+	// e.g. "net/http.Get", "(*net/http.Client).Get".
+	// For "get" and "set" joinpoints, Name is the full name of the field,
+	// e.g. "net/http.Request.Method".
+	// For "go", "send", "recv", "select" and "close" joinpoints,
+	// Name is the full name of the enclosing function, e.g. "main.main".
+	Name string
+
+	// ShortName is Name qualified by the package name instead of the import path,
+	// e.g. "http.Get", "(*http.Client).Get", "http.Request.Method".
+	// Unlike Pos, ShortName is stable across the edits of the source, so it can be
+	// used as the name of a span (or a metric) for the joinpoint.
+	ShortName string
+
+	// Pos is the position of the call site (or the field access, or the statement),
+	// e.g. "example.com/foo/main.go:42:2".
+	// The file name is relative to $GOPATH/src.
+	Pos string
+
+	// Caller is the full name of the function that contains the joinpoint,
+	// e.g. "main.main", or "main.init" for the initializers of the package-level variables.
+	// For "go", "send", "recv", "select" and "close" joinpoints, Caller is the same as Name.
+	// Caller and Name are the edge of the call graph for "call" and "handler" joinpoints.
+	Caller string
+
+	// NumResults is the number of the results of the function.
+	NumResults int
+
+	// ErrorResult is true if the last result of the function is an error.
+	ErrorResult bool
+
+	// Annotations are the directive comments on the declaration of the function,
+	// e.g. `//aspect:retry max=3`.
+	Annotations []*Annotation
+
+	// ParamNames are the names of the parameters of the function.
+	// The name of an unnamed (or blank) parameter is empty.
+	// For "set" joinpoints, ParamNames is the name of the field.
+	// For "go" and "send" joinpoints, ParamNames is "fn" and "value" respectively,
+	// and it is empty for "recv", "select" and "close" joinpoints.
+	ParamNames []string
+
+	// ResultNames are the names of the results of the function.
+	// The name of an unnamed (or blank) result is empty.
+	// For "get" joinpoints, ResultNames is the name of the field.
+	// For "recv" joinpoints, ResultNames is "value" (and "ok" for `v, ok := <-ch`),
+	// and for "select" joinpoints, "chosen", "recv" and "recvOK".
+	// It is empty for "go", "send" and "close" joinpoints.
+	ResultNames []string
+
+	// ParamTypes are the types of the args passed to Context.Call.
+	// For a variadic function, the last one is the slice type.
+	// For "go" joinpoints, ParamTypes is func(), and for "send" joinpoints,
+	// the element type of the channel.
+	ParamTypes []reflect.Type
+
+	// ResultTypes are the types of the results returned from Aspect.Advice.
+	// For "recv" joinpoints, ResultTypes is the element type of the channel
+	// (and bool for `v, ok := <-ch`), and for "select" joinpoints, int, interface{} and bool.
+	ResultTypes []reflect.Type
+}
+
+// Annotation is the type for a directive comment on a function declaration.
+//
+// The syntax is `//aspect:name key=value ...`, with no space after `//`.
+// A parameter without `=` has the empty value.
+type Annotation struct {
+	// Name is the name of the directive, e.g. "retry".
+	Name string
+
+	// Params are the parameters of the directive, e.g. {"max": "3"}.
+	Params map[string]string
+}
+
+// Annotation returns the annotation named name, or nil.
+func (jp *JoinPoint) Annotation(name string) *Annotation {
+	for _, a := range jp.Annotations {
+		if a.Name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+func (jp *JoinPoint) String() string {
+	return fmt.Sprintf("%s (%s)", jp.Name, jp.Pos)
 }
 
 // Pointcut is the type for pointcut definition.
 // User should NOT be aware of the internal representation. (string)
-// Currently, only "call" pointcut is supported.
+// Currently, "call", "handler", "get", "set", "go", "send", "recv", "select" and "close" pointcuts are supported.
+// "call" and "handler" pointcuts can be combined with "cflow" and "cflowbelow" pointcuts.
 // TODO: support "execution" pointcut.
 type Pointcut string
 
@@ -38,6 +252,88 @@ func NewCallPointcutFromRegexp(s string) Pointcut {
 	return Pointcut(s)
 }
 
+// NewAnnotationPointcutFromRegexp creates a "call" pointcut for the functions
+// annotated with a directive comment, e.g. `//aspect:trace`.
+// s needs to be a regexp for the whole name of the directive, e.g. "trace".
+func NewAnnotationPointcutFromRegexp(s string) Pointcut {
+	return Pointcut("annotation(" + s + ")")
+}
+
+// NewGetPointcutFromRegexp creates a "get" pointcut from s.
+// s needs to be a regexp for the full name of the field,
+// e.g. "example\\.com/foo\\.S\\.X".
+func NewGetPointcutFromRegexp(s string) Pointcut {
+	return Pointcut("get(" + s + ")")
+}
+
+// NewSetPointcutFromRegexp creates a "set" pointcut from s.
+// s needs to be a regexp for the full name of the field.
+// Compound assignments (e.g. `s.X += 1`) and increments are also "set" joinpoints.
+func NewSetPointcutFromRegexp(s string) Pointcut {
+	return Pointcut("set(" + s + ")")
+}
+
+// NewGoPointcutFromRegexp creates a "go" pointcut from s.
+// s needs to be a regexp for the full name of the function that contains the `go` statement.
+func NewGoPointcutFromRegexp(s string) Pointcut {
+	return Pointcut("go(" + s + ")")
+}
+
+// NewSendPointcutFromRegexp creates a "send" pointcut from s.
+// s needs to be a regexp for the full name of the function that contains the send statement.
+func NewSendPointcutFromRegexp(s string) Pointcut {
+	return Pointcut("send(" + s + ")")
+}
+
+// NewRecvPointcutFromRegexp creates a "recv" pointcut from s.
+// s needs to be a regexp for the full name of the function that contains the receive expression.
+func NewRecvPointcutFromRegexp(s string) Pointcut {
+	return Pointcut("recv(" + s + ")")
+}
+
+// NewSelectPointcutFromRegexp creates a "select" pointcut from s.
+// s needs to be a regexp for the full name of the function that contains the `select` statement.
+func NewSelectPointcutFromRegexp(s string) Pointcut {
+	return Pointcut("select(" + s + ")")
+}
+
+// NewClosePointcutFromRegexp creates a "close" pointcut from s.
+// s needs to be a regexp for the full name of the function that calls close(ch).
+func NewClosePointcutFromRegexp(s string) Pointcut {
+	return Pointcut("close(" + s + ")")
+}
+
+// NewHandlerPointcutFromRegexp creates a "handler" pointcut from s.
+// s needs to be a regexp for function/method name, as in "call" pointcuts.
+// The advice receives HandlerContext.
+func NewHandlerPointcutFromRegexp(s string) Pointcut {
+	return Pointcut("handler(" + s + ")")
+}
+
+// NewCflowPointcut creates a "cflow" pointcut for the joinpoints in the
+// control flow of pc, i.e. while a joinpoint of pc is being executed on the
+// same goroutine. The joinpoints of pc itself are included.
+// pc needs to be a "call" pointcut.
+//
+// A "cflow" pointcut needs to be combined with a "call" or "handler" pointcut
+// with And, e.g. `sqlQuery.And(NewCflowPointcut(httpHandler))`.
+func NewCflowPointcut(pc Pointcut) Pointcut {
+	return Pointcut("cflow(" + pc + ")")
+}
+
+// NewCflowBelowPointcut is like NewCflowPointcut,
+// but the joinpoints of pc itself are excluded.
+func NewCflowBelowPointcut(pc Pointcut) Pointcut {
+	return Pointcut("cflowbelow(" + pc + ")")
+}
+
+// And creates a pointcut that matches the joinpoints matched by
+// both pc and other.
+// Currently, other needs to be a "cflow" or "cflowbelow" pointcut.
+func (pc Pointcut) And(other Pointcut) Pointcut {
+	return pc + " && " + other
+}
+
 // NewExecPointcutFromRegexp creates a "execution" pointcut from s.
 // s needs to be a regexp for function/method name.
 func NewExecPointcutFromRegexp(s string) Pointcut {
@@ -55,5 +351,80 @@ type Aspect interface {
 	// User must be careful about the length and the type of
 	// the []interface{} slice.
 	// The slice can be empty []interface{}{}, but cannot be nil.
+	// The woven code panics with *TypeError if the results do not match
+	// JoinPoint().ResultTypes.
 	Advice(Context) []interface{}
 }
+
+// Introduction is the interface for inter-type declarations.
+// An introduction introduces its exported fields and methods
+// (except Targets) to the target types in the woven packages.
+//
+// The fields are introduced as the promoted fields of an embedded struct.
+// So they can be accessed as `t.X`, but cannot be used as the keys of composite literals.
+//
+// Each method needs to take the target as the first parameter of type interface{}.
+// e.g. `func (i *I) String(target interface{}) string` is introduced as
+// `func (t T) String() string`, which calls the method with the copy of the value.
+// A new introduction instance is created for each call.
+type Introduction interface {
+	// Targets returns the pattern for the target types.
+	// Targets is executed on compilation-time.
+	Targets() TypePattern
+}
+
+// TypePattern is the type for the target types of an introduction.
+// User should NOT be aware of the internal representation. (string)
+type TypePattern string
+
+// NewTypePatternFromRegexp creates a TypePattern from s.
+// s needs to be a regexp for the full name of package-level named types,
+// e.g. "example\\.com/foo\\.(S|T)".
+func NewTypePatternFromRegexp(s string) TypePattern {
+	return TypePattern(s)
+}
+
+// Instantiation is the type for aspect instantiation models.
+type Instantiation int
+
+const (
+	// PerCall instantiates the aspect for every advised call.
+	// This is the default model.
+	PerCall Instantiation = iota
+
+	// PerJoinPoint instantiates the aspect once for each joinpoint.
+	// i.e., the instance is shared among the calls from the same call site.
+	PerJoinPoint
+
+	// Singleton instantiates the aspect once for the whole program.
+	Singleton
+)
+
+func (i Instantiation) String() string {
+	switch i {
+	case PerCall:
+		return "percall"
+	case PerJoinPoint:
+		return "perjoinpoint"
+	case Singleton:
+		return "singleton"
+	}
+	return fmt.Sprintf("Instantiation(%d)", int(i))
+}
+
+// Instantiator is optionally implemented by an aspect
+// for declaring its instantiation model.
+// If an aspect does not implement Instantiator, PerCall is used.
+type Instantiator interface {
+	// Instantiation returns the instantiation model for the aspect.
+	// Instantiation is executed on compilation-time.
+	Instantiation() Instantiation
+}
+
+// Initializer is optionally implemented by an aspect
+// for initializing its state.
+type Initializer interface {
+	// Init is executed on runtime, once for each instance,
+	// before the first Advice of the instance.
+	Init()
+}
@@ -0,0 +1,278 @@
+// Package mock provides mock/stub injection for tests.
+//
+// A test registers replacement behaviour for a joinpoint:
+//
+//	mock.On("net/http.Get", "http://example.com").Return(resp, nil)
+//	defer mock.Reset()
+//	... // code that calls http.Get
+//	mock.AssertExpectations(t)
+//
+// The calls are routed to the stubs by Aspect, which needs to be woven to
+// the call sites of the functions to be mocked:
+//
+//	type MockAspect struct {
+//		mock.Aspect
+//	}
+//
+//	func (a *MockAspect) Pointcut() asp.Pointcut {
+//		return asp.NewCallPointcutFromRegexp(regexp.QuoteMeta("net/http.Get"))
+//	}
+//
+// Calls that match no stub are passed to the original function.
+package mock
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"golang.org/x/exp/aspectgo/aspect"
+)
+
+// Matcher matches an argument.
+type Matcher interface {
+	Match(arg interface{}) bool
+	String() string
+}
+
+type anything struct{}
+
+func (anything) Match(interface{}) bool { return true }
+func (anything) String() string         { return "mock.Anything" }
+
+// Anything matches any argument.
+var Anything Matcher = anything{}
+
+type matchedBy struct {
+	fn func(interface{}) bool
+}
+
+func (m matchedBy) Match(arg interface{}) bool { return m.fn(arg) }
+func (m matchedBy) String() string             { return "mock.MatchedBy(...)" }
+
+// MatchedBy returns a Matcher that matches an argument if fn returns true.
+func MatchedBy(fn func(arg interface{}) bool) Matcher {
+	return matchedBy{fn: fn}
+}
+
+type anythingOfType string
+
+func (m anythingOfType) Match(arg interface{}) bool { return fmt.Sprintf("%T", arg) == string(m) }
+func (m anythingOfType) String() string             { return fmt.Sprintf("mock.AnythingOfType(%q)", string(m)) }
+
+// AnythingOfType returns a Matcher that matches an argument of the type
+// (formatted with "%T"), e.g. "string", "*http.Request".
+func AnythingOfType(typ string) Matcher {
+	return anythingOfType(typ)
+}
+
+// Call is an expected call.
+type Call struct {
+	name     string
+	args     []interface{}
+	anyArgs  bool
+	results  []interface{}
+	run      func(args []interface{}) []interface{}
+	times    int
+	calls    int
+	callThru bool
+}
+
+// Return sets the results of the call.
+// nil is converted to the zero value of the result type.
+// Advice panics if the results do not match the results of the function.
+func (c *Call) Return(results ...interface{}) *Call {
+	mu.Lock()
+	defer mu.Unlock()
+	c.results = results
+	return c
+}
+
+// Run sets the function that computes the results from the arguments.
+// Advice panics if the results do not match the results of the function.
+func (c *Call) Run(fn func(args []interface{}) []interface{}) *Call {
+	mu.Lock()
+	defer mu.Unlock()
+	c.run = fn
+	return c
+}
+
+// CallThrough passes the call to the original function,
+// so that only the number of calls is checked.
+func (c *Call) CallThrough() *Call {
+	mu.Lock()
+	defer mu.Unlock()
+	c.callThru = true
+	return c
+}
+
+// Times sets the expected number of the calls.
+// The stub is not used after it is called n times.
+func (c *Call) Times(n int) *Call {
+	mu.Lock()
+	defer mu.Unlock()
+	c.times = n
+	return c
+}
+
+// Once is the same as Times(1).
+func (c *Call) Once() *Call {
+	return c.Times(1)
+}
+
+// Calls returns the number of the calls.
+func (c *Call) Calls() int {
+	mu.Lock()
+	defer mu.Unlock()
+	return c.calls
+}
+
+func (c *Call) String() string {
+	if c.anyArgs {
+		return fmt.Sprintf("%s(...)", c.name)
+	}
+	return fmt.Sprintf("%s%v", c.name, c.args)
+}
+
+func (c *Call) matches(jp *aspect.JoinPoint, args []interface{}) bool {
+	if c.name != jp.Name {
+		return false
+	}
+	if c.times > 0 && c.calls >= c.times {
+		return false
+	}
+	if c.anyArgs {
+		return true
+	}
+	if len(c.args) != len(args) {
+		return false
+	}
+	for i, expected := range c.args {
+		if m, ok := expected.(Matcher); ok {
+			if !m.Match(args[i]) {
+				return false
+			}
+		} else if !reflect.DeepEqual(expected, args[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	mu       sync.Mutex
+	expected []*Call
+)
+
+// On registers an expected call to the function named name
+// (aspect.JoinPoint.Name, e.g. "net/http.Get", "(*net/http.Client).Get").
+// args are the expected arguments, which can be Matchers.
+// If args is omitted, any arguments are accepted.
+// Variadic arguments are passed as a slice.
+func On(name string, args ...interface{}) *Call {
+	mu.Lock()
+	defer mu.Unlock()
+	c := &Call{
+		name:    name,
+		args:    args,
+		anyArgs: len(args) == 0,
+	}
+	expected = append(expected, c)
+	return c
+}
+
+// Reset removes all the expected calls.
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	expected = nil
+}
+
+// TB is the subset of testing.TB.
+type TB interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// AssertExpectations asserts that all the expected calls were called,
+// the expected number of times if specified by Times.
+func AssertExpectations(t TB) bool {
+	t.Helper()
+	mu.Lock()
+	defer mu.Unlock()
+	ok := true
+	for _, c := range expected {
+		switch {
+		case c.times > 0 && c.calls != c.times:
+			t.Errorf("mock: %s: expected %d calls, got %d", c, c.times, c.calls)
+			ok = false
+		case c.calls == 0:
+			t.Errorf("mock: %s: not called", c)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// AssertCalled asserts that the function named name was called n times
+// in total.
+func AssertCalled(t TB, name string, n int) bool {
+	t.Helper()
+	mu.Lock()
+	defer mu.Unlock()
+	calls := 0
+	for _, c := range expected {
+		if c.name == name {
+			calls += c.calls
+		}
+	}
+	if calls != n {
+		t.Errorf("mock: %s: expected %d calls, got %d", name, n, calls)
+		return false
+	}
+	return true
+}
+
+// lookup returns the matching call, and increments its counter.
+func lookup(jp *aspect.JoinPoint, args []interface{}) *Call {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, c := range expected {
+		if c.matches(jp, args) {
+			c.calls++
+			return c
+		}
+	}
+	return nil
+}
+
+// Aspect is an aspect that routes the calls to the stubs.
+type Aspect struct {
+}
+
+// Advice calls the stub, if any. Otherwise it calls the original function.
+func (a *Aspect) Advice(ctx aspect.Context) []interface{} {
+	jp, args := ctx.JoinPoint(), ctx.Args()
+	c := lookup(jp, args)
+	if c == nil || c.callThru {
+		return ctx.Call(args)
+	}
+	var res []interface{}
+	if c.run != nil {
+		res = c.run(args)
+	} else {
+		res = append([]interface{}(nil), c.results...)
+	}
+	checkResults(c, jp, res)
+	return res
+}
+
+// checkResults panics if res, the results of c, do not match jp.
+func checkResults(c *Call, jp *aspect.JoinPoint, res []interface{}) {
+	if len(res) != jp.NumResults {
+		panic(fmt.Sprintf("mock: %s: expected %d results, got %d", c, jp.NumResults, len(res)))
+	}
+	if err := jp.CheckResults(res); err != nil {
+		panic(fmt.Sprintf("mock: %s: %v", c, err))
+	}
+}
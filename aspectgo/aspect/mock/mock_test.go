@@ -0,0 +1,120 @@
+package mock
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/exp/aspectgo/aspect"
+	"golang.org/x/exp/aspectgo/aspect/rt/rttest"
+)
+
+func readFile(name string) (string, error) {
+	return "real " + name, nil
+}
+
+// newContext returns the context for `readFile(name)`.
+func newContext(name string) aspect.Context {
+	jp := &aspect.JoinPoint{
+		Name:        "example.com/foo.readFile",
+		Pos:         "example.com/foo/main.go:42:2",
+		NumResults:  2,
+		ErrorResult: true,
+	}
+	return rttest.NewContext(jp, readFile, name)
+}
+
+type fakeTB struct {
+	errors []string
+}
+
+func (t *fakeTB) Helper() {}
+
+func (t *fakeTB) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestStub(t *testing.T) {
+	defer Reset()
+	errNotFound := errors.New("not found")
+	On("example.com/foo.readFile", "a").Return("stub a", nil).Once()
+	On("example.com/foo.readFile", MatchedBy(func(arg interface{}) bool {
+		return arg.(string) == "b"
+	})).Return(nil, errNotFound)
+	a := &Aspect{}
+	for _, tc := range []struct {
+		name string
+		res0 interface{}
+		res1 interface{}
+	}{
+		{"a", "stub a", nil},
+		{"a", "real a", nil}, // Once
+		{"b", nil, errNotFound},
+		{"c", "real c", nil},
+	} {
+		res := a.Advice(newContext(tc.name))
+		if len(res) != 2 || res[0] != tc.res0 || res[1] != tc.res1 {
+			t.Fatalf("%s: unexpected results: %v", tc.name, res)
+		}
+	}
+	if !AssertExpectations(t) {
+		t.Fatal("expectations not met")
+	}
+	AssertCalled(t, "example.com/foo.readFile", 2)
+}
+
+func TestAssertExpectations(t *testing.T) {
+	defer Reset()
+	On("example.com/foo.readFile", Anything).Return("stub", nil).Times(2)
+	On("example.com/foo.writeFile")
+	(&Aspect{}).Advice(newContext("a"))
+	ft := &fakeTB{}
+	if AssertExpectations(ft) || len(ft.errors) != 2 {
+		t.Fatalf("unexpected errors: %v", ft.errors)
+	}
+}
+
+func TestRunAndCallThrough(t *testing.T) {
+	defer Reset()
+	On("example.com/foo.readFile", AnythingOfType("string")).Run(func(args []interface{}) []interface{} {
+		return []interface{}{"run " + args[0].(string), nil}
+	}).Once()
+	c := On("example.com/foo.readFile").CallThrough()
+	a := &Aspect{}
+	if res := a.Advice(newContext("a")); res[0] != "run a" {
+		t.Fatalf("unexpected results: %v", res)
+	}
+	if res := a.Advice(newContext("b")); res[0] != "real b" {
+		t.Fatalf("unexpected results: %v", res)
+	}
+	if c.Calls() != 1 {
+		t.Fatalf("expected 1 call, got %d", c.Calls())
+	}
+}
+
+func TestResultMismatch(t *testing.T) {
+	for _, tc := range []struct {
+		stub func(c *Call)
+		msg  string
+	}{
+		{func(c *Call) { c.Return("stub") }, "expected 2 results, got 1"},
+		{func(c *Call) { c.Return("stub", nil, nil) }, "expected 2 results, got 3"},
+		{func(c *Call) { c.Return(42, nil) }, "wrong type of result #0"},
+		{func(c *Call) {
+			c.Run(func([]interface{}) []interface{} { return []interface{}{"run"} })
+		}, "expected 2 results, got 1"},
+	} {
+		func() {
+			defer Reset()
+			tc.stub(On("example.com/foo.readFile", "a"))
+			defer func() {
+				r, _ := recover().(string)
+				if !strings.Contains(r, `example.com/foo.readFile[a]`) || !strings.Contains(r, tc.msg) {
+					t.Fatalf("unexpected panic: %q (expected %q)", r, tc.msg)
+				}
+			}()
+			(&Aspect{}).Advice(newContext("a"))
+		}()
+	}
+}
@@ -0,0 +1,231 @@
+package rt
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/exp/aspectgo/aspect"
+)
+
+// ring is a bounded lock-free queue of events, which can be used by
+// multiple producers and consumers.
+// See http://www.1024cores.net/home/lock-free-algorithms/queues/bounded-mpmc-queue
+type ring struct {
+	mask  uint64
+	slots []ringSlot
+	head  uint64
+	tail  uint64
+}
+
+type ringSlot struct {
+	// seq is the position that the slot is ready for.
+	// It is pos if the slot is empty for enqueueing at pos,
+	// and pos+1 if the slot holds the event enqueued at pos.
+	seq uint64
+	ev  aspect.Event
+}
+
+func newRing(size int) *ring {
+	n := 1
+	for n < size {
+		n <<= 1
+	}
+	r := &ring{mask: uint64(n - 1), slots: make([]ringSlot, n)}
+	for i := range r.slots {
+		r.slots[i].seq = uint64(i)
+	}
+	return r
+}
+
+// push enqueues ev, or returns false if the ring is full.
+func (r *ring) push(ev *aspect.Event) bool {
+	for {
+		pos := atomic.LoadUint64(&r.tail)
+		s := &r.slots[pos&r.mask]
+		switch diff := int64(atomic.LoadUint64(&s.seq) - pos); {
+		case diff == 0:
+			if atomic.CompareAndSwapUint64(&r.tail, pos, pos+1) {
+				s.ev = *ev
+				atomic.StoreUint64(&s.seq, pos+1)
+				return true
+			}
+		case diff < 0:
+			return false
+		}
+	}
+}
+
+// pop dequeues the oldest event into ev, or returns false if the ring is empty.
+func (r *ring) pop(ev *aspect.Event) bool {
+	for {
+		pos := atomic.LoadUint64(&r.head)
+		s := &r.slots[pos&r.mask]
+		switch diff := int64(atomic.LoadUint64(&s.seq) - (pos + 1)); {
+		case diff == 0:
+			if atomic.CompareAndSwapUint64(&r.head, pos, pos+1) {
+				*ev = s.ev
+				s.ev = aspect.Event{}
+				atomic.StoreUint64(&s.seq, pos+r.mask+1)
+				return true
+			}
+		case diff < 0:
+			return false
+		}
+	}
+}
+
+// lastEventID is the ID of the last event.
+var lastEventID uint64
+
+// AsyncDispatcher implements aspect.Aspect for aspect.AsyncAspect.
+// Its Advice calls the function, and enqueues the event for the background goroutine.
+type AsyncDispatcher struct {
+	asp  aspect.AsyncAspect
+	drop aspect.DropPolicy
+	ring *ring
+
+	// notify wakes up the background goroutine.
+	notify chan struct{}
+
+	// enqueued is the number of the events passed to enqueue.
+	// Each of them is either handled or dropped eventually.
+	enqueued uint64
+	handled  uint64
+	dropped  uint64
+
+	// pendingDrops is the number of the events dropped after the previous event was handled.
+	pendingDrops uint64
+}
+
+// NewAsyncDispatcher should NOT be called manually.
+// It instantiates an aspect.AsyncAspect with newAspect, and starts the background goroutine.
+func NewAsyncDispatcher(newAspect func() interface{}) *AsyncDispatcher {
+	asp := NewAspect(newAspect).(aspect.AsyncAspect)
+	opts := asp.AsyncOptions()
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = aspect.DefaultBufferSize
+	}
+	d := &AsyncDispatcher{
+		asp:    asp,
+		drop:   opts.Drop,
+		ring:   newRing(opts.BufferSize),
+		notify: make(chan struct{}, 1),
+	}
+	go d.run()
+	aspect.OnFlushAsync(d.Flush)
+	return d
+}
+
+var asyncDispatchers = struct {
+	sync.Mutex
+	m map[string]*AsyncDispatcher
+}{m: make(map[string]*AsyncDispatcher)}
+
+// Async should NOT be called manually.
+// It returns the AsyncDispatcher shared among all the joinpoints of
+// the aspect named name.
+func Async(name string, newAspect func() interface{}) *AsyncDispatcher {
+	asyncDispatchers.Lock()
+	defer asyncDispatchers.Unlock()
+	d, ok := asyncDispatchers.m[name]
+	if !ok {
+		d = NewAsyncDispatcher(newAspect)
+		asyncDispatchers.m[name] = d
+	}
+	return d
+}
+
+// Pointcut should NOT be called manually.
+func (d *AsyncDispatcher) Pointcut() aspect.Pointcut {
+	return d.asp.Pointcut()
+}
+
+// Advice should NOT be called manually.
+func (d *AsyncDispatcher) Advice(ctx aspect.Context) (res []interface{}) {
+	args := ctx.Args()
+	ev := aspect.Event{
+		JoinPoint: ctx.JoinPoint(),
+		Args:      snapshot(args),
+		Begin:     time.Now(),
+	}
+	panicked := true
+	defer func() {
+		ev.Duration = time.Since(ev.Begin)
+		ev.Panicked = panicked
+		if !panicked {
+			ev.Results = snapshot(res)
+		}
+		d.enqueue(&ev)
+	}()
+	res = ctx.Call(args)
+	panicked = false
+	return res
+}
+
+func snapshot(values []interface{}) []interface{} {
+	s := make([]interface{}, len(values))
+	copy(s, values)
+	return s
+}
+
+func (d *AsyncDispatcher) enqueue(ev *aspect.Event) {
+	ev.ID = atomic.AddUint64(&lastEventID, 1)
+	atomic.AddUint64(&d.enqueued, 1)
+	for !d.ring.push(ev) {
+		switch d.drop {
+		case aspect.DropOldest:
+			var old aspect.Event
+			if d.ring.pop(&old) {
+				d.dropOne()
+			}
+		case aspect.Block:
+			d.wake()
+			runtime.Gosched()
+		default:
+			d.dropOne()
+			return
+		}
+	}
+	d.wake()
+}
+
+func (d *AsyncDispatcher) dropOne() {
+	atomic.AddUint64(&d.pendingDrops, 1)
+	atomic.AddUint64(&d.dropped, 1)
+}
+
+func (d *AsyncDispatcher) wake() {
+	select {
+	case d.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (d *AsyncDispatcher) run() {
+	var ev aspect.Event
+	for range d.notify {
+		for d.ring.pop(&ev) {
+			ev.Dropped = atomic.SwapUint64(&d.pendingDrops, 0)
+			d.asp.HandleEvent(ev)
+			atomic.AddUint64(&d.handled, 1)
+		}
+	}
+}
+
+// Flush should NOT be called manually.
+// It blocks until the events enqueued so far are handled or dropped.
+func (d *AsyncDispatcher) Flush() {
+	n := atomic.LoadUint64(&d.enqueued)
+	for atomic.LoadUint64(&d.handled)+atomic.LoadUint64(&d.dropped) < n {
+		d.wake()
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Dropped should NOT be called manually.
+// It returns the number of the dropped events.
+func (d *AsyncDispatcher) Dropped() uint64 {
+	return atomic.LoadUint64(&d.dropped)
+}
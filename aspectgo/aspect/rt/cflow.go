@@ -0,0 +1,82 @@
+package rt
+
+import (
+	"sync"
+
+	"golang.org/x/exp/aspectgo/aspect"
+	"golang.org/x/exp/aspectgo/internal/goid"
+)
+
+// cflowFrame is an entry of the joinpoint stack.
+type cflowFrame struct {
+	jp        *JoinPoint
+	pointcuts []string
+}
+
+// cflowStacks are the joinpoint stacks, keyed by the goroutine ID.
+var cflowStacks = struct {
+	sync.Mutex
+	m map[int64][]cflowFrame
+}{m: make(map[int64][]cflowFrame)}
+
+// PushCflow should NOT be called manually.
+// It pushes jp, which statically matches the cflow pointcuts,
+// to the joinpoint stack of the current goroutine.
+// It returns the function for popping jp.
+func PushCflow(jp *JoinPoint, pointcuts ...string) func() {
+	id := goid.ID()
+	cflowStacks.Lock()
+	cflowStacks.m[id] = append(cflowStacks.m[id], cflowFrame{jp: jp, pointcuts: pointcuts})
+	cflowStacks.Unlock()
+	return func() {
+		cflowStacks.Lock()
+		defer cflowStacks.Unlock()
+		stack := cflowStacks.m[id]
+		if len(stack) <= 1 {
+			delete(cflowStacks.m, id)
+			return
+		}
+		cflowStacks.m[id] = stack[:len(stack)-1]
+	}
+}
+
+// InCflow should NOT be called manually.
+// It returns true if a joinpoint of the pointcut is on the joinpoint stack
+// of the current goroutine.
+func InCflow(pointcut string) bool {
+	id := goid.ID()
+	cflowStacks.Lock()
+	defer cflowStacks.Unlock()
+	for _, f := range cflowStacks.m[id] {
+		for _, pc := range f.pointcuts {
+			if pc == pointcut {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Proceed is the aspect that just calls the joinpoint.
+type Proceed struct {
+}
+
+// Advice calls the joinpoint with the original arguments.
+func (a Proceed) Advice(ctx aspect.Context) []interface{} {
+	return ctx.Call(ctx.Args())
+}
+
+// Pointcut should NOT be called manually.
+func (a Proceed) Pointcut() aspect.Pointcut {
+	return ""
+}
+
+// Guard should NOT be called manually.
+// It instantiates the aspect with newAspect if cond is true.
+// Otherwise it returns Proceed.
+func Guard(cond bool, newAspect func() interface{}) aspect.Aspect {
+	if !cond {
+		return Proceed{}
+	}
+	return newAspect().(aspect.Aspect)
+}
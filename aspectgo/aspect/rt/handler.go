@@ -0,0 +1,98 @@
+package rt
+
+import (
+	"golang.org/x/exp/aspectgo/aspect"
+)
+
+// HandlerContextImpl implements aspect.HandlerContext
+type HandlerContextImpl struct {
+	ContextImpl
+
+	// XErr should NOT be accessed manually.
+	XErr error
+
+	// XPanicked should NOT be accessed manually.
+	XPanicked bool
+
+	// XRecovered should NOT be accessed manually.
+	XRecovered interface{}
+
+	// XResults should NOT be accessed manually.
+	XResults []interface{}
+
+	// recover is set by Return.
+	recover bool
+}
+
+// Err should NOT be called manually.
+func (ctx *HandlerContextImpl) Err() error {
+	return ctx.XErr
+}
+
+// Panicked should NOT be called manually.
+func (ctx *HandlerContextImpl) Panicked() bool {
+	return ctx.XPanicked
+}
+
+// Recovered should NOT be called manually.
+func (ctx *HandlerContextImpl) Recovered() interface{} {
+	return ctx.XRecovered
+}
+
+// Results should NOT be called manually.
+func (ctx *HandlerContextImpl) Results() []interface{} {
+	return ctx.XResults
+}
+
+// Return should NOT be called manually.
+func (ctx *HandlerContextImpl) Return(err error) []interface{} {
+	ctx.recover = true
+	res := make([]interface{}, ctx.XJoinPoint.NumResults)
+	copy(res, ctx.XResults)
+	if ctx.XJoinPoint.ErrorResult {
+		res[len(res)-1] = err
+	}
+	return res
+}
+
+// Handle should NOT be called manually.
+// It calls ctx.XFunc, and executes the advice of asp only if
+// the call returned a non-nil error or panicked.
+func (ctx *HandlerContextImpl) Handle(asp aspect.Aspect) (res []interface{}) {
+	call := ctx.XFunc
+	ctx.XFunc = func(args []interface{}) []interface{} {
+		if ctx.XPanicked {
+			panic(ctx.XRecovered)
+		}
+		return ctx.XResults
+	}
+	panicked := true
+	defer func() {
+		if !panicked {
+			return
+		}
+		r := recover()
+		if r == nil {
+			// runtime.Goexit()
+			return
+		}
+		ctx.XPanicked = true
+		ctx.XRecovered = r
+		res = asp.Advice(ctx)
+		if !ctx.recover {
+			panic(ctx.XRecovered)
+		}
+	}()
+	res = call(ctx.XArgs)
+	panicked = false
+	if !ctx.XJoinPoint.ErrorResult {
+		return res
+	}
+	err, _ := res[len(res)-1].(error)
+	if err == nil {
+		return res
+	}
+	ctx.XErr = err
+	ctx.XResults = res
+	return asp.Advice(ctx)
+}
@@ -2,6 +2,13 @@
 // Do NOT access rt from an aspect file.
 package rt
 
+import (
+	"reflect"
+	"sync"
+
+	"golang.org/x/exp/aspectgo/aspect"
+)
+
 // ContextImpl implements aspect.Context
 type ContextImpl struct {
 	// XArgs should NOT be accessed manually.
@@ -12,8 +19,18 @@ type ContextImpl struct {
 
 	// XReceiver should NOT be accessed manually.
 	XReceiver interface{}
+
+	// XJoinPoint should NOT be accessed manually.
+	XJoinPoint *JoinPoint
 }
 
+// JoinPoint is an alias for aspect.JoinPoint,
+// so that woven files do not need to import aspect.
+type JoinPoint = aspect.JoinPoint
+
+// Annotation is an alias for aspect.Annotation.
+type Annotation = aspect.Annotation
+
 // Args should NOT be called manually.
 func (ctx *ContextImpl) Args() []interface{} {
 	return ctx.XArgs
@@ -21,10 +38,152 @@ func (ctx *ContextImpl) Args() []interface{} {
 
 // Call should NOT be called manually.
 func (ctx *ContextImpl) Call(args []interface{}) []interface{} {
-	return ctx.XFunc(args)
+	res, err := ctx.CallChecked(args)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// CallChecked should NOT be called manually.
+func (ctx *ContextImpl) CallChecked(args []interface{}) ([]interface{}, error) {
+	if ctx.XJoinPoint != nil {
+		if err := ctx.XJoinPoint.CheckArgs(args); err != nil {
+			return nil, err
+		}
+	}
+	return ctx.XFunc(args), nil
+}
+
+// Types should NOT be called manually.
+// It returns the types pointed by ptrs, e.g. []reflect.Type{int} for (*int)(nil).
+func Types(ptrs ...interface{}) []reflect.Type {
+	types := make([]reflect.Type, len(ptrs))
+	for i, ptr := range ptrs {
+		types[i] = reflect.TypeOf(ptr).Elem()
+	}
+	return types
+}
+
+// CheckResults should NOT be called manually.
+// It panics with *aspect.TypeError if the results of the advice do not match jp.
+func CheckResults(jp *JoinPoint, results []interface{}) {
+	if err := jp.CheckResults(results); err != nil {
+		panic(err)
+	}
 }
 
 // Receiver should NOT be called manually.
 func (ctx *ContextImpl) Receiver() interface{} {
 	return ctx.XReceiver
 }
+
+// JoinPoint should NOT be called manually.
+func (ctx *ContextImpl) JoinPoint() *JoinPoint {
+	return ctx.XJoinPoint
+}
+
+// ParamNames should NOT be called manually.
+func (ctx *ContextImpl) ParamNames() []string {
+	if ctx.XJoinPoint == nil {
+		return nil
+	}
+	return ctx.XJoinPoint.ParamNames
+}
+
+// ParamTypes should NOT be called manually.
+func (ctx *ContextImpl) ParamTypes() []reflect.Type {
+	if ctx.XJoinPoint == nil {
+		return nil
+	}
+	return ctx.XJoinPoint.ParamTypes
+}
+
+// ResultNames should NOT be called manually.
+func (ctx *ContextImpl) ResultNames() []string {
+	if ctx.XJoinPoint == nil {
+		return nil
+	}
+	return ctx.XJoinPoint.ResultNames
+}
+
+// ResultTypes should NOT be called manually.
+func (ctx *ContextImpl) ResultTypes() []reflect.Type {
+	if ctx.XJoinPoint == nil {
+		return nil
+	}
+	return ctx.XJoinPoint.ResultTypes
+}
+
+// FieldContextImpl implements aspect.FieldContext
+type FieldContextImpl struct {
+	ContextImpl
+
+	// XOldValue should NOT be accessed manually.
+	XOldValue interface{}
+}
+
+// OldValue should NOT be called manually.
+func (ctx *FieldContextImpl) OldValue() interface{} {
+	return ctx.XOldValue
+}
+
+// NewValue should NOT be called manually.
+func (ctx *FieldContextImpl) NewValue() interface{} {
+	if ctx.XJoinPoint.Kind == aspect.KindSet {
+		return ctx.XArgs[0]
+	}
+	return ctx.XOldValue
+}
+
+// NewAspect should NOT be called manually.
+// It instantiates an aspect with newAspect and calls its Init hook if any.
+func NewAspect(newAspect func() interface{}) aspect.Aspect {
+	asp := newAspect().(aspect.Aspect)
+	if initializer, ok := asp.(aspect.Initializer); ok {
+		initializer.Init()
+	}
+	return asp
+}
+
+// AspectInstance holds a lazily-instantiated aspect.
+// It is used for aspect.PerJoinPoint and aspect.Singleton aspects.
+type AspectInstance struct {
+	// XNew should NOT be accessed manually.
+	XNew func() interface{}
+
+	once sync.Once
+	asp  aspect.Aspect
+}
+
+// NewAspectInstance should NOT be called manually.
+func NewAspectInstance(newAspect func() interface{}) *AspectInstance {
+	return &AspectInstance{XNew: newAspect}
+}
+
+// Get should NOT be called manually.
+func (ai *AspectInstance) Get() aspect.Aspect {
+	ai.once.Do(func() {
+		ai.asp = NewAspect(ai.XNew)
+	})
+	return ai.asp
+}
+
+var singletons = struct {
+	sync.Mutex
+	m map[string]*AspectInstance
+}{m: make(map[string]*AspectInstance)}
+
+// Singleton should NOT be called manually.
+// It returns the AspectInstance shared among all the joinpoints of
+// the aspect named name.
+func Singleton(name string, newAspect func() interface{}) *AspectInstance {
+	singletons.Lock()
+	defer singletons.Unlock()
+	ai, ok := singletons.m[name]
+	if !ok {
+		ai = NewAspectInstance(newAspect)
+		singletons.m[name] = ai
+	}
+	return ai
+}
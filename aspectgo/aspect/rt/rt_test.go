@@ -2,8 +2,14 @@ package rt
 
 import (
 	"fmt"
+	"os"
+	"reflect"
+	"runtime"
 	"runtime/debug"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	asp "golang.org/x/exp/aspectgo/aspect"
 )
@@ -40,3 +46,440 @@ func TestContextImpl(t *testing.T) {
 				return _ag_res
 			}})
 }
+
+type countingAspect struct {
+	inits int
+	calls int
+}
+
+func (a *countingAspect) Pointcut() asp.Pointcut {
+	return asp.Pointcut("counting")
+}
+
+func (a *countingAspect) Init() {
+	a.inits++
+}
+
+func (a *countingAspect) Advice(ctx asp.Context) []interface{} {
+	a.calls++
+	return ctx.Call(ctx.Args())
+}
+
+func TestAspectInstance(t *testing.T) {
+	ai := NewAspectInstance(func() interface{} { return &countingAspect{} })
+	for i := 0; i < 3; i++ {
+		ai.Get().Advice(
+			&ContextImpl{
+				XArgs: []interface{}{},
+				XFunc: func(_ag_args []interface{}) []interface{} {
+					return []interface{}{}
+				}})
+	}
+	a := ai.Get().(*countingAspect)
+	if a.inits != 1 || a.calls != 3 {
+		t.Fatalf("expected inits=1, calls=3, got inits=%d, calls=%d", a.inits, a.calls)
+	}
+}
+
+func TestSingleton(t *testing.T) {
+	newAspect := func() interface{} { return &countingAspect{} }
+	ai1 := Singleton("TestSingleton", newAspect)
+	ai2 := Singleton("TestSingleton", newAspect)
+	if ai1 != ai2 || ai1.Get() != ai2.Get() {
+		t.Fatal("singleton instances differ")
+	}
+	if ai1 == Singleton("TestSingleton2", newAspect) {
+		t.Fatal("different singletons share the instance")
+	}
+}
+
+func TestSelect(t *testing.T) {
+	ch1 := make(chan int, 1)
+	ch2 := make(chan error, 1)
+	ch1 <- 42
+	res := NewSelectResult(Select([]SelectCase{
+		SelectRecv(ch1),
+	}))
+	if res.Chosen != 0 || res.Recv != 42 || !res.RecvOK {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	res = NewSelectResult(Select([]SelectCase{
+		SelectRecv(ch1),
+		SelectSend(ch2, nil),
+	}))
+	if err := <-ch2; res.Chosen != 1 || err != nil {
+		t.Fatalf("unexpected result: %+v, err=%v", res, err)
+	}
+	close(ch1)
+	res = NewSelectResult(Select([]SelectCase{
+		SelectRecv(ch1),
+	}))
+	if res.Chosen != 0 || res.Recv != 0 || res.RecvOK {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	res = NewSelectResult(Select([]SelectCase{
+		SelectRecv((chan int)(nil)),
+		SelectDefault(),
+	}))
+	if res.Chosen != 1 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+}
+
+// annotatingAspect annotates the error with the joinpoint name.
+type annotatingAspect struct {
+	calls int
+}
+
+func (a *annotatingAspect) Pointcut() asp.Pointcut {
+	return asp.NewHandlerPointcutFromRegexp("dummy")
+}
+
+func (a *annotatingAspect) Advice(ctx asp.Context) []interface{} {
+	a.calls++
+	hctx := ctx.(asp.HandlerContext)
+	if hctx.Panicked() {
+		return hctx.Return(fmt.Errorf("%s: panic: %v", ctx.JoinPoint().Name, hctx.Recovered()))
+	}
+	return hctx.Return(fmt.Errorf("%s: %v", ctx.JoinPoint().Name, hctx.Err()))
+}
+
+func TestHandlerContext(t *testing.T) {
+	jp := &JoinPoint{Kind: asp.KindHandler, Name: "f", NumResults: 2, ErrorResult: true}
+	newCtx := func(f func(int) (int, error)) *HandlerContextImpl {
+		return &HandlerContextImpl{
+			ContextImpl: ContextImpl{
+				XArgs: []interface{}{42},
+				XFunc: func(args []interface{}) []interface{} {
+					x, err := f(args[0].(int))
+					return []interface{}{x, err}
+				},
+				XJoinPoint: jp,
+			}}
+	}
+	a := &annotatingAspect{}
+
+	res := newCtx(func(x int) (int, error) { return x, nil }).Handle(a)
+	if a.calls != 0 || res[0] != 42 || res[1] != nil {
+		t.Fatalf("unexpected result: %v (calls=%d)", res, a.calls)
+	}
+
+	res = newCtx(func(x int) (int, error) { return x, fmt.Errorf("failed") }).Handle(a)
+	if a.calls != 1 || res[0] != 42 || res[1].(error).Error() != "f: failed" {
+		t.Fatalf("unexpected result: %v (calls=%d)", res, a.calls)
+	}
+
+	res = newCtx(func(x int) (int, error) { panic("oops") }).Handle(a)
+	if a.calls != 2 || res[0] != nil || res[1].(error).Error() != "f: panic: oops" {
+		t.Fatalf("unexpected result: %v (calls=%d)", res, a.calls)
+	}
+}
+
+// passingAspect does not handle panics.
+type passingAspect struct {
+}
+
+func (a *passingAspect) Pointcut() asp.Pointcut {
+	return asp.NewHandlerPointcutFromRegexp("dummy")
+}
+
+func (a *passingAspect) Advice(ctx asp.Context) []interface{} {
+	return ctx.Call(ctx.Args())
+}
+
+func TestHandlerContextRepanic(t *testing.T) {
+	ctx := &HandlerContextImpl{
+		ContextImpl: ContextImpl{
+			XArgs: []interface{}{},
+			XFunc: func(args []interface{}) []interface{} {
+				panic("oops")
+			},
+			XJoinPoint: &JoinPoint{Kind: asp.KindHandler, Name: "f"},
+		}}
+	defer func() {
+		if r := recover(); r != "oops" {
+			t.Fatalf("unexpected recovered value: %v", r)
+		}
+	}()
+	ctx.Handle(&passingAspect{})
+	t.Fatal("should not reach here")
+}
+
+func TestCflow(t *testing.T) {
+	const pc = "main\\.handle"
+	if InCflow(pc) {
+		t.Fatal("unexpected cflow")
+	}
+	pop := PushCflow(&JoinPoint{Name: "main.handle"}, pc)
+	pop2 := PushCflow(&JoinPoint{Name: "main.f"}, "main\\.f")
+	if !InCflow(pc) || !InCflow("main\\.f") {
+		t.Fatal("expected cflow")
+	}
+	done := make(chan bool)
+	go func() {
+		done <- InCflow(pc)
+	}()
+	if <-done {
+		t.Fatal("the stack should not be shared with other goroutines")
+	}
+	pop2()
+	if !InCflow(pc) || InCflow("main\\.f") {
+		t.Fatal("unexpected cflow after pop")
+	}
+	pop()
+	if InCflow(pc) {
+		t.Fatal("unexpected cflow after pop")
+	}
+
+	asp := &passingAspect{}
+	if _, ok := Guard(false, func() interface{} { return asp }).(Proceed); !ok {
+		t.Fatal("expected Proceed")
+	}
+	if Guard(true, func() interface{} { return asp }) != asp {
+		t.Fatal("expected the aspect")
+	}
+}
+
+func TestCallChecked(t *testing.T) {
+	jp := &JoinPoint{
+		Kind:        asp.KindCall,
+		Name:        "main.parse",
+		Pos:         "example.com/foo/main.go:12:2",
+		NumResults:  2,
+		ErrorResult: true,
+		ParamTypes:  Types((*string)(nil), (*[]interface{})(nil)),
+		ResultTypes: Types((*int)(nil), (*error)(nil)),
+	}
+	ctx := &ContextImpl{
+		XArgs: []interface{}{"42", []interface{}{}},
+		XFunc: func(_ag_args []interface{}) []interface{} {
+			return []interface{}{42, nil}
+		},
+		XJoinPoint: jp,
+	}
+	for _, c := range []struct {
+		args     []interface{}
+		expected string
+	}{
+		{[]interface{}{"42", []interface{}{}}, ""},
+		{[]interface{}{"42", nil}, ""},
+		{[]interface{}{"42"}, "main.parse (example.com/foo/main.go:12:2): wrong number of args: expected 2, got 1"},
+		{[]interface{}{42, nil}, "main.parse (example.com/foo/main.go:12:2): wrong type of arg #0: expected string, got int"},
+	} {
+		_, err := ctx.CallChecked(c.args)
+		if c.expected == "" {
+			if err != nil {
+				t.Fatalf("unexpected error for %v: %v", c.args, err)
+			}
+			continue
+		}
+		terr, ok := err.(*asp.TypeError)
+		if !ok || terr.Error() != c.expected {
+			t.Fatalf("unexpected error for %v: %v", c.args, err)
+		}
+		func() {
+			defer func() {
+				if r := recover(); r == nil || r.(error).Error() != c.expected {
+					t.Fatalf("unexpected panic for %v: %v", c.args, r)
+				}
+			}()
+			ctx.Call(c.args)
+		}()
+	}
+
+	if err := jp.CheckResults([]interface{}{42, fmt.Errorf("error")}); err != nil {
+		t.Fatal(err)
+	}
+	err := jp.CheckResults([]interface{}{42, "error"})
+	if terr, ok := err.(*asp.TypeError); !ok || !terr.Results || terr.Index != 1 || terr.Expected != "error" || terr.Actual != "string" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestContextSignature(t *testing.T) {
+	jp := &JoinPoint{
+		Kind:        asp.KindCall,
+		Name:        "os.OpenFile",
+		ParamNames:  []string{"name", "flag", "perm"},
+		ResultNames: []string{"", ""},
+		ParamTypes:  Types((*string)(nil), (*int)(nil), (*os.FileMode)(nil)),
+		ResultTypes: Types((**os.File)(nil), (*error)(nil)),
+	}
+	var ctx asp.Context = &ContextImpl{XJoinPoint: jp}
+	if !reflect.DeepEqual(ctx.ParamNames(), jp.ParamNames) ||
+		!reflect.DeepEqual(ctx.ResultNames(), jp.ResultNames) {
+		t.Fatalf("unexpected names: %v, %v", ctx.ParamNames(), ctx.ResultNames())
+	}
+	if s := fmt.Sprint(ctx.ParamTypes()); s != "[string int fs.FileMode]" {
+		t.Fatalf("unexpected param types: %s", s)
+	}
+	if s := fmt.Sprint(ctx.ResultTypes()); s != "[*os.File error]" {
+		t.Fatalf("unexpected result types: %s", s)
+	}
+	ctx = &ContextImpl{}
+	if ctx.ParamNames() != nil || ctx.ParamTypes() != nil || ctx.ResultNames() != nil || ctx.ResultTypes() != nil {
+		t.Fatal("the signature of a context without joinpoint should be nil")
+	}
+}
+
+type asyncAspect struct {
+	opts   asp.AsyncOptions
+	gate   chan struct{}
+	events []asp.Event
+}
+
+func (a *asyncAspect) Pointcut() asp.Pointcut {
+	return asp.Pointcut("dummy")
+}
+
+func (a *asyncAspect) Advice(ctx asp.Context) []interface{} {
+	panic("Advice of an AsyncAspect should not be called")
+}
+
+func (a *asyncAspect) AsyncOptions() asp.AsyncOptions {
+	return a.opts
+}
+
+func (a *asyncAspect) HandleEvent(ev asp.Event) {
+	if a.gate != nil {
+		<-a.gate
+	}
+	a.events = append(a.events, ev)
+}
+
+func newAsyncContext(x int) *ContextImpl {
+	return &ContextImpl{
+		XArgs: []interface{}{x},
+		XFunc: func(_ag_args []interface{}) []interface{} {
+			if _ag_args[0].(int) < 0 {
+				panic("negative")
+			}
+			return []interface{}{_ag_args[0].(int) * 2}
+		},
+		XJoinPoint: &JoinPoint{Kind: asp.KindCall, Name: "main.double", NumResults: 1},
+	}
+}
+
+func TestAsyncDispatcher(t *testing.T) {
+	a := &asyncAspect{}
+	d := NewAsyncDispatcher(func() interface{} { return a })
+	for i := 0; i < 3; i++ {
+		if res := d.Advice(newAsyncContext(i)); res[0] != i*2 {
+			t.Fatalf("unexpected results: %v", res)
+		}
+	}
+	func() {
+		defer func() {
+			if r := recover(); r != "negative" {
+				t.Fatalf("unexpected panic: %v", r)
+			}
+		}()
+		d.Advice(newAsyncContext(-1))
+	}()
+	asp.FlushAsync()
+	if len(a.events) != 4 {
+		t.Fatalf("unexpected events: %v", a.events)
+	}
+	for i, ev := range a.events[:3] {
+		if ev.JoinPoint.Name != "main.double" || ev.Args[0] != i || ev.Results[0] != i*2 || ev.Panicked {
+			t.Fatalf("unexpected event #%d: %+v", i, ev)
+		}
+		if i > 0 && ev.ID <= a.events[i-1].ID {
+			t.Fatalf("unordered events: %v", a.events)
+		}
+	}
+	if ev := a.events[3]; !ev.Panicked || ev.Results != nil {
+		t.Fatalf("unexpected event for the panic: %+v", ev)
+	}
+}
+
+func TestAsyncDispatcherDrop(t *testing.T) {
+	for _, c := range []struct {
+		drop     asp.DropPolicy
+		expected []interface{}
+	}{
+		// the first event is being handled, and the buffer holds the next 4 events
+		{asp.DropNewest, []interface{}{0, 1, 2, 3, 4}},
+		{asp.DropOldest, []interface{}{0, 5, 6, 7, 8}},
+		{asp.Block, []interface{}{0, 1, 2, 3, 4, 5, 6, 7, 8}},
+	} {
+		a := &asyncAspect{
+			opts: asp.AsyncOptions{BufferSize: 3, Drop: c.drop},
+			gate: make(chan struct{}),
+		}
+		d := NewAsyncDispatcher(func() interface{} { return a })
+		d.Advice(newAsyncContext(0))
+		// wait for the first event to be dequeued
+		for atomic.LoadUint64(&d.ring.head) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		done := make(chan struct{})
+		go func() {
+			for i := 1; i < 9; i++ {
+				d.Advice(newAsyncContext(i))
+			}
+			close(done)
+		}()
+		if c.drop != asp.Block {
+			<-done
+		}
+		close(a.gate)
+		<-done
+		d.Flush()
+		var args []interface{}
+		var dropped uint64
+		for _, ev := range a.events {
+			args = append(args, ev.Args[0])
+			dropped += ev.Dropped
+		}
+		if !reflect.DeepEqual(args, c.expected) {
+			t.Fatalf("%s: unexpected events: %v", c.drop, args)
+		}
+		if n := uint64(9 - len(c.expected)); d.Dropped() != n || (c.drop == asp.DropOldest && dropped != n) {
+			t.Fatalf("%s: unexpected number of the dropped events: %d, %d", c.drop, d.Dropped(), dropped)
+		}
+	}
+}
+
+func TestRing(t *testing.T) {
+	r := newRing(64)
+	const producers, n = 4, 1000
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				ev := asp.Event{ID: uint64(p*n + i)}
+				for !r.push(&ev) {
+					runtime.Gosched()
+				}
+			}
+		}(p)
+	}
+	seen := make(map[uint64]bool)
+	last := make([]int, producers)
+	for i := range last {
+		last[i] = -1
+	}
+	var ev asp.Event
+	for len(seen) < producers*n {
+		if !r.pop(&ev) {
+			runtime.Gosched()
+			continue
+		}
+		if seen[ev.ID] {
+			t.Fatalf("duplicated event %d", ev.ID)
+		}
+		seen[ev.ID] = true
+		p, i := int(ev.ID)/n, int(ev.ID)%n
+		if i <= last[p] {
+			t.Fatalf("unordered event %d from producer %d", i, p)
+		}
+		last[p] = i
+	}
+	wg.Wait()
+	if r.pop(&ev) {
+		t.Fatalf("unexpected event %d", ev.ID)
+	}
+}
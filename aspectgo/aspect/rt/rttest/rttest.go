@@ -0,0 +1,74 @@
+// Package rttest provides the contexts for testing the advices without weaving.
+package rttest
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"golang.org/x/exp/aspectgo/aspect"
+	"golang.org/x/exp/aspectgo/aspect/rt"
+)
+
+// mu protects ParamTypes and ResultTypes of the join points,
+// which can be shared by the contexts created concurrently.
+var mu sync.Mutex
+
+// NewContext returns the context like the one woven for `fn(args...)` at jp.
+// If jp.ParamTypes or jp.ResultTypes is nil, it is set from the signature of fn,
+// so that Call panics with *aspect.TypeError on mismatched args, as in the woven code,
+// and jp.CheckResults can check the results of the advice.
+// A nil arg is passed as the zero value.
+// For a variadic fn, the last arg is the slice, as in the woven code.
+// The other fields of jp, such as NumResults, need to be set by the caller.
+// NewContext panics if fn is not a function or the number of args does not match.
+func NewContext(jp *aspect.JoinPoint, fn interface{}, args ...interface{}) aspect.Context {
+	f := reflect.ValueOf(fn)
+	if f.Kind() != reflect.Func {
+		panic(fmt.Sprintf("rttest: %T is not a function", fn))
+	}
+	ft := f.Type()
+	if len(args) != ft.NumIn() {
+		panic(fmt.Sprintf("rttest: %d args for %s of %s", len(args), ft, jp.Name))
+	}
+	mu.Lock()
+	if jp.ParamTypes == nil {
+		jp.ParamTypes = make([]reflect.Type, ft.NumIn())
+		for i := range jp.ParamTypes {
+			jp.ParamTypes[i] = ft.In(i)
+		}
+	}
+	if jp.ResultTypes == nil {
+		jp.ResultTypes = make([]reflect.Type, ft.NumOut())
+		for i := range jp.ResultTypes {
+			jp.ResultTypes[i] = ft.Out(i)
+		}
+	}
+	mu.Unlock()
+	return &rt.ContextImpl{
+		XArgs: args,
+		// the args are checked with jp.ParamTypes before XFunc is called
+		XFunc: func(args []interface{}) []interface{} {
+			in := make([]reflect.Value, len(args))
+			for i, arg := range args {
+				if arg == nil {
+					in[i] = reflect.Zero(ft.In(i))
+				} else {
+					in[i] = reflect.ValueOf(arg).Convert(ft.In(i))
+				}
+			}
+			var out []reflect.Value
+			if ft.IsVariadic() {
+				out = f.CallSlice(in)
+			} else {
+				out = f.Call(in)
+			}
+			res := make([]interface{}, len(out))
+			for i, v := range out {
+				res[i] = v.Interface()
+			}
+			return res
+		},
+		XJoinPoint: jp,
+	}
+}
@@ -0,0 +1,61 @@
+package rttest
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/exp/aspectgo/aspect"
+)
+
+func TestNewContext(t *testing.T) {
+	jp := &aspect.JoinPoint{Name: "example.com/foo.fn", NumResults: 2, ErrorResult: true}
+	fn := func(s string, x int) (int, error) {
+		if s == "" {
+			return 0, errors.New("empty")
+		}
+		return len(s) * x, nil
+	}
+	ctx := NewContext(jp, fn, "foo", 2)
+	if ctx.JoinPoint() != jp || !reflect.DeepEqual(ctx.Args(), []interface{}{"foo", 2}) {
+		t.Fatalf("unexpected context: %v %v", ctx.JoinPoint(), ctx.Args())
+	}
+	if !reflect.DeepEqual(ctx.ParamTypes(), []reflect.Type{reflect.TypeOf(""), reflect.TypeOf(0)}) ||
+		len(ctx.ResultTypes()) != 2 {
+		t.Fatalf("unexpected types: %v %v", ctx.ParamTypes(), ctx.ResultTypes())
+	}
+	if res := ctx.Call(ctx.Args()); !reflect.DeepEqual(res, []interface{}{6, nil}) {
+		t.Fatalf("unexpected results: %v", res)
+	}
+	// nil is passed as the zero value
+	if res := ctx.Call([]interface{}{nil, 2}); res[0] != 0 || res[1].(error).Error() != "empty" {
+		t.Fatalf("unexpected results: %v", res)
+	}
+	// the wrong type is rejected as in the woven code
+	_, err := ctx.(aspect.CheckedContext).CallChecked([]interface{}{"foo", "2"})
+	if _, ok := err.(*aspect.TypeError); !ok {
+		t.Fatalf("expected *aspect.TypeError, got %v", err)
+	}
+	if err := jp.CheckResults([]interface{}{1}); err == nil {
+		t.Fatal("expected an error for the missing result")
+	}
+}
+
+func TestNewContextVariadic(t *testing.T) {
+	jp := &aspect.JoinPoint{Name: "example.com/foo.join", NumResults: 1}
+	ctx := NewContext(jp, func(sep string, s ...string) string { return strings.Join(s, sep) },
+		",", []string{"a", "b"})
+	if res := ctx.Call(ctx.Args()); res[0] != "a,b" {
+		t.Fatalf("unexpected results: %v", res)
+	}
+}
+
+func TestNewContextArgCount(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(r.(string), "1 args") {
+			t.Fatalf("unexpected panic: %v", r)
+		}
+	}()
+	NewContext(&aspect.JoinPoint{Name: "example.com/foo.fn"}, func(int, int) {}, 1)
+}
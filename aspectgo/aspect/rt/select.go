@@ -0,0 +1,64 @@
+package rt
+
+import (
+	"reflect"
+)
+
+// SelectCase is an alias for reflect.SelectCase,
+// so that woven files do not need to import reflect.
+type SelectCase = reflect.SelectCase
+
+// SelectResult is the result of Select.
+type SelectResult struct {
+	// Chosen should NOT be accessed manually.
+	Chosen int
+
+	// Recv should NOT be accessed manually.
+	Recv interface{}
+
+	// RecvOK should NOT be accessed manually.
+	RecvOK bool
+}
+
+// SelectRecv should NOT be called manually.
+// It returns the case for `case <-ch`.
+func SelectRecv(ch interface{}) SelectCase {
+	return SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch)}
+}
+
+// SelectSend should NOT be called manually.
+// It returns the case for `case ch <- v`.
+func SelectSend(ch interface{}, v interface{}) SelectCase {
+	chV := reflect.ValueOf(ch)
+	vV := reflect.ValueOf(v)
+	if !vV.IsValid() {
+		// nil interface
+		vV = reflect.Zero(chV.Type().Elem())
+	}
+	return SelectCase{Dir: reflect.SelectSend, Chan: chV, Send: vV}
+}
+
+// SelectDefault should NOT be called manually.
+// It returns the case for `default`.
+func SelectDefault() SelectCase {
+	return SelectCase{Dir: reflect.SelectDefault}
+}
+
+// Select should NOT be called manually.
+// It executes the select statement with reflect.Select.
+func Select(cases []SelectCase) []interface{} {
+	chosen, recv, recvOK := reflect.Select(cases)
+	var recvI interface{}
+	if recv.IsValid() {
+		recvI = recv.Interface()
+	}
+	return []interface{}{chosen, recvI, recvOK}
+}
+
+// NewSelectResult should NOT be called manually.
+// res is the result of Select, or the result of the advice.
+func NewSelectResult(res []interface{}) SelectResult {
+	chosen, _ := res[0].(int)
+	recvOK, _ := res[2].(bool)
+	return SelectResult{Chosen: chosen, Recv: res[1], RecvOK: recvOK}
+}
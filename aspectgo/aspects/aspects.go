@@ -0,0 +1,32 @@
+// Package aspects provides reusable aspects for AspectGo.
+//
+// The aspects in this package do not implement Pointcut().
+// To use them, embed one into an aspect structure in an aspect file:
+//
+//	type TraceAspect struct {
+//		aspects.Tracer
+//	}
+//
+//	func (a *TraceAspect) Pointcut() asp.Pointcut {
+//		return asp.NewCallPointcutFromRegexp("example\\.com/foo\\..*")
+//	}
+//
+// The zero value of each aspect is ready to use.
+// Stateful aspects (e.g. Memoizer) need to be instantiated as
+// asp.PerJoinPoint or asp.Singleton, and configurable aspects can be
+// configured in Init().
+package aspects
+
+import (
+	"golang.org/x/exp/aspectgo/aspect"
+)
+
+// lastError returns the last result as an error,
+// if the function of the joinpoint returns an error.
+func lastError(jp *aspect.JoinPoint, res []interface{}) error {
+	if jp == nil || !jp.ErrorResult || len(res) == 0 {
+		return nil
+	}
+	err, _ := res[len(res)-1].(error)
+	return err
+}
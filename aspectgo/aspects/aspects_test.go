@@ -0,0 +1,138 @@
+package aspects
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/aspectgo/aspect"
+	"golang.org/x/exp/aspectgo/aspect/rt/rttest"
+)
+
+// fnJoinPoint returns the join point of `fn(x)`, where fn returns (int, error).
+func fnJoinPoint() *aspect.JoinPoint {
+	return &aspect.JoinPoint{
+		Name:        "example.com/foo.fn",
+		Pos:         "example.com/foo/main.go:42:2",
+		NumResults:  2,
+		ErrorResult: true,
+	}
+}
+
+func TestTracer(t *testing.T) {
+	var b bytes.Buffer
+	tr := &Tracer{Logger: slog.New(slog.NewTextHandler(&b, nil))}
+	res := tr.Advice(rttest.NewContext(fnJoinPoint(), func(x int) (int, error) { return x * 2, nil }, 21))
+	if res[0] != 42 {
+		t.Fatalf("unexpected results: %v", res)
+	}
+	s := b.String()
+	for _, expected := range []string{"func=example.com/foo.fn", "args=[21]", "results=\"[42 <nil>]\""} {
+		if !strings.Contains(s, expected) {
+			t.Fatalf("%q not found in %q", expected, s)
+		}
+	}
+}
+
+func TestLatency(t *testing.T) {
+	hs := NewHistograms("test_seconds", []float64{0.5, 0.1})
+	l := &Latency{Histograms: hs}
+	for i := 0; i < 3; i++ {
+		l.Advice(rttest.NewContext(fnJoinPoint(), func(x int) (int, error) { return x, nil }, i))
+	}
+	h := hs.Snapshot()["example.com/foo.fn"]
+	if h.Count != 3 || h.Counts[0] != 3 || h.Counts[1] != 3 {
+		t.Fatalf("unexpected histogram: %+v", h)
+	}
+	var b bytes.Buffer
+	if err := hs.WritePrometheus(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"# TYPE test_seconds histogram\n",
+		"test_seconds_bucket{func=\"example.com/foo.fn\",le=\"0.1\"} 3\n",
+		"test_seconds_bucket{func=\"example.com/foo.fn\",le=\"+Inf\"} 3\n",
+		"test_seconds_count{func=\"example.com/foo.fn\"} 3\n",
+	} {
+		if !strings.Contains(b.String(), expected) {
+			t.Fatalf("%q not found in %q", expected, b.String())
+		}
+	}
+	if !strings.HasPrefix(hs.String(), "{\"example.com/foo.fn\":") {
+		t.Fatalf("unexpected JSON: %s", hs.String())
+	}
+}
+
+func TestPanicToError(t *testing.T) {
+	p := &PanicToError{}
+	res := p.Advice(rttest.NewContext(fnJoinPoint(), func(x int) (int, error) { panic("boom") }, 42))
+	perr, ok := res[1].(*PanicError)
+	if !ok || res[0] != nil || perr.Value != "boom" {
+		t.Fatalf("unexpected results: %v", res)
+	}
+	res = p.Advice(rttest.NewContext(fnJoinPoint(), func(x int) (int, error) { return x, nil }, 42))
+	if res[0] != 42 || res[1] != nil {
+		t.Fatalf("unexpected results: %v", res)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var sleeps []time.Duration
+	r := &Retry{
+		MaxAttempts: 4,
+		Sleep:       func(d time.Duration) { sleeps = append(sleeps, d) },
+	}
+	calls := 0
+	fn := func(x int) (int, error) {
+		calls++
+		if calls < 3 {
+			return 0, errors.New("transient")
+		}
+		return x, nil
+	}
+	res := r.Advice(rttest.NewContext(fnJoinPoint(), fn, 42))
+	if res[0] != 42 || res[1] != nil || calls != 3 {
+		t.Fatalf("unexpected results: %v (calls=%d)", res, calls)
+	}
+	if len(sleeps) != 2 || sleeps[0] != 10*time.Millisecond || sleeps[1] != 20*time.Millisecond {
+		t.Fatalf("unexpected backoff: %v", sleeps)
+	}
+
+	calls = 0
+	ctx := rttest.NewContext(fnJoinPoint(), fn, 42)
+	ctx.JoinPoint().Annotations = []*aspect.Annotation{{Name: "retry", Params: map[string]string{"max": "2"}}}
+	res = r.Advice(ctx)
+	if res[1] == nil || calls != 2 {
+		t.Fatalf("unexpected results: %v (calls=%d)", res, calls)
+	}
+
+	calls = 0
+	r.Retryable = func(error) bool { return false }
+	res = r.Advice(rttest.NewContext(fnJoinPoint(), fn, 42))
+	if res[1] == nil || calls != 1 {
+		t.Fatalf("unexpected results: %v (calls=%d)", res, calls)
+	}
+}
+
+func TestMemoizer(t *testing.T) {
+	m := &Memoizer{}
+	calls := 0
+	fn := func(x int) (int, error) {
+		calls++
+		return x * x, nil
+	}
+	for i := 0; i < 3; i++ {
+		for _, x := range []int{2, 3} {
+			res := m.Advice(rttest.NewContext(fnJoinPoint(), fn, x))
+			if res[0] != x*x {
+				t.Fatalf("unexpected results: %v", res)
+			}
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 calls, got %d", calls)
+	}
+}
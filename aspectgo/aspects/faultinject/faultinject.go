@@ -0,0 +1,333 @@
+// Package faultinject provides a fault-injection aspect for AspectGo.
+//
+// Faults are injected according to a Schedule, which is written in JSON or YAML:
+//
+//	{
+//	  "seed": 42,
+//	  "rules": [
+//	    {"joinpoint": "example\\.com/db\\.Query", "fault": "error", "error": "connection reset", "probability": 0.3},
+//	    {"joinpoint": "\\(\\*example\\.com/db\\.Conn\\)\\.Close", "fault": "delay", "delay": "100ms", "after": 2, "times": 1},
+//	    {"joinpoint": "example\\.com/cache\\.Put", "fault": "drop"},
+//	    {"joinpoint": "example\\.com/worker\\.Run", "pos": "worker/main\\.go:42:", "fault": "panic", "panic": "oops"}
+//	  ]
+//	}
+//
+// or, in YAML (the block-style subset parsed by the aspectgo config files):
+//
+//	seed: 42
+//	rules:
+//	  - joinpoint: 'example\.com/db\.Query'
+//	    fault: error
+//	    error: connection reset
+//	    probability: 0.3
+//
+// The decisions of a rule are made for each joinpoint (the name and the position)
+// that the rule matches, with its own random number generator and counters.
+// So the faults are deterministic for a given seed, as long as the sequence of
+// the calls to each joinpoint is deterministic, regardless of the interleaving
+// of the calls to different joinpoints.
+// So a flaky-bug reproduction can be replayed in CI by fixing the seed.
+//
+// To use the aspect, embed Injector into an aspect structure in an aspect file,
+// and set $ASPECTGO_FAULT_SCHEDULE to the schedule file (.json, .yaml or .yml) on runtime.
+package faultinject
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/aspectgo/aspect"
+	"golang.org/x/exp/aspectgo/internal/node"
+)
+
+// ScheduleEnv is the environment variable for the schedule file
+// used by DefaultSchedule.
+const ScheduleEnv = "ASPECTGO_FAULT_SCHEDULE"
+
+// Fault is the type for the kind of faults.
+type Fault string
+
+const (
+	// FaultError replaces the returned error with an *Error.
+	// It is ignored for functions that do not return an error.
+	FaultError Fault = "error"
+
+	// FaultDelay sleeps before calling the function.
+	FaultDelay Fault = "delay"
+
+	// FaultPanic panics with an *Error instead of calling the function.
+	FaultPanic Fault = "panic"
+
+	// FaultDrop does not call the function, and returns zero values.
+	FaultDrop Fault = "drop"
+)
+
+// Rule is a rule in the schedule.
+type Rule struct {
+	// JoinPoint is the regexp for aspect.JoinPoint.Name.
+	JoinPoint string `json:"joinpoint"`
+
+	// Pos is the optional regexp for aspect.JoinPoint.Pos.
+	Pos string `json:"pos,omitempty"`
+
+	// Fault is the kind of the fault.
+	Fault Fault `json:"fault"`
+
+	// Error is the message for FaultError.
+	Error string `json:"error,omitempty"`
+
+	// Delay is the duration for FaultDelay, e.g. "100ms".
+	Delay string `json:"delay,omitempty"`
+
+	// Panic is the message for FaultPanic.
+	Panic string `json:"panic,omitempty"`
+
+	// Probability is the probability of the injection, in (0, 1].
+	// Zero means 1.
+	Probability float64 `json:"probability,omitempty"`
+
+	// After skips the first After calls to each joinpoint that matches the rule.
+	After int `json:"after,omitempty"`
+
+	// Times is the maximum number of the injections to each joinpoint.
+	// Zero means no limit.
+	Times int `json:"times,omitempty"`
+
+	jpRe, posRe *regexp.Regexp
+	delay       time.Duration
+	seed        int64
+	// states are keyed by the name and the position of the joinpoint.
+	states map[string]*ruleState
+}
+
+// ruleState is the state of a rule for a joinpoint.
+type ruleState struct {
+	rand     *rand.Rand
+	calls    int
+	injected int
+}
+
+// Schedule is the fault-injection schedule.
+type Schedule struct {
+	// Seed is the seed for the random number generators.
+	Seed int64 `json:"seed"`
+
+	// Rules are the rules. The first matching rule is applied.
+	Rules []*Rule `json:"rules"`
+
+	// Verbose logs every injection.
+	Verbose bool `json:"verbose,omitempty"`
+
+	mu sync.Mutex
+}
+
+// ParseSchedule parses a JSON schedule.
+func ParseSchedule(b []byte) (*Schedule, error) {
+	var s Schedule
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+	if err := s.init(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// ParseYAMLSchedule parses a YAML schedule.
+// The fields are the same as the JSON schedule.
+func ParseYAMLSchedule(b []byte) (*Schedule, error) {
+	n, err := node.ParseYAML(b)
+	if err != nil {
+		return nil, err
+	}
+	j, err := json.Marshal(n.Interface())
+	if err != nil {
+		return nil, err
+	}
+	return ParseSchedule(j)
+}
+
+// LoadSchedule loads a schedule file.
+// The file is parsed as YAML if the extension is .yaml or .yml,
+// and as JSON otherwise.
+func LoadSchedule(filename string) (*Schedule, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	parse := ParseSchedule
+	switch filepath.Ext(filename) {
+	case ".yaml", ".yml":
+		parse = ParseYAMLSchedule
+	}
+	s, err := parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return s, nil
+}
+
+func (s *Schedule) init() error {
+	for i, r := range s.Rules {
+		var err error
+		if r.jpRe, err = regexp.Compile(r.JoinPoint); err != nil {
+			return fmt.Errorf("rule %d: invalid joinpoint: %s", i, err)
+		}
+		if r.Pos != "" {
+			if r.posRe, err = regexp.Compile(r.Pos); err != nil {
+				return fmt.Errorf("rule %d: invalid pos: %s", i, err)
+			}
+		}
+		switch r.Fault {
+		case FaultError, FaultPanic, FaultDrop:
+		case FaultDelay:
+			if r.delay, err = time.ParseDuration(r.Delay); err != nil {
+				return fmt.Errorf("rule %d: invalid delay: %s", i, err)
+			}
+		default:
+			return fmt.Errorf("rule %d: unknown fault %q", i, r.Fault)
+		}
+		if r.Probability < 0 || r.Probability > 1 {
+			return fmt.Errorf("rule %d: invalid probability %v", i, r.Probability)
+		}
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%d/%s", i, r.JoinPoint)
+		r.seed = s.Seed ^ int64(h.Sum64())
+		r.states = make(map[string]*ruleState)
+	}
+	return nil
+}
+
+// state returns the state of r for jp.
+// Each joinpoint has its own generator so that the decisions for
+// a joinpoint are not affected by the calls to other joinpoints.
+func (r *Rule) state(jp *aspect.JoinPoint) *ruleState {
+	key := jp.Name + " " + jp.Pos
+	st, ok := r.states[key]
+	if !ok {
+		h := fnv.New64a()
+		fmt.Fprint(h, key)
+		st = &ruleState{rand: rand.New(rand.NewSource(r.seed ^ int64(h.Sum64())))}
+		r.states[key] = st
+	}
+	return st
+}
+
+func (r *Rule) matches(jp *aspect.JoinPoint) bool {
+	if !r.jpRe.MatchString(jp.Name) {
+		return false
+	}
+	return r.posRe == nil || r.posRe.MatchString(jp.Pos)
+}
+
+// decide returns the rule to be applied to the call, or nil.
+func (s *Schedule) decide(jp *aspect.JoinPoint) *Rule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.Rules {
+		if !r.matches(jp) {
+			continue
+		}
+		st := r.state(jp)
+		st.calls++
+		if st.calls <= r.After {
+			return nil
+		}
+		if r.Times > 0 && st.injected >= r.Times {
+			return nil
+		}
+		if r.Probability > 0 && st.rand.Float64() >= r.Probability {
+			return nil
+		}
+		st.injected++
+		return r
+	}
+	return nil
+}
+
+var (
+	defaultSchedule     *Schedule
+	defaultScheduleOnce sync.Once
+)
+
+// DefaultSchedule returns the schedule loaded from $ASPECTGO_FAULT_SCHEDULE.
+// If $ASPECTGO_FAULT_SCHEDULE is not set, the schedule is empty.
+// It panics if the schedule cannot be loaded.
+func DefaultSchedule() *Schedule {
+	defaultScheduleOnce.Do(func() {
+		filename := os.Getenv(ScheduleEnv)
+		if filename == "" {
+			defaultSchedule = &Schedule{}
+			return
+		}
+		s, err := LoadSchedule(filename)
+		if err != nil {
+			panic(fmt.Errorf("faultinject: %s", err))
+		}
+		defaultSchedule = s
+	})
+	return defaultSchedule
+}
+
+// Error is the error injected by FaultError,
+// and the value passed to panic() by FaultPanic.
+type Error struct {
+	// JoinPoint is the joinpoint.
+	JoinPoint *aspect.JoinPoint
+	// Fault is the kind of the fault.
+	Fault Fault
+	// Message is the message.
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("injected %s in %s: %s", e.Fault, e.JoinPoint.Name, e.Message)
+}
+
+// Injector is an aspect that injects faults.
+type Injector struct {
+	// Schedule is the schedule. If nil, DefaultSchedule() is used.
+	Schedule *Schedule
+}
+
+// Advice injects a fault, if the schedule says so.
+func (inj *Injector) Advice(ctx aspect.Context) []interface{} {
+	s := inj.Schedule
+	if s == nil {
+		s = DefaultSchedule()
+	}
+	jp := ctx.JoinPoint()
+	r := s.decide(jp)
+	if r == nil {
+		return ctx.Call(ctx.Args())
+	}
+	if s.Verbose {
+		log.Printf("faultinject: injecting %s to %s", r.Fault, jp)
+	}
+	switch r.Fault {
+	case FaultError:
+		res := ctx.Call(ctx.Args())
+		if jp.ErrorResult {
+			res[len(res)-1] = &Error{JoinPoint: jp, Fault: r.Fault, Message: r.Error}
+		}
+		return res
+	case FaultDelay:
+		time.Sleep(r.delay)
+		return ctx.Call(ctx.Args())
+	case FaultPanic:
+		panic(&Error{JoinPoint: jp, Fault: r.Fault, Message: r.Panic})
+	case FaultDrop:
+		// nil is converted to the zero value by the woven proxy
+		return make([]interface{}, jp.NumResults)
+	}
+	panic(fmt.Errorf("faultinject: impl error: unknown fault %q", r.Fault))
+}
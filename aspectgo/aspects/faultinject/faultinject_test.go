@@ -0,0 +1,216 @@
+package faultinject
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/exp/aspectgo/aspect"
+	"golang.org/x/exp/aspectgo/aspect/rt/rttest"
+)
+
+// joinPoint returns the join point of the call to name, which returns (int, error).
+func joinPoint(name string) *aspect.JoinPoint {
+	return &aspect.JoinPoint{
+		Name:        name,
+		Pos:         "example.com/foo/main.go:42:2",
+		NumResults:  2,
+		ErrorResult: true,
+	}
+}
+
+// counted returns the function that counts the calls.
+func counted(calls *int) func() (int, error) {
+	return func() (int, error) {
+		*calls++
+		return 42, nil
+	}
+}
+
+const testSchedule = `{
+  "seed": 42,
+  "rules": [
+    {"joinpoint": "^example\\.com/foo\\.Get$", "fault": "error", "error": "injected", "probability": 0.5},
+    {"joinpoint": "^example\\.com/foo\\.Put$", "fault": "drop", "after": 1, "times": 2},
+    {"joinpoint": "^example\\.com/foo\\.Del$", "fault": "panic", "panic": "oops"}
+  ]
+}`
+
+// testYAMLSchedule is the same as testSchedule.
+const testYAMLSchedule = `
+seed: 42
+rules:
+  - joinpoint: '^example\.com/foo\.Get$'
+    fault: error
+    error: injected
+    probability: 0.5
+  - joinpoint: '^example\.com/foo\.Put$'
+    fault: drop
+    after: 1
+    times: 2
+  - joinpoint: '^example\.com/foo\.Del$'
+    fault: panic
+    panic: oops
+`
+
+func pattern(t *testing.T) []bool {
+	s, err := ParseSchedule([]byte(testSchedule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schedulePattern(t, s)
+}
+
+func schedulePattern(t *testing.T, s *Schedule) []bool {
+	inj := &Injector{Schedule: s}
+	var injected []bool
+	calls := 0
+	for i := 0; i < 32; i++ {
+		res := inj.Advice(rttest.NewContext(joinPoint("example.com/foo.Get"), counted(&calls)))
+		_, ok := res[1].(*Error)
+		injected = append(injected, ok)
+	}
+	if calls != 32 {
+		t.Fatalf("expected 32 calls, got %d", calls)
+	}
+	return injected
+}
+
+func TestErrorIsDeterministic(t *testing.T) {
+	p1, p2 := pattern(t), pattern(t)
+	n := 0
+	for i := range p1 {
+		if p1[i] != p2[i] {
+			t.Fatalf("not deterministic: %v vs %v", p1, p2)
+		}
+		if p1[i] {
+			n++
+		}
+	}
+	if n == 0 || n == len(p1) {
+		t.Fatalf("unexpected pattern: %v", p1)
+	}
+}
+
+func TestDrop(t *testing.T) {
+	s, err := ParseSchedule([]byte(testSchedule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inj := &Injector{Schedule: s}
+	calls := 0
+	for i := 0; i < 4; i++ {
+		res := inj.Advice(rttest.NewContext(joinPoint("example.com/foo.Put"), counted(&calls)))
+		if len(res) != 2 {
+			t.Fatalf("unexpected results: %v", res)
+		}
+	}
+	// 1st: skipped by "after", 2nd and 3rd: dropped, 4th: exceeds "times"
+	if calls != 2 {
+		t.Fatalf("expected 2 calls, got %d", calls)
+	}
+}
+
+func TestPerJoinPoint(t *testing.T) {
+	p1 := pattern(t)
+	s, err := ParseSchedule([]byte(testSchedule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inj := &Injector{Schedule: s}
+	// the calls to another position do not affect the decisions for joinPoint
+	other := joinPoint("example.com/foo.Get")
+	other.Pos = "example.com/foo/main.go:43:2"
+	var p2 []bool
+	calls := 0
+	for i := range p1 {
+		for j := 0; j < i%3; j++ {
+			inj.Advice(rttest.NewContext(other, counted(&calls)))
+		}
+		res := inj.Advice(rttest.NewContext(joinPoint("example.com/foo.Get"), counted(&calls)))
+		_, ok := res[1].(*Error)
+		p2 = append(p2, ok)
+	}
+	if !reflect.DeepEqual(p1, p2) {
+		t.Fatalf("expected %v, got %v", p1, p2)
+	}
+	// "after" and "times" are counted for each joinpoint
+	calls = 0
+	for i := 0; i < 4; i++ {
+		for _, pos := range []string{"main.go:1:1", "main.go:2:1"} {
+			jp := joinPoint("example.com/foo.Put")
+			jp.Pos = pos
+			inj.Advice(rttest.NewContext(jp, counted(&calls)))
+		}
+	}
+	if calls != 4 {
+		t.Fatalf("expected 4 calls, got %d", calls)
+	}
+}
+
+func TestPanic(t *testing.T) {
+	s, err := ParseSchedule([]byte(testSchedule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inj := &Injector{Schedule: s}
+	defer func() {
+		ierr, ok := recover().(*Error)
+		if !ok || ierr.Fault != FaultPanic || ierr.Message != "oops" {
+			t.Fatalf("unexpected panic: %v", ierr)
+		}
+	}()
+	calls := 0
+	inj.Advice(rttest.NewContext(joinPoint("example.com/foo.Del"), counted(&calls)))
+	t.Fatal("should not reach here")
+}
+
+func TestParseYAMLSchedule(t *testing.T) {
+	js, err := ParseSchedule([]byte(testSchedule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "faultinject")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "schedule.yaml")
+	if err := ioutil.WriteFile(fname, []byte(testYAMLSchedule), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ys, err := LoadSchedule(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ys.Seed != js.Seed || len(ys.Rules) != len(js.Rules) {
+		t.Fatalf("unexpected schedule: %+v", ys)
+	}
+	for i, r := range ys.Rules {
+		if r.JoinPoint != js.Rules[i].JoinPoint || r.Fault != js.Rules[i].Fault ||
+			r.After != js.Rules[i].After || r.Times != js.Rules[i].Times {
+			t.Fatalf("unexpected rule %d: %+v", i, r)
+		}
+	}
+	if p1, p2 := schedulePattern(t, js), schedulePattern(t, ys); !reflect.DeepEqual(p1, p2) {
+		t.Fatalf("expected %v, got %v", p1, p2)
+	}
+	if _, err := ParseYAMLSchedule([]byte("rules:\n\t- x\n")); err == nil {
+		t.Fatal("expected an error for tabs")
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, s := range []string{
+		`{"rules": [{"joinpoint": "(", "fault": "drop"}]}`,
+		`{"rules": [{"joinpoint": "x", "fault": "unknown"}]}`,
+		`{"rules": [{"joinpoint": "x", "fault": "delay", "delay": "forever"}]}`,
+		`{"rules": [{"joinpoint": "x", "fault": "drop", "probability": 2}]}`,
+	} {
+		if _, err := ParseSchedule([]byte(s)); err == nil {
+			t.Fatalf("expected an error for %s", s)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package aspects
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/aspectgo/aspect"
+)
+
+// DefaultBuckets are the default upper bounds (in seconds) of the histogram buckets.
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram is a latency histogram.
+type Histogram struct {
+	// Buckets are the upper bounds (in seconds) of the buckets.
+	Buckets []float64
+	// Counts are the cumulative counts for Buckets.
+	Counts []uint64
+	// Count is the total count.
+	Count uint64
+	// Sum is the total latency in seconds.
+	Sum float64
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	sec := d.Seconds()
+	for i, b := range h.Buckets {
+		if sec <= b {
+			h.Counts[i]++
+		}
+	}
+	h.Count++
+	h.Sum += sec
+}
+
+// Histograms is a set of latency histograms, keyed by the function name.
+// Histograms implements expvar.Var.
+type Histograms struct {
+	// Name is the metric name for WritePrometheus.
+	Name    string
+	buckets []float64
+	mu      sync.Mutex
+	m       map[string]*Histogram
+}
+
+// NewHistograms creates Histograms with the metric name and the buckets.
+// If buckets is nil, DefaultBuckets is used.
+func NewHistograms(name string, buckets []float64) *Histograms {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Histograms{
+		Name:    name,
+		buckets: buckets,
+		m:       make(map[string]*Histogram),
+	}
+}
+
+// DefaultHistograms is the default Histograms used by Latency.
+var DefaultHistograms = NewHistograms("aspectgo_call_duration_seconds", nil)
+
+// Observe records the latency d for the function fn.
+func (hs *Histograms) Observe(fn string, d time.Duration) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	h, ok := hs.m[fn]
+	if !ok {
+		h = &Histogram{
+			Buckets: hs.buckets,
+			Counts:  make([]uint64, len(hs.buckets)),
+		}
+		hs.m[fn] = h
+	}
+	h.observe(d)
+}
+
+// Snapshot returns a copy of the histograms.
+func (hs *Histograms) Snapshot() map[string]Histogram {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	snap := make(map[string]Histogram, len(hs.m))
+	for fn, h := range hs.m {
+		c := *h
+		c.Counts = append([]uint64(nil), h.Counts...)
+		snap[fn] = c
+	}
+	return snap
+}
+
+// String returns the JSON representation of the histograms.
+// String implements expvar.Var.
+func (hs *Histograms) String() string {
+	b, err := json.Marshal(hs.Snapshot())
+	if err != nil {
+		return strconv.Quote(err.Error())
+	}
+	return string(b)
+}
+
+// Publish publishes the histograms to expvar with name.
+func (hs *Histograms) Publish(name string) {
+	expvar.Publish(name, hs)
+}
+
+// WritePrometheus writes the histograms in the Prometheus text exposition format.
+func (hs *Histograms) WritePrometheus(w io.Writer) error {
+	snap := hs.Snapshot()
+	fns := make([]string, 0, len(snap))
+	for fn := range snap {
+		fns = append(fns, fn)
+	}
+	sort.Strings(fns)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# HELP %s Latency of the calls advised by AspectGo.\n", hs.Name)
+	fmt.Fprintf(&b, "# TYPE %s histogram\n", hs.Name)
+	for _, fn := range fns {
+		h := snap[fn]
+		label := fmt.Sprintf("func=%s", strconv.Quote(fn))
+		for i, bound := range h.Buckets {
+			fmt.Fprintf(&b, "%s_bucket{%s,le=\"%s\"} %d\n", hs.Name, label,
+				strconv.FormatFloat(bound, 'g', -1, 64), h.Counts[i])
+		}
+		fmt.Fprintf(&b, "%s_bucket{%s,le=\"+Inf\"} %d\n", hs.Name, label, h.Count)
+		fmt.Fprintf(&b, "%s_sum{%s} %s\n", hs.Name, label,
+			strconv.FormatFloat(h.Sum, 'g', -1, 64))
+		fmt.Fprintf(&b, "%s_count{%s} %d\n", hs.Name, label, h.Count)
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// Latency is an aspect that records the latency of every advised call
+// into histograms.
+type Latency struct {
+	// Histograms is the histograms. If nil, DefaultHistograms is used.
+	Histograms *Histograms
+}
+
+// Advice records the latency of the call.
+func (l *Latency) Advice(ctx aspect.Context) []interface{} {
+	hs := l.Histograms
+	if hs == nil {
+		hs = DefaultHistograms
+	}
+	begin := time.Now()
+	defer func() {
+		hs.Observe(ctx.JoinPoint().Name, time.Since(begin))
+	}()
+	return ctx.Call(ctx.Args())
+}
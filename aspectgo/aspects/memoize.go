@@ -0,0 +1,49 @@
+package aspects
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/exp/aspectgo/aspect"
+)
+
+// Memoizer is an aspect that caches the results of pure functions.
+// The cache is keyed by the function name, the receiver and the arguments
+// formatted with "%#v", so the arguments should be values rather than pointers.
+// Calls that return a non-nil error are not cached.
+//
+// Memoizer is useless for asp.PerCall aspects, as the cache is held in the instance.
+type Memoizer struct {
+	// MaxEntries is the maximum number of the cache entries.
+	// If the cache is full, it is cleared. Zero means no limit.
+	MaxEntries int
+
+	mu    sync.Mutex
+	cache map[string][]interface{}
+}
+
+func memoKey(ctx aspect.Context) string {
+	return fmt.Sprintf("%s|%#v|%#v", ctx.JoinPoint().Name, ctx.Receiver(), ctx.Args())
+}
+
+// Advice returns the cached results if any.
+func (m *Memoizer) Advice(ctx aspect.Context) []interface{} {
+	key := memoKey(ctx)
+	m.mu.Lock()
+	res, ok := m.cache[key]
+	m.mu.Unlock()
+	if ok {
+		return append([]interface{}(nil), res...)
+	}
+	res = ctx.Call(ctx.Args())
+	if lastError(ctx.JoinPoint(), res) != nil {
+		return res
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cache == nil || (m.MaxEntries > 0 && len(m.cache) >= m.MaxEntries) {
+		m.cache = make(map[string][]interface{})
+	}
+	m.cache[key] = append([]interface{}(nil), res...)
+	return res
+}
@@ -0,0 +1,201 @@
+// Package profile provides the profiling aspect that counts the advised calls
+// for each call site, i.e. for each caller→callee edge of the call graph.
+//
+// The counts are written to the JSON file specified by $ASPECTGO_PROFILE:
+//
+//	ASPECTGO_PROFILE=/tmp/profile.json ./woven-program
+//
+// The file is rewritten every Interval while the program is running, and on Flush.
+// `aspectgo callgraph` merges the file with the call sites found by the weaver,
+// so that the call sites never called are reported as uncovered.
+//
+// If $ASPECTGO_PROFILE is not set, the calls are counted but not written.
+package profile
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/exp/aspectgo/aspect"
+)
+
+// Env is the environment variable for the profile file.
+const Env = "ASPECTGO_PROFILE"
+
+// Interval is the interval for writing the profile file.
+const Interval = time.Second
+
+// Edge is the number of the calls at a call site.
+type Edge struct {
+	// Kind is aspect.JoinPoint.Kind.
+	Kind aspect.JoinPointKind `json:"kind"`
+	// Caller is aspect.JoinPoint.Caller.
+	Caller string `json:"caller"`
+	// Callee is aspect.JoinPoint.Name.
+	Callee string `json:"callee"`
+	// Pos is aspect.JoinPoint.Pos.
+	Pos string `json:"pos"`
+	// Count is the number of the calls.
+	Count int64 `json:"count"`
+}
+
+// Profile is the content of the profile file.
+type Profile struct {
+	// Edges are sorted by Caller, Callee and Pos.
+	Edges []*Edge `json:"edges"`
+}
+
+// ReadFile reads the profile file.
+func ReadFile(filename string) (*Profile, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var p Profile
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// WriteFile writes p to the profile file.
+// The file is replaced atomically, so that the reader never sees a partial file.
+func (p *Profile) WriteFile(filename string) error {
+	b, err := json.MarshalIndent(p, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// Counter counts the calls for each joinpoint.
+// The zero value is ready to use.
+type Counter struct {
+	// counts maps *aspect.JoinPoint to *int64.
+	counts sync.Map
+	// total is the number of the calls, which is used for skipping the unchanged profile.
+	total int64
+}
+
+// Add counts a call of jp.
+func (c *Counter) Add(jp *aspect.JoinPoint) {
+	n, ok := c.counts.Load(jp)
+	if !ok {
+		n, _ = c.counts.LoadOrStore(jp, new(int64))
+	}
+	atomic.AddInt64(n.(*int64), 1)
+	atomic.AddInt64(&c.total, 1)
+}
+
+// Total returns the number of the calls counted so far.
+func (c *Counter) Total() int64 {
+	return atomic.LoadInt64(&c.total)
+}
+
+// Profile returns the snapshot of the counts.
+func (c *Counter) Profile() *Profile {
+	p := &Profile{Edges: []*Edge{}}
+	c.counts.Range(func(k, v interface{}) bool {
+		jp := k.(*aspect.JoinPoint)
+		p.Edges = append(p.Edges, &Edge{
+			Kind:   jp.Kind,
+			Caller: jp.Caller,
+			Callee: jp.Name,
+			Pos:    jp.Pos,
+			Count:  atomic.LoadInt64(v.(*int64)),
+		})
+		return true
+	})
+	sort.Slice(p.Edges, func(i, j int) bool {
+		a, b := p.Edges[i], p.Edges[j]
+		if a.Caller != b.Caller {
+			return a.Caller < b.Caller
+		}
+		if a.Callee != b.Callee {
+			return a.Callee < b.Callee
+		}
+		return a.Pos < b.Pos
+	})
+	return p
+}
+
+var (
+	defaultCounter Counter
+	defaultWriter  sync.Once
+	defaultWriteMu sync.Mutex
+	writeErrorOnce sync.Once
+)
+
+// Flush writes the counts of the aspects without Counter to $ASPECTGO_PROFILE.
+// The program should call Flush before exiting, as the file is otherwise
+// written only every Interval.
+// Flush does nothing if $ASPECTGO_PROFILE is not set.
+func Flush() error {
+	filename := os.Getenv(Env)
+	if filename == "" {
+		return nil
+	}
+	defaultWriteMu.Lock()
+	defer defaultWriteMu.Unlock()
+	return defaultCounter.Profile().WriteFile(filename)
+}
+
+// startDefaultWriter starts the goroutine that writes the file every Interval.
+func startDefaultWriter() {
+	if os.Getenv(Env) == "" {
+		return
+	}
+	go func() {
+		var written int64
+		for range time.Tick(Interval) {
+			total := defaultCounter.Total()
+			if total == written {
+				continue
+			}
+			if err := Flush(); err != nil {
+				writeErrorOnce.Do(func() {
+					log.Printf("profile: could not write the profile: %s (the later errors are not logged)", err)
+				})
+				continue
+			}
+			written = total
+		}
+	}()
+}
+
+// Aspect is an aspect that counts the calls.
+type Aspect struct {
+	// Counter counts the calls.
+	// If nil, the calls are counted for $ASPECTGO_PROFILE.
+	Counter *Counter
+}
+
+// Advice counts the call, and calls the joinpoint.
+func (a *Aspect) Advice(ctx aspect.Context) []interface{} {
+	c := a.Counter
+	if c == nil {
+		defaultWriter.Do(startDefaultWriter)
+		c = &defaultCounter
+	}
+	c.Add(ctx.JoinPoint())
+	return ctx.Call(ctx.Args())
+}
@@ -0,0 +1,67 @@
+package profile
+
+import (
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"golang.org/x/exp/aspectgo/aspect"
+	"golang.org/x/exp/aspectgo/aspect/rt/rttest"
+)
+
+func TestAspect(t *testing.T) {
+	greet := &aspect.JoinPoint{Kind: aspect.KindCall, Caller: "main.main", Name: "main.greet", Pos: "example.com/foo/main.go:10:2"}
+	shout := &aspect.JoinPoint{Kind: aspect.KindCall, Caller: "main.main", Name: "main.shout", Pos: "example.com/foo/main.go:11:2"}
+	a := &Aspect{Counter: &Counter{}}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.Advice(rttest.NewContext(greet, func() {}))
+		}()
+	}
+	wg.Wait()
+	a.Advice(rttest.NewContext(shout, func() {}))
+	if a.Counter.Total() != 11 {
+		t.Fatalf("unexpected total: %d", a.Counter.Total())
+	}
+	expected := &Profile{Edges: []*Edge{
+		{Kind: aspect.KindCall, Caller: "main.main", Callee: "main.greet", Pos: greet.Pos, Count: 10},
+		{Kind: aspect.KindCall, Caller: "main.main", Callee: "main.shout", Pos: shout.Pos, Count: 1},
+	}}
+	p := a.Counter.Profile()
+	if !reflect.DeepEqual(p, expected) {
+		t.Fatalf("unexpected profile: %+v", p.Edges)
+	}
+
+	filename := filepath.Join(t.TempDir(), "profile.json")
+	if err := p.WriteFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, expected) {
+		t.Fatalf("unexpected profile read: %+v", read.Edges)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "profile.json")
+	t.Setenv(Env, filename)
+	jp := &aspect.JoinPoint{Kind: aspect.KindCall, Caller: "main.main", Name: "main.greet"}
+	(&Aspect{}).Advice(rttest.NewContext(jp, func() {}))
+	if err := Flush(); err != nil {
+		t.Fatal(err)
+	}
+	p, err := ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Edges) != 1 || p.Edges[0].Callee != "main.greet" || p.Edges[0].Count != 1 {
+		t.Fatalf("unexpected profile: %+v", p.Edges)
+	}
+}
@@ -0,0 +1,56 @@
+package aspects
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"golang.org/x/exp/aspectgo/aspect"
+)
+
+// PanicError is the error converted from a panic by PanicToError.
+type PanicError struct {
+	// JoinPoint is the joinpoint that panicked.
+	JoinPoint *aspect.JoinPoint
+	// Value is the value passed to panic().
+	Value interface{}
+	// Stack is the stack trace of the panic.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic in %s: %v", e.JoinPoint.Name, e.Value)
+}
+
+// PanicToError is an aspect that converts a panic into an error.
+// The panic is converted only if the last result of the function is an error;
+// otherwise the panic is propagated.
+type PanicToError struct {
+	// OnPanic is called with the converted error, if not nil.
+	OnPanic func(*PanicError)
+}
+
+// Advice calls the function and converts a panic into a *PanicError.
+func (p *PanicToError) Advice(ctx aspect.Context) (res []interface{}) {
+	jp := ctx.JoinPoint()
+	if !jp.ErrorResult {
+		return ctx.Call(ctx.Args())
+	}
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		perr := &PanicError{
+			JoinPoint: jp,
+			Value:     r,
+			Stack:     debug.Stack(),
+		}
+		if p.OnPanic != nil {
+			p.OnPanic(perr)
+		}
+		// other results are set to their zero values
+		res = make([]interface{}, jp.NumResults)
+		res[jp.NumResults-1] = perr
+	}()
+	return ctx.Call(ctx.Args())
+}
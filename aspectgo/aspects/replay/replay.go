@@ -0,0 +1,344 @@
+// Package replay provides the record-and-replay aspect for AspectGo.
+//
+// In the record mode, the aspect serializes the sequence of the advised calls
+// (joinpoint, goroutine, arguments and results) to a gob log.
+// In the replay mode, the aspect enforces the recorded interleaving of the
+// advised calls across goroutines, using the woven proxies as scheduling points.
+// A call is identified by the joinpoint and the arguments, and each goroutine
+// is bound to a recorded goroutine on its first call.
+//
+// The mode is specified by $ASPECTGO_REPLAY:
+//
+//	ASPECTGO_REPLAY=record:/tmp/trace.gob ./woven-program
+//	ASPECTGO_REPLAY=replay:/tmp/trace.gob ./woven-program
+//
+// If $ASPECTGO_REPLAY is not set, the aspect just calls the joinpoint.
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/aspectgo/aspect"
+	"golang.org/x/exp/aspectgo/internal/goid"
+)
+
+// Env is the environment variable for the mode and the log file.
+const Env = "ASPECTGO_REPLAY"
+
+// Kind is the type for the kind of the events.
+type Kind int
+
+const (
+	// Call is the event for the beginning of a call.
+	Call Kind = iota
+	// Return is the event for the end of a call.
+	Return
+)
+
+// Event is an event in the log.
+type Event struct {
+	// Seq is the sequence number of the call.
+	// Call and Return events for the same call share Seq.
+	Seq int64
+	// Kind is the kind of the event.
+	Kind Kind
+	// JoinPoint is aspect.JoinPoint.Name.
+	JoinPoint string
+	// Pos is aspect.JoinPoint.Pos.
+	Pos string
+	// Goroutine is the ID of the goroutine in the recorded run.
+	Goroutine int64
+	// Values are the arguments for Call events, and the results for Return events.
+	// Values that cannot be encoded with gob are recorded as Opaque.
+	Values []interface{}
+}
+
+// Opaque is recorded for a value that cannot be encoded with gob.
+type Opaque struct {
+	// Type is the type of the value.
+	Type string
+	// Repr is the representation of the value (%#v).
+	Repr string
+}
+
+func init() {
+	gob.Register(Opaque{})
+}
+
+// encodable replaces the values that cannot be encoded with gob with Opaque.
+func encodable(values []interface{}) []interface{} {
+	res := make([]interface{}, len(values))
+	for i, v := range values {
+		if v != nil {
+			if err := gob.NewEncoder(io.Discard).Encode(&v); err != nil {
+				v = Opaque{Type: fmt.Sprintf("%T", v), Repr: fmt.Sprintf("%#v", v)}
+			}
+		}
+		res[i] = v
+	}
+	return res
+}
+
+// Recorder records events.
+type Recorder struct {
+	mu  sync.Mutex
+	w   *bufio.Writer
+	enc *gob.Encoder
+	seq int64
+	err error
+}
+
+// NewRecorder creates a Recorder that writes the log to w.
+func NewRecorder(w io.Writer) *Recorder {
+	bw := bufio.NewWriter(w)
+	return &Recorder{w: bw, enc: gob.NewEncoder(bw)}
+}
+
+func (rec *Recorder) record(ev *Event) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if ev.Kind == Call {
+		rec.seq++
+		ev.Seq = rec.seq
+	}
+	if rec.err != nil {
+		return
+	}
+	// flush every event, so that the log survives a crash
+	if rec.err = rec.enc.Encode(ev); rec.err == nil {
+		rec.err = rec.w.Flush()
+	}
+	if rec.err != nil {
+		log.Printf("replay: could not record the event: %s", rec.err)
+	}
+}
+
+// Err returns the first error while recording.
+func (rec *Recorder) Err() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return rec.err
+}
+
+// Advice records the call.
+func (rec *Recorder) Advice(ctx aspect.Context) []interface{} {
+	jp := ctx.JoinPoint()
+	args := ctx.Args()
+	ev := &Event{
+		Kind:      Call,
+		JoinPoint: jp.Name,
+		Pos:       jp.Pos,
+		Goroutine: goid.ID(),
+		Values:    encodable(args),
+	}
+	rec.record(ev)
+	res := ctx.Call(args)
+	rec.record(&Event{
+		Seq:       ev.Seq,
+		Kind:      Return,
+		JoinPoint: ev.JoinPoint,
+		Pos:       ev.Pos,
+		Goroutine: ev.Goroutine,
+		Values:    encodable(res),
+	})
+	return res
+}
+
+// ReadEvents reads all the events from r.
+func ReadEvents(r io.Reader) ([]*Event, error) {
+	dec := gob.NewDecoder(r)
+	var events []*Event
+	for {
+		var ev Event
+		err := dec.Decode(&ev)
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return events, err
+		}
+		events = append(events, &ev)
+	}
+}
+
+// DefaultTimeout is the default value for Replayer.Timeout.
+const DefaultTimeout = 3 * time.Second
+
+// Replayer enforces the recorded interleaving.
+type Replayer struct {
+	// Timeout is the timeout for waiting for the turn of a call.
+	// On timeout, the replayer gives up enforcing the interleaving.
+	Timeout time.Duration
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	calls    []*Event
+	next     int
+	gids     map[int64]int64 // recorded goroutine -> current goroutine
+	bound    map[int64]bool  // current goroutines bound to recorded ones
+	diverged bool
+}
+
+// NewReplayer creates a Replayer from the recorded events.
+func NewReplayer(events []*Event) *Replayer {
+	rep := &Replayer{
+		Timeout: DefaultTimeout,
+		gids:    make(map[int64]int64),
+		bound:   make(map[int64]bool),
+	}
+	rep.cond = sync.NewCond(&rep.mu)
+	for _, ev := range events {
+		if ev.Kind == Call {
+			rep.calls = append(rep.calls, ev)
+		}
+	}
+	return rep
+}
+
+// valuesMatch returns true if the current values match the recorded ones.
+// Opaque values match any value.
+func valuesMatch(recorded, current []interface{}) bool {
+	if len(recorded) != len(current) {
+		return false
+	}
+	current = encodable(current)
+	for i := range recorded {
+		if _, ok := recorded[i].(Opaque); ok {
+			continue
+		}
+		if !reflect.DeepEqual(recorded[i], current[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// turn returns true if it is the turn of the goroutine gid to call jp with args.
+// turn binds gid to the recorded goroutine on its first turn.
+func (rep *Replayer) turn(jp *aspect.JoinPoint, args []interface{}, gid int64) bool {
+	ev := rep.calls[rep.next]
+	if ev.JoinPoint != jp.Name || ev.Pos != jp.Pos || !valuesMatch(ev.Values, args) {
+		return false
+	}
+	cur, ok := rep.gids[ev.Goroutine]
+	if ok {
+		return cur == gid
+	}
+	if rep.bound[gid] {
+		return false
+	}
+	rep.gids[ev.Goroutine] = gid
+	rep.bound[gid] = true
+	return true
+}
+
+func (rep *Replayer) wait(jp *aspect.JoinPoint, args []interface{}) {
+	gid := goid.ID()
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	var timedOut bool
+	timer := time.AfterFunc(rep.Timeout, func() {
+		rep.mu.Lock()
+		timedOut = true
+		rep.mu.Unlock()
+		rep.cond.Broadcast()
+	})
+	defer timer.Stop()
+	for !rep.diverged && rep.next < len(rep.calls) {
+		if rep.turn(jp, args, gid) {
+			rep.next++
+			rep.cond.Broadcast()
+			return
+		}
+		if timedOut {
+			log.Printf("replay: diverged at call #%d (expected %s (%s), got %s), giving up replaying",
+				rep.next+1, rep.calls[rep.next].JoinPoint, rep.calls[rep.next].Pos, jp)
+			rep.diverged = true
+			rep.cond.Broadcast()
+			return
+		}
+		rep.cond.Wait()
+	}
+}
+
+// Advice waits for the turn of the call, and calls the joinpoint.
+func (rep *Replayer) Advice(ctx aspect.Context) []interface{} {
+	args := ctx.Args()
+	rep.wait(ctx.JoinPoint(), args)
+	return ctx.Call(args)
+}
+
+var (
+	defaultAdvice     func(aspect.Context) []interface{}
+	defaultAdviceOnce sync.Once
+)
+
+// parseEnv parses the value of $ASPECTGO_REPLAY.
+func parseEnv(s string) (mode, filename string, err error) {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return "", "", fmt.Errorf("%s must be \"record:FILE\" or \"replay:FILE\": %q", Env, s)
+	}
+	mode, filename = s[:i], s[i+1:]
+	if mode != "record" && mode != "replay" {
+		return "", "", fmt.Errorf("unknown mode %q in %s", mode, Env)
+	}
+	return mode, filename, nil
+}
+
+func newDefaultAdvice() (func(aspect.Context) []interface{}, error) {
+	s := os.Getenv(Env)
+	if s == "" {
+		return func(ctx aspect.Context) []interface{} {
+			return ctx.Call(ctx.Args())
+		}, nil
+	}
+	mode, filename, err := parseEnv(s)
+	if err != nil {
+		return nil, err
+	}
+	if mode == "record" {
+		f, err := os.Create(filename)
+		if err != nil {
+			return nil, err
+		}
+		// f is never closed, as the recorder flushes every event
+		return NewRecorder(f).Advice, nil
+	}
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	events, err := ReadEvents(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return NewReplayer(events).Advice, nil
+}
+
+// Aspect is an aspect that records or replays the calls,
+// depending on $ASPECTGO_REPLAY.
+type Aspect struct {
+}
+
+// Advice records or replays the call.
+func (a *Aspect) Advice(ctx aspect.Context) []interface{} {
+	defaultAdviceOnce.Do(func() {
+		var err error
+		defaultAdvice, err = newDefaultAdvice()
+		if err != nil {
+			panic(fmt.Errorf("replay: %s", err))
+		}
+	})
+	return defaultAdvice(ctx)
+}
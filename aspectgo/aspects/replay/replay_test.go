@@ -0,0 +1,96 @@
+package replay
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/aspectgo/aspect"
+	"golang.org/x/exp/aspectgo/aspect/rt/rttest"
+)
+
+// joinPoint returns the join point of the call to name, which returns int.
+func joinPoint(name string) *aspect.JoinPoint {
+	return &aspect.JoinPoint{
+		Name:       name,
+		Pos:        "example.com/foo/main.go:42:2",
+		NumResults: 1,
+	}
+}
+
+// run calls "w0" .. "w{n-1}" from n goroutines, with the advice,
+// and returns the order of the calls.
+// delays[i] is the delay before the goroutine i calls.
+func run(advice func(aspect.Context) []interface{}, delays []time.Duration) []string {
+	var (
+		mu    sync.Mutex
+		order []string
+		wg    sync.WaitGroup
+	)
+	for i, d := range delays {
+		wg.Add(1)
+		name := string(rune('a' + i))
+		go func(d time.Duration) {
+			defer wg.Done()
+			time.Sleep(d)
+			advice(rttest.NewContext(joinPoint(name), func(x int) int {
+				mu.Lock()
+				order = append(order, name)
+				mu.Unlock()
+				return x * 2
+			}, 21))
+		}(d)
+	}
+	wg.Wait()
+	return order
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	var b bytes.Buffer
+	rec := NewRecorder(&b)
+	ms := time.Millisecond
+	recorded := run(rec.Advice, []time.Duration{30 * ms, 0, 15 * ms})
+	if err := rec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if s := fmtOrder(recorded); s != "bca" {
+		t.Fatalf("unexpected recorded order %s", s)
+	}
+	events, err := ReadEvents(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 6 {
+		t.Fatalf("expected 6 events, got %d", len(events))
+	}
+	ret := events[1]
+	if ret.Kind != Return || ret.Seq != events[0].Seq || ret.Values[0] != 42 {
+		t.Fatalf("unexpected event: %+v", ret)
+	}
+
+	// the delays are reversed, but the recorded order should be enforced
+	rep := NewReplayer(events)
+	replayed := run(rep.Advice, []time.Duration{0, 30 * ms, 15 * ms})
+	if s := fmtOrder(replayed); s != "bca" {
+		t.Fatalf("unexpected replayed order %s", s)
+	}
+}
+
+func fmtOrder(order []string) string {
+	s := ""
+	for _, o := range order {
+		s += o
+	}
+	return s
+}
+
+func TestEncodable(t *testing.T) {
+	values := encodable([]interface{}{42, "foo", func() {}, nil})
+	if values[0] != 42 || values[1] != "foo" || values[3] != nil {
+		t.Fatalf("unexpected values: %v", values)
+	}
+	if o, ok := values[2].(Opaque); !ok || o.Type != "func()" {
+		t.Fatalf("unexpected value: %v", values[2])
+	}
+}
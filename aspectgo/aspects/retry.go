@@ -0,0 +1,76 @@
+package aspects
+
+import (
+	"strconv"
+	"time"
+
+	"golang.org/x/exp/aspectgo/aspect"
+)
+
+// Retry is an aspect that retries a call while it returns a non-nil error.
+// Functions that do not return an error are called just once.
+type Retry struct {
+	// MaxAttempts is the maximum number of the attempts. The default is 3.
+	// It is overridden by the "max" parameter of the `//aspect:retry` annotation
+	// on the function, if any.
+	MaxAttempts int
+
+	// InitialBackoff is the backoff before the first retry. The default is 10ms.
+	InitialBackoff time.Duration
+
+	// MaxBackoff is the maximum backoff. The default is 1s.
+	MaxBackoff time.Duration
+
+	// Multiplier is the multiplier for the backoff. The default is 2.
+	Multiplier float64
+
+	// Retryable returns true if err is retryable.
+	// If nil, all the errors are retryable.
+	Retryable func(err error) bool
+
+	// Sleep is used for sleeping during the backoff.
+	// If nil, time.Sleep is used.
+	Sleep func(time.Duration)
+}
+
+// Advice calls the function, and retries the call with exponential backoff.
+func (r *Retry) Advice(ctx aspect.Context) []interface{} {
+	maxAttempts, backoff, maxBackoff, multiplier, sleep :=
+		r.MaxAttempts, r.InitialBackoff, r.MaxBackoff, r.Multiplier, r.Sleep
+	if a := ctx.JoinPoint().Annotation("retry"); a != nil {
+		if n, err := strconv.Atoi(a.Params["max"]); err == nil {
+			maxAttempts = n
+		}
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = 3
+	}
+	if backoff <= 0 {
+		backoff = 10 * time.Millisecond
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = time.Second
+	}
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+	args := ctx.Args()
+	for attempt := 1; ; attempt++ {
+		res := ctx.Call(args)
+		err := lastError(ctx.JoinPoint(), res)
+		if err == nil || attempt >= maxAttempts {
+			return res
+		}
+		if r.Retryable != nil && !r.Retryable(err) {
+			return res
+		}
+		sleep(backoff)
+		backoff = time.Duration(float64(backoff) * multiplier)
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package span
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Exporter exports the ended spans.
+type Exporter interface {
+	// ExportSpan exports s.
+	// ExportSpan can be called concurrently.
+	ExportSpan(s *Span) error
+}
+
+// Format is the type for the file formats of the spans.
+type Format string
+
+const (
+	// OTLPJSON is the OTLP-JSON format, as written by the file exporter of
+	// OpenTelemetry Collector. Each line is an ExportTraceServiceRequest
+	// that contains one span.
+	OTLPJSON Format = "otlp"
+
+	// Chrome is the JSON array format of the Chrome trace events,
+	// which can be loaded to chrome://tracing or Perfetto.
+	// Each span is a complete ("X") event, and the thread ID is the goroutine ID.
+	Chrome Format = "chrome"
+)
+
+// Formats are the supported formats.
+var Formats = []Format{OTLPJSON, Chrome}
+
+// FileExporter writes the spans to a file.
+type FileExporter struct {
+	mu     sync.Mutex
+	f      *os.File
+	w      *bufio.Writer
+	format Format
+	n      int
+	err    error
+}
+
+// NewFileExporter creates a FileExporter that writes the spans to filename in format.
+//
+// The spans are flushed to the file one by one, so that they survive a crash.
+// For Chrome, the file lacks the closing bracket until Close is called,
+// which is allowed by the format.
+func NewFileExporter(filename string, format Format) (*FileExporter, error) {
+	if format != OTLPJSON && format != Chrome {
+		return nil, fmt.Errorf("unknown format %q (should be one of %v)", format, Formats)
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	e := &FileExporter{f: f, w: bufio.NewWriter(f), format: format}
+	if format == Chrome {
+		e.w.WriteString("[")
+	}
+	return e, nil
+}
+
+// ExportSpan writes s to the file.
+// After an error, the later spans are not written.
+func (e *FileExporter) ExportSpan(s *Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.err != nil {
+		return e.err
+	}
+	var v interface{}
+	switch e.format {
+	case OTLPJSON:
+		v = otlpRequest(s)
+	case Chrome:
+		v = chromeEvent(s)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		e.err = err
+		return err
+	}
+	switch {
+	case e.format == OTLPJSON:
+		b = append(b, '\n')
+	case e.n > 0:
+		e.w.WriteString(",\n")
+	default:
+		e.w.WriteString("\n")
+	}
+	e.w.Write(b)
+	e.n++
+	e.err = e.w.Flush()
+	return e.err
+}
+
+// Close completes and closes the file.
+func (e *FileExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.err == nil && e.format == Chrome {
+		e.w.WriteString("\n]\n")
+		e.err = e.w.Flush()
+	}
+	if err := e.f.Close(); e.err == nil {
+		e.err = err
+	}
+	err := e.err
+	if err == nil {
+		e.err = os.ErrClosed
+	}
+	return err
+}
+
+// parseEnv parses the value of $ASPECTGO_SPAN.
+func parseEnv(s string) (Format, string, error) {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return "", "", fmt.Errorf("%s must be \"FORMAT:FILE\" (FORMAT is one of %v): %q", Env, Formats, s)
+	}
+	return Format(s[:i]), s[i+1:], nil
+}
+
+func newDefaultExporter() (Exporter, error) {
+	s := os.Getenv(Env)
+	if s == "" {
+		return nil, nil
+	}
+	format, filename, err := parseEnv(s)
+	if err != nil {
+		return nil, err
+	}
+	// the exporter is never closed, as it flushes every span
+	return NewFileExporter(filename, format)
+}
+
+// OTLP-JSON. See https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+
+type otlpKeyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            *otlpStatus    `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpTraceRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusCodeError  = 2
+	otlpScopeName        = "golang.org/x/exp/aspectgo/aspects/span"
+)
+
+func otlpAttributes(attrs map[string]string) []otlpKeyValue {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var kvs []otlpKeyValue
+	for _, k := range keys {
+		kv := otlpKeyValue{Key: k}
+		kv.Value.StringValue = attrs[k]
+		kvs = append(kvs, kv)
+	}
+	return kvs
+}
+
+func otlpRequest(s *Span) *otlpTraceRequest {
+	sp := otlpSpan{
+		TraceID:           s.TraceID.String(),
+		SpanID:            s.SpanID.String(),
+		Name:              s.Name,
+		Kind:              otlpSpanKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		Attributes:        otlpAttributes(s.Attributes),
+	}
+	if !s.ParentID.IsZero() {
+		sp.ParentSpanID = s.ParentID.String()
+	}
+	if s.Err != "" {
+		sp.Status = &otlpStatus{Code: otlpStatusCodeError, Message: s.Err}
+	}
+	ss := otlpScopeSpans{Spans: []otlpSpan{sp}}
+	ss.Scope.Name = otlpScopeName
+	rs := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{ss}}
+	rs.Resource.Attributes = otlpAttributes(map[string]string{
+		"service.name": filepath.Base(os.Args[0]),
+	})
+	return &otlpTraceRequest{ResourceSpans: []otlpResourceSpans{rs}}
+}
+
+// Chrome trace events, as specified in "Trace Event Format" of the Catapult project.
+
+type chromeTraceEvent struct {
+	Name string            `json:"name"`
+	Cat  string            `json:"cat"`
+	Ph   string            `json:"ph"`
+	Ts   float64           `json:"ts"`
+	Dur  float64           `json:"dur"`
+	Pid  int               `json:"pid"`
+	Tid  int64             `json:"tid"`
+	Args map[string]string `json:"args"`
+}
+
+func chromeEvent(s *Span) *chromeTraceEvent {
+	args := map[string]string{
+		"trace_id": s.TraceID.String(),
+		"span_id":  s.SpanID.String(),
+	}
+	if !s.ParentID.IsZero() {
+		args["parent_id"] = s.ParentID.String()
+	}
+	if s.Err != "" {
+		args["error"] = s.Err
+	}
+	for k, v := range s.Attributes {
+		args[k] = v
+	}
+	return &chromeTraceEvent{
+		Name: s.Name,
+		Cat:  "aspectgo",
+		Ph:   "X",
+		Ts:   float64(s.Start.UnixNano()) / 1e3,
+		Dur:  float64(s.End.Sub(s.Start).Nanoseconds()) / 1e3,
+		Pid:  os.Getpid(),
+		Tid:  s.Goroutine,
+		Args: args,
+	}
+}
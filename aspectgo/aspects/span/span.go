@@ -0,0 +1,250 @@
+// Package span provides the tracing aspect that creates a span for each
+// advised call, in the manner of OpenTelemetry, and exports the spans to a local file.
+//
+// The parent of a span is the span carried by the context.Context arg of the call,
+// or the innermost span of the current goroutine if the arg carries no span.
+// The context.Context arg is replaced with the one carrying the new span,
+// so that the span is propagated to the callee, even across goroutines.
+//
+// The spans are exported as they end, in OTLP-JSON or in the Chrome trace-event format.
+// The exporter is specified by $ASPECTGO_SPAN:
+//
+//	ASPECTGO_SPAN=otlp:/tmp/spans.jsonl ./woven-program
+//	ASPECTGO_SPAN=chrome:/tmp/trace.json ./woven-program
+//
+// If $ASPECTGO_SPAN is not set, the spans are propagated but not exported.
+package span
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/rand"
+	"reflect"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/aspectgo/aspect"
+	"golang.org/x/exp/aspectgo/internal/goid"
+)
+
+// Env is the environment variable for the format and the file of the exporter.
+const Env = "ASPECTGO_SPAN"
+
+// TraceID is the ID of a trace.
+type TraceID [16]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanID is the ID of a span.
+type SpanID [8]byte
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsZero returns true if id is the zero value, i.e. no span.
+func (id SpanID) IsZero() bool {
+	return id == SpanID{}
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	binary.BigEndian.PutUint64(id[:8], rand.Uint64())
+	binary.BigEndian.PutUint64(id[8:], rand.Uint64())
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for id.IsZero() {
+		binary.BigEndian.PutUint64(id[:], rand.Uint64())
+	}
+	return id
+}
+
+// Span is a span for an advised call.
+// Span should NOT be modified after the call.
+type Span struct {
+	TraceID TraceID
+	SpanID  SpanID
+	// ParentID is the ID of the parent span. It is zero for a root span.
+	ParentID SpanID
+
+	// Name is aspect.JoinPoint.ShortName, e.g. "(*http.Client).Get".
+	Name string
+
+	// Start and End are the time when the call began and ended.
+	Start, End time.Time
+
+	// Goroutine is the ID of the goroutine that made the call.
+	Goroutine int64
+
+	// Attributes are the attributes of the span, e.g. "code.function".
+	Attributes map[string]string
+
+	// Err is the error returned by the call, or the value of the panic.
+	// It is empty if the call succeeded.
+	Err string
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of ctx that carries s.
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// FromContext returns the span carried by ctx, or nil.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// stacks are the span stacks, keyed by the goroutine ID.
+var stacks = struct {
+	sync.Mutex
+	m map[int64][]*Span
+}{m: make(map[int64][]*Span)}
+
+func push(gid int64, s *Span) {
+	stacks.Lock()
+	defer stacks.Unlock()
+	stacks.m[gid] = append(stacks.m[gid], s)
+}
+
+func pop(gid int64) {
+	stacks.Lock()
+	defer stacks.Unlock()
+	stack := stacks.m[gid]
+	if len(stack) <= 1 {
+		delete(stacks.m, gid)
+		return
+	}
+	stacks.m[gid] = stack[:len(stack)-1]
+}
+
+// Current returns the innermost span of the current goroutine, or nil.
+func Current() *Span {
+	gid := goid.ID()
+	stacks.Lock()
+	defer stacks.Unlock()
+	stack := stacks.m[gid]
+	if len(stack) == 0 {
+		return nil
+	}
+	return stack[len(stack)-1]
+}
+
+var (
+	defaultExporter     Exporter
+	defaultExporterOnce sync.Once
+	exportErrorOnce     sync.Once
+)
+
+// Aspect is an aspect that creates a span for each call.
+type Aspect struct {
+	// Exporter exports the ended spans.
+	// If nil, the exporter specified by $ASPECTGO_SPAN is used.
+	Exporter Exporter
+}
+
+func (a *Aspect) exporter() Exporter {
+	if a.Exporter != nil {
+		return a.Exporter
+	}
+	defaultExporterOnce.Do(func() {
+		var err error
+		defaultExporter, err = newDefaultExporter()
+		if err != nil {
+			panic(fmt.Errorf("span: %s", err))
+		}
+	})
+	return defaultExporter
+}
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// Advice creates a span for the call, and exports it after the call.
+func (a *Aspect) Advice(ctx aspect.Context) []interface{} {
+	jp := ctx.JoinPoint()
+	gid := goid.ID()
+	s := &Span{
+		SpanID:    newSpanID(),
+		Name:      jp.ShortName,
+		Goroutine: gid,
+		Attributes: map[string]string{
+			"code.function": jp.Name,
+			"aspectgo.kind": string(jp.Kind),
+			"aspectgo.pos":  jp.Pos,
+		},
+	}
+	if s.Name == "" {
+		s.Name = jp.Name
+	}
+	parent := Current()
+	args := ctx.Args()
+	paramTypes := ctx.ParamTypes()
+	for i, arg := range args {
+		c, ok := arg.(context.Context)
+		if !ok {
+			continue
+		}
+		if p := FromContext(c); p != nil {
+			parent = p
+		}
+		// the arg can be replaced only if the parameter is context.Context,
+		// rather than a type implementing it
+		if i < len(paramTypes) && paramTypes[i] == contextType {
+			args = append([]interface{}{}, args...)
+			args[i] = ContextWithSpan(c, s)
+		}
+		break
+	}
+	if parent != nil {
+		s.TraceID = parent.TraceID
+		s.ParentID = parent.SpanID
+	} else {
+		s.TraceID = newTraceID()
+	}
+
+	push(gid, s)
+	s.Start = time.Now()
+	panicked := true
+	defer func() {
+		s.End = time.Now()
+		pop(gid)
+		if panicked {
+			if r := recover(); r != nil {
+				s.Err = fmt.Sprintf("panic: %v", r)
+				a.export(s)
+				panic(r)
+			}
+		}
+		a.export(s)
+	}()
+	res := ctx.Call(args)
+	panicked = false
+	if jp.ErrorResult && len(res) != 0 {
+		if err, ok := res[len(res)-1].(error); ok && err != nil {
+			s.Err = err.Error()
+		}
+	}
+	return res
+}
+
+func (a *Aspect) export(s *Span) {
+	exp := a.exporter()
+	if exp == nil {
+		return
+	}
+	if err := exp.ExportSpan(s); err != nil {
+		exportErrorOnce.Do(func() {
+			log.Printf("span: could not export the span: %s (the later errors are not logged)", err)
+		})
+	}
+}
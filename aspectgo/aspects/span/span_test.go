@@ -0,0 +1,198 @@
+package span
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"golang.org/x/exp/aspectgo/aspect"
+	"golang.org/x/exp/aspectgo/aspect/rt"
+	"golang.org/x/exp/aspectgo/aspect/rt/rttest"
+)
+
+// memExporter keeps the spans in memory.
+type memExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (e *memExporter) ExportSpan(s *Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, s)
+	return nil
+}
+
+// joinPoint returns the join point of `fn(ctx, x)`,
+// where fn is named name and returns error.
+func joinPoint(name string) *aspect.JoinPoint {
+	return &aspect.JoinPoint{
+		Kind:        aspect.KindCall,
+		Name:        "example.com/foo." + name,
+		ShortName:   "foo." + name,
+		Pos:         "example.com/foo/main.go:42:2",
+		NumResults:  1,
+		ErrorResult: true,
+		ParamNames:  []string{"ctx", "x"},
+		ResultNames: []string{""},
+		ParamTypes:  rt.Types((*context.Context)(nil), (*int)(nil)),
+		ResultTypes: rt.Types((*error)(nil)),
+	}
+}
+
+func TestAspect(t *testing.T) {
+	exp := &memExporter{}
+	a := &Aspect{Exporter: exp}
+	var wg sync.WaitGroup
+	// handle calls query in the same goroutine (without ctx), and fetch in another goroutine (with ctx)
+	query := func(c context.Context, x int) error {
+		return errors.New("no rows")
+	}
+	fetch := func(c context.Context, x int) error {
+		if FromContext(c) == nil {
+			t.Error("the span is not propagated through ctx")
+		}
+		return nil
+	}
+	handle := func(c context.Context, x int) error {
+		a.Advice(rttest.NewContext(joinPoint("query"), query, context.Background(), x))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.Advice(rttest.NewContext(joinPoint("fetch"), fetch, c, x))
+		}()
+		wg.Wait()
+		return nil
+	}
+	a.Advice(rttest.NewContext(joinPoint("handle"), handle, context.Background(), 42))
+	if Current() != nil {
+		t.Fatalf("the stack of the goroutine is not empty: %v", Current())
+	}
+
+	names := make(map[string]*Span)
+	for _, s := range exp.spans {
+		names[s.Name] = s
+	}
+	h, q, f := names["foo.handle"], names["foo.query"], names["foo.fetch"]
+	if len(exp.spans) != 3 || h == nil || q == nil || f == nil {
+		t.Fatalf("unexpected spans: %v", exp.spans)
+	}
+	if !h.ParentID.IsZero() || q.ParentID != h.SpanID || f.ParentID != h.SpanID {
+		t.Fatalf("unexpected parents: handle=%v, query=%v, fetch=%v", h.ParentID, q.ParentID, f.ParentID)
+	}
+	if q.TraceID != h.TraceID || f.TraceID != h.TraceID {
+		t.Fatalf("unexpected traces: handle=%v, query=%v, fetch=%v", h.TraceID, q.TraceID, f.TraceID)
+	}
+	if f.Goroutine == h.Goroutine {
+		t.Fatalf("fetch is expected to be in another goroutine")
+	}
+	if q.Err != "no rows" || h.Err != "" {
+		t.Fatalf("unexpected errors: handle=%q, query=%q", h.Err, q.Err)
+	}
+	if h.Attributes["code.function"] != "example.com/foo.handle" || h.End.Before(h.Start) {
+		t.Fatalf("unexpected span: %+v", h)
+	}
+}
+
+func TestAspectPanic(t *testing.T) {
+	exp := &memExporter{}
+	a := &Aspect{Exporter: exp}
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Fatalf("unexpected panic: %v", r)
+			}
+		}()
+		a.Advice(rttest.NewContext(joinPoint("boom"), func(context.Context, int) error { panic("boom") }, context.Background(), 0))
+	}()
+	if len(exp.spans) != 1 || exp.spans[0].Err != "panic: boom" {
+		t.Fatalf("unexpected spans: %v", exp.spans)
+	}
+	if Current() != nil {
+		t.Fatalf("the stack of the goroutine is not empty: %v", Current())
+	}
+}
+
+func exportToFile(t *testing.T, format Format) string {
+	filename := filepath.Join(t.TempDir(), "spans")
+	e, err := NewFileExporter(filename, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Aspect{Exporter: e}
+	inner := func(context.Context, int) error { return errors.New("error") }
+	outer := func(c context.Context, x int) error {
+		return a.Advice(rttest.NewContext(joinPoint("inner"), inner, c, x))[0].(error)
+	}
+	a.Advice(rttest.NewContext(joinPoint("outer"), outer, context.Background(), 1))
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestFileExporterOTLPJSON(t *testing.T) {
+	f, err := os.Open(exportToFile(t, OTLPJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var spans []otlpSpan
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var req otlpTraceRequest
+		if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+			t.Fatalf("%s: %q", err, sc.Text())
+		}
+		spans = append(spans, req.ResourceSpans[0].ScopeSpans[0].Spans...)
+	}
+	if len(spans) != 2 || spans[0].Name != "foo.inner" || spans[1].Name != "foo.outer" {
+		t.Fatalf("unexpected spans: %+v", spans)
+	}
+	inner, outer := spans[0], spans[1]
+	if inner.ParentSpanID != outer.SpanID || outer.ParentSpanID != "" || len(inner.TraceID) != 32 || len(inner.SpanID) != 16 {
+		t.Fatalf("unexpected IDs: %+v", spans)
+	}
+	if inner.Status == nil || inner.Status.Code != otlpStatusCodeError || inner.Status.Message != "error" {
+		t.Fatalf("unexpected status: %+v", inner.Status)
+	}
+}
+
+func TestFileExporterChrome(t *testing.T) {
+	b, err := os.ReadFile(exportToFile(t, Chrome))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []chromeTraceEvent
+	if err := json.Unmarshal(b, &events); err != nil {
+		t.Fatalf("%s: %q", err, b)
+	}
+	if len(events) != 2 {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+	inner, outer := events[0], events[1]
+	if inner.Name != "foo.inner" || inner.Ph != "X" || inner.Args["parent_id"] != outer.Args["span_id"] || inner.Args["error"] != "error" {
+		t.Fatalf("unexpected event: %+v", inner)
+	}
+	if inner.Ts < outer.Ts || inner.Tid != outer.Tid {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+}
+
+func TestParseEnv(t *testing.T) {
+	format, filename, err := parseEnv("chrome:/tmp/trace.json")
+	if err != nil || format != Chrome || filename != "/tmp/trace.json" {
+		t.Fatalf("unexpected: %q %q %v", format, filename, err)
+	}
+	if _, _, err := parseEnv("/tmp/trace.json"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := NewFileExporter(filepath.Join(t.TempDir(), "x"), "xml"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
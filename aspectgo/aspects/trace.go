@@ -0,0 +1,53 @@
+package aspects
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"golang.org/x/exp/aspectgo/aspect"
+)
+
+// Tracer is an aspect that logs every advised call to log/slog.
+type Tracer struct {
+	// Logger is the logger. If nil, slog.Default() is used.
+	Logger *slog.Logger
+
+	// Level is the level for the log records.
+	Level slog.Level
+
+	// OmitArgs omits the arguments and the results from the log records.
+	OmitArgs bool
+}
+
+// Advice logs the call.
+func (t *Tracer) Advice(ctx aspect.Context) []interface{} {
+	logger := t.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	args := ctx.Args()
+	var res []interface{}
+	panicked := true
+	begin := time.Now()
+	defer func() {
+		attrs := []slog.Attr{
+			slog.String("func", ctx.JoinPoint().Name),
+			slog.String("pos", ctx.JoinPoint().Pos),
+		}
+		if !t.OmitArgs {
+			attrs = append(attrs, slog.Any("args", args))
+			if !panicked {
+				attrs = append(attrs, slog.Any("results", res))
+			}
+		}
+		attrs = append(attrs, slog.Duration("duration", time.Since(begin)))
+		if panicked {
+			attrs = append(attrs, slog.Bool("panicked", true))
+		}
+		logger.LogAttrs(context.Background(), t.Level, "call", attrs...)
+	}()
+	res = ctx.Call(args)
+	panicked = false
+	return res
+}
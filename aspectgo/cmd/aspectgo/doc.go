@@ -2,12 +2,104 @@
 AspectGo weaves aspects to Go programs.
 
 Usage:
-	aspectgo flags path
+	aspectgo [weave] flags [path]
+The path is the import path of the aspect package,
+or the name of the aspect file (package main, ending with .go).
+It can be omitted if the config file specifies the aspect.
 The flags are:
 	-t target
 		Specify the target package name.
+	-config file
+		Specify the config file. The default value is aspectgo.yaml,
+		aspectgo.yml or aspectgo.json in the current directory, if any.
+		The flags override the config file.
+	-tags tags
+		Specify the comma-separated build tags.
 	-w wovengopath
 		Specify the output GOPATH.
                 The default value is /tmp/wovengopath.
+	-deep
+		Weave the dependency packages of the target as well.
+	-deep-allow patterns
+		Specify the comma-separated import path patterns of the
+		dependency packages to be woven with -deep.
+	-cache
+		Reuse the woven files for the unchanged target packages.
+		The default value is true.
+	-watch
+		Weave again whenever the aspect, the target packages or
+		their dependencies are changed.
+	-output strategy
+		Specify how the untouched files are made available in the
+		output GOPATH: symlink, hardlink, copy or overlay.
+		The default value is symlink.
+	-verify
+		Type-check the woven packages, and report the errors along with
+		the join points and the aspects they are originated from.
+		The default value is true.
+
+Build, run or test:
+	aspectgo build|run|test flags package [-- args]
+Weave the aspect to the package in a temporary GOPATH, and run
+`go build`, `go run` or `go test` for the package with it.
+The package is an import path, or a directory in GOPATH (e.g. ./foo).
+The args are passed to the go tool, or to the program for run.
+The flags can follow the package, and are the same as weave, plus:
+	-aspect path
+		Specify the aspect package or the aspect file.
+	-keep
+		Keep the temporary GOPATH.
+	-w wovengopath
+		Use wovengopath instead of the temporary GOPATH.
+	-t target
+		Specify the packages to weave (e.g. ./...).
+		The default value is the package.
+
+List:
+	aspectgo list flags package
+Print the join points in the package, without weaving.
+The flags are -aspect, -deep, -deep-allow, and:
+	-json
+		Print the join points in JSON.
+
+Clean:
+	aspectgo clean [-config file] [-w wovengopath | wovengopath...]
+Remove the files written to the woven GOPATHs by aspectgo, including the cache.
+The default woven GOPATH is the one in the config file, if any.
+
+Config file:
+The config file (YAML or JSON) can specify the aspects, the targets and
+the options, so that the path and -t can be omitted:
+	aspects:
+	  - path: ./aspect/main_aspect.go
+	  - path: example.com/aspects/trace
+	    enabled: false
+	targets: [./...]
+	excludes: [example.com/foo/internal/...]
+	tags: [integration]
+	output: symlink
+	wovenGOPATH: /tmp/wovengopath
+The other fields are deep, deepAllow, cache and verify.
+Relative paths are relative to the directory of the config file.
+Only one aspect can be enabled.
+
+Call graph:
+	aspectgo callgraph flags path
+Write the call graph of the call sites advised by the aspect, merged with
+the calls counted by the profile aspect (aspects/profile).
+The flags are:
+	-t target
+		Specify the target package name.
+	-deep, -deep-allow patterns
+		Include the call sites in the dependency packages, as in weaving.
+	-profile files
+		Specify the comma-separated profile files written by the
+		profile aspect ($ASPECTGO_PROFILE).
+	-format format
+		Specify the output format: dot or json.
+		The default value is dot.
+	-o file
+		Specify the output file.
+		The default value is the standard output.
 */
 package main
@@ -0,0 +1,176 @@
+// Package cache provides the content-addressed cache of the woven files.
+//
+// An entry of the cache is keyed by the hash of everything that affects the
+// woven files, e.g. the aspect file and the target package with its
+// dependencies (see Closure). The entry is the list of the woven files, and
+// the contents of the files are stored by their hashes.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Cache is the cache of the woven files.
+type Cache struct {
+	dir string
+}
+
+// entry is the list of the woven files.
+type entry struct {
+	Files []file
+}
+
+// file is a woven file.
+type file struct {
+	// Path is the slash-separated path relative to the woven GOPATH.
+	Path string
+	// Hash is the hash of the content.
+	Hash string
+}
+
+// Open opens the cache in dir, creating dir if needed.
+func Open(dir string) (*Cache, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "objects"), 0755); err != nil {
+		return nil, err
+	}
+	return &Cache{dir: dir}, nil
+}
+
+// Key returns the key for the parts.
+func Key(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		fmt.Fprintf(h, "%q\n", part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func hashBytes(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) entryFilename(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+func (c *Cache) objectFilename(hash string) string {
+	return filepath.Join(c.dir, "objects", hash[:2], hash)
+}
+
+// Get returns the woven files for key in wovenGOPATH.
+// The files that are missing or modified in wovenGOPATH are restored from the cache.
+// ok is false if key is not in the cache.
+func (c *Cache) Get(key, wovenGOPATH string) (fnames []string, ok bool, err error) {
+	b, err := ioutil.ReadFile(c.entryFilename(key))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var e entry
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, false, fmt.Errorf("broken cache entry %s: %s", key, err)
+	}
+	fnames = []string{}
+	for _, f := range e.Files {
+		fname := filepath.Join(wovenGOPATH, filepath.FromSlash(f.Path))
+		if cur, err := ioutil.ReadFile(fname); err == nil && hashBytes(cur) == f.Hash {
+			fnames = append(fnames, fname)
+			continue
+		}
+		obj, err := ioutil.ReadFile(c.objectFilename(f.Hash))
+		if os.IsNotExist(err) {
+			// the object was removed. weave again.
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+			return nil, false, err
+		}
+		// fname may be a symlink to the original file.
+		if err := os.Remove(fname); err != nil && !os.IsNotExist(err) {
+			return nil, false, err
+		}
+		if err := ioutil.WriteFile(fname, obj, 0644); err != nil {
+			return nil, false, err
+		}
+		fnames = append(fnames, fname)
+	}
+	return fnames, true, nil
+}
+
+// Put stores the woven files in wovenGOPATH for key.
+func (c *Cache) Put(key, wovenGOPATH string, fnames []string) error {
+	e := entry{Files: []file{}}
+	for _, fname := range fnames {
+		rel, err := filepath.Rel(wovenGOPATH, fname)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("%s is not in %s", fname, wovenGOPATH)
+		}
+		b, err := ioutil.ReadFile(fname)
+		if err != nil {
+			return err
+		}
+		hash := hashBytes(b)
+		if err := c.putObject(hash, b); err != nil {
+			return err
+		}
+		e.Files = append(e.Files, file{Path: filepath.ToSlash(rel), Hash: hash})
+	}
+	b, err := json.MarshalIndent(e, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.entryFilename(key), b, 0644)
+}
+
+// putObject stores b unless already stored.
+func (c *Cache) putObject(hash string, b []byte) error {
+	obj := c.objectFilename(hash)
+	if _, err := os.Stat(obj); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(obj), 0755); err != nil {
+		return err
+	}
+	// write to a temporary file first, not to leave a broken object
+	tmp, err := ioutil.TempFile(filepath.Dir(obj), "tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), obj)
+}
+
+// ExecutableHash returns the hash of the running executable.
+// It is used as a part of the keys, so that the cache is invalidated when AspectGo is updated.
+func ExecutableHash() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	b, err := ioutil.ReadFile(exe)
+	if err != nil {
+		return "", err
+	}
+	return hashBytes(b), nil
+}
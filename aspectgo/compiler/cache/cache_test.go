@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aspectgocachetest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wovenGOPATH := filepath.Join(dir, "woven")
+	c, err := Open(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := Key("target", "aspect")
+	if _, ok, err := c.Get(key, wovenGOPATH); ok || err != nil {
+		t.Fatalf("unexpected hit: ok=%t, err=%v", ok, err)
+	}
+
+	fname := filepath.Join(wovenGOPATH, "src", "example.com", "foo", "main.go")
+	if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fname, []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put(key, wovenGOPATH, []string{fname}); err != nil {
+		t.Fatal(err)
+	}
+
+	// modify and remove the woven file, and get it again from the cache
+	for _, modify := range []func() error{
+		func() error { return nil },
+		func() error { return ioutil.WriteFile(fname, []byte("package modified\n"), 0644) },
+		func() error { return os.RemoveAll(wovenGOPATH) },
+	} {
+		if err := modify(); err != nil {
+			t.Fatal(err)
+		}
+		fnames, ok, err := c.Get(key, wovenGOPATH)
+		if !ok || err != nil {
+			t.Fatalf("unexpected miss: ok=%t, err=%v", ok, err)
+		}
+		if !reflect.DeepEqual(fnames, []string{fname}) {
+			t.Fatalf("unexpected files: %v", fnames)
+		}
+		if b, err := ioutil.ReadFile(fname); err != nil || string(b) != "package main\n" {
+			t.Fatalf("unexpected content: %q, err=%v", b, err)
+		}
+	}
+
+	if Key("target", "aspect") == Key("target", "aspect2") || Key("a", "b") == Key("ab") {
+		t.Fatal("key conflict")
+	}
+}
+
+func TestClosureBuildTags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aspectgocachetest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pkgDir := filepath.Join(dir, "src", "example.com", "foo")
+	if err := os.MkdirAll(pkgDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	tagged := filepath.Join(pkgDir, "other_foo.go")
+	for fname, content := range map[string]string{
+		filepath.Join(pkgDir, "main.go"): "package main\n\nfunc main() {}\n",
+		tagged:                           "// +build foo\n\npackage main\n\nvar x = 1\n",
+	} {
+		if err := ioutil.WriteFile(fname, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hashes := func(ctxt *build.Context) string {
+		cl, err := NewClosure(ctxt, []string{"example.com/foo"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cl.Hash
+	}
+	ctxt := build.Default
+	ctxt.GOPATH = dir
+	tagCtxt := ctxt
+	tagCtxt.BuildTags = []string{"foo"}
+	before, tagBefore := hashes(&ctxt), hashes(&tagCtxt)
+	if err := ioutil.WriteFile(tagged, []byte("// +build foo\n\npackage main\n\nvar x = 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if hashes(&ctxt) != before {
+		t.Fatal("the hash is changed by the file excluded by the build tags")
+	}
+	if hashes(&tagCtxt) == tagBefore {
+		t.Fatal("the hash is not changed by the file selected by the build tags")
+	}
+}
@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+)
+
+// Closure is the transitive closure of the packages imported by some files
+// (or packages), without loading the type information.
+type Closure struct {
+	// Hash is the hash of the files in the closure.
+	// The packages in the standard library are hashed by the import paths
+	// and the Go version, instead of the contents.
+	Hash string
+
+	// Dirs are the directories of the packages in the closure,
+	// except for the standard library.
+	Dirs []string
+}
+
+// NewClosure computes the closure of the packages and the files.
+// The packages are resolved with ctxt, so that the files selected by the build tags
+// are in the closure.
+func NewClosure(ctxt *build.Context, importPaths, filenames []string) (*Closure, error) {
+	cl := &closure{
+		ctxt: ctxt,
+		h:    sha256.New(),
+		seen: make(map[string]bool),
+	}
+	fmt.Fprintf(cl.h, "go %s\n", runtime.Version())
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range importPaths {
+		if err := cl.addPackage(path, wd); err != nil {
+			return nil, err
+		}
+	}
+	for _, fname := range filenames {
+		if err := cl.addFile(fname); err != nil {
+			return nil, err
+		}
+		imports, err := FileImports(fname)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range imports {
+			if err := cl.addPackage(path, filepath.Dir(fname)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	sort.Strings(cl.dirs)
+	return &Closure{
+		Hash: hex.EncodeToString(cl.h.Sum(nil)),
+		Dirs: cl.dirs,
+	}, nil
+}
+
+type closure struct {
+	ctxt *build.Context
+	h    hash.Hash
+	seen map[string]bool // keyed by the resolved import paths
+	dirs []string
+}
+
+// addPackage hashes the package and its dependencies.
+// srcDir is used for resolving the vendored packages.
+func (cl *closure) addPackage(path, srcDir string) error {
+	if path == "C" || path == "unsafe" {
+		return nil
+	}
+	bp, err := cl.ctxt.Import(path, srcDir, 0)
+	if err != nil {
+		return err
+	}
+	if cl.seen[bp.ImportPath] {
+		return nil
+	}
+	cl.seen[bp.ImportPath] = true
+	if bp.Goroot {
+		fmt.Fprintf(cl.h, "std %s\n", bp.ImportPath)
+	} else {
+		fmt.Fprintf(cl.h, "package %s\n", bp.ImportPath)
+		cl.dirs = append(cl.dirs, bp.Dir)
+		var fnames []string
+		for _, files := range [][]string{bp.GoFiles, bp.CgoFiles, bp.SFiles, bp.CFiles, bp.HFiles} {
+			fnames = append(fnames, files...)
+		}
+		sort.Strings(fnames)
+		for _, fname := range fnames {
+			if err := cl.addFile(filepath.Join(bp.Dir, fname)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, imp := range bp.Imports {
+		if err := cl.addPackage(imp, bp.Dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addFile hashes the name and the content of the file.
+func (cl *closure) addFile(fname string) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cl.h, "file %q %d\n", fname, fi.Size())
+	_, err = io.Copy(cl.h, f)
+	return err
+}
+
+// FileImports returns the import paths of the file.
+func FileImports(fname string) ([]string, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), fname, nil, parser.ImportsOnly)
+	if err != nil {
+		return nil, err
+	}
+	var imports []string
+	for _, spec := range f.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			return nil, err
+		}
+		imports = append(imports, path)
+	}
+	return imports, nil
+}
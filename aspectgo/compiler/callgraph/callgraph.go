@@ -0,0 +1,172 @@
+// Package callgraph merges the call sites found by the weaver with the calls
+// counted by the profile aspect (golang.org/x/exp/aspectgo/aspects/profile),
+// and writes the call graph in DOT or JSON.
+package callgraph
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"golang.org/x/exp/aspectgo/aspect"
+	"golang.org/x/exp/aspectgo/aspects/profile"
+	"golang.org/x/exp/aspectgo/compiler/weave"
+)
+
+// Edge is a call site in the call graph.
+type Edge struct {
+	Kind   aspect.JoinPointKind `json:"kind"`
+	Caller string               `json:"caller"`
+	Callee string               `json:"callee"`
+	Pos    string               `json:"pos"`
+	// Count is the number of the calls in the profiles.
+	// The static edge is uncovered if Count is zero.
+	Count int64 `json:"count"`
+	// Static is true if the call site is found by the weaver.
+	// It is false for a call site found only in the profiles, e.g. the one
+	// woven from a different version of the source.
+	Static bool `json:"static"`
+}
+
+// Graph is the call graph.
+type Graph struct {
+	// Edges are sorted by Caller, Callee and Pos.
+	Edges []*Edge `json:"edges"`
+	// Covered is the number of the static edges called at least once.
+	Covered int `json:"covered"`
+	// Total is the number of the static edges.
+	Total int `json:"total"`
+}
+
+type edgeKey struct {
+	caller, callee, pos string
+}
+
+// New merges the static call sites with the profiles.
+// The counts of the same call site in the profiles are summed up.
+func New(sites []*weave.CallSite, profiles []*profile.Profile) *Graph {
+	edges := make(map[edgeKey]*Edge)
+	for _, s := range sites {
+		edges[edgeKey{s.Caller, s.Callee, s.Pos}] = &Edge{
+			Kind:   s.Kind,
+			Caller: s.Caller,
+			Callee: s.Callee,
+			Pos:    s.Pos,
+			Static: true,
+		}
+	}
+	for _, p := range profiles {
+		for _, pe := range p.Edges {
+			k := edgeKey{pe.Caller, pe.Callee, pe.Pos}
+			e, ok := edges[k]
+			if !ok {
+				e = &Edge{Kind: pe.Kind, Caller: pe.Caller, Callee: pe.Callee, Pos: pe.Pos}
+				edges[k] = e
+			}
+			e.Count += pe.Count
+		}
+	}
+	g := &Graph{Edges: []*Edge{}}
+	for _, e := range edges {
+		g.Edges = append(g.Edges, e)
+		if e.Static {
+			g.Total++
+			if e.Count > 0 {
+				g.Covered++
+			}
+		}
+	}
+	sort.Slice(g.Edges, func(i, j int) bool {
+		a, b := g.Edges[i], g.Edges[j]
+		if a.Caller != b.Caller {
+			return a.Caller < b.Caller
+		}
+		if a.Callee != b.Callee {
+			return a.Callee < b.Callee
+		}
+		return a.Pos < b.Pos
+	})
+	return g
+}
+
+// Format is the type for the output formats.
+type Format string
+
+const (
+	// DOT is the Graphviz format. The call sites of the same caller and callee
+	// are merged into an edge, labeled with the number of the calls.
+	// The uncovered edges are drawn as red dashed lines.
+	DOT Format = "dot"
+
+	// JSON is the JSON encoding of Graph.
+	JSON Format = "json"
+)
+
+// Formats are the supported formats.
+var Formats = []Format{DOT, JSON}
+
+// Write writes g to w in format.
+func (g *Graph) Write(w io.Writer, format Format) error {
+	switch format {
+	case DOT:
+		return g.WriteDOT(w)
+	case JSON:
+		return g.WriteJSON(w)
+	}
+	return fmt.Errorf("unknown format %q (should be one of %v)", format, Formats)
+}
+
+// WriteJSON writes g to w in JSON.
+func (g *Graph) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(g)
+}
+
+// WriteDOT writes g to w in the Graphviz format.
+func (g *Graph) WriteDOT(w io.Writer) error {
+	type funcPair struct {
+		caller, callee string
+	}
+	var pairs []funcPair
+	counts := make(map[funcPair]int64)
+	sites := make(map[funcPair]int)
+	for _, e := range g.Edges {
+		p := funcPair{e.Caller, e.Callee}
+		if _, ok := sites[p]; !ok {
+			pairs = append(pairs, p)
+		}
+		counts[p] += e.Count
+		sites[p]++
+	}
+	bw := &errWriter{w: w}
+	bw.printf("digraph callgraph {\n")
+	bw.printf("\tlabel=%q;\n", fmt.Sprintf("%d/%d call sites covered", g.Covered, g.Total))
+	bw.printf("\tnode [shape=box];\n")
+	for _, p := range pairs {
+		label := fmt.Sprintf("%d", counts[p])
+		if sites[p] > 1 {
+			label = fmt.Sprintf("%d (%d sites)", counts[p], sites[p])
+		}
+		attrs := fmt.Sprintf("label=%q", label)
+		if counts[p] == 0 {
+			attrs += ", style=dashed, color=red"
+		}
+		bw.printf("\t%q -> %q [%s];\n", p.caller, p.callee, attrs)
+	}
+	bw.printf("}\n")
+	return bw.err
+}
+
+// errWriter keeps the first error of the writes.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) printf(format string, args ...interface{}) {
+	if ew.err == nil {
+		_, ew.err = fmt.Fprintf(ew.w, format, args...)
+	}
+}
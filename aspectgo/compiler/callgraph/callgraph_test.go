@@ -0,0 +1,57 @@
+package callgraph
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/exp/aspectgo/aspect"
+	"golang.org/x/exp/aspectgo/aspects/profile"
+	"golang.org/x/exp/aspectgo/compiler/weave"
+)
+
+func TestNew(t *testing.T) {
+	sites := []*weave.CallSite{
+		{Kind: aspect.KindCall, Caller: "main.main", Callee: "main.greet", Pos: "main.go:10:2"},
+		{Kind: aspect.KindCall, Caller: "main.main", Callee: "main.greet", Pos: "main.go:11:2"},
+		{Kind: aspect.KindCall, Caller: "main.main", Callee: "main.shout", Pos: "main.go:12:2"},
+	}
+	profiles := []*profile.Profile{
+		{Edges: []*profile.Edge{
+			{Kind: aspect.KindCall, Caller: "main.main", Callee: "main.greet", Pos: "main.go:10:2", Count: 2},
+			{Kind: aspect.KindCall, Caller: "main.old", Callee: "main.greet", Pos: "main.go:20:2", Count: 1},
+		}},
+		{Edges: []*profile.Edge{
+			{Kind: aspect.KindCall, Caller: "main.main", Callee: "main.greet", Pos: "main.go:10:2", Count: 3},
+		}},
+	}
+	g := New(sites, profiles)
+	if g.Covered != 1 || g.Total != 3 || len(g.Edges) != 4 {
+		t.Fatalf("unexpected graph: %+v", g)
+	}
+	if e := g.Edges[0]; e.Pos != "main.go:10:2" || e.Count != 5 || !e.Static {
+		t.Fatalf("unexpected edge: %+v", e)
+	}
+	if e := g.Edges[3]; e.Caller != "main.old" || e.Count != 1 || e.Static {
+		t.Fatalf("unexpected edge: %+v", e)
+	}
+
+	var buf bytes.Buffer
+	if err := g.Write(&buf, DOT); err != nil {
+		t.Fatal(err)
+	}
+	dot := buf.String()
+	for _, s := range []string{
+		`label="1/3 call sites covered";`,
+		`"main.main" -> "main.greet" [label="5 (2 sites)"];`,
+		`"main.main" -> "main.shout" [label="0", style=dashed, color=red];`,
+		`"main.old" -> "main.greet" [label="1"];`,
+	} {
+		if !strings.Contains(dot, s) {
+			t.Errorf("%q is not found in:\n%s", s, dot)
+		}
+	}
+	if err := g.Write(&buf, "xml"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
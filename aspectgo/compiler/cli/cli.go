@@ -2,56 +2,512 @@
 package cli
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 
+	"golang.org/x/exp/aspectgo/aspects/profile"
 	"golang.org/x/exp/aspectgo/compiler"
+	"golang.org/x/exp/aspectgo/compiler/callgraph"
+	"golang.org/x/exp/aspectgo/compiler/config"
+	"golang.org/x/exp/aspectgo/compiler/gopath"
 	"golang.org/x/exp/aspectgo/compiler/util"
 )
 
+// DefaultWovenGOPATH is the default woven GOPATH for weave and clean.
+const DefaultWovenGOPATH = "/tmp/wovengopath"
+
 // Main is the CLI for AspectGo.
 func Main(args []string) int {
-	var (
-		debug  bool
-		weave  string
-		target string
-	)
-	f := flag.NewFlagSet(args[0], flag.ExitOnError)
-	f.BoolVar(&debug, "debug", false, "enable debug print")
-	f.StringVar(&weave, "w", "/tmp/wovengopath", "woven gopath")
-	f.StringVar(&target, "t", "", "target package name")
-	f.Parse(args[1:])
+	return Run(args, os.Stdout, os.Stderr)
+}
 
-	if target == "" {
-		fmt.Fprintf(os.Stderr, "No target package specified\n")
-		return 1
+// Run is the same as Main, except that the output of the subcommands,
+// including the go tool and the woven program, is written to stdout and stderr.
+// The log output is written to the standard logger.
+//
+// The subcommands are:
+//
+//	weave      weave the aspect to the target packages (default)
+//	build      weave, and run `go build` with the woven GOPATH
+//	run        weave, and run `go run` with the woven GOPATH
+//	test       weave, and run `go test` with the woven GOPATH
+//	list       list the join points
+//	clean      remove the woven GOPATHs
+//	callgraph  write the call graph of the woven call sites
+func Run(args []string, stdout, stderr io.Writer) int {
+	c := &command{name: args[0], stdout: stdout, stderr: stderr}
+	if len(args) > 1 {
+		switch args[1] {
+		case "weave":
+			return c.weave(args[1:])
+		case "build", "run", "test":
+			return c.goTool(args[1:])
+		case "list":
+			return c.list(args[1:])
+		case "clean":
+			return c.clean(args[1:])
+		case "callgraph":
+			return c.callgraph(args[1:])
+		}
 	}
-	if f.NArg() < 1 {
-		fmt.Fprintf(os.Stderr, "No aspect file specified\n")
-		return 1
+	// without a subcommand, e.g. `aspectgo -t example.com/foo main_aspect.go`
+	return c.weave(args)
+}
+
+// command runs a subcommand.
+type command struct {
+	// name is the name of the executable.
+	name   string
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func (c *command) errorf(format string, args ...interface{}) int {
+	fmt.Fprintf(c.stderr, format+"\n", args...)
+	return 1
+}
+
+func (c *command) flagSet(sub string) *flag.FlagSet {
+	name := c.name
+	if sub != "" {
+		name += " " + sub
 	}
-	if f.NArg() >= 2 {
-		fmt.Fprintf(os.Stderr, "Too many aspect files specified: %s\n", f.Args())
-		return 1
+	f := flag.NewFlagSet(name, flag.ExitOnError)
+	f.SetOutput(c.stderr)
+	return f
+}
+
+// weaveFlags are the flags for weaving, shared among the subcommands.
+type weaveFlags struct {
+	f         *flag.FlagSet
+	debug     bool
+	config    string
+	weave     string
+	target    string
+	tags      string
+	deep      bool
+	deepAllow string
+	cache     bool
+	watch     bool
+	output    string
+	verify    bool
+}
+
+// register registers the flags to f.
+// The flags only for weaving to a woven GOPATH (-w, -cache, -watch, -output and -verify)
+// are registered only if forOutput is true.
+func (wf *weaveFlags) register(f *flag.FlagSet, forOutput bool, defaultWeave string) {
+	wf.f = f
+	f.BoolVar(&wf.debug, "debug", false, "enable debug print")
+	f.StringVar(&wf.config, "config", "", fmt.Sprintf("config file (default %s in the current directory, if any)", strings.Join(config.DefaultFilenames, ", ")))
+	f.StringVar(&wf.target, "t", "", "target package name")
+	f.StringVar(&wf.tags, "tags", "", "comma-separated build tags")
+	f.BoolVar(&wf.deep, "deep", false, "weave the dependency packages as well")
+	f.StringVar(&wf.deepAllow, "deep-allow", "", "comma-separated import path patterns of the dependency packages to be woven with -deep")
+	if !forOutput {
+		return
 	}
+	f.StringVar(&wf.weave, "w", defaultWeave, "woven gopath")
+	f.BoolVar(&wf.cache, "cache", true, "reuse the woven files for the unchanged target packages")
+	f.BoolVar(&wf.watch, "watch", false, "weave again whenever the aspect or the target packages are changed")
+	f.StringVar(&wf.output, "output", string(gopath.Symlink), "how the untouched files are made available in the woven gopath (symlink, hardlink, copy or overlay)")
+	f.BoolVar(&wf.verify, "verify", true, "type-check the woven packages")
+}
 
+// compiler returns the compiler for weaving aspect, which is an aspect file
+// (ending with .go) or the import path of an aspect package.
+// aspect can be empty if the config file specifies the aspect.
+//
+// The fields are set from the flags, the config file, and the flags specified
+// in the command line, in this order.
+func (wf *weaveFlags) compiler(aspect string) (*compiler.Compiler, error) {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	util.DebugMode = debug
+	util.DebugMode = wf.debug
 	if util.DebugMode {
 		log.Printf("running in debug mode")
 	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	comp := &compiler.Compiler{}
+	if err := wf.apply(comp, wd, func(string) bool { return true }); err != nil {
+		return nil, err
+	}
+
+	cfgFname := wf.config
+	if cfgFname == "" {
+		if cfgFname, err = config.Find(wd); err != nil {
+			return nil, err
+		}
+	}
+	if cfgFname != "" {
+		cfg, err := config.Load(cfgFname)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Using the config file %s", cfg.Filename)
+		cfg.Apply(comp)
+		// the flags specified in the command line override the config file
+		specified := make(map[string]bool)
+		wf.f.Visit(func(fl *flag.Flag) { specified[fl.Name] = true })
+		if err := wf.apply(comp, wd, func(name string) bool { return specified[name] }); err != nil {
+			return nil, err
+		}
+	}
+
+	// an argument ending with .go is an aspect file, otherwise an aspect package
+	if strings.HasSuffix(aspect, ".go") {
+		comp.AspectFilenames, comp.AspectPackages = []string{aspect}, nil
+	} else if aspect != "" {
+		comp.AspectFilenames, comp.AspectPackages = nil, []string{aspect}
+	}
+	if len(comp.AspectFilenames)+len(comp.AspectPackages) == 0 {
+		return nil, errors.New("No aspect file or package specified")
+	}
+	return comp, nil
+}
+
+// apply sets the fields of comp from the flags, for which use returns true.
+// The empty values of -w, -t, -tags, -deep-allow and -output are not set.
+func (wf *weaveFlags) apply(comp *compiler.Compiler, wd string, use func(name string) bool) error {
+	if use("w") && wf.weave != "" {
+		comp.WovenGOPATH = wf.weave
+	}
+	if use("t") && wf.target != "" {
+		target, err := compiler.ImportPath(wd, wf.target)
+		if err != nil {
+			return err
+		}
+		comp.Target, comp.Targets = target, nil
+	}
+	if use("tags") && wf.tags != "" {
+		comp.BuildTags = strings.Split(wf.tags, ",")
+	}
+	if use("deep") {
+		comp.Deep = wf.deep
+	}
+	if use("deep-allow") && wf.deepAllow != "" {
+		comp.DeepAllow = strings.Split(wf.deepAllow, ",")
+	}
+	if use("cache") {
+		comp.Cache = wf.cache
+	}
+	if use("verify") {
+		comp.Verify = wf.verify
+	}
+	if use("output") && wf.output != "" {
+		strategy, err := gopath.ParseStrategy(wf.output)
+		if err != nil {
+			return err
+		}
+		comp.Output = strategy
+	}
+	return nil
+}
+
+// parseArgs parses args, where the flags can follow the positional args,
+// e.g. `./foo -aspect main_aspect.go`.
+// The args after "--" are not parsed, and returned as extra.
+func parseArgs(f *flag.FlagSet, args []string) (positional, extra []string) {
+	for i, arg := range args {
+		if arg == "--" {
+			args, extra = args[:i], args[i+1:]
+			break
+		}
+	}
+	for {
+		f.Parse(args)
+		if f.NArg() == 0 {
+			return positional, extra
+		}
+		positional = append(positional, f.Arg(0))
+		args = f.Args()[1:]
+	}
+}
+
+// importPath returns the import path of pkg, which can be a relative or absolute
+// directory in GOPATH, e.g. "./foo" or "./foo/...".
+func importPath(pkg string) (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	return compiler.ImportPath(wd, pkg)
+}
 
-	aspectFile := f.Args()[0]
-	comp := compiler.Compiler{
-		WovenGOPATH:     weave,
-		Target:          target,
-		AspectFilenames: []string{aspectFile},
+// weave is the CLI for `aspectgo weave`.
+func (c *command) weave(args []string) int {
+	sub := ""
+	if args[0] == "weave" {
+		sub = "weave"
+	}
+	var wf weaveFlags
+	f := c.flagSet(sub)
+	wf.register(f, true, DefaultWovenGOPATH)
+	f.Parse(args[1:])
+
+	if f.NArg() >= 2 {
+		return c.errorf("Too many aspect files or packages specified: %s", f.Args())
+	}
+	comp, err := wf.compiler(f.Arg(0))
+	if err != nil {
+		return c.errorf("%s", err)
+	}
+	if comp.Target == "" && len(comp.Targets) == 0 {
+		return c.errorf("No target package specified")
+	}
+	if wf.watch {
+		err = comp.Watch(nil)
+	} else {
+		err = comp.Do()
+	}
+	if err != nil {
+		return c.errorf("%s", err)
+	}
+	return 0
+}
+
+// goTool is the CLI for `aspectgo build`, `aspectgo run` and `aspectgo test`.
+// It weaves the aspect to the package, and runs the go tool for the package
+// with the woven GOPATH (or with the overlay for `-output overlay`).
+// The args after "--" are passed to the go tool, or to the program for `aspectgo run`.
+//
+// Unless -w is specified, the package is woven to a temporary directory,
+// which is removed after the go tool exits.
+func (c *command) goTool(args []string) int {
+	sub := args[0]
+	var (
+		wf     weaveFlags
+		aspect string
+		keep   bool
+	)
+	f := c.flagSet(sub)
+	wf.register(f, true, "")
+	f.StringVar(&aspect, "aspect", "", "aspect file or package")
+	f.BoolVar(&keep, "keep", false, "keep the temporary woven gopath")
+	pkgs, extra := parseArgs(f, args[1:])
+
+	if len(pkgs) != 1 {
+		return c.errorf("Exactly one package should be specified: %s", pkgs)
+	}
+	if wf.watch {
+		return c.errorf("-watch is not supported by %s", sub)
+	}
+	pkg, err := importPath(pkgs[0])
+	if err != nil {
+		return c.errorf("%s", err)
+	}
+	comp, err := wf.compiler(aspect)
+	if err != nil {
+		return c.errorf("%s", err)
+	}
+	if comp.Target == "" && len(comp.Targets) == 0 {
+		comp.Target = pkg
+	}
+	if comp.WovenGOPATH == "" {
+		comp.WovenGOPATH, err = ioutil.TempDir("", "aspectgo")
+		if err != nil {
+			return c.errorf("%s", err)
+		}
+		// the cache is useless in the temporary directory
+		comp.Cache = false
+		if keep {
+			log.Printf("Keeping the woven GOPATH %s", comp.WovenGOPATH)
+		} else {
+			defer os.RemoveAll(comp.WovenGOPATH)
+		}
 	}
 	if err := comp.Do(); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return 1
+		return c.errorf("%s", err)
+	}
+
+	goArgs := []string{sub}
+	env := append(os.Environ(), "GO111MODULE=off")
+	if comp.Output == gopath.Overlay {
+		goArgs = append(goArgs, "-overlay", filepath.Join(comp.WovenGOPATH, gopath.OverlayFilename))
+	} else {
+		env = append(env, "GOPATH="+comp.WovenGOPATH)
+	}
+	if len(comp.BuildTags) > 0 {
+		goArgs = append(goArgs, "-tags", strings.Join(comp.BuildTags, ","))
+	}
+	if sub == "run" {
+		goArgs = append(append(goArgs, pkg), extra...)
+	} else {
+		goArgs = append(append(goArgs, extra...), pkg)
+	}
+	log.Printf("Running go %s", strings.Join(goArgs, " "))
+	cmd := exec.Command("go", goArgs...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = c.stdout
+	cmd.Stderr = c.stderr
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() > 0 {
+			return exitErr.ExitCode()
+		}
+		return c.errorf("%s", err)
+	}
+	return 0
+}
+
+// list is the CLI for `aspectgo list`.
+// It prints the join points in the package, without weaving.
+func (c *command) list(args []string) int {
+	var (
+		wf     weaveFlags
+		aspect string
+		asJSON bool
+	)
+	f := c.flagSet("list")
+	wf.register(f, false, "")
+	f.StringVar(&aspect, "aspect", "", "aspect file or package")
+	f.BoolVar(&asJSON, "json", false, "print the join points in JSON")
+	pkgs, _ := parseArgs(f, args[1:])
+
+	if len(pkgs) != 1 {
+		return c.errorf("Exactly one package should be specified: %s", pkgs)
+	}
+	pkg, err := importPath(pkgs[0])
+	if err != nil {
+		return c.errorf("%s", err)
+	}
+	comp, err := wf.compiler(aspect)
+	if err != nil {
+		return c.errorf("%s", err)
+	}
+	comp.Target, comp.Targets = pkg, nil
+	jps, err := comp.JoinPoints()
+	if err != nil {
+		return c.errorf("%s", err)
+	}
+	if asJSON {
+		enc := json.NewEncoder(c.stdout)
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(jps); err != nil {
+			return c.errorf("%s", err)
+		}
+		return 0
+	}
+	for _, jp := range jps {
+		asp := jp.Aspect
+		if asp == "" {
+			asp = "(cflow)"
+		}
+		fmt.Fprintf(c.stdout, "%s: %s %s in %s, aspect: %s\n", jp.Pos, jp.Kind, jp.Name, jp.Caller, asp)
+	}
+	return 0
+}
+
+// clean is the CLI for `aspectgo clean`.
+// It removes the woven GOPATHs specified as the args, or the one specified by -w
+// (or by the config file).
+func (c *command) clean(args []string) int {
+	var weave, cfgFname string
+	f := c.flagSet("clean")
+	f.StringVar(&weave, "w", DefaultWovenGOPATH, "woven gopath")
+	f.StringVar(&cfgFname, "config", "", fmt.Sprintf("config file (default %s in the current directory, if any)", strings.Join(config.DefaultFilenames, ", ")))
+	f.Parse(args[1:])
+
+	dirs := f.Args()
+	if len(dirs) == 0 {
+		specified := false
+		f.Visit(func(fl *flag.Flag) { specified = specified || fl.Name == "w" })
+		if !specified {
+			var err error
+			if cfgFname == "" {
+				if cfgFname, err = config.Find("."); err != nil {
+					return c.errorf("%s", err)
+				}
+			}
+			if cfgFname != "" {
+				cfg, err := config.Load(cfgFname)
+				if err != nil {
+					return c.errorf("%s", err)
+				}
+				if cfg.WovenGOPATH != "" {
+					weave = cfg.WovenGOPATH
+				}
+			}
+		}
+		dirs = []string{weave}
+	}
+	for _, dir := range dirs {
+		if err := compiler.Clean(dir); err != nil {
+			return c.errorf("%s", err)
+		}
+	}
+	return 0
+}
+
+// callgraph is the CLI for `aspectgo callgraph`.
+// It writes the call graph of the call sites woven with the aspect,
+// along with the calls counted by the profile aspect.
+func (c *command) callgraph(args []string) int {
+	var (
+		wf       weaveFlags
+		profiles string
+		format   string
+		output   string
+	)
+	f := c.flagSet("callgraph")
+	wf.register(f, false, "")
+	f.StringVar(&profiles, "profile", "", "comma-separated profile files written by the profile aspect ($"+profile.Env+")")
+	f.StringVar(&format, "format", string(callgraph.DOT), fmt.Sprintf("output format %v", callgraph.Formats))
+	f.StringVar(&output, "o", "", "output file (default stdout)")
+	f.Parse(args[1:])
+
+	if f.NArg() >= 2 {
+		return c.errorf("Too many aspect files or packages specified: %s", f.Args())
+	}
+	comp, err := wf.compiler(f.Arg(0))
+	if err != nil {
+		return c.errorf("%s", err)
+	}
+	if comp.Target == "" && len(comp.Targets) == 0 {
+		return c.errorf("No target package specified")
+	}
+	sites, err := comp.CallSites()
+	if err != nil {
+		return c.errorf("%s", err)
+	}
+	var ps []*profile.Profile
+	if profiles != "" {
+		for _, fname := range strings.Split(profiles, ",") {
+			p, err := profile.ReadFile(fname)
+			if err != nil {
+				return c.errorf("%s", err)
+			}
+			ps = append(ps, p)
+		}
+	}
+	g := callgraph.New(sites, ps)
+
+	if output == "" {
+		err = g.Write(c.stdout, callgraph.Format(format))
+	} else {
+		var outf *os.File
+		if outf, err = os.Create(output); err != nil {
+			return c.errorf("%s", err)
+		}
+		err = g.Write(outf, callgraph.Format(format))
+		if cerr := outf.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return c.errorf("%s", err)
 	}
+	fmt.Fprintf(c.stderr, "%d/%d call sites covered\n", g.Covered, g.Total)
 	return 0
 }
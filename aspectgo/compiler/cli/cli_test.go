@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/exp/aspectgo/compiler"
+	"golang.org/x/exp/aspectgo/compiler/gopath"
+)
+
+func TestParseArgs(t *testing.T) {
+	var aspect string
+	var keep bool
+	f := flag.NewFlagSet("aspectgo run", flag.ContinueOnError)
+	f.StringVar(&aspect, "aspect", "", "")
+	f.BoolVar(&keep, "keep", false, "")
+	positional, extra := parseArgs(f, []string{"./foo", "-aspect", "a.go", "-keep", "--", "-v", "bar"})
+	if aspect != "a.go" || !keep {
+		t.Fatalf("unexpected flags: aspect=%q, keep=%t", aspect, keep)
+	}
+	if !reflect.DeepEqual(positional, []string{"./foo"}) || !reflect.DeepEqual(extra, []string{"-v", "bar"}) {
+		t.Fatalf("unexpected args: %q %q", positional, extra)
+	}
+}
+
+func TestCompilerWithConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aspectgo-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := "aspects: [main_aspect.go]\ntargets: [example.com/foo]\ncache: false\noutput: copy\n"
+	cfgFname := filepath.Join(dir, "aspectgo.yaml")
+	if err := ioutil.WriteFile(cfgFname, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var wf weaveFlags
+	f := flag.NewFlagSet("aspectgo", flag.ContinueOnError)
+	wf.register(f, true, DefaultWovenGOPATH)
+	if err := f.Parse([]string{"-config", cfgFname, "-output", "hardlink", "-tags", "a,b", "-t", "example.com/bar"}); err != nil {
+		t.Fatal(err)
+	}
+	comp, err := wf.compiler("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &compiler.Compiler{
+		WovenGOPATH:     DefaultWovenGOPATH,
+		Target:          "example.com/bar",
+		BuildTags:       []string{"a", "b"},
+		AspectFilenames: []string{filepath.Join(dir, "main_aspect.go")},
+		Output:          gopath.Hardlink,
+		Verify:          true,
+	}
+	if !reflect.DeepEqual(comp, expected) {
+		t.Fatalf("unexpected compiler:\n%+v\nexpected:\n%+v", comp, expected)
+	}
+}
@@ -4,12 +4,15 @@ package compiler
 import (
 	"errors"
 	"fmt"
+	"go/build"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 
+	"golang.org/x/exp/aspectgo/compiler/cache"
 	"golang.org/x/exp/aspectgo/compiler/gopath"
 	"golang.org/x/exp/aspectgo/compiler/parse"
 	"golang.org/x/exp/aspectgo/compiler/weave"
@@ -24,61 +27,423 @@ type Compiler struct {
 	// Can contain ... for recursive weaving.
 	Target string
 
+	// Targets are the target package names, in addition to Target.
+	// Can contain ... as well.
+	Targets []string
+
+	// Excludes are the import path patterns of the packages that are not woven,
+	// even if they are resolved from Target or Targets. Can contain ... as wildcards.
+	Excludes []string
+
+	// BuildTags are the build tags for loading the target packages.
+	BuildTags []string
+
 	// AspectFilenames are aspect file names.
 	// currently, only single aspect file is supported
 	AspectFilenames []string
+
+	// AspectPackages are the import paths of the aspect packages.
+	// Either AspectFilenames or AspectPackages needs to be specified.
+	// currently, only single aspect package is supported
+	AspectPackages []string
+
+	// Deep enables weaving the dependency packages of the target.
+	Deep bool
+
+	// DeepAllow is the list of the import path patterns of the dependency
+	// packages to be woven when Deep is set. Can contain ... as wildcards.
+	// If empty, all the eligible dependency packages are woven.
+	DeepAllow []string
+
+	// Cache enables the cache of the woven files, in CacheDirName under WovenGOPATH.
+	// The targets are not woven again unless the aspect, the target packages or
+	// their dependencies are changed.
+	Cache bool
+
+	// Output is the strategy for making the untouched files available in WovenGOPATH.
+	// If empty, gopath.Symlink is used.
+	Output gopath.Strategy
+
+	// Verify enables type-checking the woven packages after weaving them,
+	// so that the errors in the woven files are reported as the errors of Do.
+	Verify bool
 }
 
+// CacheDirName is the name of the cache directory in WovenGOPATH.
+const CacheDirName = ".aspectgo-cache"
+
+// cacheVersion is changed when the format of the cache is changed.
+const cacheVersion = "1"
+
 // Do does all the compilation phases.
 func (c *Compiler) Do() error {
 	log.Printf("Phase 0: Checking arguments")
 	if c.WovenGOPATH == "" {
 		return errors.New("WovenGOPATH not specified")
 	}
-	if c.Target == "" {
+	if c.Target == "" && len(c.Targets) == 0 {
 		return errors.New("Target not specified")
 	}
-	if len(c.AspectFilenames) != 1 {
-		return fmt.Errorf("only single aspect file is supported at the moment: %v", c.AspectFilenames)
+	if len(c.AspectFilenames)+len(c.AspectPackages) != 1 {
+		return fmt.Errorf("only single aspect file or package is supported at the moment: %v %v",
+			c.AspectFilenames, c.AspectPackages)
 	}
-	aspectFilename := c.AspectFilenames[0]
 	oldGOPATH := os.Getenv("GOPATH")
 	if oldGOPATH == "" {
 		return errors.New("GOPATH not set")
 	}
-
-	log.Printf("Phase 1: Parsing the aspects")
-	aspectFile, err := parse.ParseAspectFile(aspectFilename)
+	targets, err := c.resolveTargets(oldGOPATH)
 	if err != nil {
 		return err
 	}
-
-	log.Printf("Phase 2: Weaving the aspects to the target packages")
-	targets, err := resolveTarget(oldGOPATH, c.Target)
+	excludedFnames, err := absFilenames(c.AspectFilenames)
 	if err != nil {
 		return err
 	}
-	var writtenFnames []string
-	for _, target := range targets {
-		w, err := weave.Weave(c.WovenGOPATH, target, aspectFile)
+	output := c.Output
+	if output == "" {
+		output = gopath.Symlink
+	}
+	// the files of the previous run are removed, so that the stale ones do not remain,
+	// and the woven files are not written through the stale symlinks.
+	if err = gopath.Clean(c.WovenGOPATH); err != nil {
+		return err
+	}
+
+	var (
+		writtenFnames []string
+		cch           *cache.Cache
+		keys          map[string]string
+		woven         map[string][]string
+	)
+	missedTargets := targets
+	if c.Cache {
+		cch, keys, err = c.openCache(oldGOPATH, targets)
 		if err != nil {
 			return err
 		}
-		writtenFnames = append(writtenFnames, w...)
+		missedTargets = nil
+		for _, target := range targets {
+			fnames, ok, err := cch.Get(keys[target], c.WovenGOPATH)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				missedTargets = append(missedTargets, target)
+				continue
+			}
+			log.Printf("Reusing the cached woven files for %s", target)
+			writtenFnames = append(writtenFnames, fnames...)
+		}
+	}
+
+	if len(missedTargets) != 0 {
+		log.Printf("Phase 1: Parsing the aspects")
+		aspectFile, err := c.parseAspect()
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Phase 2: Weaving the aspects to the target packages")
+		woven, err = weave.Weave(c.WovenGOPATH, missedTargets, aspectFile, c.weaveOptions())
+		if err != nil {
+			return err
+		}
+		for _, target := range missedTargets {
+			writtenFnames = append(writtenFnames, woven[target]...)
+		}
 	}
 	if len(writtenFnames) == 0 {
 		log.Printf("Nothing to do")
-		return nil
+	} else {
+		log.Printf("Phase 3: Laying out GOPATH (%s)", output)
+		var pkgDirs []string
+		if output == gopath.Hardlink || output == gopath.Copy {
+			// unlike the symlinks, the hard links and the copies are made only
+			// for the packages needed for building
+			if pkgDirs, err = c.packageDirs(oldGOPATH, targets, writtenFnames); err != nil {
+				return err
+			}
+		}
+		err = gopath.Layout(output, oldGOPATH, c.WovenGOPATH, pkgDirs, writtenFnames, excludedFnames)
+		if err != nil {
+			return err
+		}
+		// the cached targets were verified when they were woven
+		if c.Verify && len(missedTargets) != 0 {
+			log.Printf("Phase 4: Type-checking the woven packages")
+			if err = c.verify(targets); err != nil {
+				return err
+			}
+		}
+	}
+
+	// the woven files are cached only after they are verified
+	for _, target := range missedTargets {
+		if cch != nil {
+			if err = cch.Put(keys[target], c.WovenGOPATH, woven[target]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseAspect parses the aspect file or the aspect package.
+func (c *Compiler) parseAspect() (*parse.AspectFile, error) {
+	if len(c.AspectFilenames) != 0 {
+		return parse.ParseAspectFile(c.AspectFilenames[0])
+	}
+	return parse.ParseAspectPackage(c.AspectPackages[0])
+}
+
+func (c *Compiler) weaveOptions() *weave.Options {
+	return &weave.Options{
+		Deep:      c.Deep,
+		DeepAllow: c.DeepAllow,
+		BuildTags: c.BuildTags,
+	}
+}
+
+// parseWithoutWeaving checks the arguments other than WovenGOPATH,
+// and returns the resolved targets and the parsed aspect, for the functions
+// that do not write WovenGOPATH.
+func (c *Compiler) parseWithoutWeaving() ([]string, *parse.AspectFile, error) {
+	if c.Target == "" && len(c.Targets) == 0 {
+		return nil, nil, errors.New("Target not specified")
+	}
+	if len(c.AspectFilenames)+len(c.AspectPackages) != 1 {
+		return nil, nil, fmt.Errorf("only single aspect file or package is supported at the moment: %v %v",
+			c.AspectFilenames, c.AspectPackages)
+	}
+	targets, err := c.resolveTargets(os.Getenv("GOPATH"))
+	if err != nil {
+		return nil, nil, err
 	}
+	aspectFile, err := c.parseAspect()
+	if err != nil {
+		return nil, nil, err
+	}
+	return targets, aspectFile, nil
+}
 
-	log.Printf("Phase 3: Fixing up GOPATH")
-	err = gopath.FixUp(oldGOPATH, c.WovenGOPATH, writtenFnames)
+// CallSites returns the call sites in the target packages that are woven with
+// the "call" and "handler" pointcuts of the aspect, i.e. the static edges of the call graph.
+// Nothing is written to WovenGOPATH, so WovenGOPATH, Cache, Output and Verify are ignored.
+func (c *Compiler) CallSites() ([]*weave.CallSite, error) {
+	targets, aspectFile, err := c.parseWithoutWeaving()
 	if err != nil {
+		return nil, err
+	}
+	return weave.CallSites(targets, aspectFile, c.weaveOptions())
+}
+
+// JoinPoints returns the join points in the target packages that are woven with the aspect.
+// Nothing is written to WovenGOPATH, so WovenGOPATH, Cache, Output and Verify are ignored.
+func (c *Compiler) JoinPoints() ([]*weave.JoinPoint, error) {
+	targets, aspectFile, err := c.parseWithoutWeaving()
+	if err != nil {
+		return nil, err
+	}
+	return weave.JoinPoints(targets, aspectFile, c.weaveOptions())
+}
+
+// Clean removes the files written to wovenGOPATH by Do, including the cache,
+// and wovenGOPATH itself if it becomes empty.
+// The other files in wovenGOPATH are kept, so that a mistyped wovenGOPATH
+// does not remove an unrelated directory.
+func Clean(wovenGOPATH string) error {
+	if err := gopath.Clean(wovenGOPATH); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(wovenGOPATH, CacheDirName)); err != nil {
 		return err
 	}
+	// the directories created by Layout for the woven files
+	if fis, err := ioutil.ReadDir(filepath.Join(wovenGOPATH, "src")); err == nil && len(fis) == 0 {
+		os.Remove(filepath.Join(wovenGOPATH, "src"))
+	}
+	if fis, err := ioutil.ReadDir(wovenGOPATH); err == nil && len(fis) == 0 {
+		return os.Remove(wovenGOPATH)
+	}
 	return nil
 }
 
+// verify type-checks the woven targets.
+func (c *Compiler) verify(targets []string) error {
+	ctxt, err := gopath.BuildContext(c.WovenGOPATH)
+	if err != nil {
+		return err
+	}
+	ctxt.BuildTags = c.BuildTags
+	return weave.Verify(ctxt, targets)
+}
+
+// openCache opens the cache in WovenGOPATH, and returns the keys for the targets.
+func (c *Compiler) openCache(oldGOPATH string, targets []string) (*cache.Cache, map[string]string, error) {
+	cch, err := cache.Open(filepath.Join(c.WovenGOPATH, CacheDirName))
+	if err != nil {
+		return nil, nil, err
+	}
+	exeHash, err := cache.ExecutableHash()
+	if err != nil {
+		return nil, nil, err
+	}
+	aspectClosure, err := c.aspectClosure()
+	if err != nil {
+		return nil, nil, err
+	}
+	aspectFnames, err := absFilenames(c.AspectFilenames)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := make(map[string]string)
+	for _, target := range targets {
+		targetClosure, err := cache.NewClosure(c.buildContext(), []string{target}, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys[target] = cache.Key(cacheVersion, exeHash, oldGOPATH, target,
+			fmt.Sprintf("deep=%t %q tags=%q", c.Deep, c.DeepAllow, c.BuildTags),
+			fmt.Sprintf("aspect=%q %q", aspectFnames, c.AspectPackages),
+			aspectClosure.Hash, targetClosure.Hash)
+	}
+	return cch, keys, nil
+}
+
+// buildContext returns the build context for the original GOPATH, with BuildTags.
+func (c *Compiler) buildContext() *build.Context {
+	ctxt := build.Default
+	ctxt.BuildTags = c.BuildTags
+	return &ctxt
+}
+
+// aspectClosure returns the closure of the aspect file or the aspect package.
+func (c *Compiler) aspectClosure() (*cache.Closure, error) {
+	fnames, err := absFilenames(c.AspectFilenames)
+	if err != nil {
+		return nil, err
+	}
+	return cache.NewClosure(c.buildContext(), c.AspectPackages, fnames)
+}
+
+// packageDirs returns the directories of the packages needed for building
+// (and testing) the woven targets: the closure of the targets, their test imports,
+// the aspect, and the packages imported by the woven files.
+func (c *Compiler) packageDirs(oldGOPATH string, targets, writtenFnames []string) ([]string, error) {
+	ctxt := c.buildContext()
+	importPaths := append([]string{}, targets...)
+	for _, target := range targets {
+		bp, err := ctxt.Import(target, "", 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, imp := range append(bp.TestImports, bp.XTestImports...) {
+			// resolves the vendored packages
+			if ibp, err := ctxt.Import(imp, bp.Dir, build.FindOnly); err == nil {
+				importPaths = append(importPaths, ibp.ImportPath)
+			}
+		}
+	}
+	for _, wf := range writtenFnames {
+		rel, err := filepath.Rel(c.WovenGOPATH, wf)
+		if err != nil {
+			return nil, err
+		}
+		imports, err := cache.FileImports(wf)
+		if err != nil {
+			return nil, err
+		}
+		for _, imp := range imports {
+			// the packages generated to WovenGOPATH (e.g. the introductions) are not found
+			if ibp, err := ctxt.Import(imp, filepath.Dir(filepath.Join(oldGOPATH, rel)), build.FindOnly); err == nil {
+				importPaths = append(importPaths, ibp.ImportPath)
+			}
+		}
+	}
+	fnames, err := absFilenames(c.AspectFilenames)
+	if err != nil {
+		return nil, err
+	}
+	cl, err := cache.NewClosure(ctxt, append(importPaths, c.AspectPackages...), fnames)
+	if err != nil {
+		return nil, err
+	}
+	return cl.Dirs, nil
+}
+
+func absFilenames(fnames []string) ([]string, error) {
+	var abs []string
+	for _, fname := range fnames {
+		a, err := filepath.Abs(fname)
+		if err != nil {
+			return nil, err
+		}
+		abs = append(abs, a)
+	}
+	return abs, nil
+}
+
+// resolveTargets resolves Target and Targets, and returns the list of
+// the resolved packages except for Excludes.
+func (c *Compiler) resolveTargets(gopath string) ([]string, error) {
+	seen := make(map[string]bool)
+	var resolved []string
+	for _, target := range append([]string{c.Target}, c.Targets...) {
+		if target == "" {
+			continue
+		}
+		pkgs, err := resolveTarget(gopath, target)
+		if err != nil {
+			return nil, err
+		}
+		for _, pkg := range pkgs {
+			if seen[pkg] || excluded(c.Excludes, pkg) {
+				continue
+			}
+			seen[pkg] = true
+			resolved = append(resolved, pkg)
+		}
+	}
+	if len(resolved) == 0 {
+		return nil, fmt.Errorf("no target package (all excluded by %q)", c.Excludes)
+	}
+	return resolved, nil
+}
+
+func excluded(excludes []string, pkg string) bool {
+	for _, pattern := range excludes {
+		if weave.MatchPackagePattern(pattern, pkg) {
+			return true
+		}
+	}
+	return false
+}
+
+// ImportPath returns the import path of pkg, which can be a directory in GOPATH
+// relative to dir (or absolute), e.g. "./foo" or "./foo/...".
+// Other values of pkg are returned as is.
+func ImportPath(dir, pkg string) (string, error) {
+	if !build.IsLocalImport(pkg) && !filepath.IsAbs(pkg) {
+		return pkg, nil
+	}
+	pkgDir, suffix := pkg, ""
+	if filepath.Base(pkg) == "..." {
+		pkgDir, suffix = filepath.Dir(pkg), "/..."
+	}
+	if !filepath.IsAbs(pkgDir) {
+		pkgDir = filepath.Join(dir, pkgDir)
+	}
+	p, err := build.ImportDir(pkgDir, build.FindOnly)
+	if err != nil {
+		return "", err
+	}
+	if p.ImportPath == "" || build.IsLocalImport(p.ImportPath) {
+		return "", fmt.Errorf("%s is not in GOPATH", pkg)
+	}
+	return p.ImportPath + suffix, nil
+}
+
 // resolveTarget resolves target that can contain ... and returns the list of
 // resolved packages.
 func resolveTarget(gopath, target string) ([]string, error) {
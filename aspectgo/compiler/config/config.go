@@ -0,0 +1,412 @@
+// Package config reads the project config file for weaving
+// (aspectgo.yaml, aspectgo.yml or aspectgo.json), which maps onto the fields
+// of compiler.Compiler.
+//
+// An example of aspectgo.yaml:
+//
+//	aspects:
+//	  - path: ./aspect/main_aspect.go
+//	  - path: example.com/aspects/trace
+//	    enabled: false
+//	targets:
+//	  - ./...
+//	excludes:
+//	  - example.com/foo/internal/...
+//	tags: [integration]
+//	output: symlink
+//	wovenGOPATH: /tmp/wovengopath
+//	deep: false
+//	cache: true
+//	verify: true
+//
+// Relative paths are relative to the directory of the config file.
+// A relative target or a relative aspect package is resolved to the import path,
+// and hence the directory needs to be in GOPATH.
+// An aspect item can also be written as a string, which is the same as
+// `path: <string>`.
+//
+// Only the fields specified in the config file are applied to the compiler.
+// Only one aspect can be enabled at the moment.
+//
+// The YAML file is parsed as the block-style subset of YAML, which is
+// enough for the config file; anchors, tags and multi-line scalars are
+// not supported.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/aspectgo/compiler"
+	"golang.org/x/exp/aspectgo/compiler/gopath"
+	"golang.org/x/exp/aspectgo/internal/node"
+)
+
+// DefaultFilenames are the file names of the config file looked up by Find,
+// in the order of precedence.
+var DefaultFilenames = []string{"aspectgo.yaml", "aspectgo.yml", "aspectgo.json"}
+
+// Config is the project config for weaving.
+type Config struct {
+	// Filename is the absolute path of the config file.
+	Filename string
+
+	// Aspects are the aspects, including the disabled ones.
+	Aspects []*Aspect
+
+	// Targets are the import paths of the target packages.
+	// Can contain ... for recursive weaving.
+	Targets []string
+
+	// Excludes are the import path patterns of the packages that are not woven.
+	Excludes []string
+
+	// Tags are the build tags.
+	Tags []string
+
+	// Output is the strategy for the woven GOPATH. Empty if not specified.
+	Output gopath.Strategy
+
+	// WovenGOPATH is the woven GOPATH. Empty if not specified.
+	WovenGOPATH string
+
+	// DeepAllow is the list of the import path patterns of the dependency
+	// packages to be woven when Deep is set.
+	DeepAllow []string
+
+	// Deep, Cache and Verify are nil if not specified.
+	Deep   *bool
+	Cache  *bool
+	Verify *bool
+}
+
+// Aspect is an aspect in the config file.
+type Aspect struct {
+	// Path is the absolute path of the aspect file (ending with .go),
+	// or the import path of the aspect package.
+	Path string
+
+	// Enabled is false if the aspect is disabled in the config file.
+	Enabled bool
+
+	// Line is the line number in the config file.
+	Line int
+}
+
+// Error is an error in the config file.
+type Error struct {
+	Filename string
+	Line     int
+	Msg      string
+}
+
+func (e *Error) Error() string {
+	if e.Filename == "" {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.Filename, e.Line, e.Msg)
+}
+
+// ErrorList is the list of the errors in the config file.
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	s := make([]string, len(l))
+	for i, e := range l {
+		s[i] = e.Error()
+	}
+	return strings.Join(s, "\n")
+}
+
+// Find returns the path of the config file in dir, or an empty string
+// if none of DefaultFilenames exists in dir.
+func Find(dir string) (string, error) {
+	for _, name := range DefaultFilenames {
+		fname := filepath.Join(dir, name)
+		if _, err := os.Stat(fname); err == nil {
+			return fname, nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// Load reads the config file.
+// The format is determined from the extension (.yaml, .yml or .json).
+// The errors in the config file are returned as ErrorList.
+func Load(filename string) (*Config, error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	var parse func([]byte) (*node.Node, error)
+	switch filepath.Ext(abs) {
+	case ".yaml", ".yml":
+		parse = node.ParseYAML
+	case ".json":
+		parse = node.ParseJSON
+	default:
+		return nil, fmt.Errorf("%s: unknown config file format (should be .yaml, .yml or .json)", filename)
+	}
+	b, err := ioutil.ReadFile(abs)
+	if err != nil {
+		return nil, err
+	}
+	n, err := parse(b)
+	if err != nil {
+		if e, ok := err.(*node.Error); ok {
+			return nil, ErrorList{{Filename: filename, Line: e.Line, Msg: e.Msg}}
+		}
+		return nil, err
+	}
+	d := &decoder{filename: filename, dir: filepath.Dir(abs)}
+	cfg := d.decode(n)
+	if len(d.errs) > 0 {
+		sort.SliceStable(d.errs, func(i, j int) bool { return d.errs[i].Line < d.errs[j].Line })
+		return nil, d.errs
+	}
+	cfg.Filename = abs
+	return cfg, nil
+}
+
+// Apply sets the fields of c specified in the config.
+// The enabled aspect replaces the aspect of c, and Targets replaces the targets of c.
+func (cfg *Config) Apply(c *compiler.Compiler) {
+	for _, asp := range cfg.Aspects {
+		if !asp.Enabled {
+			continue
+		}
+		if strings.HasSuffix(asp.Path, ".go") {
+			c.AspectFilenames, c.AspectPackages = []string{asp.Path}, nil
+		} else {
+			c.AspectFilenames, c.AspectPackages = nil, []string{asp.Path}
+		}
+	}
+	if cfg.Targets != nil {
+		c.Target, c.Targets = "", cfg.Targets
+	}
+	if cfg.Excludes != nil {
+		c.Excludes = cfg.Excludes
+	}
+	if cfg.Tags != nil {
+		c.BuildTags = cfg.Tags
+	}
+	if cfg.Output != "" {
+		c.Output = cfg.Output
+	}
+	if cfg.WovenGOPATH != "" {
+		c.WovenGOPATH = cfg.WovenGOPATH
+	}
+	if cfg.DeepAllow != nil {
+		c.DeepAllow = cfg.DeepAllow
+	}
+	if cfg.Deep != nil {
+		c.Deep = *cfg.Deep
+	}
+	if cfg.Cache != nil {
+		c.Cache = *cfg.Cache
+	}
+	if cfg.Verify != nil {
+		c.Verify = *cfg.Verify
+	}
+}
+
+// decoder decodes the nodes into Config, validating them against the schema.
+// The errors are collected, so that all of them can be reported at once.
+type decoder struct {
+	filename string
+	// dir is the directory of the config file, for the relative paths.
+	dir  string
+	errs ErrorList
+}
+
+func (d *decoder) errorf(line int, format string, args ...interface{}) {
+	d.errs = append(d.errs, &Error{Filename: d.filename, Line: line, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (d *decoder) decode(n *node.Node) *Config {
+	cfg := &Config{}
+	if n.Kind != node.MappingNode {
+		d.errorf(n.Line, "the config should be a mapping, got %s", n.Describe())
+		return cfg
+	}
+	seen := make(map[string]int)
+	for _, f := range n.Fields {
+		if line, ok := seen[f.Key]; ok {
+			d.errorf(f.Line, "duplicate field %q (first specified at line %d)", f.Key, line)
+			continue
+		}
+		seen[f.Key] = f.Line
+		if f.Value.Kind == node.ScalarNode && f.Value.Type == node.NullScalar {
+			// same as unspecified
+			continue
+		}
+		switch f.Key {
+		case "aspects":
+			cfg.Aspects = d.aspects(f)
+		case "targets":
+			cfg.Targets = d.importPaths(f)
+		case "excludes":
+			cfg.Excludes = d.importPaths(f)
+		case "tags":
+			cfg.Tags = d.strings(f)
+		case "output":
+			if s, ok := d.string(f); ok {
+				strategy, err := gopath.ParseStrategy(s)
+				if err != nil {
+					d.errorf(f.Value.Line, "%s", err)
+				}
+				cfg.Output = strategy
+			}
+		case "wovenGOPATH":
+			if s, ok := d.string(f); ok {
+				cfg.WovenGOPATH = d.abs(s)
+			}
+		case "deepAllow":
+			cfg.DeepAllow = d.strings(f)
+		case "deep":
+			cfg.Deep = d.bool(f)
+		case "cache":
+			cfg.Cache = d.bool(f)
+		case "verify":
+			cfg.Verify = d.bool(f)
+		default:
+			d.errorf(f.Line, "unknown field %q", f.Key)
+		}
+	}
+	var enabled []*Aspect
+	for _, asp := range cfg.Aspects {
+		if asp.Enabled {
+			enabled = append(enabled, asp)
+		}
+	}
+	if len(enabled) > 1 {
+		d.errorf(enabled[1].Line, "only single aspect can be enabled at the moment (another one is enabled at line %d)", enabled[0].Line)
+	}
+	return cfg
+}
+
+func (d *decoder) aspects(f *node.Field) []*Aspect {
+	if f.Value.Kind != node.SequenceNode {
+		d.errorf(f.Value.Line, "%q should be a sequence, got %s", f.Key, f.Value.Describe())
+		return nil
+	}
+	var aspects []*Aspect
+	for _, item := range f.Value.Items {
+		asp := &Aspect{Enabled: true, Line: item.Line}
+		switch {
+		case item.Kind == node.ScalarNode && item.Type == node.StringScalar:
+			// shorthand for `path: <string>`
+			asp.Path = item.Value
+		case item.Kind == node.MappingNode:
+			seen := make(map[string]bool)
+			for _, af := range item.Fields {
+				if seen[af.Key] {
+					d.errorf(af.Line, "duplicate field %q in aspect", af.Key)
+					continue
+				}
+				seen[af.Key] = true
+				switch af.Key {
+				case "path":
+					if s, ok := d.string(af); ok {
+						asp.Path = s
+					}
+				case "enabled":
+					if b := d.bool(af); b != nil {
+						asp.Enabled = *b
+					}
+				default:
+					d.errorf(af.Line, "unknown field %q in aspect", af.Key)
+				}
+			}
+		default:
+			d.errorf(item.Line, "aspect should be a string or a mapping, got %s", item.Describe())
+			continue
+		}
+		if asp.Path == "" {
+			d.errorf(item.Line, "aspect should have a non-empty \"path\"")
+			continue
+		}
+		asp.Path = d.aspectPath(asp.Path, item.Line)
+		aspects = append(aspects, asp)
+	}
+	return aspects
+}
+
+// aspectPath resolves the relative path of the aspect file or package.
+func (d *decoder) aspectPath(p string, line int) string {
+	if strings.HasSuffix(p, ".go") {
+		return d.abs(p)
+	}
+	return d.importPath(p, line)
+}
+
+func (d *decoder) abs(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(d.dir, p)
+}
+
+func (d *decoder) importPath(p string, line int) string {
+	importPath, err := compiler.ImportPath(d.dir, p)
+	if err != nil {
+		d.errorf(line, "%s", err)
+		return p
+	}
+	return importPath
+}
+
+func (d *decoder) importPaths(f *node.Field) []string {
+	ss := d.strings(f)
+	for i, s := range ss {
+		ss[i] = d.importPath(s, f.Value.Line)
+	}
+	return ss
+}
+
+func (d *decoder) string(f *node.Field) (string, bool) {
+	n := f.Value
+	if n.Kind != node.ScalarNode || n.Type != node.StringScalar {
+		d.errorf(n.Line, "%q should be a string, got %s", f.Key, n.Describe())
+		return "", false
+	}
+	return n.Value, true
+}
+
+// strings decodes a sequence of strings. A string is also accepted,
+// as a sequence of a single string.
+func (d *decoder) strings(f *node.Field) []string {
+	n := f.Value
+	if n.Kind == node.ScalarNode && n.Type == node.StringScalar {
+		return []string{n.Value}
+	}
+	if n.Kind != node.SequenceNode {
+		d.errorf(n.Line, "%q should be a sequence of strings, got %s", f.Key, n.Describe())
+		return nil
+	}
+	ss := []string{}
+	for _, item := range n.Items {
+		if item.Kind != node.ScalarNode || item.Type != node.StringScalar {
+			d.errorf(item.Line, "%q should be a sequence of strings, got %s in the sequence", f.Key, item.Describe())
+			continue
+		}
+		ss = append(ss, item.Value)
+	}
+	return ss
+}
+
+func (d *decoder) bool(f *node.Field) *bool {
+	n := f.Value
+	if n.Kind != node.ScalarNode || n.Type != node.BoolScalar {
+		d.errorf(n.Line, "%q should be a bool, got %s", f.Key, n.Describe())
+		return nil
+	}
+	b := n.Value == "true"
+	return &b
+}
@@ -0,0 +1,162 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/exp/aspectgo/compiler"
+	"golang.org/x/exp/aspectgo/compiler/gopath"
+)
+
+const testYAML = `# aspectgo config
+aspects:
+  - path: aspect/main_aspect.go
+  - path: example.com/aspects/trace
+    enabled: false
+targets:
+  - example.com/foo/...
+excludes: [example.com/foo/internal/..., "example.com/foo/gen"]
+tags: integration
+output: hardlink
+wovenGOPATH: woven # relative to the config file
+cache: false
+`
+
+const testJSON = `{
+	"aspects": [
+		"aspect/main_aspect.go",
+		{"path": "example.com/aspects/trace", "enabled": false}
+	],
+	"targets": ["example.com/foo/..."],
+	"excludes": ["example.com/foo/internal/...", "example.com/foo/gen"],
+	"tags": "integration",
+	"output": "hardlink",
+	"wovenGOPATH": "woven",
+	"cache": false
+}
+`
+
+func writeConfig(t *testing.T, name, content string) string {
+	dir, err := ioutil.TempDir("", "aspectgo-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fname := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(fname, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fname
+}
+
+func TestLoad(t *testing.T) {
+	for name, content := range map[string]string{"aspectgo.yaml": testYAML, "aspectgo.json": testJSON} {
+		fname := writeConfig(t, name, content)
+		defer os.RemoveAll(filepath.Dir(fname))
+		found, err := Find(filepath.Dir(fname))
+		if err != nil || found != fname {
+			t.Fatalf("%s: unexpected Find result: %q, %v", name, found, err)
+		}
+		cfg, err := Load(fname)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		dir := filepath.Dir(fname)
+		c := &compiler.Compiler{Target: "example.com/bar", Cache: true, Verify: true}
+		cfg.Apply(c)
+		expected := &compiler.Compiler{
+			WovenGOPATH:     filepath.Join(dir, "woven"),
+			Targets:         []string{"example.com/foo/..."},
+			Excludes:        []string{"example.com/foo/internal/...", "example.com/foo/gen"},
+			BuildTags:       []string{"integration"},
+			AspectFilenames: []string{filepath.Join(dir, "aspect/main_aspect.go")},
+			Output:          gopath.Hardlink,
+			Verify:          true,
+		}
+		if !reflect.DeepEqual(c, expected) {
+			t.Fatalf("%s: unexpected compiler:\n%+v\nexpected:\n%+v", name, c, expected)
+		}
+		if len(cfg.Aspects) != 2 || cfg.Aspects[1].Enabled || cfg.Aspects[1].Line != 4 {
+			t.Fatalf("%s: unexpected aspects: %+v", name, cfg.Aspects[1])
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	testCases := []struct {
+		name     string
+		content  string
+		expected []string
+	}{
+		{
+			name: "aspectgo.yaml",
+			content: `aspects:
+  - path: a.go
+  - b.go
+targets: example.com/foo
+output: zip
+cache: yes
+unknown: 1
+targets: []
+`,
+			expected: []string{
+				`aspectgo.yaml:3: only single aspect can be enabled at the moment (another one is enabled at line 2)`,
+				`aspectgo.yaml:5: unknown output strategy "zip"`,
+				`aspectgo.yaml:6: "cache" should be a bool, got a string`,
+				`aspectgo.yaml:7: unknown field "unknown"`,
+				`aspectgo.yaml:8: duplicate field "targets" (first specified at line 4)`,
+			},
+		},
+		{
+			name: "aspectgo.yaml",
+			content: `aspects:
+  - path: a.go
+    enabled: 1
+tags:
+  - [foo]
+`,
+			expected: []string{
+				`aspectgo.yaml:3: "enabled" should be a bool, got a number`,
+				`aspectgo.yaml:5: "tags" should be a sequence of strings, got a sequence in the sequence`,
+			},
+		},
+		{
+			name:     "aspectgo.yaml",
+			content:  "aspects:\n  - a.go\n   - b.go\n",
+			expected: []string{`aspectgo.yaml:3: unexpected indentation`},
+		},
+		{
+			name:    "aspectgo.json",
+			content: "{\n\t\"aspects\": [\"a.go\"],\n\t\"deep\": \"true\",\n\t\"aspects\": []\n}\n",
+			expected: []string{
+				`aspectgo.json:3: "deep" should be a bool, got a string`,
+				`aspectgo.json:4: duplicate field "aspects" (first specified at line 2)`,
+			},
+		},
+		{
+			name:     "aspectgo.json",
+			content:  "{\n\t\"aspects\": [\"a.go\"],\n\t\"deep\": tru\n}\n",
+			expected: []string{`aspectgo.json:3: invalid character`},
+		},
+	}
+	for _, tc := range testCases {
+		fname := writeConfig(t, tc.name, tc.content)
+		defer os.RemoveAll(filepath.Dir(fname))
+		_, err := Load(fname)
+		errs, ok := err.(ErrorList)
+		if !ok {
+			t.Fatalf("expected ErrorList, got %v", err)
+		}
+		if len(errs) != len(tc.expected) {
+			t.Fatalf("expected %d errors, got:\n%v", len(tc.expected), errs)
+		}
+		for i, e := range errs {
+			if s := strings.TrimPrefix(e.Error(), filepath.Dir(fname)+string(filepath.Separator)); !strings.HasPrefix(s, tc.expected[i]) {
+				t.Errorf("expected %q, got %q", tc.expected[i], s)
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package gopath
+
+import (
+	"encoding/json"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BuildContext returns the build context for loading the woven packages in wovenGOPATH.
+// If wovenGOPATH was laid out with Overlay, the context reads the original GOPATH
+// through the overlay, as `go build -overlay` does.
+func BuildContext(wovenGOPATH string) (*build.Context, error) {
+	ctxt := build.Default
+	m, err := ReadManifest(wovenGOPATH)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil || m.Strategy != Overlay {
+		ctxt.GOPATH = wovenGOPATH
+		return &ctxt, nil
+	}
+	b, err := ioutil.ReadFile(filepath.Join(wovenGOPATH, OverlayFilename))
+	if err != nil {
+		return nil, err
+	}
+	var overlay struct {
+		Replace map[string]string
+	}
+	if err := json.Unmarshal(b, &overlay); err != nil {
+		return nil, err
+	}
+	o := overlayFS(overlay.Replace)
+	ctxt.OpenFile = o.openFile
+	ctxt.IsDir = o.isDir
+	ctxt.ReadDir = o.readDir
+	return &ctxt, nil
+}
+
+// overlayFS maps the original file names to the replaced file names.
+// An empty replaced file name means that the file is deleted.
+type overlayFS map[string]string
+
+func (o overlayFS) openFile(name string) (io.ReadCloser, error) {
+	if replaced, ok := o[name]; ok {
+		if replaced == "" {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		name = replaced
+	}
+	return os.Open(name)
+}
+
+func (o overlayFS) isDir(name string) bool {
+	if fi, err := os.Stat(name); err == nil && fi.IsDir() {
+		return true
+	}
+	for orig, replaced := range o {
+		if replaced != "" && strings.HasPrefix(orig, name+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o overlayFS) readDir(dir string) ([]os.FileInfo, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil && !o.isDir(dir) {
+		return nil, err
+	}
+	var result []os.FileInfo
+	seen := make(map[string]bool)
+	for _, fi := range fis {
+		name := filepath.Join(dir, fi.Name())
+		if replaced, ok := o[name]; ok {
+			if replaced == "" {
+				continue
+			}
+			if fi, err = os.Stat(replaced); err != nil {
+				return nil, err
+			}
+		}
+		seen[name] = true
+		result = append(result, fi)
+	}
+	// the files added by the overlay
+	for orig, replaced := range o {
+		if replaced == "" || seen[orig] || filepath.Dir(orig) != dir {
+			continue
+		}
+		fi, err := os.Stat(replaced)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, fi)
+	}
+	return result, nil
+}
@@ -2,8 +2,6 @@
 package gopath
 
 import (
-	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -31,14 +29,6 @@ func FileForNewGOPATH(s, oldGOPATH, wovenGOPATH string) (*os.File, error) {
 	return os.Create(n)
 }
 
-type fixUpAction string
-
-const (
-	symlink fixUpAction = "symlink"
-	recur               = "recur"
-	skip                = "skip"
-)
-
 func exists(name string) (bool, error) {
 	_, err := os.Stat(name)
 	if !os.IsNotExist(err) {
@@ -58,76 +48,3 @@ func isDir(name string) (bool, error) {
 	}
 	return fi.IsDir(), nil
 }
-
-func _fixUp(oc os.FileInfo, oldDir, wovenDir string, writtenFnames []string) (fixUpAction, string, string, error) {
-	act := symlink
-	ocFullName := filepath.Join(oldDir, oc.Name())
-	wcFullName := filepath.Join(wovenDir, oc.Name())
-	ocIsDir, _ := isDir(ocFullName)
-	wcExists, _ := exists(wcFullName)
-	if ocIsDir {
-		for _, wf := range writtenFnames {
-			if strings.HasPrefix(wf, wcFullName) {
-				act = recur
-				goto ret
-			}
-		}
-	} else {
-		if strings.HasSuffix(ocFullName, "_aspect.go") {
-			act = skip
-			goto ret
-		}
-		for _, wf := range writtenFnames {
-			if wf == wcFullName {
-				act = skip
-				goto ret
-			}
-		}
-	}
-	if wcExists {
-		act = skip
-		goto ret
-	}
-ret:
-	return act, ocFullName, wcFullName, nil
-}
-
-// FixUp fixes up GOPATH after the weaving phase.
-// It makes some symbolic links from wovenDir to oldDir so that
-// the woven package can be built with wovenDir as GOPATH.
-func FixUp(oldDir, wovenDir string, writtenFnames []string) error {
-	ochildren, err := ioutil.ReadDir(oldDir)
-	if err != nil {
-		return err
-	}
-	for _, oc := range ochildren {
-		act, ocFullName, wcFullName, err := _fixUp(oc, oldDir, wovenDir, writtenFnames)
-		if err != nil {
-			return err
-		}
-		switch act {
-		case symlink:
-			wgExists, _ := exists(wovenDir)
-			if !wgExists {
-				err = os.MkdirAll(wovenDir, 0755)
-				if err != nil {
-					return err
-				}
-			}
-			err = os.Symlink(ocFullName, wcFullName)
-			if err != nil {
-				return err
-			}
-		case recur:
-			err = FixUp(ocFullName, wcFullName, writtenFnames)
-			if err != nil {
-				return err
-			}
-		case skip:
-			// NOP
-		default:
-			return fmt.Errorf("impl error: act=%s", act)
-		}
-	}
-	return nil
-}
@@ -0,0 +1,372 @@
+package gopath
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Strategy is the strategy for making the untouched files of the original GOPATH
+// available in the woven GOPATH.
+type Strategy string
+
+const (
+	// Symlink makes symbolic links to the untouched files and directories.
+	Symlink Strategy = "symlink"
+	// Hardlink makes hard links to the untouched files.
+	// The original GOPATH and the woven GOPATH need to be on the same filesystem.
+	Hardlink Strategy = "hardlink"
+	// Copy copies the untouched files.
+	Copy Strategy = "copy"
+	// Overlay does not make the woven GOPATH buildable by itself.
+	// Instead, it writes OverlayFilename, which can be passed to `go build -overlay`
+	// along with the original GOPATH.
+	Overlay Strategy = "overlay"
+)
+
+// Strategies are the supported strategies.
+var Strategies = []Strategy{Symlink, Hardlink, Copy, Overlay}
+
+// ParseStrategy parses the name of the strategy.
+func ParseStrategy(s string) (Strategy, error) {
+	for _, st := range Strategies {
+		if string(st) == s {
+			return st, nil
+		}
+	}
+	return "", fmt.Errorf("unknown output strategy %q (should be one of %v)", s, Strategies)
+}
+
+// ManifestFilename is the name of the manifest in the woven GOPATH.
+const ManifestFilename = ".aspectgo-manifest.json"
+
+// OverlayFilename is the name of the overlay file for `go build -overlay`
+// in the woven GOPATH.
+const OverlayFilename = "overlay.json"
+
+// Manifest records the files in the woven GOPATH.
+type Manifest struct {
+	Strategy Strategy
+	Entries  []ManifestEntry
+}
+
+// ManifestEntry is an entry of Manifest.
+type ManifestEntry struct {
+	// Path is the slash-separated path relative to the woven GOPATH.
+	Path string
+	// Origin is the original file (or directory) in the original GOPATH.
+	// It is empty for a generated file, e.g. the introductions.
+	Origin string
+	// Kind is "woven" for a woven (or generated) file, or the strategy that
+	// made the entry for an untouched file.
+	Kind string
+}
+
+// KindWoven is ManifestEntry.Kind for a woven (or generated) file.
+const KindWoven = "woven"
+
+// ReadManifest reads the manifest in wovenGOPATH.
+// It returns nil if wovenGOPATH has no manifest.
+func ReadManifest(wovenGOPATH string) (*Manifest, error) {
+	b, err := ioutil.ReadFile(filepath.Join(wovenGOPATH, ManifestFilename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("broken manifest in %s: %s", wovenGOPATH, err)
+	}
+	return &m, nil
+}
+
+func (m *Manifest) write(wovenGOPATH string) error {
+	sort.Slice(m.Entries, func(i, j int) bool { return m.Entries[i].Path < m.Entries[j].Path })
+	b, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(wovenGOPATH, ManifestFilename), b, 0644)
+}
+
+// Clean removes the files recorded in the manifest of the previous run,
+// and the directories that become empty.
+// The other files in wovenGOPATH (e.g. the cache) are kept.
+func Clean(wovenGOPATH string) error {
+	m, err := ReadManifest(wovenGOPATH)
+	if err != nil || m == nil {
+		return err
+	}
+	wovenGOPATH = filepath.Clean(wovenGOPATH)
+	names := make([]string, len(m.Entries))
+	for i, e := range m.Entries {
+		// an edited or broken manifest must not remove the files outside wovenGOPATH
+		name := filepath.Join(wovenGOPATH, filepath.FromSlash(e.Path))
+		if !strings.HasPrefix(name, wovenGOPATH+string(filepath.Separator)) {
+			return fmt.Errorf("broken manifest in %s: %q is not under the woven GOPATH", wovenGOPATH, e.Path)
+		}
+		names[i] = name
+	}
+	dirs := make(map[string]bool)
+	for _, name := range names {
+		// os.Remove does not follow the symlinks
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for d := filepath.Dir(name); d != wovenGOPATH && strings.HasPrefix(d, wovenGOPATH); d = filepath.Dir(d) {
+			dirs[d] = true
+		}
+	}
+	var sorted []string
+	for d := range dirs {
+		sorted = append(sorted, d)
+	}
+	// remove the deeper ones first
+	sort.Sort(sort.Reverse(sort.StringSlice(sorted)))
+	for _, d := range sorted {
+		if fis, err := ioutil.ReadDir(d); err == nil && len(fis) == 0 {
+			os.Remove(d)
+		}
+	}
+	for _, name := range []string{ManifestFilename, OverlayFilename} {
+		if err := os.Remove(filepath.Join(wovenGOPATH, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Layout makes the untouched files in oldGOPATH available in wovenGOPATH
+// with the strategy, after the weaving phase. writtenFnames are the woven files,
+// and the files in excludedFnames (e.g. the aspect file) are not made available.
+// Layout writes the manifest for all of them.
+//
+// Only the files under oldGOPATH/src are made available, i.e. pkg and bin are not.
+// If pkgDirs is not nil, only the files in pkgDirs (the directories of the packages
+// needed for building the woven packages) and in their testdata are made available.
+func Layout(strategy Strategy, oldGOPATH, wovenGOPATH string, pkgDirs, writtenFnames, excludedFnames []string) error {
+	m := &Manifest{Strategy: strategy}
+	written := make(map[string]bool)
+	for _, wf := range writtenFnames {
+		if written[wf] {
+			continue
+		}
+		written[wf] = true
+		rel, err := filepath.Rel(wovenGOPATH, wf)
+		if err != nil {
+			return err
+		}
+		origin := filepath.Join(oldGOPATH, rel)
+		if ok, _ := exists(origin); !ok {
+			origin = ""
+		}
+		m.Entries = append(m.Entries, ManifestEntry{Path: filepath.ToSlash(rel), Origin: origin, Kind: KindWoven})
+	}
+	if strategy == Overlay {
+		if err := writeOverlay(oldGOPATH, wovenGOPATH, writtenFnames, excludedFnames); err != nil {
+			return err
+		}
+		return m.write(wovenGOPATH)
+	}
+	l := &layouter{
+		strategy:       strategy,
+		wovenGOPATH:    wovenGOPATH,
+		writtenFnames:  writtenFnames,
+		excludedFnames: excludedFnames,
+		manifest:       m,
+	}
+	if pkgDirs != nil {
+		l.pkgDirs = make(map[string]bool)
+		for _, d := range pkgDirs {
+			l.pkgDirs[filepath.Clean(d)] = true
+		}
+	}
+	oldSrc := filepath.Join(oldGOPATH, "src")
+	if ok, _ := isDir(oldSrc); ok {
+		if err := l.layOut(oldSrc, filepath.Join(wovenGOPATH, "src"), pkgDirs == nil); err != nil {
+			return err
+		}
+	}
+	return m.write(wovenGOPATH)
+}
+
+// writeOverlay writes the overlay that replaces the original files with the woven ones,
+// and deletes the excluded files.
+func writeOverlay(oldGOPATH, wovenGOPATH string, writtenFnames, excludedFnames []string) error {
+	overlay := struct {
+		Replace map[string]string
+	}{Replace: make(map[string]string)}
+	for _, wf := range writtenFnames {
+		rel, err := filepath.Rel(wovenGOPATH, wf)
+		if err != nil {
+			return err
+		}
+		overlay.Replace[filepath.Join(oldGOPATH, rel)] = wf
+	}
+	for _, ef := range excludedFnames {
+		overlay.Replace[ef] = ""
+	}
+	b, err := json.MarshalIndent(overlay, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(wovenGOPATH, OverlayFilename), b, 0644)
+}
+
+// layouter lays out the untouched files.
+type layouter struct {
+	strategy       Strategy
+	wovenGOPATH    string
+	writtenFnames  []string
+	excludedFnames []string
+	manifest       *Manifest
+	// pkgDirs are the directories of the packages needed for building.
+	// nil if all the packages are laid out.
+	pkgDirs map[string]bool
+}
+
+type layoutAction string
+
+const (
+	link  layoutAction = "link"
+	recur              = "recur"
+	skip               = "skip"
+)
+
+// action returns the action for the child oc of the directory oldDir.
+// all is true if all the files under oldDir are laid out.
+// For a directory, action also returns whether all the files under it are laid out.
+func (l *layouter) action(oldDir string, all bool, oc os.FileInfo, ocFullName, wcFullName string) (layoutAction, bool) {
+	if ocIsDir, _ := isDir(ocFullName); ocIsDir {
+		childAll := all || (l.pkgDirs[oldDir] && oc.Name() == "testdata")
+		if !childAll && !l.needed(ocFullName) {
+			return skip, false
+		}
+		if l.strategy != Symlink {
+			// the hidden directories (e.g. VCS metadata) are not needed for building
+			if strings.HasPrefix(oc.Name(), ".") {
+				return skip, false
+			}
+			return recur, childAll
+		}
+		for _, wf := range l.writtenFnames {
+			if strings.HasPrefix(wf, wcFullName+string(filepath.Separator)) {
+				return recur, childAll
+			}
+		}
+	} else {
+		if !all && !l.pkgDirs[oldDir] {
+			return skip, false
+		}
+		for _, ef := range l.excludedFnames {
+			if ef == ocFullName {
+				return skip, false
+			}
+		}
+		for _, wf := range l.writtenFnames {
+			if wf == wcFullName {
+				return skip, false
+			}
+		}
+	}
+	if wcExists, _ := exists(wcFullName); wcExists {
+		return skip, false
+	}
+	return link, false
+}
+
+// needed returns whether dir is one of pkgDirs, or contains any of them.
+func (l *layouter) needed(dir string) bool {
+	for d := range l.pkgDirs {
+		if d == dir || strings.HasPrefix(d, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// layOut lays out the children of oldDir to wovenDir.
+// all is true if all the files under oldDir are laid out.
+func (l *layouter) layOut(oldDir, wovenDir string, all bool) error {
+	ochildren, err := ioutil.ReadDir(oldDir)
+	if err != nil {
+		return err
+	}
+	for _, oc := range ochildren {
+		ocFullName := filepath.Join(oldDir, oc.Name())
+		wcFullName := filepath.Join(wovenDir, oc.Name())
+		act, childAll := l.action(oldDir, all, oc, ocFullName, wcFullName)
+		switch act {
+		case link:
+			if err := os.MkdirAll(wovenDir, 0755); err != nil {
+				return err
+			}
+			if err := l.link(ocFullName, wcFullName); err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(l.wovenGOPATH, wcFullName)
+			if err != nil {
+				return err
+			}
+			l.manifest.Entries = append(l.manifest.Entries,
+				ManifestEntry{Path: filepath.ToSlash(rel), Origin: ocFullName, Kind: string(l.strategy)})
+		case recur:
+			if err := l.layOut(ocFullName, wcFullName, childAll); err != nil {
+				return err
+			}
+		case skip:
+			// NOP
+		default:
+			return fmt.Errorf("impl error: act=%s", act)
+		}
+	}
+	return nil
+}
+
+func (l *layouter) link(ocFullName, wcFullName string) error {
+	switch l.strategy {
+	case Symlink:
+		return os.Symlink(ocFullName, wcFullName)
+	case Hardlink:
+		if err := os.Link(ocFullName, wcFullName); err != nil {
+			return fmt.Errorf("%s (-output=%s may work instead)", err, Copy)
+		}
+		return nil
+	case Copy:
+		return copyFile(ocFullName, wcFullName)
+	}
+	return fmt.Errorf("impl error: strategy=%s", l.strategy)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		log.Printf("Not copying %s (%s)", src, fi.Mode())
+		return nil
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,225 @@
+package gopath
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, name, content string) {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLayout(t *testing.T) {
+	for _, strategy := range Strategies {
+		t.Run(string(strategy), func(t *testing.T) {
+			testLayout(t, strategy)
+		})
+	}
+}
+
+func testLayout(t *testing.T, strategy Strategy) {
+	dir, err := ioutil.TempDir("", "aspectgolayouttest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldGOPATH := filepath.Join(dir, "old")
+	wovenGOPATH := filepath.Join(dir, "woven")
+	writeTestFile(t, filepath.Join(oldGOPATH, "src", "foo", "main.go"), "package main\n")
+	writeTestFile(t, filepath.Join(oldGOPATH, "src", "foo", "util.go"), "package main // util\n")
+	writeTestFile(t, filepath.Join(oldGOPATH, "src", "foo", "main_aspect.go"), "package main // aspect\n")
+	writeTestFile(t, filepath.Join(oldGOPATH, "src", "bar", "bar.go"), "package bar\n")
+	// pkg and bin are never laid out
+	writeTestFile(t, filepath.Join(oldGOPATH, "pkg", "mod", "example.com", "baz.go"), "package baz\n")
+	writeTestFile(t, filepath.Join(oldGOPATH, "bin", "baz"), "")
+
+	woven := filepath.Join(wovenGOPATH, "src", "foo", "main.go")
+	generated := filepath.Join(wovenGOPATH, "src", "agaspect", "agaspect.go")
+	writeTestFile(t, woven, "package main // woven\n")
+	writeTestFile(t, generated, "package agaspect\n")
+	excluded := filepath.Join(oldGOPATH, "src", "foo", "main_aspect.go")
+	if err := Layout(strategy, oldGOPATH, wovenGOPATH, nil, []string{woven, generated}, []string{excluded}); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := ReadManifest(wovenGOPATH)
+	if err != nil || m == nil {
+		t.Fatalf("no manifest: %v", err)
+	}
+	if m.Strategy != strategy {
+		t.Fatalf("unexpected strategy: %s", m.Strategy)
+	}
+	entries := make(map[string]ManifestEntry)
+	for _, e := range m.Entries {
+		entries[e.Path] = e
+	}
+	if e := entries["src/foo/main.go"]; e.Kind != KindWoven || e.Origin != filepath.Join(oldGOPATH, "src", "foo", "main.go") {
+		t.Fatalf("unexpected entry for the woven file: %+v", e)
+	}
+	if e := entries["src/agaspect/agaspect.go"]; e.Kind != KindWoven || e.Origin != "" {
+		t.Fatalf("unexpected entry for the generated file: %+v", e)
+	}
+
+	if strategy == Overlay {
+		if _, err := os.Lstat(filepath.Join(wovenGOPATH, "src", "foo", "util.go")); !os.IsNotExist(err) {
+			t.Fatalf("untouched file is laid out: %v", err)
+		}
+		b, err := ioutil.ReadFile(filepath.Join(wovenGOPATH, OverlayFilename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Logf("overlay: %s", b)
+	} else {
+		for _, rel := range []string{"src/foo/util.go", "src/bar/bar.go"} {
+			b, err := ioutil.ReadFile(filepath.Join(wovenGOPATH, filepath.FromSlash(rel)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, _ := ioutil.ReadFile(filepath.Join(oldGOPATH, filepath.FromSlash(rel)))
+			if string(b) != string(want) {
+				t.Fatalf("unexpected content of %s: %q", rel, b)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(wovenGOPATH, "src", "foo", "main_aspect.go")); !os.IsNotExist(err) {
+			t.Fatalf("excluded file is laid out: %v", err)
+		}
+	}
+	for _, name := range []string{"pkg", "bin"} {
+		if _, err := os.Lstat(filepath.Join(wovenGOPATH, name)); !os.IsNotExist(err) {
+			t.Fatalf("%s is laid out: %v", name, err)
+		}
+	}
+	if b, err := ioutil.ReadFile(woven); err != nil || string(b) != "package main // woven\n" {
+		t.Fatalf("woven file is overwritten: %q, err=%v", b, err)
+	}
+
+	// the cache and so on are not recorded in the manifest, and kept
+	kept := filepath.Join(wovenGOPATH, ".aspectgo-cache", "kept")
+	writeTestFile(t, kept, "")
+	if err := Clean(wovenGOPATH); err != nil {
+		t.Fatal(err)
+	}
+	fis, err := ioutil.ReadDir(wovenGOPATH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 1 || fis[0].Name() != ".aspectgo-cache" {
+		t.Fatalf("unexpected files after cleaning: %v", fis)
+	}
+	if _, err := os.Stat(filepath.Join(oldGOPATH, "src", "bar", "bar.go")); err != nil {
+		t.Fatalf("original file is removed: %v", err)
+	}
+}
+
+func TestBuildContextOverlay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aspectgolayouttest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldGOPATH := filepath.Join(dir, "old")
+	wovenGOPATH := filepath.Join(dir, "woven")
+	writeTestFile(t, filepath.Join(oldGOPATH, "src", "foo", "main.go"), "package main\n")
+	writeTestFile(t, filepath.Join(oldGOPATH, "src", "foo", "main_aspect.go"), "package main // aspect\n")
+	woven := filepath.Join(wovenGOPATH, "src", "foo", "main.go")
+	generated := filepath.Join(wovenGOPATH, "src", "agaspect", "agaspect.go")
+	writeTestFile(t, woven, "package main // woven\n")
+	writeTestFile(t, generated, "package agaspect\n")
+	excluded := filepath.Join(oldGOPATH, "src", "foo", "main_aspect.go")
+	if err := Layout(Overlay, oldGOPATH, wovenGOPATH, nil, []string{woven, generated}, []string{excluded}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctxt, err := BuildContext(wovenGOPATH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctxt.GOPATH = oldGOPATH
+	pkg, err := ctxt.Import("foo", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkg.GoFiles) != 1 || pkg.GoFiles[0] != "main.go" {
+		t.Fatalf("unexpected files: %v", pkg.GoFiles)
+	}
+	rc, err := ctxt.OpenFile(filepath.Join(pkg.Dir, "main.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if b, err := ioutil.ReadAll(rc); err != nil || string(b) != "package main // woven\n" {
+		t.Fatalf("unexpected content: %q, err=%v", b, err)
+	}
+	if _, err := ctxt.Import("agaspect", "", 0); err != nil {
+		t.Fatalf("the package added by the overlay is not found: %v", err)
+	}
+}
+
+func TestLayoutPackageDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aspectgolayouttest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldGOPATH := filepath.Join(dir, "old")
+	wovenGOPATH := filepath.Join(dir, "woven")
+	writeTestFile(t, filepath.Join(oldGOPATH, "src", "foo", "main.go"), "package main\n")
+	writeTestFile(t, filepath.Join(oldGOPATH, "src", "foo", "util.go"), "package main // util\n")
+	writeTestFile(t, filepath.Join(oldGOPATH, "src", "foo", "testdata", "data", "input.txt"), "input\n")
+	writeTestFile(t, filepath.Join(oldGOPATH, "src", "foo", "sub", "sub.go"), "package sub\n")
+	writeTestFile(t, filepath.Join(oldGOPATH, "src", "example.com", "lib", "lib.go"), "package lib\n")
+	writeTestFile(t, filepath.Join(oldGOPATH, "src", "example.com", "unused", "unused.go"), "package unused\n")
+	woven := filepath.Join(wovenGOPATH, "src", "foo", "main.go")
+	writeTestFile(t, woven, "package main // woven\n")
+	pkgDirs := []string{
+		filepath.Join(oldGOPATH, "src", "foo"),
+		filepath.Join(oldGOPATH, "src", "example.com", "lib"),
+	}
+	if err := Layout(Copy, oldGOPATH, wovenGOPATH, pkgDirs, []string{woven}, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, rel := range []string{"src/foo/util.go", "src/foo/testdata/data/input.txt", "src/example.com/lib/lib.go"} {
+		if _, err := os.Stat(filepath.Join(wovenGOPATH, filepath.FromSlash(rel))); err != nil {
+			t.Fatalf("%s is not laid out: %v", rel, err)
+		}
+	}
+	for _, rel := range []string{"src/foo/sub", "src/example.com/unused"} {
+		if _, err := os.Stat(filepath.Join(wovenGOPATH, filepath.FromSlash(rel))); !os.IsNotExist(err) {
+			t.Fatalf("%s is laid out: %v", rel, err)
+		}
+	}
+}
+
+func TestCleanBrokenManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aspectgolayouttest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wovenGOPATH := filepath.Join(dir, "woven")
+	outside := filepath.Join(dir, "outside.go")
+	writeTestFile(t, outside, "package outside\n")
+	writeTestFile(t, filepath.Join(wovenGOPATH, "src", "foo", "main.go"), "package main\n")
+	m := &Manifest{Strategy: Copy, Entries: []ManifestEntry{
+		{Path: "src/foo/main.go", Kind: KindWoven},
+		{Path: "src/../../outside.go", Kind: KindWoven},
+	}}
+	if err := m.write(wovenGOPATH); err != nil {
+		t.Fatal(err)
+	}
+	if err := Clean(wovenGOPATH); err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, fname := range []string{outside, filepath.Join(wovenGOPATH, "src", "foo", "main.go")} {
+		if _, err := os.Stat(fname); err != nil {
+			t.Fatalf("%s is removed: %v", fname, err)
+		}
+	}
+}
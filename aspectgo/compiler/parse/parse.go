@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"go/parser"
 	"go/types"
+	"path/filepath"
 
 	"golang.org/x/tools/go/loader"
 
@@ -14,25 +15,88 @@ import (
 
 const aspectPackagePath = consts.AspectGoPackagePath + "/aspect"
 
-// AspectFile is the type for an aspect file.
+// LegacyImportPath is the import path of the package
+// that an aspect file is rewritten to.
+const LegacyImportPath = "agaspect"
+
+// AspectFile is the type for an aspect file, or an aspect package.
 type AspectFile struct {
-	Filename  string
-	Program   *loader.Program
-	PkgInfo   *loader.PackageInfo
-	Pointcuts map[*types.Named]aspect.Pointcut
+	// Filename is the absolute file name of the aspect file.
+	// It is empty for an aspect package.
+	Filename string
+	// ImportPath is the import path of the aspect package.
+	// It is LegacyImportPath for an aspect file.
+	ImportPath string
+	Program    *loader.Program
+	PkgInfo    *loader.PackageInfo
+	Pointcuts  map[*types.Named]aspect.Pointcut
+	// Instantiations contains the instantiation models of the aspects.
+	Instantiations map[*types.Named]aspect.Instantiation
+	// Introductions contains the target type patterns of the introductions.
+	Introductions map[*types.Named]aspect.TypePattern
+}
+
+// IsAspectFile returns true if filename is the aspect file,
+// which is not a part of the target package.
+func (af *AspectFile) IsAspectFile(filename string) bool {
+	return af.Filename != "" && filename == af.Filename
 }
 
 // ParseAspectFile parses an aspect file.
+// The aspect file needs to be package main, and it is rewritten to LegacyImportPath.
 func ParseAspectFile(aspectFilename string) (*AspectFile, error) {
+	aspectFilename, err := filepath.Abs(aspectFilename)
+	if err != nil {
+		return nil, err
+	}
 	prog, pkgInfo, err := _parseAspectFile(aspectFilename)
 	if err != nil {
 		return nil, err
 	}
-	pkg := pkgInfo.Pkg
-	if pkg.Name() != "main" {
-		return nil, fmt.Errorf("aspect package name must be main: %s", pkg.Name())
+	if name := pkgInfo.Pkg.Name(); name != "main" {
+		return nil, fmt.Errorf("aspect package name must be main: %s", name)
+	}
+	return parseAspects(&AspectFile{
+		Filename:   aspectFilename,
+		ImportPath: LegacyImportPath,
+		Program:    prog,
+		PkgInfo:    pkgInfo,
+	})
+}
+
+// ParseAspectPackage parses the aspect package of the import path.
+// Unlike an aspect file, the aspect package is imported by the woven packages as is.
+func ParseAspectPackage(importPath string) (*AspectFile, error) {
+	conf := loader.Config{
+		ParserMode: parser.ParseComments,
+	}
+	conf.Import(importPath)
+	prog, err := conf.Load()
+	if err != nil {
+		return nil, err
+	}
+	pkgInfo := prog.Package(importPath)
+	if pkgInfo == nil {
+		return nil, fmt.Errorf("could not find %s", importPath)
 	}
-	aspectIntf, err := lookupAspectInterface(prog)
+	if len(pkgInfo.Errors) != 0 {
+		return nil, fmt.Errorf("package %s has errors: %v", pkgInfo, pkgInfo.Errors)
+	}
+	if pkgInfo.Pkg.Name() == "main" {
+		return nil, fmt.Errorf("aspect package cannot be main: %s", importPath)
+	}
+	return parseAspects(&AspectFile{
+		ImportPath: importPath,
+		Program:    prog,
+		PkgInfo:    pkgInfo,
+	})
+}
+
+// parseAspects looks up the aspects and the introductions in the package of af,
+// and determines the pointcuts.
+func parseAspects(af *AspectFile) (*AspectFile, error) {
+	pkg := af.PkgInfo.Pkg
+	aspectIntf, err := lookupAspectInterface(af.Program, "Aspect")
 	if err != nil {
 		return nil, err
 	}
@@ -40,17 +104,22 @@ func ParseAspectFile(aspectFilename string) (*AspectFile, error) {
 	if err != nil {
 		return nil, err
 	}
-	aspectFile := &AspectFile{
-		Filename:  aspectFilename,
-		Program:   prog,
-		PkgInfo:   pkgInfo,
-		Pointcuts: make(map[*types.Named]aspect.Pointcut),
+	introIntf, err := lookupAspectInterface(af.Program, "Introduction")
+	if err != nil {
+		return nil, err
+	}
+	introductions, err := lookupIntroductions(pkg, introIntf, aspectIntf)
+	if err != nil {
+		return nil, err
 	}
-	err = aspectFile.determinePointcuts(aspects)
+	af.Pointcuts = make(map[*types.Named]aspect.Pointcut)
+	af.Instantiations = make(map[*types.Named]aspect.Instantiation)
+	af.Introductions = make(map[*types.Named]aspect.TypePattern)
+	err = af.determinePointcuts(aspects, introductions)
 	if err != nil {
 		return nil, err
 	}
-	return aspectFile, nil
+	return af, nil
 }
 
 func _parseAspectFile(aspectFilename string) (*loader.Program, *loader.PackageInfo, error) {
@@ -100,10 +169,61 @@ func lookupAspects(pkg *types.Package, aspectIntf *types.Named) ([]*types.Named,
 	return result, nil
 }
 
-func lookupAspectInterface(program *loader.Program) (*types.Named, error) {
+// lookupIntroductions returns the introductions.
+// An introduction cannot be an aspect at the same time,
+// and its exported methods need to take the target as the first parameter of type interface{}.
+func lookupIntroductions(pkg *types.Package, introIntf, aspectIntf *types.Named) ([]*types.Named, error) {
+	var result []*types.Named
+	for _, name := range pkg.Scope().Names() {
+		tObj, ok := pkg.Scope().Lookup(name).(*types.TypeName)
+		if !ok {
+			continue
+		}
+		named := tObj.Type().(*types.Named)
+		ptr := types.NewPointer(named)
+		if !types.AssignableTo(ptr, introIntf) {
+			continue
+		}
+		if types.AssignableTo(named, introIntf) {
+			return nil, fmt.Errorf("introduction should have pointer-receiver: %s", named)
+		}
+		if types.AssignableTo(ptr, aspectIntf) {
+			return nil, fmt.Errorf("introduction cannot be an aspect: %s", named)
+		}
+		for _, m := range IntroducedMethods(named) {
+			params := m.Type().(*types.Signature).Params()
+			if params.Len() == 0 || !isEmptyInterface(params.At(0).Type()) {
+				return nil, fmt.Errorf("introduced method %s needs to take the target as the first parameter of type interface{}", m.FullName())
+			}
+		}
+		result = append(result, named)
+	}
+	return result, nil
+}
+
+func isEmptyInterface(typ types.Type) bool {
+	intf, ok := typ.Underlying().(*types.Interface)
+	return ok && intf.NumMethods() == 0
+}
+
+// IntroducedMethods returns the methods introduced by the introduction.
+func IntroducedMethods(intro *types.Named) []*types.Func {
+	var methods []*types.Func
+	mset := types.NewMethodSet(types.NewPointer(intro))
+	for i := 0; i < mset.Len(); i++ {
+		m := mset.At(i).Obj().(*types.Func)
+		if !m.Exported() || m.Name() == "Targets" {
+			continue
+		}
+		methods = append(methods, m)
+	}
+	return methods
+}
+
+func lookupAspectInterface(program *loader.Program, name string) (*types.Named, error) {
 	for pkg := range program.AllPackages {
 		if pkg.Path() == aspectPackagePath {
-			obj := pkg.Scope().Lookup("Aspect")
+			obj := pkg.Scope().Lookup(name)
 			tObj, ok := obj.(*types.TypeName)
 			if !ok {
 				return nil, fmt.Errorf("invalid aspect definition (not *types.TypeName)")
@@ -2,6 +2,7 @@ package parse
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"go/types"
 	"io/ioutil"
@@ -15,39 +16,59 @@ import (
 	"golang.org/x/exp/aspectgo/compiler/consts"
 )
 
-// compile the aspect and get Pointcut data
+// compile the aspect and get Pointcut data (and Instantiation data)
+// for aspects, or TypePattern data for introductions.
 // steps:
-//  * copy the aspect file to tmp.go
+//  * copy the aspect file to tmp.go (or import the aspect package)
 // * add main() to tmp.go
 // * compile and run tmp.go
 // * parse the output and generate Pointcut data
-func (af *AspectFile) determinePointcuts(aspects []*types.Named) error {
+func (af *AspectFile) determinePointcuts(aspects, introductions []*types.Named) error {
 	// TODO: do them at once
 	for _, aspect := range aspects {
-		dir, err := ioutil.TempDir("", "aspectgo")
+		out, err := af.runTmpAspect(aspect)
 		if err != nil {
 			return err
 		}
-		defer os.RemoveAll(dir)
-		if err = locateTmpAspectFile(af.Filename, dir); err != nil {
-			return err
-		}
-		if err = locateTmpAspectMainFile(aspect.Obj().Name(), dir); err != nil {
-			return err
-		}
-		s, err := runTmpAspectMain(dir)
-		if err != nil {
-			return err
-		}
-		pointcut, err := parseTmpAspectMainOutput(s)
+		af.Pointcuts[aspect] = out.Pointcut
+		af.Instantiations[aspect] = out.Instantiation
+	}
+	for _, intro := range introductions {
+		out, err := af.runTmpAspect(intro)
 		if err != nil {
 			return err
 		}
-		af.Pointcuts[aspect] = pointcut
+		af.Introductions[intro] = out.Targets
 	}
 	return nil
 }
 
+func (af *AspectFile) runTmpAspect(aspect *types.Named) (*tmpAspectMainOutput, error) {
+	dir, err := ioutil.TempDir("", "aspectgo")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	files := []string{"main.go"}
+	aspectPackagePath := ""
+	if af.Filename != "" {
+		if err = locateTmpAspectFile(af.Filename, dir); err != nil {
+			return nil, err
+		}
+		files = append(files, "aspect.go")
+	} else {
+		aspectPackagePath = af.ImportPath
+	}
+	if err = locateTmpAspectMainFile(aspect.Obj().Name(), aspectPackagePath, dir); err != nil {
+		return nil, err
+	}
+	s, err := runTmpAspectMain(dir, files)
+	if err != nil {
+		return nil, err
+	}
+	return parseTmpAspectMainOutput(s)
+}
+
 // locate aspectFilename to dir to determine the pointcut value
 // TODO: eliminate aspectStructure.Advice()
 func locateTmpAspectFile(aspectFilename, dir string) error {
@@ -65,9 +86,15 @@ func locateTmpAspectFile(aspectFilename, dir string) error {
 const tmpAspectMainFileTmpl = consts.AutogenFileHeader + `package main
 
 import (
+    "encoding/json"
     "fmt"
     "io/ioutil"
     "os"
+
+    _ag_aspect "` + consts.AspectGoPackagePath + `/aspect"
+{{- if .aspectPackagePath}}
+    _ag_aspects "{{.aspectPackagePath}}"
+{{- end}}
 )
 
 func main() {
@@ -76,20 +103,42 @@ func main() {
     }
     fName := os.Args[1]
 
-    asp := &{{.aspectStructureName}}{}
-    pointcut := asp.Pointcut()
+    asp := &{{if .aspectPackagePath}}_ag_aspects.{{end}}{{.aspectStructureName}}{}
+    var out struct {
+        Pointcut      _ag_aspect.Pointcut
+        Instantiation _ag_aspect.Instantiation
+        Targets       _ag_aspect.TypePattern
+    }
+    if a, ok := interface{}(asp).(_ag_aspect.Aspect); ok {
+        out.Pointcut = a.Pointcut()
+    }
+    if i, ok := interface{}(asp).(_ag_aspect.Instantiator); ok {
+        out.Instantiation = i.Instantiation()
+    }
+    if i, ok := interface{}(asp).(_ag_aspect.Introduction); ok {
+        out.Targets = i.Targets()
+    }
+    b, err := json.Marshal(out)
+    if err != nil {
+        panic(err)
+    }
 
-    err := ioutil.WriteFile(fName, []byte(pointcut), 0444)
+    err = ioutil.WriteFile(fName, b, 0444)
     if err != nil {
         panic(err)
     }
 }
 `
 
-func locateTmpAspectMainFile(aspectStructureName, dir string) error {
+// locateTmpAspectMainFile generates main.go.
+// aspectPackagePath is empty if the aspect file is located to dir.
+func locateTmpAspectMainFile(aspectStructureName, aspectPackagePath, dir string) error {
 	var b bytes.Buffer
 	t := template.New("t")
-	m := map[string]string{"aspectStructureName": aspectStructureName}
+	m := map[string]string{
+		"aspectStructureName": aspectStructureName,
+		"aspectPackagePath":   aspectPackagePath,
+	}
 	template.Must(t.Parse(tmpAspectMainFileTmpl))
 	if err := t.Execute(&b, m); err != nil {
 		return err
@@ -101,9 +150,9 @@ func locateTmpAspectMainFile(aspectStructureName, dir string) error {
 	return nil
 }
 
-func runTmpAspectMain(dir string) (string, error) {
+func runTmpAspectMain(dir string, files []string) (string, error) {
 	cmdName := "go"
-	arg := []string{"run", "main.go", "aspect.go", "result.txt"}
+	arg := append(append([]string{"run"}, files...), "result.txt")
 	cmd := exec.Command(cmdName, arg...)
 	var (
 		stdout bytes.Buffer
@@ -129,6 +178,17 @@ func runTmpAspectMain(dir string) (string, error) {
 	return resultS, nil
 }
 
-func parseTmpAspectMainOutput(s string) (aspect.Pointcut, error) {
-	return aspect.Pointcut(s), nil
+// tmpAspectMainOutput is the output of the tmp main.
+type tmpAspectMainOutput struct {
+	Pointcut      aspect.Pointcut
+	Instantiation aspect.Instantiation
+	Targets       aspect.TypePattern
+}
+
+func parseTmpAspectMainOutput(s string) (*tmpAspectMainOutput, error) {
+	var out tmpAspectMainOutput
+	if err := json.Unmarshal([]byte(s), &out); err != nil {
+		return nil, fmt.Errorf("could not parse the aspect output %q: %s", s, err)
+	}
+	return &out, nil
 }
@@ -0,0 +1,93 @@
+package compiler
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/aspectgo/compiler/cache"
+)
+
+// debounceDuration is the duration for coalescing the changes, e.g. the ones
+// made by `git checkout`.
+const debounceDuration = 200 * time.Millisecond
+
+// Watch does all the compilation phases, and does them again whenever the
+// aspect, the target packages or their dependencies are changed, until done is closed.
+// The errors in the compilation phases are logged, and do not stop watching.
+// done can be nil.
+func (c *Compiler) Watch(done <-chan struct{}) error {
+	var dirs []string
+	for {
+		if err := c.Do(); err != nil {
+			log.Printf("%s", err)
+		}
+		if newDirs, err := c.watchedDirs(); err != nil {
+			// e.g. a broken import statement. keep watching the old ones.
+			log.Printf("%s", err)
+		} else {
+			dirs = newDirs
+		}
+		if len(dirs) == 0 {
+			return errors.New("nothing to watch")
+		}
+		log.Printf("Watching %d directories for changes", len(dirs))
+		changed, err := waitForChange(dirs, done)
+		if err != nil {
+			return err
+		}
+		if changed == "" {
+			return nil
+		}
+		log.Printf("Changed: %s", changed)
+	}
+}
+
+// watchedDirs returns the directories of the aspect, the target packages and
+// their dependencies, except for the standard library.
+func (c *Compiler) watchedDirs() ([]string, error) {
+	oldGOPATH := os.Getenv("GOPATH")
+	targets, err := c.resolveTargets(oldGOPATH)
+	if err != nil {
+		return nil, err
+	}
+	closures := make([]*cache.Closure, 0, len(targets)+1)
+	aspectClosure, err := c.aspectClosure()
+	if err != nil {
+		return nil, err
+	}
+	closures = append(closures, aspectClosure)
+	targetClosure, err := cache.NewClosure(c.buildContext(), targets, nil)
+	if err != nil {
+		return nil, err
+	}
+	closures = append(closures, targetClosure)
+
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, cl := range closures {
+		for _, dir := range cl.Dirs {
+			// the woven files are not watched, in case WovenGOPATH is in GOPATH
+			if !seen[dir] && !strings.HasPrefix(dir, c.WovenGOPATH) {
+				seen[dir] = true
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+// isWatchedFile returns true if the change of the file needs re-weaving.
+// The temporary files of editors are ignored.
+func isWatchedFile(name string) bool {
+	for _, ext := range []string{".go", ".s", ".c", ".h"} {
+		if strings.HasSuffix(name, ext) && !strings.HasPrefix(name, ".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package compiler
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/exp/inotify"
+)
+
+const inotifyMask = inotify.IN_CLOSE_WRITE | inotify.IN_CREATE | inotify.IN_DELETE |
+	inotify.IN_MOVED_FROM | inotify.IN_MOVED_TO
+
+// waitForChange waits for a change of the files in dirs using inotify,
+// and returns the name of the changed file.
+// It returns an empty string when done is closed.
+func waitForChange(dirs []string, done <-chan struct{}) (string, error) {
+	w, err := inotify.NewWatcher()
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		w.Close()
+		// the reader goroutine of the watcher exits after the pending events
+		// are received
+		go func() {
+			for range w.Event {
+			}
+		}()
+		go func() {
+			for range w.Error {
+			}
+		}()
+	}()
+	for _, dir := range dirs {
+		if err := w.AddWatch(dir, inotifyMask); err != nil {
+			return "", fmt.Errorf("cannot watch %s: %s", dir, err)
+		}
+	}
+
+	var (
+		changed  string
+		debounce <-chan time.Time
+	)
+	for {
+		select {
+		case <-done:
+			return "", nil
+		case <-debounce:
+			return changed, nil
+		case err := <-w.Error:
+			return "", err
+		case ev := <-w.Event:
+			// ev.Name is the watched directory followed by the file name
+			if ev.Mask&inotifyMask == 0 || !isWatchedFile(filepath.Base(ev.Name)) {
+				continue
+			}
+			if changed == "" {
+				changed = ev.Name
+			}
+			// wait for the subsequent changes
+			debounce = time.After(debounceDuration)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+//go:build !linux
+
+package compiler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+)
+
+// pollInterval is the interval for polling the changes.
+const pollInterval = time.Second
+
+// waitForChange waits for a change of the files in dirs by polling,
+// and returns the name of the changed file.
+// It returns an empty string when done is closed.
+func waitForChange(dirs []string, done <-chan struct{}) (string, error) {
+	old, err := snapshot(dirs)
+	if err != nil {
+		return "", err
+	}
+	for {
+		select {
+		case <-done:
+			return "", nil
+		case <-time.After(pollInterval):
+		}
+		cur, err := snapshot(dirs)
+		if err != nil {
+			return "", err
+		}
+		for name, mtime := range cur {
+			if !old[name].Equal(mtime) {
+				time.Sleep(debounceDuration)
+				return name, nil
+			}
+		}
+		for name := range old {
+			if _, ok := cur[name]; !ok {
+				time.Sleep(debounceDuration)
+				return name, nil
+			}
+		}
+	}
+}
+
+// snapshot returns the modification times of the watched files in dirs.
+func snapshot(dirs []string) (map[string]time.Time, error) {
+	m := make(map[string]time.Time)
+	for _, dir := range dirs {
+		fis, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("cannot watch %s: %s", dir, err)
+		}
+		for _, fi := range fis {
+			if isWatchedFile(fi.Name()) {
+				m[filepath.Join(dir, fi.Name())] = fi.ModTime()
+			}
+		}
+	}
+	return m, nil
+}
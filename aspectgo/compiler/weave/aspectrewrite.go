@@ -17,7 +17,16 @@ import (
 	"golang.org/x/exp/aspectgo/compiler/parse"
 )
 
+// aspectPkgName is the name of the aspect package in the woven files.
+// The name is prefixed with "_ag_" so that it does not collide with the identifiers in the target.
+const aspectPkgName = "_ag_aspects"
+
+// rewriteAspectFile rewrites the aspect file to the LegacyImportPath package in wovenGOPATH.
+// An aspect package is not rewritten, as it is imported as is.
 func rewriteAspectFile(wovenGOPATH string, af *parse.AspectFile) ([]string, error) {
+	if af.Filename == "" {
+		return nil, nil
+	}
 	// look up *ast.File object
 	var target *ast.File
 	for _, file := range af.PkgInfo.Files {
@@ -31,7 +40,7 @@ func rewriteAspectFile(wovenGOPATH string, af *parse.AspectFile) ([]string, erro
 	}
 	// prepare file name
 	wovenPkgPath := filepath.Join(filepath.Join(wovenGOPATH, "src"),
-		"agaspect")
+		parse.LegacyImportPath)
 	err := os.MkdirAll(wovenPkgPath, 0755)
 	if err != nil {
 		return nil, err
@@ -70,7 +79,7 @@ func (r *aspectFileRewriter) Rewrite(node ast.Node) (ast.Node, rewrite.Rewriter)
 		if oldName != "main" {
 			log.Fatalf("impl error: why not main? this is unexpected and critical: %s: %v", oldName, n)
 		}
-		newName := "agaspect"
+		newName := parse.LegacyImportPath
 		rewritten := *n
 		rewritten.Name = ast.NewIdent(newName)
 		return &rewritten, r
@@ -0,0 +1,44 @@
+package weave
+
+import (
+	"golang.org/x/exp/aspectgo/aspect"
+	"golang.org/x/exp/aspectgo/compiler/parse"
+)
+
+// CallSite is a call site matched with a "call" or "handler" pointcut of an aspect,
+// i.e. a static edge of the call graph.
+// The fields are the same as aspect.JoinPoint of the woven call site.
+type CallSite struct {
+	Kind aspect.JoinPointKind
+	// Caller is the full name of the function that contains the call site.
+	Caller string
+	// Callee is the full name of the called function.
+	Callee string
+	// Pos is the position of the call site, relative to $GOPATH/src.
+	Pos string
+}
+
+// CallSites returns the call sites in the target packages that would be woven with af,
+// in the order of the positions in each package.
+// The call sites woven only for "cflow" terms are not included,
+// as they are not advised.
+// opts can be nil.
+func CallSites(targets []string, af *parse.AspectFile, opts *Options) ([]*CallSite, error) {
+	jps, err := JoinPoints(targets, af, opts)
+	if err != nil {
+		return nil, err
+	}
+	var sites []*CallSite
+	for _, jp := range jps {
+		if (jp.Kind != aspect.KindCall && jp.Kind != aspect.KindHandler) || jp.Aspect == "" {
+			continue
+		}
+		sites = append(sites, &CallSite{
+			Kind:   jp.Kind,
+			Caller: jp.Caller,
+			Callee: jp.Name,
+			Pos:    jp.Pos,
+		})
+	}
+	return sites, nil
+}
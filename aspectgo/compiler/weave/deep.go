@@ -0,0 +1,159 @@
+package weave
+
+import (
+	"go/ast"
+	"go/build"
+	"go/types"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/loader"
+
+	"golang.org/x/exp/aspectgo/compiler/consts"
+	"golang.org/x/exp/aspectgo/compiler/parse"
+	"golang.org/x/exp/aspectgo/compiler/util"
+)
+
+// deepPackages returns the dependency packages of the target that can be woven
+// in the deep mode, sorted by the import paths.
+// The packages that match none of the allow patterns are not woven.
+// See deepDenied for the packages that are never woven.
+func deepPackages(prog *loader.Program, af *parse.AspectFile, allow []string) []*loader.PackageInfo {
+	initial := make(map[*loader.PackageInfo]bool)
+	for _, pkgInfo := range prog.InitialPackages() {
+		initial[pkgInfo] = true
+	}
+	// the packages imported by the aspect file cannot import the aspect package
+	aspectDeps := make(map[string]bool)
+	for pkg := range af.Program.AllPackages {
+		aspectDeps[pkg.Path()] = true
+	}
+	var pkgs []*loader.PackageInfo
+	for pkg, pkgInfo := range prog.AllPackages {
+		if initial[pkgInfo] || !matchAnyPackagePattern(allow, pkg.Path()) {
+			continue
+		}
+		if reason := deepDenied(prog, pkgInfo, aspectDeps); reason != "" {
+			if util.DebugMode {
+				log.Printf("Not weaving %s: %s", pkg.Path(), reason)
+			}
+			continue
+		}
+		pkgs = append(pkgs, pkgInfo)
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Pkg.Path() < pkgs[j].Pkg.Path() })
+	return pkgs
+}
+
+// deepDenied returns the reason why the dependency package cannot be woven,
+// or an empty string.
+func deepDenied(prog *loader.Program, pkgInfo *loader.PackageInfo, aspectDeps map[string]bool) string {
+	path := pkgInfo.Pkg.Path()
+	switch {
+	case len(pkgInfo.Files) == 0:
+		return "no Go file"
+	case path == consts.AspectGoPackagePath+"/aspect",
+		strings.HasPrefix(path, consts.AspectGoPackagePath+"/aspect/"),
+		strings.HasPrefix(path, consts.AspectGoPackagePath+"/internal/"):
+		return "part of the AspectGo runtime"
+	case aspectDeps[path]:
+		return "imported by the aspect file"
+	}
+	dir := filepath.Dir(prog.Fset.Position(pkgInfo.Files[0].Pos()).Filename)
+	bp, err := build.ImportDir(dir, 0)
+	if err != nil {
+		return err.Error()
+	}
+	switch {
+	case bp.Goroot:
+		// the woven GOPATH cannot override GOROOT
+		return "standard library"
+	case len(bp.SFiles) != 0:
+		return "has assembly files"
+	case len(bp.CgoFiles) != 0:
+		return "uses cgo"
+	}
+	return ""
+}
+
+// matchAnyPackagePattern returns true if path matches any of the patterns,
+// or the patterns are empty.
+// The path of a vendored package is also matched without the vendor prefix.
+func matchAnyPackagePattern(patterns []string, path string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	paths := []string{path}
+	if i := strings.LastIndex(path, "/vendor/"); i >= 0 {
+		paths = append(paths, path[i+len("/vendor/"):])
+	} else if strings.HasPrefix(path, "vendor/") {
+		paths = append(paths, strings.TrimPrefix(path, "vendor/"))
+	}
+	for _, pattern := range patterns {
+		re := packagePatternRegexp(pattern)
+		for _, p := range paths {
+			if re.MatchString(p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MatchPackagePattern returns true if path matches pattern.
+// pattern can contain "..." as wildcards, in the same way as the go tool.
+func MatchPackagePattern(pattern, path string) bool {
+	return packagePatternRegexp(pattern).MatchString(path)
+}
+
+// packagePatternRegexp compiles the package pattern, in the same way as the go tool.
+// "..." matches any string, and "foo/..." matches "foo" as well.
+func packagePatternRegexp(pattern string) *regexp.Regexp {
+	re := regexp.QuoteMeta(pattern)
+	re = strings.Replace(re, `\.\.\.`, `.*`, -1)
+	if strings.HasSuffix(re, `/.*`) {
+		re = strings.TrimSuffix(re, `/.*`) + `(/.*)?`
+	}
+	return regexp.MustCompile(`^` + re + `$`)
+}
+
+// packagesToRewrite returns the initial packages, and the other packages in pkgs
+// that contain any of the matched nodes.
+func packagesToRewrite(prog *loader.Program, pkgs []*loader.PackageInfo, matched map[*ast.Ident]types.Object, stmts map[ast.Node]*stmtMatch) []*loader.PackageInfo {
+	initial := make(map[*loader.PackageInfo]bool)
+	for _, pkgInfo := range prog.InitialPackages() {
+		initial[pkgInfo] = true
+	}
+	var nodes []ast.Node
+	for id := range matched {
+		nodes = append(nodes, id)
+	}
+	for node := range stmts {
+		nodes = append(nodes, node)
+	}
+	var result []*loader.PackageInfo
+	for _, pkgInfo := range pkgs {
+		if initial[pkgInfo] || containsNode(pkgInfo, nodes) {
+			if !initial[pkgInfo] {
+				log.Printf("Weaving the dependency package %s", pkgInfo.Pkg.Path())
+			}
+			result = append(result, pkgInfo)
+		}
+	}
+	return result
+}
+
+// containsNode returns true if any of the nodes is in the files of the package.
+func containsNode(pkgInfo *loader.PackageInfo, nodes []ast.Node) bool {
+	for _, file := range pkgInfo.Files {
+		for _, node := range nodes {
+			if file.Pos() <= node.Pos() && node.Pos() < file.End() {
+				return true
+			}
+		}
+	}
+	return false
+}
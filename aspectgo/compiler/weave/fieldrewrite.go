@@ -0,0 +1,783 @@
+package weave
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"log"
+
+	rewrite "github.com/tsuna/gorewrite"
+
+	"golang.org/x/tools/go/loader"
+
+	"golang.org/x/exp/aspectgo/aspect"
+)
+
+// field access rewriting.
+//
+// `x.f` (get) is rewritten to `_ag_proxy_N(x)`.
+// `x.f = v` (set) is rewritten to `_ag_proxy_N(&x, v)`.
+// `x.f += v` and `x.f++` are rewritten to `_ag_proxy_N(&x, x.f + (v))`,
+// where `x.f` in the new value is also rewritten if it matches a "get" pointcut.
+// If x is not a simple expression (e.g. `get().f += v`), x is evaluated once
+// into the parameter of a proxy that calls the "get" and "set" proxies
+// (see compoundAssignProxy).
+// The tuple assignment `x.f, y = v, w` is rewritten to `_ag_proxy_N(&x, &y)(v, w)`,
+// so that the operands on the left and the values on the right are evaluated
+// before any assignment (see tupleAssignProxy).
+//
+// The expressions that need to be addressable (e.g. `x` in `x.f = v`) are
+// rewritten with rewriteAddressed(), which does not rewrite field reads into proxies,
+// because the proxies return copies.
+
+func (r *rewriter) typesInfo() *loader.PackageInfo {
+	return r.Program.AllPackages[r.pkg]
+}
+
+func (r *rewriter) typeOf(e ast.Expr) types.Type {
+	return r.typesInfo().TypeOf(e)
+}
+
+func (r *rewriter) isPointer(e ast.Expr) bool {
+	typ := r.typeOf(e)
+	if typ == nil {
+		return false
+	}
+	_, ok := typ.Underlying().(*types.Pointer)
+	return ok
+}
+
+func (r *rewriter) isArray(e ast.Expr) bool {
+	typ := r.typeOf(e)
+	if typ == nil {
+		return false
+	}
+	_, ok := typ.Underlying().(*types.Array)
+	return ok
+}
+
+// fieldSelector returns the selector expression if e is a field selector
+// (optionally parenthesized).
+func (r *rewriter) fieldSelector(e ast.Expr) *ast.SelectorExpr {
+	se, ok := unparen(e).(*ast.SelectorExpr)
+	if !ok {
+		return nil
+	}
+	sel, ok := r.typesInfo().Selections[se]
+	if !ok || sel.Kind() != types.FieldVal {
+		return nil
+	}
+	return se
+}
+
+func (r *rewriter) fieldPointcut(se *ast.SelectorExpr, kind aspect.JoinPointKind) (aspect.Pointcut, bool) {
+	pointcut, ok := r.FieldPointcuts[se][kind]
+	return pointcut, ok
+}
+
+func (r *rewriter) fieldMatched(se *ast.SelectorExpr) bool {
+	_, ok := r.FieldPointcuts[se]
+	return ok
+}
+
+// unsupported reports the field access that cannot be woven.
+func (r *rewriter) unsupported(node ast.Node, format string, args ...interface{}) {
+	log.Printf("%s: unsupported: %s (not woven)",
+		r.Program.Fset.Position(node.Pos()), fmt.Sprintf(format, args...))
+}
+
+// isAddressedMethodValue returns true if se is a method value with a pointer receiver
+// and a non-pointer base, i.e. se.X needs to be addressable.
+func (r *rewriter) isAddressedMethodValue(se *ast.SelectorExpr) bool {
+	sel, ok := r.typesInfo().Selections[se]
+	if !ok || sel.Kind() != types.MethodVal {
+		return false
+	}
+	recv := sel.Obj().Type().(*types.Signature).Recv()
+	if recv == nil {
+		return false
+	}
+	_, recvIsPointer := recv.Type().(*types.Pointer)
+	return recvIsPointer && !r.isPointer(se.X)
+}
+
+// rewriteAddressed rewrites e, keeping e addressable.
+func (r *rewriter) rewriteAddressed(e ast.Expr) ast.Expr {
+	switch x := e.(type) {
+	case *ast.ParenExpr:
+		x.X = r.rewriteAddressed(x.X)
+		return x
+	case *ast.SelectorExpr:
+		if r.fieldSelector(x) == nil {
+			break
+		}
+		if r.isPointer(x.X) {
+			x.X = rewrite.Rewrite(r, x.X).(ast.Expr)
+		} else {
+			x.X = r.rewriteAddressed(x.X)
+		}
+		return x
+	case *ast.IndexExpr:
+		if !r.isArray(x.X) {
+			break
+		}
+		x.X = r.rewriteAddressed(x.X)
+		x.Index = rewrite.Rewrite(r, x.Index).(ast.Expr)
+		return x
+	}
+	return rewrite.Rewrite(r, e).(ast.Expr)
+}
+
+func (r *rewriter) rewriteSliceIndices(n *ast.SliceExpr) {
+	if n.Low != nil {
+		n.Low = rewrite.Rewrite(r, n.Low).(ast.Expr)
+	}
+	if n.High != nil {
+		n.High = rewrite.Rewrite(r, n.High).(ast.Expr)
+	}
+	if n.Max != nil {
+		n.Max = rewrite.Rewrite(r, n.Max).(ast.Expr)
+	}
+}
+
+// isSimpleExpr returns true if e can be evaluated twice without side effects.
+func isSimpleExpr(e ast.Expr) bool {
+	switch x := e.(type) {
+	case *ast.Ident, *ast.BasicLit:
+		return true
+	case *ast.ParenExpr:
+		return isSimpleExpr(x.X)
+	case *ast.SelectorExpr:
+		return isSimpleExpr(x.X)
+	case *ast.StarExpr:
+		return isSimpleExpr(x.X)
+	case *ast.IndexExpr:
+		return isSimpleExpr(x.X) && isSimpleExpr(x.Index)
+	}
+	return false
+}
+
+// cloneExpr clones e. e needs to satisfy isSimpleExpr.
+func cloneExpr(e ast.Expr) ast.Expr {
+	switch x := e.(type) {
+	case *ast.Ident:
+		return &ast.Ident{NamePos: x.NamePos, Name: x.Name}
+	case *ast.BasicLit:
+		return &ast.BasicLit{ValuePos: x.ValuePos, Kind: x.Kind, Value: x.Value}
+	case *ast.ParenExpr:
+		return &ast.ParenExpr{Lparen: x.Lparen, X: cloneExpr(x.X), Rparen: x.Rparen}
+	case *ast.SelectorExpr:
+		return &ast.SelectorExpr{X: cloneExpr(x.X), Sel: cloneExpr(x.Sel).(*ast.Ident)}
+	case *ast.StarExpr:
+		return &ast.StarExpr{Star: x.Star, X: cloneExpr(x.X)}
+	case *ast.IndexExpr:
+		return &ast.IndexExpr{X: cloneExpr(x.X), Lbrack: x.Lbrack, Index: cloneExpr(x.Index), Rbrack: x.Rbrack}
+	}
+	log.Fatalf("impl error: %T is unexpected type", e)
+	return nil
+}
+
+// rewriteAssignStmt rewrites non-define assignments.
+func (r *rewriter) rewriteAssignStmt(n *ast.AssignStmt) ast.Stmt {
+	if len(n.Lhs) == 1 && len(n.Rhs) == 1 {
+		if se := r.fieldSelector(n.Lhs[0]); se != nil {
+			if n.Tok == token.ASSIGN {
+				if pointcut, ok := r.fieldPointcut(se, aspect.KindSet); ok {
+					value := rewrite.Rewrite(r, n.Rhs[0]).(ast.Expr)
+					return r.fieldSetStmt(se, pointcut, r.fieldSetReceiver(se), value)
+				}
+			} else {
+				// e.g. token.ADD_ASSIGN ("+=") --> token.ADD ("+")
+				op := token.ADD + (n.Tok - token.ADD_ASSIGN)
+				if stmt := r.rewriteCompoundAssign(se, op, n.Rhs[0]); stmt != nil {
+					return stmt
+				}
+			}
+		}
+	} else {
+		for _, lhs := range n.Lhs {
+			if se := r.fieldSelector(lhs); se != nil {
+				if _, ok := r.fieldPointcut(se, aspect.KindSet); ok {
+					return r.rewriteTupleAssign(n)
+				}
+			}
+		}
+	}
+	for i := range n.Lhs {
+		n.Lhs[i] = r.rewriteAddressed(n.Lhs[i])
+	}
+	for i := range n.Rhs {
+		n.Rhs[i] = rewrite.Rewrite(r, n.Rhs[i]).(ast.Expr)
+	}
+	return n
+}
+
+func (r *rewriter) rewriteIncDecStmt(n *ast.IncDecStmt) ast.Stmt {
+	if se := r.fieldSelector(n.X); se != nil {
+		op := token.ADD
+		if n.Tok == token.DEC {
+			op = token.SUB
+		}
+		one := &ast.BasicLit{ValuePos: n.TokPos, Kind: token.INT, Value: "1"}
+		if stmt := r.rewriteCompoundAssign(se, op, one); stmt != nil {
+			return stmt
+		}
+	}
+	n.X = r.rewriteAddressed(n.X)
+	return n
+}
+
+// rewriteCompoundAssign rewrites `se op= y`.
+// It returns nil if se is not matched, or cannot be rewritten.
+func (r *rewriter) rewriteCompoundAssign(se *ast.SelectorExpr, op token.Token, y ast.Expr) ast.Stmt {
+	if !r.fieldMatched(se) {
+		return nil
+	}
+	if !isSimpleExpr(se.X) {
+		return r.compoundAssignProxyStmt(se, op, y)
+	}
+	// clone se before rewriting se.X in place
+	cur := cloneExpr(se)
+	if pointcut, ok := r.fieldPointcut(se, aspect.KindGet); ok {
+		cur = r.fieldGetProxy(se, cloneExpr(se.X), pointcut)
+	}
+	value := &ast.BinaryExpr{
+		X:  cur,
+		Op: op,
+		Y:  &ast.ParenExpr{X: rewrite.Rewrite(r, y).(ast.Expr)},
+	}
+	if pointcut, ok := r.fieldPointcut(se, aspect.KindSet); ok {
+		return r.fieldSetStmt(se, pointcut, r.fieldSetReceiver(se), value)
+	}
+	return &ast.AssignStmt{
+		Lhs: []ast.Expr{r.rewriteAddressed(se)},
+		Tok: token.ASSIGN,
+		Rhs: []ast.Expr{value},
+	}
+}
+
+func (r *rewriter) rewriteRangeStmt(n *ast.RangeStmt) ast.Stmt {
+	for _, e := range []ast.Expr{n.Key, n.Value} {
+		if e == nil {
+			continue
+		}
+		if se := r.fieldSelector(e); se != nil {
+			if _, ok := r.fieldPointcut(se, aspect.KindSet); ok {
+				r.unsupported(se, "range assignment to %s", se.Sel.Name)
+			}
+		}
+	}
+	if n.Key != nil {
+		n.Key = r.rewriteAddressed(n.Key)
+	}
+	if n.Value != nil {
+		n.Value = r.rewriteAddressed(n.Value)
+	}
+	n.X = rewrite.Rewrite(r, n.X).(ast.Expr)
+	n.Body = rewrite.Rewrite(r, n.Body).(*ast.BlockStmt)
+	return n
+}
+
+// rewriteCommClause rewrites the select case.
+// The communication cannot be replaced with a proxy call,
+// so only the operands are rewritten.
+func (r *rewriter) rewriteCommClause(n *ast.CommClause) ast.Node {
+	switch comm := n.Comm.(type) {
+	case *ast.SendStmt:
+		comm.Chan = rewrite.Rewrite(r, comm.Chan).(ast.Expr)
+		comm.Value = rewrite.Rewrite(r, comm.Value).(ast.Expr)
+	case *ast.ExprStmt:
+		r.rewriteRecvOperand(comm.X)
+	case *ast.AssignStmt:
+		if comm.Tok == token.ASSIGN {
+			for i, lhs := range comm.Lhs {
+				if se := r.fieldSelector(lhs); se != nil {
+					if _, ok := r.fieldPointcut(se, aspect.KindSet); ok {
+						r.unsupported(se, "receive assignment to %s", se.Sel.Name)
+					}
+				}
+				comm.Lhs[i] = r.rewriteAddressed(lhs)
+			}
+		}
+		r.rewriteRecvOperand(comm.Rhs[0])
+	}
+	for i := range n.Body {
+		n.Body[i] = rewrite.Rewrite(r, n.Body[i]).(ast.Stmt)
+	}
+	return n
+}
+
+// rewriteRecvOperand rewrites ch in `<-ch`, without rewriting the receive itself.
+func (r *rewriter) rewriteRecvOperand(e ast.Expr) {
+	if u, ok := unparen(e).(*ast.UnaryExpr); ok && u.Op == token.ARROW {
+		u.X = rewrite.Rewrite(r, u.X).(ast.Expr)
+	}
+}
+
+// rewriteAddressOf rewrites `&x`.
+// `&x.f` is not woven, as the pointer escapes the advice.
+func (r *rewriter) rewriteAddressOf(n *ast.UnaryExpr) ast.Expr {
+	if se := r.fieldSelector(n.X); se != nil && r.fieldMatched(se) {
+		r.unsupported(se, "taking the address of %s (the pointer escapes the advice)", se.Sel.Name)
+	}
+	n.X = r.rewriteAddressed(n.X)
+	return n
+}
+
+// compoundAssignProxyStmt generates `_ag_proxy_N(&x, y)` for `x.f op= y`,
+// where x is not a simple expression.
+func (r *rewriter) compoundAssignProxyStmt(se *ast.SelectorExpr, op token.Token, y ast.Expr) ast.Stmt {
+	// the proxies are generated before rewriting se.X in place
+	proxyName := r.newProxyName()
+	r.fileAddendum = append(r.fileAddendum,
+		r.compoundAssignProxy(se, op, y, proxyName))
+	return &ast.ExprStmt{
+		X: &ast.CallExpr{
+			Fun:  ast.NewIdent(proxyName),
+			Args: []ast.Expr{r.fieldSetReceiver(se), rewrite.Rewrite(r, y).(ast.Expr)}}}
+}
+
+// compoundAssignProxy generates the proxy for `x.f op= y` like this:
+//
+// func _ag_proxy_0_2(_ag_x *S, _ag_y int) {
+// 	_ag_proxy_0_1(_ag_x, _ag_proxy_0_0(*_ag_x) + (_ag_y))
+// }
+//
+// where _ag_proxy_0_0 and _ag_proxy_0_1 are the "get" and "set" proxies.
+// If "get" or "set" is not matched, `_ag_x.f` is accessed directly.
+func (r *rewriter) compoundAssignProxy(se *ast.SelectorExpr, op token.Token, y ast.Expr, proxyName string) *ast.FuncDecl {
+	fieldType := r.typeOf(se)
+	yType := fieldType
+	if op == token.SHL || op == token.SHR {
+		// the shift count keeps its own type
+		yType = r.typeOf(y)
+		if basic, ok := yType.(*types.Basic); ok && basic.Info()&types.IsUntyped != 0 {
+			yType = types.Typ[types.Uint]
+		}
+	}
+	fieldExpr := func() ast.Expr {
+		return &ast.SelectorExpr{
+			X:   ast.NewIdent("_ag_x"),
+			Sel: ast.NewIdent(se.Sel.Name)}
+	}
+	var cur ast.Expr = fieldExpr()
+	if pointcut, ok := r.fieldPointcut(se, aspect.KindGet); ok {
+		var x ast.Expr = ast.NewIdent("_ag_x")
+		if !r.isPointer(se.X) {
+			x = &ast.StarExpr{X: x}
+		}
+		cur = r.fieldGetProxy(se, x, pointcut)
+	}
+	value := &ast.BinaryExpr{
+		X:  cur,
+		Op: op,
+		Y:  &ast.ParenExpr{X: ast.NewIdent("_ag_y")},
+	}
+	var stmt ast.Stmt = &ast.AssignStmt{
+		Lhs: []ast.Expr{fieldExpr()},
+		Tok: token.ASSIGN,
+		Rhs: []ast.Expr{value},
+	}
+	if pointcut, ok := r.fieldPointcut(se, aspect.KindSet); ok {
+		stmt = r.fieldSetStmt(se, pointcut, ast.NewIdent("_ag_x"), value)
+	}
+	return &ast.FuncDecl{
+		Name: ast.NewIdent(proxyName),
+		Type: &ast.FuncType{
+			Params: &ast.FieldList{
+				List: []*ast.Field{
+					r.paramField("_ag_x", r.fieldSetReceiverType(se)),
+					r.paramField("_ag_y", yType)}}},
+		Body: &ast.BlockStmt{List: []ast.Stmt{stmt}}}
+}
+
+// rewriteTupleAssign rewrites the tuple assignment with the fields matched
+// by "set" pointcuts to `_ag_proxy_N(&l0, &l1)(r0, r1)`.
+func (r *rewriter) rewriteTupleAssign(n *ast.AssignStmt) ast.Stmt {
+	var rhsTypes []types.Type
+	if len(n.Rhs) == len(n.Lhs) {
+		for _, rhs := range n.Rhs {
+			rhsTypes = append(rhsTypes, r.typeOf(rhs))
+		}
+	} else {
+		// f() or comma-ok
+		tuple := r.typeOf(n.Rhs[0]).(*types.Tuple)
+		for i := 0; i < tuple.Len(); i++ {
+			rhsTypes = append(rhsTypes, tuple.At(i).Type())
+		}
+	}
+	valueTypes := r.tupleValueTypes(n, rhsTypes)
+	proxyName := r.newProxyName()
+	decl, args := r.tupleAssignProxy(n, valueTypes, proxyName)
+	r.fileAddendum = append(r.fileAddendum, decl)
+
+	for i := range n.Rhs {
+		n.Rhs[i] = rewrite.Rewrite(r, n.Rhs[i]).(ast.Expr)
+	}
+	values := n.Rhs
+	if len(n.Rhs) != len(n.Lhs) {
+		if _, ok := unparen(n.Rhs[0]).(*ast.CallExpr); !ok {
+			values = []ast.Expr{r.commaOkFuncLit(n.Rhs, valueTypes)}
+		}
+	}
+	return &ast.ExprStmt{
+		X: &ast.CallExpr{
+			Fun: &ast.CallExpr{
+				Fun:  ast.NewIdent(proxyName),
+				Args: args},
+			Args: values}}
+}
+
+// commaOkFuncLit generates the function literal call for the comma-ok
+// expression (`m[k]`, `x.(T)` or `<-ch`), because the comma-ok expression
+// cannot be passed to the function directly:
+//
+// func() (_ag_v0 T, _ag_v1 bool) {
+// 	_ag_v0, _ag_v1 = m[k]
+// 	return
+// }()
+func (r *rewriter) commaOkFuncLit(rhs []ast.Expr, valueTypes []types.Type) ast.Expr {
+	var results []*ast.Field
+	var lhs []ast.Expr
+	for i, typ := range valueTypes {
+		name := fmt.Sprintf("_ag_v%d", i)
+		results = append(results, r.paramField(name, typ))
+		lhs = append(lhs, ast.NewIdent(name))
+	}
+	return &ast.CallExpr{
+		Fun: &ast.FuncLit{
+			Type: &ast.FuncType{
+				Params:  &ast.FieldList{},
+				Results: &ast.FieldList{List: results}},
+			Body: &ast.BlockStmt{
+				List: []ast.Stmt{
+					&ast.AssignStmt{
+						Lhs: lhs,
+						Tok: token.ASSIGN,
+						Rhs: rhs},
+					&ast.ReturnStmt{}}}}}
+}
+
+// tupleValueTypes returns the types of the values assigned in the tuple assignment,
+// i.e. the types of the left operands, or the default types of the right ones for `_`.
+func (r *rewriter) tupleValueTypes(n *ast.AssignStmt, rhsTypes []types.Type) []types.Type {
+	var valueTypes []types.Type
+	for i, lhs := range n.Lhs {
+		if id, ok := unparen(lhs).(*ast.Ident); ok && id.Name == "_" {
+			valueTypes = append(valueTypes, types.Default(rhsTypes[i]))
+		} else {
+			valueTypes = append(valueTypes, r.typeOf(lhs))
+		}
+	}
+	return valueTypes
+}
+
+// tupleAssignProxy generates the proxy for the tuple assignment
+// `x.f, y, m[k], _ = v0, v1, v2, v3` like this:
+//
+// func _ag_proxy_0_1(_ag_l0 *S, _ag_l1 *int, _ag_l2 map[string]int, _ag_k2 string) func(int, int, int, string) {
+// 	return func(_ag_v0 int, _ag_v1 int, _ag_v2 int, _ag_v3 string) {
+// 		_ag_proxy_0_0(_ag_l0, _ag_v0)
+// 		*_ag_l1 = _ag_v1
+// 		_ag_l2[_ag_k2] = _ag_v2
+// 	}
+// }
+//
+// where _ag_proxy_0_0 is the "set" proxy.
+// It also returns the arguments for the left operands, i.e. `&x, &y, m, k`.
+func (r *rewriter) tupleAssignProxy(n *ast.AssignStmt, valueTypes []types.Type, proxyName string) (*ast.FuncDecl, []ast.Expr) {
+	var params, values []*ast.Field
+	var args []ast.Expr
+	var body []ast.Stmt
+	for i, typ := range valueTypes {
+		l := ast.NewIdent(fmt.Sprintf("_ag_l%d", i))
+		v := ast.NewIdent(fmt.Sprintf("_ag_v%d", i))
+		values = append(values, r.paramField(v.Name, typ))
+		lhs := n.Lhs[i]
+		if id, ok := unparen(lhs).(*ast.Ident); ok && id.Name == "_" {
+			continue
+		}
+		if se := r.fieldSelector(lhs); se != nil {
+			if pointcut, ok := r.fieldPointcut(se, aspect.KindSet); ok {
+				params = append(params, r.paramField(l.Name, r.fieldSetReceiverType(se)))
+				body = append(body, r.fieldSetStmt(se, pointcut, l, v))
+				args = append(args, r.fieldSetReceiver(se))
+				continue
+			}
+		}
+		if ie, ok := unparen(lhs).(*ast.IndexExpr); ok {
+			if m, ok := r.typeOf(ie.X).Underlying().(*types.Map); ok {
+				k := ast.NewIdent(fmt.Sprintf("_ag_k%d", i))
+				params = append(params,
+					r.paramField(l.Name, r.typeOf(ie.X)),
+					r.paramField(k.Name, m.Key()))
+				body = append(body, &ast.AssignStmt{
+					Lhs: []ast.Expr{&ast.IndexExpr{X: l, Index: k}},
+					Tok: token.ASSIGN,
+					Rhs: []ast.Expr{v}})
+				args = append(args,
+					rewrite.Rewrite(r, ie.X).(ast.Expr),
+					rewrite.Rewrite(r, ie.Index).(ast.Expr))
+				continue
+			}
+		}
+		params = append(params, r.paramField(l.Name, types.NewPointer(typ)))
+		body = append(body, &ast.AssignStmt{
+			Lhs: []ast.Expr{&ast.StarExpr{X: l}},
+			Tok: token.ASSIGN,
+			Rhs: []ast.Expr{v}})
+		args = append(args, &ast.UnaryExpr{
+			Op: token.AND,
+			X:  r.rewriteAddressed(lhs)})
+	}
+	var unnamed []*ast.Field
+	for _, v := range values {
+		unnamed = append(unnamed, &ast.Field{Type: v.Type})
+	}
+	funcType := func(fields []*ast.Field) *ast.FuncType {
+		return &ast.FuncType{Params: &ast.FieldList{List: fields}}
+	}
+	decl := &ast.FuncDecl{
+		Name: ast.NewIdent(proxyName),
+		Type: &ast.FuncType{
+			Params: &ast.FieldList{List: params},
+			Results: &ast.FieldList{
+				List: []*ast.Field{&ast.Field{Type: funcType(unnamed)}}}},
+		Body: &ast.BlockStmt{
+			List: []ast.Stmt{
+				&ast.ReturnStmt{
+					Results: []ast.Expr{
+						&ast.FuncLit{
+							Type: funcType(values),
+							Body: &ast.BlockStmt{List: body}}}}}}}
+	return decl, args
+}
+
+// paramField generates the parameter `name typ`.
+func (r *rewriter) paramField(name string, typ types.Type) *ast.Field {
+	return &ast.Field{
+		Names: []*ast.Ident{ast.NewIdent(name)},
+		Type:  &ast.ParenExpr{X: ast.NewIdent(r.typeString(typ))}}
+}
+
+// fieldSetReceiver returns the rewritten receiver of the "set" proxy for se,
+// i.e. `x` for the pointer x, or `&x`.
+func (r *rewriter) fieldSetReceiver(se *ast.SelectorExpr) ast.Expr {
+	if r.isPointer(se.X) {
+		return rewrite.Rewrite(r, se.X).(ast.Expr)
+	}
+	return &ast.UnaryExpr{
+		Op: token.AND,
+		X:  r.rewriteAddressed(se.X)}
+}
+
+// fieldSetReceiverType returns the type of fieldSetReceiver(se).
+func (r *rewriter) fieldSetReceiverType(se *ast.SelectorExpr) types.Type {
+	xType := r.typeOf(se.X)
+	if _, ok := xType.Underlying().(*types.Pointer); !ok {
+		xType = types.NewPointer(xType)
+	}
+	return xType
+}
+
+// fieldSetStmt generates `_ag_proxy_N(x, value)` for `x.f = value`.
+// x is the receiver of the proxy (see fieldSetReceiver).
+func (r *rewriter) fieldSetStmt(se *ast.SelectorExpr, pointcut aspect.Pointcut, x, value ast.Expr) ast.Stmt {
+	proxyName := r.fieldProxy(se, aspect.KindSet, pointcut)
+	return &ast.ExprStmt{
+		X: &ast.CallExpr{
+			Fun:  ast.NewIdent(proxyName),
+			Args: []ast.Expr{x, value}}}
+}
+
+// fieldGetProxy generates `_ag_proxy_N(x)` for `x.f`.
+// x is the rewritten se.X.
+func (r *rewriter) fieldGetProxy(se *ast.SelectorExpr, x ast.Expr, pointcut aspect.Pointcut) ast.Expr {
+	proxyName := r.fieldProxy(se, aspect.KindGet, pointcut)
+	return &ast.CallExpr{
+		Fun:  ast.NewIdent(proxyName),
+		Args: []ast.Expr{x}}
+}
+
+// fieldProxy generates the proxy for the field access as an addendum,
+// and returns the name of the proxy.
+func (r *rewriter) fieldProxy(se *ast.SelectorExpr, kind aspect.JoinPointKind, pointcut aspect.Pointcut) string {
+	asp, ok := r.Aspects[pointcut]
+	if !ok {
+		log.Fatalf("impl error: asp not found for pointcut %s", pointcut)
+	}
+	proxyName := r.newProxyName()
+	r.fileAddendum = append(r.fileAddendum,
+		r._field_proxy(se, kind, proxyName, asp))
+	return proxyName
+}
+
+// _field_proxy generates the proxy for the field access like this:
+//
+// func _ag_proxy_0_0(_ag_x *S, _ag_v int) {
+// 	_ag_res := (&_ag_aspects.ExampleAspect{}).Advice(
+// 		&aspectrt.FieldContextImpl{
+// 			ContextImpl: aspectrt.ContextImpl{
+// 				XArgs: []interface{}{_ag_v},
+// 				XFunc: func(_ag_args []interface{}) []interface{} {
+// 					_ag_x.X, _ = _ag_args[0].(int)
+// 					return []interface{}{}
+// 				},
+// 				XReceiver:  _ag_x,
+// 				XJoinPoint: _ag_jp_ag_proxy_0_0,
+// 			},
+// 			XOldValue: _ag_x.X,
+// 		})
+// 	aspectrt.CheckResults(_ag_jp_ag_proxy_0_0, _ag_res)
+// 	_ = _ag_res
+// }
+//
+// For "get" joinpoints, _ag_v is omitted, XArgs is empty, XFunc returns
+// `[]interface{}{_ag_x.X}`, and the proxy returns the first result of the advice.
+func (r *rewriter) _field_proxy(se *ast.SelectorExpr, kind aspect.JoinPointKind, proxyName string, asp *types.Named) *ast.FuncDecl {
+	field := r.Matched[se.Sel].(*types.Var)
+	fieldType := ast.NewIdent(r.typeString(field.Type()))
+	xType := r.typeOf(se.X)
+	if kind == aspect.KindSet {
+		xType = r.fieldSetReceiverType(se)
+	}
+	fieldExpr := func() ast.Expr {
+		return &ast.SelectorExpr{
+			X:   ast.NewIdent("_ag_x"),
+			Sel: ast.NewIdent(se.Sel.Name)}
+	}
+	params := []*ast.Field{
+		&ast.Field{
+			Names: []*ast.Ident{ast.NewIdent("_ag_x")},
+			Type:  &ast.ParenExpr{X: ast.NewIdent(r.typeString(xType))}}}
+	var results []*ast.Field
+	var xArgs []ast.Expr
+	var xFuncBody, tail []ast.Stmt
+	sig := &signature{}
+	jp := &aspect.JoinPoint{
+		Kind:      kind,
+		Name:      r.Matcher.FieldFullName(field),
+		ShortName: r.Matcher.FieldName(field, pkgNameQualifier),
+		Pos:       r.joinPointPos(se.Sel.Pos()),
+		Caller:    enclosingFuncName(r.typesInfo(), r.file, se.Sel.Pos()),
+	}
+	switch kind {
+	case aspect.KindGet:
+		jp.NumResults = 1
+		sig.ResultNames = []string{field.Name()}
+		sig.Results = []types.Type{field.Type()}
+		results = []*ast.Field{&ast.Field{Type: fieldType}}
+		xFuncBody = []ast.Stmt{
+			&ast.ReturnStmt{
+				Results: []ast.Expr{
+					&ast.CompositeLit{
+						Type: voidIntfArrayExpr(),
+						Elts: []ast.Expr{fieldExpr()}}}}}
+		tail = []ast.Stmt{
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{ast.NewIdent("_ag_res0"), ast.NewIdent("_")},
+				Tok: token.DEFINE,
+				Rhs: []ast.Expr{
+					&ast.TypeAssertExpr{
+						X: &ast.IndexExpr{
+							X:     ast.NewIdent("_ag_res"),
+							Index: &ast.BasicLit{Kind: token.INT, Value: "0"}},
+						Type: fieldType}}},
+			&ast.ReturnStmt{
+				Results: []ast.Expr{ast.NewIdent("_ag_res0")}}}
+	case aspect.KindSet:
+		sig.ParamNames = []string{field.Name()}
+		sig.Params = []types.Type{field.Type()}
+		params = append(params,
+			&ast.Field{
+				Names: []*ast.Ident{ast.NewIdent("_ag_v")},
+				Type:  fieldType})
+		xArgs = []ast.Expr{ast.NewIdent("_ag_v")}
+		xFuncBody = []ast.Stmt{
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{fieldExpr(), ast.NewIdent("_")},
+				Tok: token.ASSIGN,
+				Rhs: []ast.Expr{
+					&ast.TypeAssertExpr{
+						X: &ast.IndexExpr{
+							X:     ast.NewIdent("_ag_args"),
+							Index: &ast.BasicLit{Kind: token.INT, Value: "0"}},
+						Type: fieldType}}},
+			&ast.ReturnStmt{
+				Results: []ast.Expr{
+					&ast.CompositeLit{Type: voidIntfArrayExpr()}}}}
+		tail = []ast.Stmt{
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{ast.NewIdent("_")},
+				Tok: token.ASSIGN,
+				Rhs: []ast.Expr{ast.NewIdent("_ag_res")}}}
+	default:
+		log.Fatalf("impl error: unexpected kind %s", kind)
+	}
+
+	xFuncLit := &ast.FuncLit{
+		Type: &ast.FuncType{
+			Params: &ast.FieldList{
+				List: []*ast.Field{
+					&ast.Field{
+						Names: []*ast.Ident{ast.NewIdent("_ag_args")},
+						Type:  voidIntfArrayExpr()}}},
+			Results: &ast.FieldList{
+				List: []*ast.Field{
+					&ast.Field{
+						Type: voidIntfArrayExpr()}}}},
+		Body: &ast.BlockStmt{List: xFuncBody}}
+	ctxExpr := &ast.UnaryExpr{
+		Op: token.AND,
+		X: &ast.CompositeLit{
+			Type: &ast.SelectorExpr{
+				X:   ast.NewIdent("aspectrt"),
+				Sel: ast.NewIdent("FieldContextImpl"),
+			},
+			Elts: []ast.Expr{
+				&ast.KeyValueExpr{
+					Key: ast.NewIdent("ContextImpl"),
+					Value: &ast.CompositeLit{
+						Type: &ast.SelectorExpr{
+							X:   ast.NewIdent("aspectrt"),
+							Sel: ast.NewIdent("ContextImpl"),
+						},
+						Elts: []ast.Expr{
+							&ast.KeyValueExpr{
+								Key: ast.NewIdent("XArgs"),
+								Value: &ast.CompositeLit{
+									Type: voidIntfArrayExpr(),
+									Elts: xArgs}},
+							&ast.KeyValueExpr{
+								Key:   ast.NewIdent("XFunc"),
+								Value: xFuncLit},
+							&ast.KeyValueExpr{
+								Key:   ast.NewIdent("XReceiver"),
+								Value: ast.NewIdent("_ag_x")},
+							&ast.KeyValueExpr{
+								Key:   ast.NewIdent("XJoinPoint"),
+								Value: r._proxy_body_XJoinPoint(jp, proxyName, sig)},
+						}}},
+				&ast.KeyValueExpr{
+					Key:   ast.NewIdent("XOldValue"),
+					Value: fieldExpr()},
+			}}}
+	adviceCall := &ast.CallExpr{
+		Fun: &ast.SelectorExpr{
+			X:   r._aspect_expr(asp, proxyName),
+			Sel: ast.NewIdent("Advice")},
+		Args: []ast.Expr{ctxExpr}}
+	stmts := append([]ast.Stmt{
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("_ag_res")},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{adviceCall}},
+		checkResultsStmt(proxyName)}, tail...)
+	return &ast.FuncDecl{
+		Name: ast.NewIdent(proxyName),
+		Type: &ast.FuncType{
+			Params:  &ast.FieldList{List: params},
+			Results: &ast.FieldList{List: results}},
+		Body: &ast.BlockStmt{List: stmts}}
+}
@@ -0,0 +1,316 @@
+package weave
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/types"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/loader"
+
+	"golang.org/x/exp/aspectgo/aspect"
+	"golang.org/x/exp/aspectgo/compiler/consts"
+	"golang.org/x/exp/aspectgo/compiler/gopath"
+	"golang.org/x/exp/aspectgo/compiler/parse"
+	"golang.org/x/exp/aspectgo/compiler/weave/match"
+)
+
+// introductionFilename is the name of the file generated in the target package.
+const introductionFilename = "aspectgo_introduction.go"
+
+// introduction is an introduction to a target type.
+type introduction struct {
+	// Intro is the introduction in the aspect file.
+	Intro *types.Named
+	// Methods are the methods introduced to the target type.
+	Methods []*types.Func
+	// Fields is true if the fields are introduced to the target type.
+	Fields bool
+}
+
+// introFieldsTypeName returns the name of the struct type that holds the
+// fields of the introduction. The struct is embedded into the target types.
+func introFieldsTypeName(intro *types.Named) string {
+	return "_ag_intro_" + intro.Obj().Name()
+}
+
+// introducedFields returns the exported fields of the introduction, and their tags.
+func introducedFields(intro *types.Named) ([]*types.Var, []string) {
+	st, ok := intro.Underlying().(*types.Struct)
+	if !ok {
+		return nil, nil
+	}
+	var fields []*types.Var
+	var tags []string
+	for i := 0; i < st.NumFields(); i++ {
+		if f := st.Field(i); f.Exported() && !f.Anonymous() {
+			fields = append(fields, f)
+			tags = append(tags, st.Tag(i))
+		}
+	}
+	return fields, tags
+}
+
+// findIntroductions returns the introductions for the package-level named types
+// in the initial packages. The fields and the methods that conflict with the
+// existing ones (or the ones introduced by another introduction) are not introduced.
+func findIntroductions(prog *loader.Program, m *match.Matcher, patterns map[*types.Named]aspect.TypePattern) map[*types.TypeName][]*introduction {
+	intros := make([]*types.Named, 0, len(patterns))
+	for intro := range patterns {
+		intros = append(intros, intro)
+	}
+	sort.Slice(intros, func(i, j int) bool { return intros[i].Obj().Name() < intros[j].Obj().Name() })
+
+	result := make(map[*types.TypeName][]*introduction)
+	for _, pkgInfo := range prog.InitialPackages() {
+		scope := pkgInfo.Pkg.Scope()
+		for _, name := range scope.Names() {
+			tObj, ok := scope.Lookup(name).(*types.TypeName)
+			if !ok || tObj.IsAlias() {
+				continue
+			}
+			named, ok := tObj.Type().(*types.Named)
+			if !ok {
+				continue
+			}
+			switch named.Underlying().(type) {
+			case *types.Interface, *types.Pointer:
+				continue
+			}
+			introduced := make(map[string]bool)
+			conflicts := func(intro *types.Named, name string) bool {
+				obj, _, _ := types.LookupFieldOrMethod(named, true, tObj.Pkg(), name)
+				if obj == nil && !introduced[name] {
+					return false
+				}
+				log.Printf("%s: %s conflicts with %s.%s (not introduced)",
+					prog.Fset.Position(tObj.Pos()), intro.Obj().Name(), named, name)
+				return true
+			}
+			for _, intro := range intros {
+				if !m.TypeMatchPattern(named, patterns[intro]) {
+					continue
+				}
+				in := &introduction{Intro: intro}
+				for _, method := range parse.IntroducedMethods(intro) {
+					if conflicts(intro, method.Name()) {
+						continue
+					}
+					introduced[method.Name()] = true
+					in.Methods = append(in.Methods, method)
+				}
+				if fields, _ := introducedFields(intro); len(fields) != 0 {
+					in.Fields = introduceFields(prog, tObj, intro, fields, conflicts)
+					for _, f := range fields {
+						introduced[f.Name()] = in.Fields
+					}
+				}
+				if len(in.Methods) != 0 || in.Fields {
+					result[tObj] = append(result[tObj], in)
+				}
+			}
+		}
+	}
+	return result
+}
+
+// introduceFields returns true if the fields can be introduced to the target.
+func introduceFields(prog *loader.Program, tObj *types.TypeName, intro *types.Named, fields []*types.Var, conflicts func(*types.Named, string) bool) bool {
+	if _, ok := tObj.Type().Underlying().(*types.Struct); !ok {
+		log.Printf("%s: %s is not a struct (fields of %s not introduced)",
+			prog.Fset.Position(tObj.Pos()), tObj.Name(), intro.Obj().Name())
+		return false
+	}
+	for _, f := range fields {
+		if conflicts(intro, f.Name()) {
+			return false
+		}
+	}
+	return true
+}
+
+// importSet manages the imports of the generated file.
+type importSet struct {
+	aspectPkg *types.Package
+	names     map[string]string // path -> name
+	paths     map[string]string // name -> path
+}
+
+func newImportSet(af *parse.AspectFile) *importSet {
+	s := &importSet{
+		aspectPkg: af.PkgInfo.Pkg,
+		names:     make(map[string]string),
+		paths:     make(map[string]string),
+	}
+	s.add(af.ImportPath, aspectPkgName)
+	return s
+}
+
+func (s *importSet) add(path, name string) string {
+	if n, ok := s.names[path]; ok {
+		return n
+	}
+	unique := name
+	for i := 2; s.paths[unique] != ""; i++ {
+		unique = fmt.Sprintf("%s%d", name, i)
+	}
+	s.names[path] = unique
+	s.paths[unique] = path
+	return unique
+}
+
+func (s *importSet) qualifier(pkg *types.Package) string {
+	if pkg == s.aspectPkg {
+		return aspectPkgName
+	}
+	return s.add(pkg.Path(), pkg.Name())
+}
+
+func (s *importSet) write(b *bytes.Buffer) {
+	paths := make([]string, 0, len(s.names))
+	for path := range s.names {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	fmt.Fprintf(b, "import (\n")
+	for _, path := range paths {
+		if name := s.names[path]; name != filepath.Base(path) {
+			fmt.Fprintf(b, "\t%s %q\n", name, path)
+		} else {
+			fmt.Fprintf(b, "\t%q\n", path)
+		}
+	}
+	fmt.Fprintf(b, ")\n")
+}
+
+// writeIntroductionFields generates the struct that holds the fields of intro like this:
+//
+// type _ag_intro_AuditIntro struct {
+// 	CreatedAt time.Time `json:"created_at"`
+// }
+func writeIntroductionFields(b *bytes.Buffer, intro *types.Named, imports *importSet) {
+	fmt.Fprintf(b, "\ntype %s struct {\n", introFieldsTypeName(intro))
+	fields, tags := introducedFields(intro)
+	for i, f := range fields {
+		fmt.Fprintf(b, "\t%s %s", f.Name(), types.TypeString(f.Type(), imports.qualifier))
+		switch {
+		case tags[i] == "":
+		case strings.Contains(tags[i], "`"):
+			fmt.Fprintf(b, " %q", tags[i])
+		default:
+			fmt.Fprintf(b, " `%s`", tags[i])
+		}
+		fmt.Fprintf(b, "\n")
+	}
+	fmt.Fprintf(b, "}\n")
+}
+
+// writeIntroductionMethod generates the introduced method like this:
+//
+// func (_ag_t S) String() string {
+// 	return (&_ag_aspects.StringIntro{}).String(_ag_t)
+// }
+func writeIntroductionMethod(b *bytes.Buffer, target *types.TypeName, intro *types.Named, method *types.Func, imports *importSet) {
+	sig := method.Type().(*types.Signature)
+	var params, args []string
+	for i := 1; i < sig.Params().Len(); i++ {
+		typ := types.TypeString(sig.Params().At(i).Type(), imports.qualifier)
+		arg := fmt.Sprintf("_ag_arg%d", i-1)
+		if sig.Variadic() && i == sig.Params().Len()-1 {
+			typ = "..." + strings.TrimPrefix(typ, "[]")
+			arg += "..."
+		}
+		params = append(params, fmt.Sprintf("_ag_arg%d %s", i-1, typ))
+		args = append(args, arg)
+	}
+	var results []string
+	for i := 0; i < sig.Results().Len(); i++ {
+		results = append(results, types.TypeString(sig.Results().At(i).Type(), imports.qualifier))
+	}
+	fmt.Fprintf(b, "\nfunc (_ag_t %s) %s(%s) (%s) {\n\t",
+		target.Name(), method.Name(), strings.Join(params, ", "), strings.Join(results, ", "))
+	if len(results) != 0 {
+		fmt.Fprintf(b, "return ")
+	}
+	fmt.Fprintf(b, "(&%s.%s{}).%s(%s)\n}\n", aspectPkgName,
+		intro.Obj().Name(), method.Name(), strings.Join(append([]string{"_ag_t"}, args...), ", "))
+}
+
+// writeIntroductions generates the introduced fields and methods for the package
+// to introductionFilename in the package directory, and returns the file name.
+func writeIntroductions(wovenGOPATH string, prog *loader.Program, pkgInfo *loader.PackageInfo, af *parse.AspectFile, targets map[*types.TypeName][]*introduction) (string, error) {
+	var tObjs []*types.TypeName
+	for tObj := range targets {
+		if tObj.Pkg() == pkgInfo.Pkg {
+			tObjs = append(tObjs, tObj)
+		}
+	}
+	if len(tObjs) == 0 || len(pkgInfo.Files) == 0 {
+		return "", nil
+	}
+	sort.Slice(tObjs, func(i, j int) bool { return tObjs[i].Name() < tObjs[j].Name() })
+
+	imports := newImportSet(af)
+	var body bytes.Buffer
+	fieldsWritten := make(map[*types.Named]bool)
+	for _, tObj := range tObjs {
+		for _, in := range targets[tObj] {
+			if in.Fields && !fieldsWritten[in.Intro] {
+				writeIntroductionFields(&body, in.Intro, imports)
+				fieldsWritten[in.Intro] = true
+			}
+			for _, method := range in.Methods {
+				writeIntroductionMethod(&body, tObj, in.Intro, method, imports)
+			}
+		}
+	}
+	var b bytes.Buffer
+	b.WriteString(consts.AutogenFileHeader)
+	fmt.Fprintf(&b, "package %s\n\n", pkgInfo.Pkg.Name())
+	imports.write(&b)
+	b.Write(body.Bytes())
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return "", fmt.Errorf("impl error: %s:\n%s", err, b.String())
+	}
+
+	dir := filepath.Dir(prog.Fset.Position(pkgInfo.Files[0].Pos()).Filename)
+	outf, err := gopath.FileForNewGOPATH(filepath.Join(dir, introductionFilename),
+		os.Getenv("GOPATH"), wovenGOPATH)
+	if err != nil {
+		return "", err
+	}
+	defer outf.Close()
+	log.Printf("Writing introductions for %s --> %s", pkgInfo.Pkg.Path(), outf.Name())
+	if _, err = outf.Write(src); err != nil {
+		return "", err
+	}
+	return outf.Name(), nil
+}
+
+// introduceFieldsToTypeSpec embeds the structs that hold the introduced fields
+// into the struct type declared by ts.
+func (r *rewriter) introduceFieldsToTypeSpec(ts *ast.TypeSpec) {
+	st, ok := ts.Type.(*ast.StructType)
+	if !ok {
+		return
+	}
+	tObj, ok := r.typesInfo().Defs[ts.Name].(*types.TypeName)
+	if !ok {
+		return
+	}
+	for _, in := range r.Introductions[tObj] {
+		if !in.Fields {
+			continue
+		}
+		st.Fields.List = append(st.Fields.List, &ast.Field{
+			Type: ast.NewIdent(introFieldsTypeName(in.Intro)),
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package weave
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"os"
+
+	"golang.org/x/exp/aspectgo/aspect"
+	"golang.org/x/exp/aspectgo/compiler/parse"
+	"golang.org/x/exp/aspectgo/compiler/weave/match"
+)
+
+// JoinPoint is a join point found by the weaver.
+// The fields are the same as aspect.JoinPoint of the woven join point.
+type JoinPoint struct {
+	Kind aspect.JoinPointKind `json:"kind"`
+	// Name is the full name of the function, the field or the enclosing function.
+	Name string `json:"name"`
+	// Caller is the full name of the function that contains the join point.
+	Caller string `json:"caller"`
+	// Pos is the position of the join point, relative to $GOPATH/src.
+	Pos string `json:"pos"`
+	// Aspect is the name of the aspect type that advises the join point.
+	// It is empty for a call woven only for "cflow" terms, which is not advised.
+	Aspect string `json:"aspect,omitempty"`
+}
+
+// JoinPoints returns the join points in the target packages that would be woven with af,
+// in the order of the positions in each package.
+// A field access is listed for each kind that the rewriter weaves it with,
+// e.g. both "get" and "set" for `x.f++`.
+// opts can be nil.
+func JoinPoints(targets []string, af *parse.AspectFile, opts *Options) ([]*JoinPoint, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	gopath := os.Getenv("GOPATH")
+	if gopath == "" {
+		return nil, fmt.Errorf("GOPATH not set")
+	}
+	_, prog, err := loadTargets(targets, opts.BuildTags)
+	if err != nil {
+		return nil, err
+	}
+	pkgs := prog.InitialPackages()
+	if opts.Deep {
+		pkgs = append(pkgs, deepPackages(prog, af, opts.DeepAllow)...)
+	}
+	m := match.NewMatcher(prog)
+	matched, pointcutsByIdent, fieldPointcuts, stmts, _, err := findMatchedThings(prog, pkgs, m, af)
+	if err != nil {
+		return nil, err
+	}
+	aspects := pointcutMapToAspectMap(af.Pointcuts)
+	aspectName := func(pointcut aspect.Pointcut) string {
+		if asp, ok := aspects[pointcut]; ok {
+			return asp.Obj().Name()
+		}
+		return ""
+	}
+	var jps []*JoinPoint
+	seen := make(map[*ast.Ident]bool)
+	for _, pkgInfo := range pkgs {
+		for _, file := range pkgInfo.Files {
+			for _, decl := range file.Decls {
+				caller, _ := declFuncName(pkgInfo, decl)
+				accesses := fieldAccessKinds(decl)
+				// the position of a join point is the one of the node rewritten to the proxy,
+				// as in aspect.JoinPoint.Pos
+				add := func(kind aspect.JoinPointKind, name string, node ast.Node, pointcut aspect.Pointcut) {
+					jps = append(jps, &JoinPoint{
+						Kind:   kind,
+						Name:   name,
+						Caller: caller,
+						Pos:    relativePos(prog.Fset, gopath, node.Pos()),
+						Aspect: aspectName(pointcut),
+					})
+				}
+				// the position of a join point is the one of the node rewritten to the proxy,
+				// as in aspect.JoinPoint.Pos
+				ast.Inspect(decl, func(node ast.Node) bool {
+					if sm, ok := stmts[node]; ok {
+						add(sm.Kind, sm.Name, node, sm.Pointcut)
+						return true
+					}
+					var id *ast.Ident
+					switch n := node.(type) {
+					case *ast.SelectorExpr:
+						id = n.Sel
+						kinds, ok := accesses[n]
+						if !ok {
+							kinds = []aspect.JoinPointKind{aspect.KindGet}
+						}
+						for _, kind := range kinds {
+							if pointcut, ok := fieldPointcuts[n][kind]; ok {
+								add(kind, m.FieldFullName(matched[n.Sel].(*types.Var)), n.Sel, pointcut)
+							}
+						}
+					case *ast.Ident:
+						id = n
+					default:
+						return true
+					}
+					pointcut, ok := pointcutsByIdent[id]
+					if !ok || seen[id] {
+						return true
+					}
+					seen[id] = true
+					add(m.Kind(pointcut), matched[id].(*types.Func).FullName(), node, pointcut)
+					return true
+				})
+			}
+		}
+	}
+	return jps, nil
+}
+
+// fieldAccessKinds returns the kinds of the field accesses in node that are not just
+// read (i.e. "get"), in the same way as the rewriter weaves them.
+// The field accesses that are not woven (e.g. `&x.f`) have no kinds.
+func fieldAccessKinds(node ast.Node) map[*ast.SelectorExpr][]aspect.JoinPointKind {
+	accesses := make(map[*ast.SelectorExpr][]aspect.JoinPointKind)
+	record := func(e ast.Expr, kinds ...aspect.JoinPointKind) {
+		if se, ok := unparen(e).(*ast.SelectorExpr); ok {
+			accesses[se] = kinds
+		}
+	}
+	// the assignments in the select cases
+	comms := make(map[ast.Stmt]bool)
+	ast.Inspect(node, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.CommClause:
+			comms[n.Comm] = true
+		case *ast.AssignStmt:
+			switch {
+			case n.Tok == token.DEFINE:
+			case len(n.Lhs) != 1 || len(n.Rhs) != 1 || comms[n]:
+				for _, lhs := range n.Lhs {
+					record(lhs)
+				}
+			case n.Tok == token.ASSIGN:
+				record(n.Lhs[0], aspect.KindSet)
+			default:
+				record(n.Lhs[0], aspect.KindGet, aspect.KindSet)
+			}
+		case *ast.IncDecStmt:
+			record(n.X, aspect.KindGet, aspect.KindSet)
+		case *ast.RangeStmt:
+			if n.Tok == token.ASSIGN {
+				for _, e := range []ast.Expr{n.Key, n.Value} {
+					record(e)
+				}
+			}
+		case *ast.UnaryExpr:
+			if n.Op == token.AND {
+				record(n.X)
+			}
+		}
+		return true
+	})
+	return accesses
+}
@@ -0,0 +1,82 @@
+package match
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"log"
+	"strings"
+
+	"golang.org/x/tools/go/loader"
+
+	"golang.org/x/exp/aspectgo/aspect"
+)
+
+const directivePrefix = "//aspect:"
+
+// ParseAnnotations parses the directive comments in doc, e.g. `//aspect:retry max=3`.
+// The other comments are ignored.
+func ParseAnnotations(doc *ast.CommentGroup) ([]*aspect.Annotation, error) {
+	if doc == nil {
+		return nil, nil
+	}
+	var annotations []*aspect.Annotation
+	for _, c := range doc.List {
+		if !strings.HasPrefix(c.Text, directivePrefix) {
+			continue
+		}
+		fields := strings.Fields(c.Text[len(directivePrefix):])
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("no name in %q", c.Text)
+		}
+		a := &aspect.Annotation{
+			Name:   fields[0],
+			Params: make(map[string]string),
+		}
+		for _, f := range fields[1:] {
+			kv := strings.SplitN(f, "=", 2)
+			if kv[0] == "" {
+				return nil, fmt.Errorf("no key for %q in %q", f, c.Text)
+			}
+			if _, ok := a.Params[kv[0]]; ok {
+				return nil, fmt.Errorf("duplicated key %q in %q", kv[0], c.Text)
+			}
+			if len(kv) == 2 {
+				a.Params[kv[0]] = kv[1]
+			} else {
+				a.Params[kv[0]] = ""
+			}
+		}
+		annotations = append(annotations, a)
+	}
+	return annotations, nil
+}
+
+// funcAnnotations returns the map from the functions to the annotations
+// on their declarations. Invalid annotations are reported and ignored.
+func funcAnnotations(prog *loader.Program) map[*types.Func][]*aspect.Annotation {
+	annotations := make(map[*types.Func][]*aspect.Annotation)
+	for _, pkgInfo := range prog.AllPackages {
+		for _, file := range pkgInfo.Files {
+			for _, decl := range file.Decls {
+				fd, ok := decl.(*ast.FuncDecl)
+				if !ok || fd.Doc == nil {
+					continue
+				}
+				fn, ok := pkgInfo.Defs[fd.Name].(*types.Func)
+				if !ok {
+					continue
+				}
+				a, err := ParseAnnotations(fd.Doc)
+				if err != nil {
+					log.Printf("%s: invalid annotation: %s", prog.Fset.Position(fd.Doc.Pos()), err)
+					continue
+				}
+				if len(a) != 0 {
+					annotations[fn] = a
+				}
+			}
+		}
+	}
+	return annotations
+}
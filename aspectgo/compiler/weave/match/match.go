@@ -5,6 +5,7 @@ import (
 	"go/types"
 	"log"
 	"regexp"
+	"sync"
 
 	"golang.org/x/tools/go/loader"
 
@@ -12,27 +13,220 @@ import (
 	"golang.org/x/exp/aspectgo/compiler/util"
 )
 
+// Matcher matches objects against pointcuts.
+// Matcher is safe for concurrent use.
+type Matcher struct {
+	prog        *loader.Program
+	mu          sync.Mutex // protects pointcuts, patterns and errs
+	pointcuts   map[aspect.Pointcut]*Pointcut
+	fieldOwners map[*types.Var]*types.Named
+	annotations map[*types.Func][]*aspect.Annotation
+	patterns    map[aspect.TypePattern]*regexp.Regexp
+	// errs contains the errors of the invalid pointcuts and type patterns
+	errs map[string]error
+}
+
+// NewMatcher creates a Matcher for prog.
+func NewMatcher(prog *loader.Program) *Matcher {
+	return &Matcher{
+		prog:        prog,
+		pointcuts:   make(map[aspect.Pointcut]*Pointcut),
+		fieldOwners: fieldOwners(prog),
+		annotations: funcAnnotations(prog),
+		patterns:    make(map[aspect.TypePattern]*regexp.Regexp),
+		errs:        make(map[string]error),
+	}
+}
+
+// fieldOwners returns the map from the struct fields to the named types that
+// declare them. Only package-level named types are scanned.
+func fieldOwners(prog *loader.Program) map[*types.Var]*types.Named {
+	owners := make(map[*types.Var]*types.Named)
+	for pkg := range prog.AllPackages {
+		scope := pkg.Scope()
+		for _, name := range scope.Names() {
+			tObj, ok := scope.Lookup(name).(*types.TypeName)
+			if !ok {
+				continue
+			}
+			named, ok := tObj.Type().(*types.Named)
+			if !ok {
+				continue
+			}
+			st, ok := named.Underlying().(*types.Struct)
+			if !ok {
+				continue
+			}
+			for i := 0; i < st.NumFields(); i++ {
+				owners[st.Field(i)] = named
+			}
+		}
+	}
+	return owners
+}
+
+// FieldFullName returns the full name of the field, e.g. "example.com/foo.S.X".
+// It returns "" if the field is not declared in a package-level named type.
+func (m *Matcher) FieldFullName(field *types.Var) string {
+	return m.FieldName(field, nil)
+}
+
+// FieldName is like FieldFullName, but the owner is qualified by qf.
+func (m *Matcher) FieldName(field *types.Var, qf types.Qualifier) string {
+	owner, ok := m.fieldOwners[field]
+	if !ok {
+		return ""
+	}
+	return types.TypeString(owner, qf) + "." + field.Name()
+}
+
+// Annotations returns the annotations on the declaration of fn.
+func (m *Matcher) Annotations(fn *types.Func) []*aspect.Annotation {
+	return m.annotations[fn]
+}
+
+// Validate returns the error if the pointcut is invalid.
+// An invalid pointcut matches nothing.
+func (m *Matcher) Validate(pointcut aspect.Pointcut) error {
+	m.parse(pointcut)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.errs["pointcut "+string(pointcut)]
+}
+
+// ValidatePattern returns the error if the type pattern is invalid.
+// An invalid type pattern matches nothing.
+func (m *Matcher) ValidatePattern(pattern aspect.TypePattern) error {
+	m.compilePattern(pattern)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.errs["pattern "+string(pattern)]
+}
+
+func (m *Matcher) parse(pointcut aspect.Pointcut) *Pointcut {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if pc, ok := m.pointcuts[pointcut]; ok {
+		return pc
+	}
+	pc, err := Parse(pointcut)
+	if err != nil {
+		m.errs["pointcut "+string(pointcut)] = err
+	}
+	// nil is cached as well, so as to parse it just once
+	m.pointcuts[pointcut] = pc
+	return pc
+}
+
+// Kind returns the kind of the pointcut.
+// It returns "" for an invalid pointcut.
+func (m *Matcher) Kind(pointcut aspect.Pointcut) aspect.JoinPointKind {
+	pc := m.parse(pointcut)
+	if pc == nil {
+		return ""
+	}
+	return pc.Kind
+}
+
+// Cflows returns the cflow terms of the pointcut.
+func (m *Matcher) Cflows(pointcut aspect.Pointcut) []*Cflow {
+	pc := m.parse(pointcut)
+	if pc == nil {
+		return nil
+	}
+	return pc.Cflows
+}
+
 // ObjMatchPointcut returns true if obj matches the pointcut.
 // current implementation is very naive: just checks regexp for types.Func.FullName()
+// (for "call" and "handler" pointcuts), or FieldFullName() (for "get" and "set" pointcuts).
+// For "annotation" pointcuts, it checks regexp for the names of the annotations.
 // TODO: support interface pointcut
-func ObjMatchPointcut(prog *loader.Program, id *ast.Ident, obj types.Object, pointcut aspect.Pointcut) bool {
-	fn, ok := obj.(*types.Func)
-	if ok {
-		return fnObjMatchPointcutByRegexp(fn, pointcut)
+func (m *Matcher) ObjMatchPointcut(id *ast.Ident, obj types.Object, pointcut aspect.Pointcut) bool {
+	pc := m.parse(pointcut)
+	if pc == nil {
+		return false
 	}
-	return false
+	var name string
+	switch o := obj.(type) {
+	case *types.Func:
+		if pc.Kind != aspect.KindCall && pc.Kind != aspect.KindHandler {
+			return false
+		}
+		if pc.Annotation {
+			return m.annotationMatchPointcut(o, pc)
+		}
+		name = o.FullName()
+	case *types.Var:
+		if (pc.Kind != aspect.KindGet && pc.Kind != aspect.KindSet) || !o.IsField() {
+			return false
+		}
+		name = m.FieldFullName(o)
+		if name == "" {
+			return false
+		}
+	default:
+		return false
+	}
+	return nameMatchPointcutByRegexp(name, pc)
 }
 
-func fnObjMatchPointcutByRegexp(fn *types.Func, pointcut aspect.Pointcut) bool {
-	// TODO: cache compiled regexp
-	re, err := regexp.Compile(string(pointcut))
+// TypeMatchPattern returns true if the full name of the named type
+// (e.g. "example.com/foo.S") matches the type pattern of an introduction.
+func (m *Matcher) TypeMatchPattern(named *types.Named, pattern aspect.TypePattern) bool {
+	re := m.compilePattern(pattern)
+	if re == nil {
+		return false
+	}
+	name := types.TypeString(named, nil)
+	matched := re.MatchString(name)
+	if util.DebugMode {
+		log.Printf("matched=%t for %s (type pattern=%s)", matched, name, pattern)
+	}
+	return matched
+}
+
+func (m *Matcher) compilePattern(pattern aspect.TypePattern) *regexp.Regexp {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if re, ok := m.patterns[pattern]; ok {
+		return re
+	}
+	re, err := regexp.Compile(string(pattern))
 	if err != nil {
-		log.Printf("pointcut %s is not a valid regexp: %s", pointcut, err)
+		m.errs["pattern "+string(pattern)] = err
+	}
+	// nil is cached as well, so as to compile it just once
+	m.patterns[pattern] = re
+	return re
+}
+
+// StmtMatchPointcut returns true if the statement (or the expression) of the kind,
+// in the function named enclosingFunc, matches the pointcut.
+func (m *Matcher) StmtMatchPointcut(kind aspect.JoinPointKind, enclosingFunc string, pointcut aspect.Pointcut) bool {
+	pc := m.parse(pointcut)
+	if pc == nil || pc.Kind != kind {
 		return false
 	}
-	matched := re.MatchString(fn.FullName())
+	return nameMatchPointcutByRegexp(enclosingFunc, pc)
+}
+
+func (m *Matcher) annotationMatchPointcut(fn *types.Func, pc *Pointcut) bool {
+	for _, a := range m.annotations[fn] {
+		if pc.anchored.MatchString(a.Name) {
+			if util.DebugMode {
+				log.Printf("matched=true for %s (annotation=%s, pointcut=%s)", fn.FullName(), a.Name, pc)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func nameMatchPointcutByRegexp(name string, pc *Pointcut) bool {
+	matched := pc.Regexp.MatchString(name)
 	if util.DebugMode {
-		log.Printf("matched=%t for %s (pointcut=%s)", matched, fn.FullName(), string(pointcut))
+		log.Printf("matched=%t for %s (pointcut=%s)", matched, name, pc)
 	}
 	return matched
 }
@@ -0,0 +1,131 @@
+package match
+
+import (
+	"go/ast"
+	"reflect"
+	"testing"
+
+	"golang.org/x/tools/go/loader"
+
+	"golang.org/x/exp/aspectgo/aspect"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		pointcut aspect.Pointcut
+		kind     aspect.JoinPointKind
+		re       string
+	}{
+		{aspect.NewCallPointcutFromRegexp(`fmt\.Print.*`), aspect.KindCall, `fmt\.Print.*`},
+		{aspect.NewCallPointcutFromRegexp(`(example\.com/foo\.I)\.Foo`), aspect.KindCall, `(example\.com/foo\.I)\.Foo`},
+		{aspect.NewGetPointcutFromRegexp(`example\.com/foo\.S\.(X|Y)`), aspect.KindGet, `example\.com/foo\.S\.(X|Y)`},
+		{aspect.NewSetPointcutFromRegexp(`.*`), aspect.KindSet, `.*`},
+		{aspect.Pointcut(`call(.*)`), aspect.KindCall, `.*`},
+		{aspect.NewHandlerPointcutFromRegexp(`os\.Open`), aspect.KindHandler, `os\.Open`},
+		{aspect.NewGoPointcutFromRegexp(`main\.main`), aspect.KindGo, `main\.main`},
+		{aspect.NewSelectPointcutFromRegexp(`main\..*`), aspect.KindSelect, `main\..*`},
+	} {
+		pc, err := Parse(tc.pointcut)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pc.Kind != tc.kind || pc.Regexp.String() != tc.re {
+			t.Fatalf("%s: unexpected result: %s", tc.pointcut, pc)
+		}
+	}
+	if _, err := Parse(aspect.NewGetPointcutFromRegexp(`(`)); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	pc, err := Parse(aspect.NewAnnotationPointcutFromRegexp(`re|retry`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pc.Kind != aspect.KindCall || !pc.Annotation || pc.String() != `annotation(re|retry)` {
+		t.Fatalf("unexpected result: %s", pc)
+	}
+	for name, expected := range map[string]bool{"re": true, "retry": true, "retryx": false, "trace": false} {
+		if pc.anchored.MatchString(name) != expected {
+			t.Fatalf("%s: expected %t", name, expected)
+		}
+	}
+}
+
+func TestParseCflow(t *testing.T) {
+	query := aspect.NewCallPointcutFromRegexp(`database/sql\.\(\*DB\)\.Query`)
+	handler := aspect.NewCallPointcutFromRegexp(`main\.handle.*`)
+	pc, err := Parse(query.And(aspect.NewCflowPointcut(handler)).And(aspect.NewCflowBelowPointcut(query)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pc.Kind != aspect.KindCall || pc.Regexp.String() != string(query) || len(pc.Cflows) != 2 {
+		t.Fatalf("unexpected result: %s", pc)
+	}
+	if pc.Cflows[0].Pointcut != handler || pc.Cflows[0].Below ||
+		pc.Cflows[1].Pointcut != query || !pc.Cflows[1].Below {
+		t.Fatalf("unexpected cflows: %s", pc)
+	}
+
+	for _, invalid := range []aspect.Pointcut{
+		aspect.NewCflowPointcut(handler),
+		query.And(query),
+		aspect.NewGetPointcutFromRegexp(`.*`).And(aspect.NewCflowPointcut(handler)),
+		query.And(aspect.NewCflowPointcut(aspect.NewGetPointcutFromRegexp(`.*`))),
+		query.And(aspect.NewCflowPointcut(`(`)),
+	} {
+		if _, err := Parse(invalid); err == nil {
+			t.Fatalf("%s: expected an error", invalid)
+		}
+	}
+}
+
+func TestParseAnnotations(t *testing.T) {
+	doc := &ast.CommentGroup{List: []*ast.Comment{
+		{Text: "// Foo does something."},
+		{Text: "//aspect:trace"},
+		{Text: "// aspect:ignored"},
+		{Text: "//aspect:retry max=3 verbose backoff=10ms"},
+	}}
+	annotations, err := ParseAnnotations(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*aspect.Annotation{
+		{Name: "trace", Params: map[string]string{}},
+		{Name: "retry", Params: map[string]string{"max": "3", "verbose": "", "backoff": "10ms"}},
+	}
+	if !reflect.DeepEqual(annotations, expected) {
+		t.Fatalf("unexpected result: %v", annotations)
+	}
+
+	for _, text := range []string{"//aspect:", "//aspect:retry =3", "//aspect:retry max=3 max=4"} {
+		doc := &ast.CommentGroup{List: []*ast.Comment{{Text: text}}}
+		if _, err := ParseAnnotations(doc); err == nil {
+			t.Fatalf("%s: expected an error", text)
+		}
+	}
+}
+
+func TestMatcherValidate(t *testing.T) {
+	m := NewMatcher(&loader.Program{})
+	if err := m.Validate(aspect.NewSetPointcutFromRegexp(`example\.com/foo\.S\.X`)); err != nil {
+		t.Fatal(err)
+	}
+	for _, pointcut := range []aspect.Pointcut{
+		aspect.NewSetPointcutFromRegexp(`(`),
+		aspect.Pointcut(`cflow(main\.main)`),
+	} {
+		if err := m.Validate(pointcut); err == nil {
+			t.Fatalf("%s: expected an error", pointcut)
+		}
+		if m.Kind(pointcut) != "" {
+			t.Fatalf("%s: expected no kind", pointcut)
+		}
+	}
+	if err := m.ValidatePattern(aspect.TypePattern(`example\.com/foo\.S`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ValidatePattern(aspect.TypePattern(`[`)); err == nil {
+		t.Fatal("expected an error")
+	}
+}
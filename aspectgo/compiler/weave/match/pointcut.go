@@ -0,0 +1,163 @@
+package match
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/exp/aspectgo/aspect"
+)
+
+// Pointcut is the parsed representation of aspect.Pointcut.
+//
+// The syntax is `kind(regexp)`, e.g. `get(example\.com/foo\.S\.X)`.
+// A pointcut without the kind is a "call" pointcut, for compatibility.
+// `annotation(regexp)` is a "call" pointcut for the annotated functions.
+//
+// A "call" or "handler" pointcut can be followed by
+// ` && cflow(pointcut)` and ` && cflowbelow(pointcut)` terms.
+type Pointcut struct {
+	Kind   aspect.JoinPointKind
+	Regexp *regexp.Regexp
+
+	// Annotation is true if Regexp is matched against the whole names of
+	// the annotations, instead of the name of the function.
+	Annotation bool
+
+	// Cflows are the cflow terms, which are checked on runtime.
+	Cflows []*Cflow
+
+	// anchored is Regexp anchored at both ends, for Annotation.
+	anchored *regexp.Regexp
+}
+
+// Cflow is the `cflow(pointcut)` or `cflowbelow(pointcut)` term.
+type Cflow struct {
+	// Pointcut is the inner "call" pointcut.
+	Pointcut aspect.Pointcut
+
+	// Below is true for cflowbelow.
+	Below bool
+}
+
+func (c *Cflow) String() string {
+	if c.Below {
+		return fmt.Sprintf("%s(%s)", cflowBelowPrefix, c.Pointcut)
+	}
+	return fmt.Sprintf("%s(%s)", cflowPrefix, c.Pointcut)
+}
+
+func (pc *Pointcut) String() string {
+	var s string
+	if pc.Annotation {
+		s = fmt.Sprintf("%s(%s)", annotationPrefix, pc.Regexp)
+	} else {
+		s = fmt.Sprintf("%s(%s)", pc.Kind, pc.Regexp)
+	}
+	for _, c := range pc.Cflows {
+		s += andOperator + c.String()
+	}
+	return s
+}
+
+const (
+	annotationPrefix = "annotation"
+	cflowPrefix      = "cflow"
+	cflowBelowPrefix = "cflowbelow"
+	andOperator      = " && "
+)
+
+var pointcutKinds = []aspect.JoinPointKind{
+	aspect.KindCall,
+	aspect.KindHandler,
+	aspect.KindGet,
+	aspect.KindSet,
+	aspect.KindGo,
+	aspect.KindSend,
+	aspect.KindRecv,
+	aspect.KindSelect,
+	aspect.KindClose,
+}
+
+// IsStmtKind returns true for the kinds that match the enclosing function
+// of the statement (or the expression), e.g. "go".
+func IsStmtKind(kind aspect.JoinPointKind) bool {
+	switch kind {
+	case aspect.KindGo, aspect.KindSend, aspect.KindRecv, aspect.KindSelect, aspect.KindClose:
+		return true
+	}
+	return false
+}
+
+// unwrap returns the inside of `prefix(...)`.
+func unwrap(s, prefix string) (string, bool) {
+	prefix += "("
+	if strings.HasPrefix(s, prefix) && strings.HasSuffix(s, ")") {
+		return s[len(prefix) : len(s)-1], true
+	}
+	return "", false
+}
+
+// Parse parses the pointcut.
+func Parse(pointcut aspect.Pointcut) (*Pointcut, error) {
+	var pc *Pointcut
+	var cflows []*Cflow
+	for _, term := range strings.Split(string(pointcut), andOperator) {
+		if inner, ok := unwrap(term, cflowBelowPrefix); ok {
+			cflows = append(cflows, &Cflow{Pointcut: aspect.Pointcut(inner), Below: true})
+			continue
+		}
+		if inner, ok := unwrap(term, cflowPrefix); ok {
+			cflows = append(cflows, &Cflow{Pointcut: aspect.Pointcut(inner)})
+			continue
+		}
+		if pc != nil {
+			return nil, fmt.Errorf("only cflow and cflowbelow can be combined, got %q", term)
+		}
+		var err error
+		pc, err = parseTerm(term)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if pc == nil {
+		return nil, fmt.Errorf("cflow needs to be combined with a call or handler pointcut")
+	}
+	if len(cflows) != 0 && pc.Kind != aspect.KindCall && pc.Kind != aspect.KindHandler {
+		return nil, fmt.Errorf("cflow cannot be combined with a %s pointcut", pc.Kind)
+	}
+	for _, c := range cflows {
+		inner, err := Parse(c.Pointcut)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", c, err)
+		}
+		if inner.Kind != aspect.KindCall || len(inner.Cflows) != 0 {
+			return nil, fmt.Errorf("%s: only a call pointcut is supported in cflow", c)
+		}
+	}
+	pc.Cflows = cflows
+	return pc, nil
+}
+
+func parseTerm(s string) (*Pointcut, error) {
+	if re, ok := unwrap(s, annotationPrefix); ok {
+		compiled, err := regexp.Compile(re)
+		if err != nil {
+			return nil, fmt.Errorf("not a valid regexp: %s", err)
+		}
+		anchored := regexp.MustCompile("^(?:" + re + ")$")
+		return &Pointcut{Kind: aspect.KindCall, Regexp: compiled, Annotation: true, anchored: anchored}, nil
+	}
+	kind, re := aspect.KindCall, s
+	for _, k := range pointcutKinds {
+		if inner, ok := unwrap(s, string(k)); ok {
+			kind, re = k, inner
+			break
+		}
+	}
+	compiled, err := regexp.Compile(re)
+	if err != nil {
+		return nil, fmt.Errorf("not a valid regexp: %s", err)
+	}
+	return &Pointcut{Kind: kind, Regexp: compiled}, nil
+}
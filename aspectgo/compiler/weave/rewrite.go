@@ -9,7 +9,11 @@ import (
 	"go/types"
 	"log"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 
 	rewrite "github.com/tsuna/gorewrite"
 
@@ -18,10 +22,22 @@ import (
 	"golang.org/x/exp/aspectgo/aspect"
 	"golang.org/x/exp/aspectgo/compiler/consts"
 	"golang.org/x/exp/aspectgo/compiler/gopath"
+	"golang.org/x/exp/aspectgo/compiler/parse"
 	"golang.org/x/exp/aspectgo/compiler/util"
+	"golang.org/x/exp/aspectgo/compiler/weave/match"
 )
 
-func rewriteProgram(wovenGOPATH string, rw *rewriter) ([]string, error) {
+// rewriteJob is a file to be rewritten by rewriteProgram.
+type rewriteJob struct {
+	pkgInfo *loader.PackageInfo
+	file    *ast.File
+	// index is the index of the file in the package.
+	index int
+}
+
+// rewriteProgram rewrites the files in rw.Packages concurrently,
+// and returns the rewritten file names for each package.
+func rewriteProgram(wovenGOPATH string, rw *rewriter) (map[*types.Package][]string, error) {
 	if err := rw.init(); err != nil {
 		return nil, err
 	}
@@ -29,78 +45,149 @@ func rewriteProgram(wovenGOPATH string, rw *rewriter) ([]string, error) {
 	if oldGOPATH == "" {
 		return nil, fmt.Errorf("GOPATH not set")
 	}
-	var rewrittenFnames []string
-	for _, pkgInfo := range rw.Program.InitialPackages() {
-		rw.currentPkg = pkgInfo.Pkg
-		for _, file := range pkgInfo.Files {
-			rw.currentFile = file
+	rw.oldGOPATH = oldGOPATH
+	var jobs []rewriteJob
+	for _, pkgInfo := range rw.Packages {
+		for i, file := range pkgInfo.Files {
 			posn := rw.Program.Fset.Position(file.Pos())
-			if strings.HasSuffix(posn.Filename, "_aspect.go") {
+			if rw.AspectFile.IsAspectFile(posn.Filename) {
 				continue
 			}
-			outf, err := gopath.FileForNewGOPATH(posn.Filename,
-				oldGOPATH, wovenGOPATH)
-			if err != nil {
-				return nil, err
-			}
-			defer outf.Close()
-			log.Printf("Rewriting %s --> %s",
-				posn.Filename, outf.Name())
-			rewritten := rewrite.Rewrite(rw, file)
-			outw := bufio.NewWriter(outf)
-			outw.Write([]byte(consts.AutogenFileHeader))
-			err = format.Node(outw, rw.Program.Fset, rewritten)
-			if err != nil {
-				return nil, err
+			// the files without join points are left untouched
+			if !rw.fileNeedsRewrite(pkgInfo, file) {
+				continue
 			}
-			for _, add := range rw.AddendumForASTFile() {
-				outw.Write([]byte("\n"))
-				format.Node(outw, rw.Program.Fset, add)
-				outw.Write([]byte("\n"))
+			jobs = append(jobs, rewriteJob{pkgInfo: pkgInfo, file: file, index: i})
+		}
+	}
+
+	fnames := make([]string, len(jobs))
+	errs := make([]error, len(jobs))
+	jobIndices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobIndices {
+				job := jobs[i]
+				fnames[i], errs[i] = rewriteFile(wovenGOPATH, rw.forFile(job.pkgInfo.Pkg, job.file, job.index), job.file)
 			}
-			outw.Flush()
-			rewrittenFnames = append(rewrittenFnames, outf.Name())
+		}()
+	}
+	for i := range jobs {
+		jobIndices <- i
+	}
+	close(jobIndices)
+	wg.Wait()
+
+	rewrittenFnames := make(map[*types.Package][]string)
+	for i, job := range jobs {
+		if errs[i] != nil {
+			return nil, errs[i]
 		}
+		rewrittenFnames[job.pkgInfo.Pkg] = append(rewrittenFnames[job.pkgInfo.Pkg], fnames[i])
 	}
 	return rewrittenFnames, nil
 }
 
-var gRewriterLastP = 0
+// rewriteFile rewrites the file with the rewriter for the file,
+// and returns the rewritten file name.
+func rewriteFile(wovenGOPATH string, r *rewriter, file *ast.File) (string, error) {
+	posn := r.Program.Fset.Position(file.Pos())
+	outf, err := gopath.FileForNewGOPATH(posn.Filename,
+		r.oldGOPATH, wovenGOPATH)
+	if err != nil {
+		return "", err
+	}
+	defer outf.Close()
+	log.Printf("Rewriting %s --> %s",
+		posn.Filename, outf.Name())
+	rewritten := r.addImports(rewrite.Rewrite(r, file).(*ast.File))
+	outw := bufio.NewWriter(outf)
+	outw.Write([]byte(consts.AutogenFileHeader))
+	err = format.Node(outw, r.Program.Fset, rewritten)
+	if err != nil {
+		return "", err
+	}
+	for _, add := range r.AddendumForASTFile() {
+		outw.Write([]byte("\n"))
+		format.Node(outw, r.Program.Fset, add)
+		outw.Write([]byte("\n"))
+	}
+	if err = outw.Flush(); err != nil {
+		return "", err
+	}
+	return outf.Name(), nil
+}
 
 // rewriter implements rewrite.Rewriter.
 // usage:
 //  Step 1: instatiate rewriter and call rewriter.init().
-//  Step 2: set rewriter.currentPkg, for each pkg in the program
-//  Step 3: set rewriter.currentFile, for each file in the pkg
-//  Step 4: call rewrite.Rewrite(rewriter, rewriter.currentFile) for rewriting the file
-//  Step 5: call rewriter.AddendumForAstFile() for getting the addendum for the file
+//  Step 2: call rewriter.forFile() for each file in the packages
+//  Step 3: call rewrite.Rewrite(fileRewriter, file) for rewriting the file
+//  Step 4: call fileRewriter.AddendumForAstFile() for getting the addendum for the file
+// The exported fields are not modified after init(), so the rewriters for
+// the files can be used concurrently.
 type rewriter struct {
+	AspectFile       *parse.AspectFile
 	Program          *loader.Program
+	Packages         []*loader.PackageInfo
+	Matcher          *match.Matcher
 	Matched          map[*ast.Ident]types.Object
 	Aspects          map[aspect.Pointcut]*types.Named
+	Instantiations   map[*types.Named]aspect.Instantiation
 	PointcutsByIdent map[*ast.Ident]aspect.Pointcut
+	FieldPointcuts   map[*ast.SelectorExpr]map[aspect.JoinPointKind]aspect.Pointcut
+	Stmts            map[ast.Node]*stmtMatch
+	CflowMarks       map[*ast.Ident][]aspect.Pointcut
+	Introductions    map[*types.TypeName][]*introduction
+	// oldGOPATH is set by rewriteProgram().
+	// It is used for rewriter.joinPointPos().
+	oldGOPATH string
+
+	// The following fields are set by rewriter.forFile().
+	// pkg and file are used for rewriter.typeString().
+	pkg  *types.Package
+	file *ast.File
+	// fileIndex is the index of the file in pkg.
+	// It is used for rewriter.newProxyName(), with lastP.
+	fileIndex int
+	lastP     int
 	// fileAddendum is set by rewriter.Rewrite().
-	// rewriteProgram() uses rewriter.AddendumForASTFile()
+	// rewriteFile() uses rewriter.AddendumForASTFile()
 	// as a getter.
 	fileAddendum []ast.Node
 	proxyExprs   map[*ast.Ident]ast.Expr
-	// currentPkg is set by the loop in rewriteProgram().
-	// It is used for rewriter.typeString().
-	currentPkg *types.Package
-	// currentFile is set by the loop in rewriteProgram().
-	// It is used for rewriter.typeString().
-	currentFile *ast.File
 }
 
 func (r *rewriter) init() error {
-	if r.Program == nil || r.Matched == nil ||
-		r.Aspects == nil || r.PointcutsByIdent == nil {
+	if r.AspectFile == nil || r.Program == nil || r.Packages == nil || r.Matcher == nil || r.Matched == nil ||
+		r.Aspects == nil || r.Instantiations == nil ||
+		r.PointcutsByIdent == nil || r.FieldPointcuts == nil ||
+		r.Stmts == nil || r.CflowMarks == nil || r.Introductions == nil {
 		log.Fatal("impl error (nil args)")
 	}
+	return nil
+}
 
+// forFile returns the rewriter for the file in pkg.
+// index is the index of the file in the package.
+func (r *rewriter) forFile(pkg *types.Package, file *ast.File, index int) *rewriter {
+	fr := *r
+	fr.pkg = pkg
+	fr.file = file
+	fr.fileIndex = index
 	// NOTE: r.fileAddendum is initialized in Rewrite():*ast.File
-	r.proxyExprs = make(map[*ast.Ident]ast.Expr)
-	return nil
+	fr.proxyExprs = make(map[*ast.Ident]ast.Expr)
+	return &fr
+}
+
+// newProxyName returns a name for a proxy, which is unique in the package.
+func (r *rewriter) newProxyName() string {
+	proxyName := fmt.Sprintf("_ag_proxy_%d_%d", r.fileIndex, r.lastP)
+	r.lastP++
+	return proxyName
 }
 
 func voidIntfArrayExpr() *ast.ArrayType {
@@ -111,7 +198,7 @@ func voidIntfArrayExpr() *ast.ArrayType {
 }
 
 // _proxy_decl generates _ag_proxy_func decl like this:
-// `func _ag_proxy_0(s string)`
+// `func _ag_proxy_0_0(s string)`
 func (r *rewriter) _proxy_decl(node ast.Node, matched types.Object, proxyName string) *ast.FuncDecl {
 	sig := matched.Type().(*types.Signature)
 	funcDecl := &ast.FuncDecl{}
@@ -161,7 +248,7 @@ func (r *rewriter) _proxy_body_XArgs(matched types.Object) []ast.Expr {
 
 // _proxy_body_XFunc generates like this:
 // `XFunc: func(_ag_args []interface{}) []interface {} {
-//                _ag_arg0 := _ag_args[0].(string)
+//                _ag_arg0, _ := _ag_args[0].(string)
 //                sayHello(_ag_arg0)
 //                _ag_res := []interface{}{}
 //                return _ag_res
@@ -181,22 +268,9 @@ func (r *rewriter) _proxy_body_XFunc(node ast.Node, matched types.Object) *ast.F
 			xFuncBodyArgExprs = append(xFuncBodyArgExprs,
 				ast.NewIdent(lhsName))
 		}
-		assignStmt := &ast.AssignStmt{
-			Lhs: []ast.Expr{
-				ast.NewIdent(lhsName),
-			},
-			Tok: token.DEFINE,
-			Rhs: []ast.Expr{
-				&ast.TypeAssertExpr{
-					X: &ast.IndexExpr{
-						X: ast.NewIdent("_ag_args"),
-						Index: &ast.BasicLit{
-							Kind:  token.INT,
-							Value: fmt.Sprintf("%d", i),
-						}},
-					Type: rhsType,
-				}}}
-		xFuncBodyStmts = append(xFuncBodyStmts, assignStmt)
+		// the args have been checked against the joinpoint, and nil is the zero value
+		xFuncBodyStmts = append(xFuncBodyStmts,
+			assertStmt(lhsName, argsExpr("_ag_args", i), rhsType))
 	}
 	var xFuncBodyCallFuncExp ast.Expr
 	switch n := node.(type) {
@@ -214,7 +288,7 @@ func (r *rewriter) _proxy_body_XFunc(node ast.Node, matched types.Object) *ast.F
 			X:   x,
 			Sel: ast.NewIdent(n.Sel.Name)}
 	default:
-		log.Fatalf("impl error: %s is unexpected type: %s", util.ASTDebugString(n))
+		log.Fatalf("impl error: %s is unexpected type: %T", util.ASTDebugString(n), n)
 	}
 	var xFuncBodyCallLhs []ast.Expr
 	var xFuncBodyCallLhs2 []ast.Expr
@@ -275,69 +349,507 @@ func (r *rewriter) _proxy_body_XReceiver(node ast.Node, matched types.Object) as
 	return ast.NewIdent("nil")
 }
 
-func (r *rewriter) _proxy_body_callExpr(node ast.Node, matched types.Object, asp *types.Named) *ast.CallExpr {
+// _aspect_newExpr generates like this:
+// `&_ag_aspects.ExampleAspect{}`
+func (r *rewriter) _aspect_newExpr(asp *types.Named) ast.Expr {
+	return &ast.UnaryExpr{
+		Op: token.AND,
+		X: &ast.CompositeLit{
+			Type: &ast.SelectorExpr{
+				X:   ast.NewIdent(aspectPkgName),
+				Sel: ast.NewIdent(asp.Obj().Name()),
+			}}}
+}
+
+// _aspect_newFuncLit generates like this:
+// `func() interface{} { return &_ag_aspects.ExampleAspect{} }`
+func (r *rewriter) _aspect_newFuncLit(asp *types.Named) *ast.FuncLit {
+	return &ast.FuncLit{
+		Type: &ast.FuncType{
+			Params: &ast.FieldList{},
+			Results: &ast.FieldList{
+				List: []*ast.Field{
+					&ast.Field{
+						Type: &ast.InterfaceType{
+							Methods: &ast.FieldList{}}}}}},
+		Body: &ast.BlockStmt{
+			List: []ast.Stmt{
+				&ast.ReturnStmt{
+					Results: []ast.Expr{r._aspect_newExpr(asp)}}}}}
+}
+
+// _aspect_instanceDecl generates the aspect instance decl like this:
+// `var _ag_aspect_ag_proxy_0_0 = aspectrt.Singleton("example.com/aspects.ExampleAspect", func() interface{} { .. })`
+// For aspect.AsyncAspect aspects, it generates like this:
+// `var _ag_aspect_ag_proxy_0_0 = aspectrt.Async("example.com/aspects.ExampleAspect", func() interface{} { .. })`
+func (r *rewriter) _aspect_instanceDecl(asp *types.Named, instanceName string) *ast.GenDecl {
+	var rhs *ast.CallExpr
+	inst := r.Instantiations[asp]
+	if isAsyncAspect(asp) {
+		inst = aspect.Singleton
+	}
+	switch inst {
+	case aspect.PerJoinPoint:
+		rhs = &ast.CallExpr{
+			Fun: &ast.SelectorExpr{
+				X:   ast.NewIdent("aspectrt"),
+				Sel: ast.NewIdent("NewAspectInstance"),
+			},
+			Args: []ast.Expr{r._aspect_newFuncLit(asp)}}
+	case aspect.Singleton:
+		singletonFunc := "Singleton"
+		if isAsyncAspect(asp) {
+			singletonFunc = "Async"
+		}
+		rhs = &ast.CallExpr{
+			Fun: &ast.SelectorExpr{
+				X:   ast.NewIdent("aspectrt"),
+				Sel: ast.NewIdent(singletonFunc),
+			},
+			Args: []ast.Expr{
+				&ast.BasicLit{
+					Kind:  token.STRING,
+					Value: fmt.Sprintf("%q", r.AspectFile.ImportPath+"."+asp.Obj().Name()),
+				},
+				r._aspect_newFuncLit(asp)}}
+	default:
+		log.Fatalf("impl error: unexpected instantiation %s for %s", inst, asp)
+	}
+	return &ast.GenDecl{
+		Tok: token.VAR,
+		Specs: []ast.Spec{
+			&ast.ValueSpec{
+				Names:  []*ast.Ident{ast.NewIdent(instanceName)},
+				Values: []ast.Expr{rhs}}}}
+}
+
+// hasInitHook returns true if asp implements aspect.Initializer.
+func hasInitHook(asp *types.Named) bool {
+	obj, _, _ := types.LookupFieldOrMethod(types.NewPointer(asp), true, asp.Obj().Pkg(), "Init")
+	fn, ok := obj.(*types.Func)
+	if !ok {
+		return false
+	}
+	sig := fn.Type().(*types.Signature)
+	return sig.Params().Len() == 0 && sig.Results().Len() == 0
+}
+
+// isAsyncAspect returns true if asp implements aspect.AsyncAspect.
+func isAsyncAspect(asp *types.Named) bool {
+	for _, name := range []string{"AsyncOptions", "HandleEvent"} {
+		obj, _, _ := types.LookupFieldOrMethod(types.NewPointer(asp), true, asp.Obj().Pkg(), name)
+		if _, ok := obj.(*types.Func); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// _aspect_expr generates the expression for the aspect instance.
+//
+// For aspect.PerCall aspects:
+// `(&_ag_aspects.ExampleAspect{})`
+// or, if the aspect implements aspect.Initializer:
+// `aspectrt.NewAspect(func() interface{} { return &_ag_aspects.ExampleAspect{} })`
+//
+// For aspect.PerJoinPoint and aspect.Singleton aspects:
+// `_ag_aspect_ag_proxy_0_0.Get()`
+// _ag_aspect_ag_proxy_0_0 is generated as an addendum.
+//
+// For aspect.AsyncAspect aspects:
+// `_ag_aspect_ag_proxy_0_0`
+// _ag_aspect_ag_proxy_0_0 is the *aspectrt.AsyncDispatcher generated as an addendum.
+func (r *rewriter) _aspect_expr(asp *types.Named, proxyName string) ast.Expr {
+	if isAsyncAspect(asp) {
+		instanceName := fmt.Sprintf("_ag_aspect%s", proxyName)
+		r.fileAddendum = append(r.fileAddendum,
+			r._aspect_instanceDecl(asp, instanceName))
+		return ast.NewIdent(instanceName)
+	}
+	if r.Instantiations[asp] == aspect.PerCall {
+		if hasInitHook(asp) {
+			return &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   ast.NewIdent("aspectrt"),
+					Sel: ast.NewIdent("NewAspect"),
+				},
+				Args: []ast.Expr{r._aspect_newFuncLit(asp)}}
+		}
+		return &ast.ParenExpr{X: r._aspect_newExpr(asp)}
+	}
+	instanceName := fmt.Sprintf("_ag_aspect%s", proxyName)
+	r.fileAddendum = append(r.fileAddendum,
+		r._aspect_instanceDecl(asp, instanceName))
+	return &ast.CallExpr{
+		Fun: &ast.SelectorExpr{
+			X:   ast.NewIdent(instanceName),
+			Sel: ast.NewIdent("Get"),
+		}}
+}
+
+// joinPointPos returns the position string for aspect.JoinPoint.Pos.
+func (r *rewriter) joinPointPos(pos token.Pos) string {
+	return relativePos(r.Program.Fset, r.oldGOPATH, pos)
+}
+
+// relativePos returns the position string of pos, with the file name relative to $GOPATH/src.
+func relativePos(fset *token.FileSet, gopath string, pos token.Pos) string {
+	posn := fset.Position(pos)
+	gopathSrc := filepath.Join(gopath, "src")
+	if rel, err := filepath.Rel(gopathSrc, posn.Filename); err == nil && !strings.HasPrefix(rel, "..") {
+		posn.Filename = filepath.ToSlash(rel)
+	}
+	return posn.String()
+}
+
+func isErrorType(typ types.Type) bool {
+	return types.Identical(typ, types.Universe.Lookup("error").Type())
+}
+
+// _callJoinPoint returns the joinpoint of the given kind for the call of matched.
+func (r *rewriter) _callJoinPoint(node ast.Node, matched types.Object, kind aspect.JoinPointKind) *aspect.JoinPoint {
+	sig := matched.Type().(*types.Signature)
+	errorResult := sig.Results().Len() > 0 &&
+		isErrorType(sig.Results().At(sig.Results().Len()-1).Type())
+	return &aspect.JoinPoint{
+		Kind:        kind,
+		Name:        matched.(*types.Func).FullName(),
+		ShortName:   shortFuncName(matched.(*types.Func)),
+		Pos:         r.joinPointPos(node.Pos()),
+		Caller:      enclosingFuncName(r.typesInfo(), r.file, node.Pos()),
+		NumResults:  sig.Results().Len(),
+		ErrorResult: errorResult,
+		Annotations: r.Matcher.Annotations(matched.(*types.Func)),
+	}
+}
+
+// pkgNameQualifier qualifies the objects by the package name.
+func pkgNameQualifier(p *types.Package) string {
+	return p.Name()
+}
+
+// shortFuncName returns the name of fn qualified by the package name,
+// e.g. "http.Get", "(*http.Client).Get".
+func shortFuncName(fn *types.Func) string {
+	if recv := fn.Type().(*types.Signature).Recv(); recv != nil {
+		return fmt.Sprintf("(%s).%s", types.TypeString(recv.Type(), pkgNameQualifier), fn.Name())
+	}
+	if fn.Pkg() == nil {
+		return fn.Name()
+	}
+	return fn.Pkg().Name() + "." + fn.Name()
+}
+
+// annotationsLit generates like this:
+// `[]*aspectrt.Annotation{{Name: "retry", Params: map[string]string{"max": "3"}}}`
+func annotationsLit(annotations []*aspect.Annotation) string {
+	var elts []string
+	for _, a := range annotations {
+		keys := make([]string, 0, len(a.Params))
+		for k := range a.Params {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var params []string
+		for _, k := range keys {
+			params = append(params, fmt.Sprintf("%q: %q", k, a.Params[k]))
+		}
+		elts = append(elts, fmt.Sprintf("{Name: %q, Params: map[string]string{%s}}",
+			a.Name, strings.Join(params, ", ")))
+	}
+	return fmt.Sprintf("[]*aspectrt.Annotation{%s}", strings.Join(elts, ", "))
+}
+
+// signature is the names and the types of the args and the results of a joinpoint.
+// The types are checked at runtime.
+type signature struct {
+	ParamNames, ResultNames []string
+	Params, Results         []types.Type
+}
+
+// callSignature returns the signature of the call of matched.
+func callSignature(matched types.Object) *signature {
+	sig := matched.Type().(*types.Signature)
+	s := &signature{}
+	for i := 0; i < sig.Params().Len(); i++ {
+		s.ParamNames = append(s.ParamNames, varName(sig.Params().At(i)))
+		s.Params = append(s.Params, sig.Params().At(i).Type())
+	}
+	for i := 0; i < sig.Results().Len(); i++ {
+		s.ResultNames = append(s.ResultNames, varName(sig.Results().At(i)))
+		s.Results = append(s.Results, sig.Results().At(i).Type())
+	}
+	return s
+}
+
+// varName returns the name of the parameter (or the result) v,
+// or "" if v is unnamed or blank.
+func varName(v *types.Var) string {
+	if v.Name() == "_" {
+		return ""
+	}
+	return v.Name()
+}
+
+// namesLit generates like this:
+// `[]string{"name", "flag"}`
+func namesLit(names []string) string {
+	var quoted []string
+	for _, name := range names {
+		quoted = append(quoted, fmt.Sprintf("%q", name))
+	}
+	return fmt.Sprintf("[]string{%s}", strings.Join(quoted, ", "))
+}
+
+// typesLit generates like this:
+// `aspectrt.Types((*string)(nil), (*error)(nil))`
+func (r *rewriter) typesLit(typs []types.Type) string {
+	var ptrs []string
+	for _, typ := range typs {
+		ptrs = append(ptrs, fmt.Sprintf("(*%s)(nil)", r.typeString(typ)))
+	}
+	return fmt.Sprintf("aspectrt.Types(%s)", strings.Join(ptrs, ", "))
+}
+
+// _joinPointDecl generates the joinpoint decl like this:
+// `var _ag_jp_ag_proxy_0_0 = &aspectrt.JoinPoint{Kind: "call", Name: "main.sayHello", ShortName: "main.sayHello", Pos: "example.com/hello/main.go:12:2", Caller: "main.main", NumResults: 0, ErrorResult: false, ParamNames: []string{"s"}, ResultNames: []string{}, ParamTypes: aspectrt.Types((*string)(nil)), ResultTypes: aspectrt.Types()}`
+// Annotations is generated only if any.
+// ParamNames, ResultNames, ParamTypes and ResultTypes are generated only if sig is not nil.
+func (r *rewriter) _joinPointDecl(jp *aspect.JoinPoint, jpName string, sig *signature) *ast.GenDecl {
+	kv := func(k string, v string) ast.Expr {
+		return &ast.KeyValueExpr{
+			Key:   ast.NewIdent(k),
+			Value: &ast.BasicLit{Kind: token.STRING, Value: v},
+		}
+	}
+	elts := []ast.Expr{
+		kv("Kind", fmt.Sprintf("%q", string(jp.Kind))),
+		kv("Name", fmt.Sprintf("%q", jp.Name)),
+		kv("ShortName", fmt.Sprintf("%q", jp.ShortName)),
+		kv("Pos", fmt.Sprintf("%q", jp.Pos)),
+		kv("Caller", fmt.Sprintf("%q", jp.Caller)),
+		kv("NumResults", fmt.Sprintf("%d", jp.NumResults)),
+		kv("ErrorResult", fmt.Sprintf("%t", jp.ErrorResult)),
+	}
+	if len(jp.Annotations) != 0 {
+		elts = append(elts, kv("Annotations", annotationsLit(jp.Annotations)))
+	}
+	if sig != nil {
+		elts = append(elts,
+			kv("ParamNames", namesLit(sig.ParamNames)),
+			kv("ResultNames", namesLit(sig.ResultNames)),
+			kv("ParamTypes", r.typesLit(sig.Params)),
+			kv("ResultTypes", r.typesLit(sig.Results)))
+	}
+	lit := &ast.UnaryExpr{
+		Op: token.AND,
+		X: &ast.CompositeLit{
+			Type: &ast.SelectorExpr{
+				X:   ast.NewIdent("aspectrt"),
+				Sel: ast.NewIdent("JoinPoint"),
+			},
+			Elts: elts}}
+	return &ast.GenDecl{
+		Tok: token.VAR,
+		Specs: []ast.Spec{
+			&ast.ValueSpec{
+				Names:  []*ast.Ident{ast.NewIdent(jpName)},
+				Values: []ast.Expr{lit}}}}
+}
+
+// _proxy_body_XJoinPoint generates like this:
+// `XJoinPoint: _ag_jp_ag_proxy_0_0`
+// _ag_jp_ag_proxy_0_0 is generated as an addendum.
+func (r *rewriter) _proxy_body_XJoinPoint(jp *aspect.JoinPoint, proxyName string, sig *signature) ast.Expr {
+	jpName := fmt.Sprintf("_ag_jp%s", proxyName)
+	r.fileAddendum = append(r.fileAddendum,
+		r._joinPointDecl(jp, jpName, sig))
+	return ast.NewIdent(jpName)
+}
+
+// _proxy_body_callExpr generates the advice call.
+// For a "handler" joinpoint, it generates like this instead:
+//
+// (&aspectrt.HandlerContextImpl{ContextImpl: aspectrt.ContextImpl{..}}).Handle(ASPECT)
+func (r *rewriter) _proxy_body_callExpr(node ast.Node, matched types.Object, proxyName string, asp *types.Named, pointcut aspect.Pointcut) *ast.CallExpr {
+	kind := r.Matcher.Kind(pointcut)
 	callExpr := &ast.CallExpr{}
 	adviceExpr := &ast.SelectorExpr{
-		X: &ast.ParenExpr{
-			X: &ast.UnaryExpr{
-				Op: token.AND,
-				X: &ast.CompositeLit{
-					Type: &ast.SelectorExpr{
-						X:   ast.NewIdent("agaspect"),
-						Sel: ast.NewIdent(asp.Obj().Name()),
-					}}}},
+		X: r._proxy_aspect_expr(asp, proxyName, pointcut),
 		Sel: &ast.Ident{
 			Name: "Advice",
 		}}
 
-	ctxExpr := &ast.UnaryExpr{
-		Op: token.AND,
-		X: &ast.CompositeLit{
+	ctxLit := &ast.CompositeLit{
+		Type: &ast.SelectorExpr{
+			X:   ast.NewIdent("aspectrt"),
+			Sel: ast.NewIdent("ContextImpl"),
+		},
+		Elts: []ast.Expr{
+			&ast.KeyValueExpr{
+				Key: ast.NewIdent("XArgs"),
+				Value: &ast.CompositeLit{
+					Type: voidIntfArrayExpr(),
+					Elts: r._proxy_body_XArgs(matched),
+				}},
+			&ast.KeyValueExpr{
+				Key:   ast.NewIdent("XFunc"),
+				Value: r._proxy_body_XFunc(node, matched),
+			},
+			&ast.KeyValueExpr{
+				Key:   ast.NewIdent("XReceiver"),
+				Value: r._proxy_body_XReceiver(node, matched),
+			},
+			&ast.KeyValueExpr{
+				Key:   ast.NewIdent("XJoinPoint"),
+				Value: r._proxy_body_XJoinPoint(r._callJoinPoint(node, matched, kind), proxyName, callSignature(matched)),
+			}}}
+
+	if kind == aspect.KindHandler {
+		handlerLit := &ast.CompositeLit{
 			Type: &ast.SelectorExpr{
 				X:   ast.NewIdent("aspectrt"),
-				Sel: ast.NewIdent("ContextImpl"),
+				Sel: ast.NewIdent("HandlerContextImpl"),
 			},
 			Elts: []ast.Expr{
 				&ast.KeyValueExpr{
-					Key: ast.NewIdent("XArgs"),
-					Value: &ast.CompositeLit{
-						Type: voidIntfArrayExpr(),
-						Elts: r._proxy_body_XArgs(matched),
-					}},
-				&ast.KeyValueExpr{
-					Key:   ast.NewIdent("XFunc"),
-					Value: r._proxy_body_XFunc(node, matched),
-				},
-				&ast.KeyValueExpr{
-					Key:   ast.NewIdent("XReceiver"),
-					Value: r._proxy_body_XReceiver(node, matched),
-				}}}}
+					Key:   ast.NewIdent("ContextImpl"),
+					Value: ctxLit,
+				}}}
+		callExpr.Fun = &ast.SelectorExpr{
+			X:   &ast.ParenExpr{X: &ast.UnaryExpr{Op: token.AND, X: handlerLit}},
+			Sel: ast.NewIdent("Handle"),
+		}
+		callExpr.Args = []ast.Expr{adviceExpr.X}
+		return callExpr
+	}
 
 	callExpr.Fun = adviceExpr
-	callExpr.Args = []ast.Expr{ctxExpr}
+	callExpr.Args = []ast.Expr{&ast.UnaryExpr{Op: token.AND, X: ctxLit}}
 	return callExpr
 }
 
+// callIdent returns the identifier of the function for the call proxy.
+func callIdent(node ast.Node) *ast.Ident {
+	switch n := node.(type) {
+	case *ast.Ident:
+		return n
+	case *ast.SelectorExpr:
+		return n.Sel
+	}
+	log.Fatalf("impl error: %s is unexpected type", util.ASTDebugString(node))
+	return nil
+}
+
+// _proxy_aspect_expr generates the expression for the aspect of the call proxy.
+// If asp is nil, the call is woven just for the cflow pointcuts:
+// `aspectrt.Proceed{}`
+// If the pointcut has cflow terms, the aspect is guarded like this:
+// `aspectrt.Guard(_ag_cflow, func() interface{} { return ASPECT })`
+func (r *rewriter) _proxy_aspect_expr(asp *types.Named, proxyName string, pointcut aspect.Pointcut) ast.Expr {
+	if asp == nil {
+		return &ast.CompositeLit{
+			Type: &ast.SelectorExpr{
+				X:   ast.NewIdent("aspectrt"),
+				Sel: ast.NewIdent("Proceed"),
+			}}
+	}
+	aspExpr := r._aspect_expr(asp, proxyName)
+	if len(r.Matcher.Cflows(pointcut)) == 0 {
+		return aspExpr
+	}
+	return &ast.CallExpr{
+		Fun: &ast.SelectorExpr{
+			X:   ast.NewIdent("aspectrt"),
+			Sel: ast.NewIdent("Guard"),
+		},
+		Args: []ast.Expr{
+			ast.NewIdent("_ag_cflow"),
+			&ast.FuncLit{
+				Type: &ast.FuncType{
+					Params: &ast.FieldList{},
+					Results: &ast.FieldList{
+						List: []*ast.Field{{Type: &ast.InterfaceType{Methods: &ast.FieldList{}}}}},
+				},
+				Body: &ast.BlockStmt{
+					List: []ast.Stmt{&ast.ReturnStmt{Results: []ast.Expr{aspExpr}}}}}}}
+}
+
+// _proxy_body_cflow generates the cflow stmts like this:
+//
+// _ag_cflow := aspectrt.InCflow("main\\.handle.*")
+// defer aspectrt.PushCflow(_ag_jp_ag_proxy_0_0, "database/sql\\.Open")()
+//
+// _ag_cflow is evaluated before pushing the joinpoint, so that "cflowbelow"
+// does not match the joinpoint itself.
+// "cflow" matches the joinpoint itself if it is statically marked.
+func (r *rewriter) _proxy_body_cflow(node ast.Node, proxyName string, asp *types.Named, pointcut aspect.Pointcut) []ast.Stmt {
+	var stmts []ast.Stmt
+	marks := r.CflowMarks[callIdent(node)]
+	if cflows := r.Matcher.Cflows(pointcut); asp != nil && len(cflows) != 0 {
+		var cond ast.Expr
+		for _, c := range cflows {
+			if !c.Below && containsPointcut(marks, c.Pointcut) {
+				continue
+			}
+			term := &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   ast.NewIdent("aspectrt"),
+					Sel: ast.NewIdent("InCflow"),
+				},
+				Args: []ast.Expr{&ast.BasicLit{Kind: token.STRING, Value: fmt.Sprintf("%q", c.Pointcut)}}}
+			if cond == nil {
+				cond = term
+			} else {
+				cond = &ast.BinaryExpr{X: cond, Op: token.LAND, Y: term}
+			}
+		}
+		if cond == nil {
+			cond = ast.NewIdent("true")
+		}
+		stmts = append(stmts, &ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("_ag_cflow")},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{cond}})
+	}
+	if len(marks) != 0 {
+		args := []ast.Expr{ast.NewIdent(fmt.Sprintf("_ag_jp%s", proxyName))}
+		for _, mark := range marks {
+			args = append(args, &ast.BasicLit{Kind: token.STRING, Value: fmt.Sprintf("%q", mark)})
+		}
+		stmts = append(stmts, &ast.DeferStmt{
+			Call: &ast.CallExpr{
+				Fun: &ast.CallExpr{
+					Fun: &ast.SelectorExpr{
+						X:   ast.NewIdent("aspectrt"),
+						Sel: ast.NewIdent("PushCflow"),
+					},
+					Args: args}}})
+	}
+	return stmts
+}
+
 // _proxy_body generates _ag_proxy_func body like this:
 //
 // _ag_res := (&dummyAspect{}).Advice(
 // 	&ContextImpl{
 // 		XArgs: []interface{}{"world"},
 // 		XFunc: func(_ag_args []interface{}) []interface{} {
-// 			_ag_arg0 := _ag_args[0].(string)
+// 			_ag_arg0, _ := _ag_args[0].(string)
 // 			sayHello(_ag_arg0)
 // 			_ag_res := []interface{}{}
 // 			return _ag_res
 // 		}})
+// aspectrt.CheckResults(_ag_jp_ag_proxy_0_0, _ag_res)
 // _ = _ag_res
 // return
-func (r *rewriter) _proxy_body(node ast.Node, matched types.Object, asp *types.Named) *ast.BlockStmt {
-	var stmts []ast.Stmt
+func (r *rewriter) _proxy_body(node ast.Node, matched types.Object, proxyName string, asp *types.Named, pointcut aspect.Pointcut) *ast.BlockStmt {
+	stmts := r._proxy_body_cflow(node, proxyName, asp, pointcut)
 	stmts = append(stmts,
 		&ast.AssignStmt{
 			Lhs: []ast.Expr{ast.NewIdent("_ag_res")},
 			Tok: token.DEFINE,
-			Rhs: []ast.Expr{r._proxy_body_callExpr(node, matched, asp)}})
+			Rhs: []ast.Expr{r._proxy_body_callExpr(node, matched, proxyName, asp, pointcut)}})
 
 	sig := matched.Type().(*types.Signature)
 	var resAssignStmts []ast.Stmt
@@ -362,6 +874,7 @@ func (r *rewriter) _proxy_body(node ast.Node, matched types.Object, asp *types.N
 	}
 
 	stmts = append(stmts,
+		checkResultsStmt(proxyName),
 		&ast.AssignStmt{
 			Lhs: []ast.Expr{ast.NewIdent("_")},
 			Tok: token.ASSIGN,
@@ -372,9 +885,21 @@ func (r *rewriter) _proxy_body(node ast.Node, matched types.Object, asp *types.N
 	return res
 }
 
-func (r *rewriter) _proxy(node ast.Node, matched types.Object, proxyName string, asp *types.Named) *ast.FuncDecl {
+// checkResultsStmt generates like this:
+// `aspectrt.CheckResults(_ag_jp_ag_proxy_0_0, _ag_res)`
+func checkResultsStmt(proxyName string) ast.Stmt {
+	return &ast.ExprStmt{
+		X: &ast.CallExpr{
+			Fun: &ast.SelectorExpr{
+				X:   ast.NewIdent("aspectrt"),
+				Sel: ast.NewIdent("CheckResults"),
+			},
+			Args: idents(fmt.Sprintf("_ag_jp%s", proxyName), "_ag_res")}}
+}
+
+func (r *rewriter) _proxy(node ast.Node, matched types.Object, proxyName string, asp *types.Named, pointcut aspect.Pointcut) *ast.FuncDecl {
 	funcDecl := r._proxy_decl(node, matched, proxyName)
-	funcDecl.Body = r._proxy_body(node, matched, asp)
+	funcDecl.Body = r._proxy_body(node, matched, proxyName, asp, pointcut)
 	return funcDecl
 }
 
@@ -504,14 +1029,14 @@ func (r *rewriter) _pgen_body(matched types.Object, pdecl *ast.FuncDecl) *ast.Bl
 // pgen is like this:
 //
 // var f func(int)
-// f := (_ag_pgen_ag_proxy_0(i)) // orig: f := i.Foo
+// f := (_ag_pgen_ag_proxy_0_0(i)) // orig: f := i.Foo
 // f(42)
 //
-// func _ag_pgen_ag_proxy_0(i I) func(int) {
-// 	return func(x int){_ag_proxy_0(i, x)}
+// func _ag_pgen_ag_proxy_0_0(i I) func(int) {
+// 	return func(x int){_ag_proxy_0_0(i, x)}
 // }
 // ​
-// func _ag_proxy_0(i I, x int) {
+// func _ag_proxy_0_0(i I, x int) {
 //   ..
 // }
 func (r *rewriter) _pgen(matched types.Object, pdecl *ast.FuncDecl, pgenName string) *ast.FuncDecl {
@@ -531,19 +1056,18 @@ func (r *rewriter) _proxy_fix_up(node ast.Node, matched types.Object, pgenName s
 		if !ok {
 			log.Fatalf("impl error: node=%s, recv=%s", util.ASTDebugString(node), recv)
 		}
-		typesInfo := r.Program.AllPackages[r.currentPkg]
+		typesInfo := r.Program.AllPackages[r.pkg]
 		xTypeInfo := typesInfo.Types[xs.X.(ast.Expr)]
 		_, xIsPointer := xTypeInfo.Type.Underlying().(*types.Pointer)
 
 		// FIXME FIXME FIXME: copy xs.X
-		x := xs.X
 		var arg ast.Expr
 		if recvIsPointer && !xIsPointer {
 			arg = &ast.UnaryExpr{
 				Op: token.AND,
-				X:  x}
+				X:  r.rewriteAddressed(xs.X)}
 		} else {
-			arg = x
+			arg = rewrite.Rewrite(r, xs.X).(ast.Expr)
 		}
 		args = append(args, arg)
 	}
@@ -565,15 +1089,7 @@ func (r *rewriter) _proxy_fix_up(node ast.Node, matched types.Object, pgenName s
 //   Step 2: calls _pgen for generating _ag_pgen_ag_proxy_N addendum
 //   Step 3: calls _proxy_fix_up for generating the new node
 func (r *rewriter) proxy(node ast.Node, pointcut aspect.Pointcut) ast.Expr {
-	var id *ast.Ident
-	switch n := node.(type) {
-	case *ast.Ident:
-		id = n
-	case *ast.SelectorExpr:
-		id = n.Sel
-	default:
-		log.Fatalf("impl error: %s is unexpected type: %s", util.ASTDebugString(n))
-	}
+	id := callIdent(node)
 	// alreadyGen, ok := r.proxyExprs[id]
 	// if ok {
 	// 	return alreadyGen
@@ -584,15 +1100,14 @@ func (r *rewriter) proxy(node ast.Node, pointcut aspect.Pointcut) ast.Expr {
 		log.Fatalf("impl error: obj not found for id %s", id)
 	}
 	asp, ok := r.Aspects[pointcut]
-	if !ok {
+	if !ok && len(r.CflowMarks[id]) == 0 {
 		log.Fatalf("impl error: asp %s not found for pointcut %s", asp, pointcut)
 	}
 
-	proxyName := fmt.Sprintf("_ag_proxy_%d", gRewriterLastP)
+	proxyName := r.newProxyName()
 	pgenName := fmt.Sprintf("_ag_pgen%s", proxyName)
-	gRewriterLastP++
 
-	proxyAst := r._proxy(node, matched, proxyName, asp)
+	proxyAst := r._proxy(node, matched, proxyName, asp, pointcut)
 	r.fileAddendum = append(r.fileAddendum, proxyAst)
 
 	pgenAst := r._pgen(matched, proxyAst, pgenName)
@@ -607,31 +1122,12 @@ func (r *rewriter) Rewrite(node ast.Node) (ast.Node, rewrite.Rewriter) {
 	switch n := node.(type) {
 	case *ast.File:
 		r.fileAddendum = make([]ast.Node, 0)
-		newImports := []*ast.ImportSpec{
-			&ast.ImportSpec{
-				Name: ast.NewIdent("aspectrt"),
-				Path: &ast.BasicLit{
-					Kind:  token.STRING,
-					Value: "\"" + consts.AspectGoPackagePath + "/aspect/rt\"",
-				}},
-			&ast.ImportSpec{
-				Path: &ast.BasicLit{
-					Kind:  token.STRING,
-					Value: "\"agaspect\"",
-				}},
-		}
+		// NOTE: the imports are added by addImports() after rewriting
 		newFile := &ast.File{}
 		newFile.Name = ast.NewIdent(n.Name.Name)
-		newFile.Decls = append([]ast.Decl{
-			&ast.GenDecl{
-				Tok:   token.IMPORT,
-				Specs: []ast.Spec{newImports[0]}},
-			&ast.GenDecl{
-				Tok:   token.IMPORT,
-				Specs: []ast.Spec{newImports[1]}},
-		}, n.Decls...)
+		newFile.Decls = append([]ast.Decl{}, n.Decls...)
 		newFile.Scope = n.Scope
-		newFile.Imports = append(newImports, n.Imports...)
+		newFile.Imports = n.Imports
 		newFile.Unresolved = n.Unresolved
 		return newFile, r
 	case *ast.Ident:
@@ -642,25 +1138,156 @@ func (r *rewriter) Rewrite(node ast.Node) (ast.Node, rewrite.Rewriter) {
 		newExpr := r.proxy(n, pointcut)
 		return newExpr, nil
 	case *ast.SelectorExpr:
-		pointcut, ok := r.PointcutsByIdent[n.Sel]
-		if !ok {
+		if pointcut, ok := r.PointcutsByIdent[n.Sel]; ok {
+			newExpr := r.proxy(n, pointcut)
+			return newExpr, nil
+		}
+		if pointcut, ok := r.fieldPointcut(n, aspect.KindGet); ok {
+			newExpr := r.fieldGetProxy(n, rewrite.Rewrite(r, n.X).(ast.Expr), pointcut)
+			return newExpr, nil
+		}
+		if r.isAddressedMethodValue(n) {
+			n.X = r.rewriteAddressed(n.X)
+			return n, nil
+		}
+	case *ast.TypeSpec:
+		r.introduceFieldsToTypeSpec(n)
+	case *ast.AssignStmt:
+		if n.Tok == token.DEFINE {
 			goto nop
 		}
-		newExpr := r.proxy(n, pointcut)
-		return newExpr, nil
+		return r.rewriteAssignStmt(n), nil
+	case *ast.IncDecStmt:
+		return r.rewriteIncDecStmt(n), nil
+	case *ast.RangeStmt:
+		if n.Tok != token.ASSIGN {
+			goto nop
+		}
+		return r.rewriteRangeStmt(n), nil
+	case *ast.UnaryExpr:
+		switch n.Op {
+		case token.AND:
+			return r.rewriteAddressOf(n), nil
+		case token.ARROW:
+			if m, ok := r.Stmts[n]; ok {
+				return r.recvProxy(n, m), nil
+			}
+		}
+	case *ast.GoStmt:
+		if m, ok := r.Stmts[n]; ok {
+			return r.goProxy(n, m), nil
+		}
+	case *ast.SendStmt:
+		if m, ok := r.Stmts[n]; ok {
+			return r.sendProxy(n, m), nil
+		}
+	case *ast.SelectStmt:
+		if m, ok := r.Stmts[n]; ok {
+			return r.selectProxy(n, m), nil
+		}
+	case *ast.CallExpr:
+		if m, ok := r.Stmts[n]; ok {
+			return r.closeProxy(n, m), nil
+		}
+	case *ast.CommClause:
+		return r.rewriteCommClause(n), nil
+	case *ast.SliceExpr:
+		if !r.isArray(n.X) {
+			goto nop
+		}
+		n.X = r.rewriteAddressed(n.X)
+		r.rewriteSliceIndices(n)
+		return n, nil
 	}
 nop:
 	return node, r
 }
 
+// fileNeedsRewrite returns true if the file contains the join points,
+// or the types that the fields are introduced to.
+func (r *rewriter) fileNeedsRewrite(pkgInfo *loader.PackageInfo, file *ast.File) bool {
+	found := false
+	ast.Inspect(file, func(node ast.Node) bool {
+		if found {
+			return false
+		}
+		switch n := node.(type) {
+		case *ast.Ident:
+			_, found = r.PointcutsByIdent[n]
+		case *ast.SelectorExpr:
+			_, found = r.FieldPointcuts[n]
+		case *ast.TypeSpec:
+			if tObj, ok := pkgInfo.Defs[n.Name].(*types.TypeName); ok {
+				for _, in := range r.Introductions[tObj] {
+					found = found || in.Fields
+				}
+			}
+		}
+		if !found && node != nil {
+			_, found = r.Stmts[node]
+		}
+		return !found
+	})
+	return found
+}
+
+// addImports adds the imports of the AspectGo runtime and the aspects to the rewritten file,
+// only if they are used in the file or the addendum.
+func (r *rewriter) addImports(file *ast.File) *ast.File {
+	nodes := append([]ast.Node{file}, r.fileAddendum...)
+	var newImports []*ast.ImportSpec
+	for _, imp := range []struct {
+		name, path string
+	}{
+		{"aspectrt", consts.AspectGoPackagePath + "/aspect/rt"},
+		{aspectPkgName, r.AspectFile.ImportPath},
+	} {
+		if !usesPackageName(nodes, imp.name) {
+			continue
+		}
+		newImports = append(newImports, &ast.ImportSpec{
+			Name: ast.NewIdent(imp.name),
+			Path: &ast.BasicLit{
+				Kind:  token.STRING,
+				Value: fmt.Sprintf("%q", imp.path),
+			}})
+	}
+	var importDecls []ast.Decl
+	for _, imp := range newImports {
+		importDecls = append(importDecls, &ast.GenDecl{
+			Tok:   token.IMPORT,
+			Specs: []ast.Spec{imp}})
+	}
+	file.Decls = append(importDecls, file.Decls...)
+	file.Imports = append(newImports, file.Imports...)
+	return file
+}
+
+// usesPackageName returns true if any of the nodes contains a selector expression
+// qualified with the package name.
+func usesPackageName(nodes []ast.Node, name string) bool {
+	used := false
+	for _, node := range nodes {
+		ast.Inspect(node, func(n ast.Node) bool {
+			if se, ok := n.(*ast.SelectorExpr); ok {
+				if x, ok := se.X.(*ast.Ident); ok && x.Name == name {
+					used = true
+				}
+			}
+			return !used
+		})
+	}
+	return used
+}
+
 func (r *rewriter) AddendumForASTFile() []ast.Node {
 	return r.fileAddendum
 }
 
 func (r *rewriter) typeString(typ types.Type) string {
 	s, err := util.LocalTypeString(typ,
-		r.currentPkg,
-		r.currentFile.Imports)
+		r.pkg,
+		r.file.Imports)
 	if err != nil {
 		log.Fatal(err)
 	}
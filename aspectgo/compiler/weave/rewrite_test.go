@@ -0,0 +1,101 @@
+package weave
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"testing"
+
+	"golang.org/x/tools/go/loader"
+
+	"golang.org/x/exp/aspectgo/aspect"
+)
+
+func TestFileNeedsRewrite(t *testing.T) {
+	src := `package foo
+
+func foo() {
+	bar()
+	baz()
+}
+
+func bar() {}
+
+func baz() {}
+`
+	file, err := parser.ParseFile(token.NewFileSet(), "foo.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkgInfo := &loader.PackageInfo{}
+	r := &rewriter{PointcutsByIdent: make(map[*ast.Ident]aspect.Pointcut)}
+	if r.fileNeedsRewrite(pkgInfo, file) {
+		t.Fatal("no join points")
+	}
+	// the join point followed by the other nodes
+	call := file.Decls[0].(*ast.FuncDecl).Body.List[0].(*ast.ExprStmt).X.(*ast.CallExpr)
+	r.PointcutsByIdent[call.Fun.(*ast.Ident)] = aspect.NewCallPointcutFromRegexp(`foo\.bar`)
+	if !r.fileNeedsRewrite(pkgInfo, file) {
+		t.Fatal("the join point is not found")
+	}
+}
+
+func TestUsesPackageName(t *testing.T) {
+	src := `package foo
+
+func foo() {
+	aspectrt.PushCflow(nil, "")
+	var _ag_aspects int
+	_ = _ag_aspects
+}
+`
+	file, err := parser.ParseFile(token.NewFileSet(), "foo.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := []ast.Node{file}
+	if !usesPackageName(nodes, "aspectrt") {
+		t.Fatal("aspectrt is used")
+	}
+	if usesPackageName(nodes, aspectPkgName) {
+		t.Fatalf("%s is not used as a package name", aspectPkgName)
+	}
+}
+
+func TestEnclosingFuncName(t *testing.T) {
+	src := `package foo
+
+var x = bar()
+
+func (f *T) foo() {
+	bar()
+}
+
+func bar() int { return 0 }
+
+type T struct{}
+`
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "foo.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkgInfo := &loader.PackageInfo{Info: types.Info{Defs: make(map[*ast.Ident]types.Object)}}
+	pkgInfo.Pkg, err = (&types.Config{}).Check("example.com/foo", fset, []*ast.File{file}, &pkgInfo.Info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls []*ast.CallExpr
+	ast.Inspect(file, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok {
+			calls = append(calls, call)
+		}
+		return true
+	})
+	for i, expected := range []string{"example.com/foo.init", "(*example.com/foo.T).foo"} {
+		if name := enclosingFuncName(pkgInfo, file, calls[i].Pos()); name != expected {
+			t.Errorf("expected %q, got %q", expected, name)
+		}
+	}
+}
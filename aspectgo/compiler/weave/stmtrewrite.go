@@ -0,0 +1,496 @@
+package weave
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"log"
+
+	rewrite "github.com/tsuna/gorewrite"
+
+	"golang.org/x/exp/aspectgo/aspect"
+)
+
+// statement rewriting.
+//
+// `go f(x)` is rewritten to `_ag_proxy_N(func() func() { _ag_f, _ag_arg0 := f, x; return func() { _ag_f(_ag_arg0) } }())`.
+// `ch <- v` is rewritten to `_ag_proxy_N(ch, v)`.
+// `<-ch` is rewritten to `_ag_proxy_N(ch)`.
+// `close(ch)` is rewritten to `_ag_proxy_N(ch)`.
+// `select { .. }` is rewritten to `switch _ag_sel := _ag_proxy_N(cases); _ag_sel.Chosen { .. }`.
+
+func argsExpr(name string, i int) ast.Expr {
+	return &ast.IndexExpr{
+		X: ast.NewIdent(name),
+		Index: &ast.BasicLit{
+			Kind:  token.INT,
+			Value: fmt.Sprintf("%d", i),
+		}}
+}
+
+// assertStmt generates `lhs, _ := x.(typ)`.
+func assertStmt(lhs string, x ast.Expr, typ ast.Expr) ast.Stmt {
+	return &ast.AssignStmt{
+		Lhs: []ast.Expr{ast.NewIdent(lhs), ast.NewIdent("_")},
+		Tok: token.DEFINE,
+		Rhs: []ast.Expr{
+			&ast.TypeAssertExpr{X: x, Type: typ}}}
+}
+
+func idents(names ...string) []ast.Expr {
+	var exprs []ast.Expr
+	for _, name := range names {
+		exprs = append(exprs, ast.NewIdent(name))
+	}
+	return exprs
+}
+
+func (r *rewriter) stmtPointcutAspect(m *stmtMatch) *types.Named {
+	asp, ok := r.Aspects[m.Pointcut]
+	if !ok {
+		log.Fatalf("impl error: asp not found for pointcut %s", m.Pointcut)
+	}
+	return asp
+}
+
+// stmtProxy describes the proxy for the statement.
+type stmtProxy struct {
+	// Params and Results are the params and the results of the proxy.
+	Params, Results []*ast.Field
+	// XArgs is the elements of XArgs.
+	XArgs []ast.Expr
+	// XFuncBody is the body of XFunc.
+	XFuncBody []ast.Stmt
+	// XReceiver is XReceiver. (can be nil)
+	XReceiver ast.Expr
+	// Tail is the statements after the advice call.
+	// The result of the advice is available as _ag_res.
+	Tail []ast.Stmt
+	// Sig is the signature of the joinpoint, for checking the args and the results.
+	Sig *signature
+}
+
+// _stmt_proxy generates the proxy for the statement like this:
+//
+// func _ag_proxy_0_0(_ag_ch chan int, _ag_v int) {
+// 	_ag_res := (&_ag_aspects.ExampleAspect{}).Advice(
+// 		&aspectrt.ContextImpl{
+// 			XArgs: []interface{}{_ag_v},
+// 			XFunc: func(_ag_args []interface{}) []interface{} {
+// 				_ag_arg0, _ := _ag_args[0].(int)
+// 				_ag_ch <- _ag_arg0
+// 				return []interface{}{}
+// 			},
+// 			XReceiver:  _ag_ch,
+// 			XJoinPoint: _ag_jp_ag_proxy_0_0,
+// 		})
+// 	aspectrt.CheckResults(_ag_jp_ag_proxy_0_0, _ag_res)
+// 	_ = _ag_res
+// }
+func (r *rewriter) _stmt_proxy(node ast.Node, m *stmtMatch, numResults int, proxyName string, sp *stmtProxy) *ast.FuncDecl {
+	jp := &aspect.JoinPoint{
+		Kind:       m.Kind,
+		Name:       m.Name,
+		ShortName:  m.ShortName,
+		Pos:        r.joinPointPos(node.Pos()),
+		Caller:     m.Name,
+		NumResults: numResults,
+	}
+	elts := []ast.Expr{
+		&ast.KeyValueExpr{
+			Key: ast.NewIdent("XArgs"),
+			Value: &ast.CompositeLit{
+				Type: voidIntfArrayExpr(),
+				Elts: sp.XArgs}},
+		&ast.KeyValueExpr{
+			Key: ast.NewIdent("XFunc"),
+			Value: &ast.FuncLit{
+				Type: &ast.FuncType{
+					Params: &ast.FieldList{
+						List: []*ast.Field{
+							&ast.Field{
+								Names: []*ast.Ident{ast.NewIdent("_ag_args")},
+								Type:  voidIntfArrayExpr()}}},
+					Results: &ast.FieldList{
+						List: []*ast.Field{
+							&ast.Field{
+								Type: voidIntfArrayExpr()}}}},
+				Body: &ast.BlockStmt{List: sp.XFuncBody}}},
+	}
+	if sp.XReceiver != nil {
+		elts = append(elts,
+			&ast.KeyValueExpr{
+				Key:   ast.NewIdent("XReceiver"),
+				Value: sp.XReceiver})
+	}
+	elts = append(elts,
+		&ast.KeyValueExpr{
+			Key:   ast.NewIdent("XJoinPoint"),
+			Value: r._proxy_body_XJoinPoint(jp, proxyName, sp.Sig)})
+	adviceCall := &ast.CallExpr{
+		Fun: &ast.SelectorExpr{
+			X:   r._aspect_expr(r.stmtPointcutAspect(m), proxyName),
+			Sel: ast.NewIdent("Advice")},
+		Args: []ast.Expr{
+			&ast.UnaryExpr{
+				Op: token.AND,
+				X: &ast.CompositeLit{
+					Type: &ast.SelectorExpr{
+						X:   ast.NewIdent("aspectrt"),
+						Sel: ast.NewIdent("ContextImpl"),
+					},
+					Elts: elts}}}}
+	tail := sp.Tail
+	if tail == nil {
+		tail = []ast.Stmt{
+			&ast.AssignStmt{
+				Lhs: idents("_"),
+				Tok: token.ASSIGN,
+				Rhs: idents("_ag_res")}}
+	}
+	stmts := append([]ast.Stmt{
+		&ast.AssignStmt{
+			Lhs: idents("_ag_res"),
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{adviceCall}},
+		checkResultsStmt(proxyName)}, tail...)
+	return &ast.FuncDecl{
+		Name: ast.NewIdent(proxyName),
+		Type: &ast.FuncType{
+			Params:  &ast.FieldList{List: sp.Params},
+			Results: &ast.FieldList{List: sp.Results}},
+		Body: &ast.BlockStmt{List: stmts}}
+}
+
+func (r *rewriter) addStmtProxy(node ast.Node, m *stmtMatch, numResults int, sp *stmtProxy) string {
+	proxyName := r.newProxyName()
+	r.fileAddendum = append(r.fileAddendum,
+		r._stmt_proxy(node, m, numResults, proxyName, sp))
+	return proxyName
+}
+
+func (r *rewriter) chanParam(ch ast.Expr) (*ast.Field, ast.Expr) {
+	elemType := r.chanElemType(ch)
+	return &ast.Field{
+			Names: []*ast.Ident{ast.NewIdent("_ag_ch")},
+			Type:  &ast.ParenExpr{X: ast.NewIdent(r.typeString(r.typeOf(ch)))}},
+		&ast.ParenExpr{X: ast.NewIdent(r.typeString(elemType))}
+}
+
+func (r *rewriter) chanElemType(ch ast.Expr) types.Type {
+	return r.typeOf(ch).Underlying().(*types.Chan).Elem()
+}
+
+// goProxy rewrites `go f(x)`.
+// f and x are evaluated in the current goroutine, as in the original statement.
+func (r *rewriter) goProxy(n *ast.GoStmt, m *stmtMatch) ast.Stmt {
+	info := r.typesInfo()
+	var lhs, rhs, innerArgs []ast.Expr
+	fun := n.Call.Fun
+	if id, ok := unparen(fun).(*ast.Ident); ok && isBuiltin(info, id, id.Name) {
+		fun = rewrite.Rewrite(r, fun).(ast.Expr)
+	} else {
+		lhs = append(lhs, ast.NewIdent("_ag_f"))
+		rhs = append(rhs, rewrite.Rewrite(r, fun).(ast.Expr))
+		fun = ast.NewIdent("_ag_f")
+	}
+	for i, arg := range n.Call.Args {
+		tv := info.Types[arg]
+		if tv.Value != nil || tv.IsNil() || isUntyped(tv.Type) {
+			// evaluating constants lazily is harmless, and binding them
+			// to variables would change their types
+			innerArgs = append(innerArgs, rewrite.Rewrite(r, arg).(ast.Expr))
+			continue
+		}
+		name := fmt.Sprintf("_ag_arg%d", i)
+		lhs = append(lhs, ast.NewIdent(name))
+		rhs = append(rhs, rewrite.Rewrite(r, arg).(ast.Expr))
+		innerArgs = append(innerArgs, ast.NewIdent(name))
+	}
+	var bodyStmts []ast.Stmt
+	if len(lhs) > 0 {
+		bodyStmts = append(bodyStmts,
+			&ast.AssignStmt{Lhs: lhs, Tok: token.DEFINE, Rhs: rhs})
+	}
+	funcType := func() *ast.FuncType {
+		return &ast.FuncType{Params: &ast.FieldList{}}
+	}
+	bodyStmts = append(bodyStmts,
+		&ast.ReturnStmt{
+			Results: []ast.Expr{
+				&ast.FuncLit{
+					Type: funcType(),
+					Body: &ast.BlockStmt{
+						List: []ast.Stmt{
+							&ast.ExprStmt{
+								X: &ast.CallExpr{
+									Fun:      fun,
+									Args:     innerArgs,
+									Ellipsis: n.Call.Ellipsis}}}}}}})
+	fnExpr := &ast.CallExpr{
+		Fun: &ast.FuncLit{
+			Type: &ast.FuncType{
+				Params: &ast.FieldList{},
+				Results: &ast.FieldList{
+					List: []*ast.Field{&ast.Field{Type: funcType()}}}},
+			Body: &ast.BlockStmt{List: bodyStmts}}}
+
+	proxyName := r.addStmtProxy(n, m, 0, &stmtProxy{
+		Params: []*ast.Field{
+			&ast.Field{
+				Names: []*ast.Ident{ast.NewIdent("_ag_fn")},
+				Type:  funcType()}},
+		XArgs: idents("_ag_fn"),
+		XFuncBody: []ast.Stmt{
+			assertStmt("_ag_arg0", argsExpr("_ag_args", 0),
+				&ast.ParenExpr{X: funcType()}),
+			&ast.GoStmt{
+				Call: &ast.CallExpr{Fun: ast.NewIdent("_ag_arg0")}},
+			&ast.ReturnStmt{
+				Results: []ast.Expr{
+					&ast.CompositeLit{Type: voidIntfArrayExpr()}}}},
+		Sig: &signature{
+			ParamNames: []string{"fn"},
+			Params:     []types.Type{types.NewSignatureType(nil, nil, nil, nil, nil, false)}},
+	})
+	return &ast.ExprStmt{
+		X: &ast.CallExpr{
+			Fun:  ast.NewIdent(proxyName),
+			Args: []ast.Expr{fnExpr}}}
+}
+
+func isUntyped(typ types.Type) bool {
+	b, ok := typ.(*types.Basic)
+	return ok && b.Info()&types.IsUntyped != 0
+}
+
+// sendProxy rewrites `ch <- v`.
+func (r *rewriter) sendProxy(n *ast.SendStmt, m *stmtMatch) ast.Stmt {
+	chParam, elemType := r.chanParam(n.Chan)
+	proxyName := r.addStmtProxy(n, m, 0, &stmtProxy{
+		Params: []*ast.Field{
+			chParam,
+			&ast.Field{
+				Names: []*ast.Ident{ast.NewIdent("_ag_v")},
+				Type:  elemType}},
+		XArgs: idents("_ag_v"),
+		XFuncBody: []ast.Stmt{
+			assertStmt("_ag_arg0", argsExpr("_ag_args", 0), elemType),
+			&ast.SendStmt{
+				Chan:  ast.NewIdent("_ag_ch"),
+				Value: ast.NewIdent("_ag_arg0")},
+			&ast.ReturnStmt{
+				Results: []ast.Expr{
+					&ast.CompositeLit{Type: voidIntfArrayExpr()}}}},
+		XReceiver: ast.NewIdent("_ag_ch"),
+		Sig: &signature{
+			ParamNames: []string{"value"},
+			Params:     []types.Type{r.chanElemType(n.Chan)}},
+	})
+	return &ast.ExprStmt{
+		X: &ast.CallExpr{
+			Fun: ast.NewIdent(proxyName),
+			Args: []ast.Expr{
+				rewrite.Rewrite(r, n.Chan).(ast.Expr),
+				rewrite.Rewrite(r, n.Value).(ast.Expr)}}}
+}
+
+// recvProxy rewrites `<-ch`.
+// For `v, ok := <-ch`, the proxy returns two values.
+func (r *rewriter) recvProxy(n *ast.UnaryExpr, m *stmtMatch) ast.Expr {
+	chParam, elemType := r.chanParam(n.X)
+	_, commaOk := r.typeOf(n).(*types.Tuple)
+	resNames := []string{"_ag_res0"}
+	resTypes := []ast.Expr{elemType}
+	sig := &signature{
+		ResultNames: []string{"value"},
+		Results:     []types.Type{r.chanElemType(n.X)}}
+	if commaOk {
+		resNames = append(resNames, "_ag_res1")
+		resTypes = append(resTypes, ast.NewIdent("bool"))
+		sig.ResultNames = append(sig.ResultNames, "ok")
+		sig.Results = append(sig.Results, types.Typ[types.Bool])
+	}
+	var results []*ast.Field
+	var tail []ast.Stmt
+	for i := range resNames {
+		results = append(results, &ast.Field{Type: resTypes[i]})
+		tail = append(tail,
+			assertStmt(resNames[i], argsExpr("_ag_res", i), resTypes[i]))
+	}
+	tail = append(tail, &ast.ReturnStmt{Results: idents(resNames...)})
+	proxyName := r.addStmtProxy(n, m, len(resNames), &stmtProxy{
+		Params:  []*ast.Field{chParam},
+		Results: results,
+		XArgs:   []ast.Expr{},
+		XFuncBody: []ast.Stmt{
+			&ast.AssignStmt{
+				Lhs: idents(resNames...),
+				Tok: token.DEFINE,
+				Rhs: []ast.Expr{
+					&ast.UnaryExpr{
+						Op: token.ARROW,
+						X:  ast.NewIdent("_ag_ch")}}},
+			&ast.ReturnStmt{
+				Results: []ast.Expr{
+					&ast.CompositeLit{
+						Type: voidIntfArrayExpr(),
+						Elts: idents(resNames...)}}}},
+		XReceiver: ast.NewIdent("_ag_ch"),
+		Tail:      tail,
+		Sig:       sig,
+	})
+	return &ast.CallExpr{
+		Fun:  ast.NewIdent(proxyName),
+		Args: []ast.Expr{rewrite.Rewrite(r, n.X).(ast.Expr)}}
+}
+
+// closeProxy rewrites `close(ch)`.
+func (r *rewriter) closeProxy(n *ast.CallExpr, m *stmtMatch) ast.Expr {
+	chParam, _ := r.chanParam(n.Args[0])
+	proxyName := r.addStmtProxy(n, m, 0, &stmtProxy{
+		Params: []*ast.Field{chParam},
+		XArgs:  []ast.Expr{},
+		XFuncBody: []ast.Stmt{
+			&ast.ExprStmt{
+				X: &ast.CallExpr{
+					Fun:  ast.NewIdent("close"),
+					Args: idents("_ag_ch")}},
+			&ast.ReturnStmt{
+				Results: []ast.Expr{
+					&ast.CompositeLit{Type: voidIntfArrayExpr()}}}},
+		XReceiver: ast.NewIdent("_ag_ch"),
+		Sig:       &signature{},
+	})
+	return &ast.CallExpr{
+		Fun:  ast.NewIdent(proxyName),
+		Args: []ast.Expr{rewrite.Rewrite(r, n.Args[0]).(ast.Expr)}}
+}
+
+// selectProxy rewrites `select { .. }` like this:
+//
+// switch _ag_sel := _ag_proxy_0_0([]aspectrt.SelectCase{
+// 	aspectrt.SelectRecv(ch1),
+// 	aspectrt.SelectSend(ch2, (int)(x)),
+// 	aspectrt.SelectDefault(),
+// }); _ag_sel.Chosen {
+// case 0:
+// 	_ag_recv, _ := _ag_sel.Recv.(int)
+// 	v := _ag_recv
+// 	ok := _ag_sel.RecvOK
+// 	..
+// case 1:
+// 	..
+// case 2:
+// 	..
+// }
+//
+// The cases are executed with reflect.Select.
+func (r *rewriter) selectProxy(n *ast.SelectStmt, m *stmtMatch) ast.Stmt {
+	sel := func(name string) ast.Expr {
+		return &ast.SelectorExpr{
+			X:   ast.NewIdent("_ag_sel"),
+			Sel: ast.NewIdent(name)}
+	}
+	rt := func(name string, args ...ast.Expr) ast.Expr {
+		return &ast.CallExpr{
+			Fun: &ast.SelectorExpr{
+				X:   ast.NewIdent("aspectrt"),
+				Sel: ast.NewIdent(name)},
+			Args: args}
+	}
+	var cases []ast.Expr
+	var clauses []ast.Stmt
+	for i, stmt := range n.Body.List {
+		cc := stmt.(*ast.CommClause)
+		var bindings []ast.Stmt
+		switch comm := cc.Comm.(type) {
+		case nil:
+			cases = append(cases, rt("SelectDefault"))
+		case *ast.SendStmt:
+			_, elemType := r.chanParam(comm.Chan)
+			cases = append(cases, rt("SelectSend",
+				rewrite.Rewrite(r, comm.Chan).(ast.Expr),
+				&ast.CallExpr{
+					Fun:  elemType,
+					Args: []ast.Expr{rewrite.Rewrite(r, comm.Value).(ast.Expr)}}))
+		case *ast.ExprStmt:
+			u := unparen(comm.X).(*ast.UnaryExpr)
+			cases = append(cases, rt("SelectRecv",
+				rewrite.Rewrite(r, u.X).(ast.Expr)))
+		case *ast.AssignStmt:
+			u := unparen(comm.Rhs[0]).(*ast.UnaryExpr)
+			_, elemType := r.chanParam(u.X)
+			cases = append(cases, rt("SelectRecv",
+				rewrite.Rewrite(r, u.X).(ast.Expr)))
+			values := []ast.Expr{ast.NewIdent("_ag_recv"), sel("RecvOK")}
+			for j, lhs := range comm.Lhs {
+				if id, ok := lhs.(*ast.Ident); ok && id.Name == "_" {
+					continue
+				}
+				if j == 0 {
+					bindings = append(bindings,
+						assertStmt("_ag_recv", sel("Recv"), elemType))
+				}
+				bindings = append(bindings,
+					&ast.AssignStmt{
+						Lhs: []ast.Expr{lhs},
+						Tok: comm.Tok,
+						Rhs: []ast.Expr{values[j]}})
+			}
+		default:
+			log.Fatalf("impl error: unexpected comm %T", comm)
+		}
+		var body []ast.Stmt
+		for _, stmt := range append(bindings, cc.Body...) {
+			body = append(body, rewrite.Rewrite(r, stmt).(ast.Stmt))
+		}
+		clauses = append(clauses,
+			&ast.CaseClause{
+				Case: cc.Case,
+				List: []ast.Expr{
+					&ast.BasicLit{Kind: token.INT, Value: fmt.Sprintf("%d", i)}},
+				Colon: cc.Colon,
+				Body:  body})
+	}
+	casesType := &ast.ArrayType{
+		Elt: &ast.SelectorExpr{
+			X:   ast.NewIdent("aspectrt"),
+			Sel: ast.NewIdent("SelectCase")}}
+	selectResultType := &ast.SelectorExpr{
+		X:   ast.NewIdent("aspectrt"),
+		Sel: ast.NewIdent("SelectResult")}
+	proxyName := r.addStmtProxy(n, m, 3, &stmtProxy{
+		Params: []*ast.Field{
+			&ast.Field{
+				Names: []*ast.Ident{ast.NewIdent("_ag_cases")},
+				Type:  casesType}},
+		Results: []*ast.Field{&ast.Field{Type: selectResultType}},
+		XArgs:   []ast.Expr{},
+		XFuncBody: []ast.Stmt{
+			&ast.ReturnStmt{
+				Results: []ast.Expr{rt("Select", ast.NewIdent("_ag_cases"))}}},
+		Tail: []ast.Stmt{
+			&ast.ReturnStmt{
+				Results: []ast.Expr{rt("NewSelectResult", ast.NewIdent("_ag_res"))}}},
+		Sig: &signature{
+			ResultNames: []string{"chosen", "recv", "recvOK"},
+			Results: []types.Type{
+				types.Typ[types.Int], types.NewInterfaceType(nil, nil).Complete(), types.Typ[types.Bool]}},
+	})
+	return &ast.SwitchStmt{
+		Switch: n.Select,
+		Init: &ast.AssignStmt{
+			Lhs: idents("_ag_sel"),
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{
+				&ast.CallExpr{
+					Fun: ast.NewIdent(proxyName),
+					Args: []ast.Expr{
+						&ast.CompositeLit{
+							Type: casesType,
+							Elts: cases}}}}},
+		Tag:  sel("Chosen"),
+		Body: &ast.BlockStmt{List: clauses}}
+}
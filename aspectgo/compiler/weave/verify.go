@@ -0,0 +1,246 @@
+package weave
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/token"
+	"go/types"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/loader"
+
+	"golang.org/x/exp/aspectgo/aspect"
+)
+
+// VerifyError is an error in the woven packages.
+type VerifyError struct {
+	// Err is the error reported by the type checker.
+	Err error
+	// JoinPoint is the join point of the proxy that the error is originated from.
+	// It is nil if the error is not in a proxy nor a call to a proxy.
+	JoinPoint *aspect.JoinPoint
+	// Aspect is the name of the aspect that advises JoinPoint.
+	// It is empty if unknown.
+	Aspect string
+}
+
+func (e *VerifyError) Error() string {
+	if e.JoinPoint == nil {
+		return e.Err.Error()
+	}
+	s := fmt.Sprintf("%s (join point: %s %s at %s", e.Err, e.JoinPoint.Kind, e.JoinPoint.Name, e.JoinPoint.Pos)
+	if e.Aspect != "" {
+		s += ", aspect: " + e.Aspect
+	}
+	return s + ")"
+}
+
+// VerifyErrors is the list of VerifyError.
+type VerifyErrors []*VerifyError
+
+func (errs VerifyErrors) Error() string {
+	var msgs []string
+	for _, e := range errs {
+		msgs = append(msgs, e.Error())
+	}
+	return fmt.Sprintf("the woven packages have %d error(s):\n\t%s", len(errs), strings.Join(msgs, "\n\t"))
+}
+
+// proxyNameRegexp matches the proxy names generated by rewriter.newProxyName().
+// The addenda for a proxy (e.g. _ag_pgen_ag_proxy_0_0, _ag_jp_ag_proxy_0_0)
+// contain the proxy name as well.
+var proxyNameRegexp = regexp.MustCompile(`_ag_proxy_[0-9]+_[0-9]+`)
+
+// Verify loads and type-checks the woven target packages with ctxt,
+// so that the errors in the woven files are reported before building them.
+// The errors are returned as VerifyErrors, and mapped back to the join points
+// and the aspects when they are in the proxies.
+func Verify(ctxt *build.Context, targets []string) error {
+	conf := loader.Config{
+		Build:       ctxt,
+		AllowErrors: true,
+	}
+	// the errors are collected from the package infos below
+	conf.TypeChecker.Error = func(error) {}
+	for _, target := range targets {
+		conf.Import(target)
+	}
+	prog, err := conf.Load()
+	if err != nil {
+		return err
+	}
+	var pkgInfos []*loader.PackageInfo
+	for _, pkgInfo := range prog.AllPackages {
+		if len(pkgInfo.Errors) != 0 {
+			pkgInfos = append(pkgInfos, pkgInfo)
+		}
+	}
+	sort.Slice(pkgInfos, func(i, j int) bool { return pkgInfos[i].Pkg.Path() < pkgInfos[j].Pkg.Path() })
+	var errs VerifyErrors
+	for _, pkgInfo := range pkgInfos {
+		for _, err := range pkgInfo.Errors {
+			ve := &VerifyError{Err: err}
+			if terr, ok := err.(types.Error); ok {
+				if proxyName := enclosingProxy(pkgInfo, terr.Pos); proxyName != "" {
+					ve.JoinPoint = proxyJoinPoint(pkgInfo, proxyName)
+					ve.Aspect = proxyAspect(pkgInfo, proxyName)
+				}
+			}
+			errs = append(errs, ve)
+		}
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+// enclosingProxy returns the name of the proxy whose addendum encloses pos,
+// or the name of the proxy called at pos.
+func enclosingProxy(pkgInfo *loader.PackageInfo, pos token.Pos) string {
+	for _, file := range pkgInfo.Files {
+		if pos < file.Pos() || pos > file.End() {
+			continue
+		}
+		for _, decl := range file.Decls {
+			if pos < decl.Pos() || pos > decl.End() {
+				continue
+			}
+			for _, name := range declNames(decl) {
+				if proxyName := proxyNameRegexp.FindString(name); proxyName != "" {
+					return proxyName
+				}
+			}
+		}
+		path, _ := astutil.PathEnclosingInterval(file, pos, pos)
+		for _, node := range path {
+			call, ok := node.(*ast.CallExpr)
+			if !ok {
+				continue
+			}
+			proxyName := ""
+			ast.Inspect(call.Fun, func(n ast.Node) bool {
+				if id, ok := n.(*ast.Ident); ok && proxyName == "" {
+					proxyName = proxyNameRegexp.FindString(id.Name)
+				}
+				return proxyName == ""
+			})
+			if proxyName != "" {
+				return proxyName
+			}
+		}
+	}
+	return ""
+}
+
+func declNames(decl ast.Decl) []string {
+	var names []string
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		names = append(names, d.Name.Name)
+	case *ast.GenDecl:
+		for _, spec := range d.Specs {
+			if vs, ok := spec.(*ast.ValueSpec); ok {
+				for _, id := range vs.Names {
+					names = append(names, id.Name)
+				}
+			}
+		}
+	}
+	return names
+}
+
+// proxyDecls returns the addenda for the proxy.
+func proxyDecls(pkgInfo *loader.PackageInfo, proxyName string) []ast.Decl {
+	var decls []ast.Decl
+	for _, file := range pkgInfo.Files {
+		for _, decl := range file.Decls {
+			for _, name := range declNames(decl) {
+				if proxyNameRegexp.FindString(name) == proxyName {
+					decls = append(decls, decl)
+					break
+				}
+			}
+		}
+	}
+	return decls
+}
+
+// proxyJoinPoint returns the join point declared for the proxy as _ag_jp<proxyName>.
+// Only Kind, Name and Pos are set.
+func proxyJoinPoint(pkgInfo *loader.PackageInfo, proxyName string) *aspect.JoinPoint {
+	jpName := "_ag_jp" + proxyName
+	for _, decl := range proxyDecls(pkgInfo, proxyName) {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			vs, ok := spec.(*ast.ValueSpec)
+			if !ok || len(vs.Names) != 1 || vs.Names[0].Name != jpName || len(vs.Values) != 1 {
+				continue
+			}
+			ue, ok := vs.Values[0].(*ast.UnaryExpr)
+			if !ok {
+				continue
+			}
+			cl, ok := ue.X.(*ast.CompositeLit)
+			if !ok {
+				continue
+			}
+			jp := &aspect.JoinPoint{}
+			for _, elt := range cl.Elts {
+				kv, ok := elt.(*ast.KeyValueExpr)
+				if !ok {
+					continue
+				}
+				key, ok := kv.Key.(*ast.Ident)
+				if !ok {
+					continue
+				}
+				lit, ok := kv.Value.(*ast.BasicLit)
+				if !ok || lit.Kind != token.STRING {
+					continue
+				}
+				value, err := strconv.Unquote(lit.Value)
+				if err != nil {
+					continue
+				}
+				switch key.Name {
+				case "Kind":
+					jp.Kind = aspect.JoinPointKind(value)
+				case "Name":
+					jp.Name = value
+				case "Pos":
+					jp.Pos = value
+				}
+			}
+			return jp
+		}
+	}
+	return nil
+}
+
+// proxyAspect returns the name of the aspect referred in the addenda for the proxy
+// as _ag_aspects.<Name>.
+func proxyAspect(pkgInfo *loader.PackageInfo, proxyName string) string {
+	aspectName := ""
+	for _, decl := range proxyDecls(pkgInfo, proxyName) {
+		ast.Inspect(decl, func(n ast.Node) bool {
+			se, ok := n.(*ast.SelectorExpr)
+			if !ok || aspectName != "" {
+				return aspectName == ""
+			}
+			if x, ok := se.X.(*ast.Ident); ok && x.Name == aspectPkgName {
+				aspectName = se.Sel.Name
+			}
+			return true
+		})
+	}
+	return aspectName
+}
@@ -0,0 +1,83 @@
+package weave
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const verifyTestWovenFile = `package foo
+
+import _ag_aspects "agaspect"
+
+type joinPoint struct {
+	Kind, Name, Pos string
+}
+
+func Foo(s string) {
+	println(s)
+}
+
+func Bar() {
+	(_ag_pgen_ag_proxy_0_0())(42)
+}
+
+var _ag_jp_ag_proxy_0_0 = &joinPoint{Kind: "call", Name: "foo.Foo", Pos: "foo/foo.go:8:2"}
+
+func _ag_proxy_0_0(s string) {
+	_ = (&_ag_aspects.ExampleAspect{})
+	var i int = s
+	_ = i
+}
+
+func _ag_pgen_ag_proxy_0_0() func(string) {
+	return func(s string) {
+		_ag_proxy_0_0(s)
+	}
+}
+`
+
+func TestVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aspectgoverifytest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		"foo/foo.go":         verifyTestWovenFile,
+		"agaspect/aspect.go": "package agaspect\n\ntype ExampleAspect struct{}\n",
+	} {
+		fname := filepath.Join(dir, "src", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fname, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctxt := build.Default
+	ctxt.GOPATH = dir
+	err = Verify(&ctxt, []string{"foo"})
+	errs, ok := err.(VerifyErrors)
+	if !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Log(errs)
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %d", len(errs))
+	}
+	for _, e := range errs {
+		if e.JoinPoint == nil {
+			t.Fatalf("join point not found for %s", e.Err)
+		}
+		if e.JoinPoint.Name != "foo.Foo" || e.JoinPoint.Pos != "foo/foo.go:8:2" || e.Aspect != "ExampleAspect" {
+			t.Fatalf("unexpected join point %+v and aspect %q for %s", e.JoinPoint, e.Aspect, e.Err)
+		}
+		if !strings.Contains(e.Error(), "foo/foo.go:8:2") {
+			t.Fatalf("unexpected message: %s", e)
+		}
+	}
+}
@@ -2,11 +2,14 @@
 package weave
 
 import (
+	"fmt"
 	"go/ast"
+	"go/build"
 	"go/parser"
+	"go/token"
 	"go/types"
 	"log"
-	"strings"
+	"sort"
 
 	"golang.org/x/tools/go/loader"
 
@@ -16,41 +19,121 @@ import (
 	"golang.org/x/exp/aspectgo/compiler/weave/match"
 )
 
-// Weave weaves aspect files to the target package and emit the woven files to wovenGOPATH.
-func Weave(wovenGOPATH string, target string, af *parse.AspectFile) ([]string, error) {
-	_, prog, err := loadTarget(target)
+// Options are the options for Weave.
+type Options struct {
+	// Deep enables weaving the call sites in the dependency packages of the target,
+	// including the vendored ones. See deepPackages for the packages that are never woven.
+	Deep bool
+
+	// DeepAllow is the list of the import path patterns of the dependency packages
+	// to be woven in the deep mode. A pattern can contain "..." wildcards.
+	// If empty, all the eligible dependency packages are woven.
+	DeepAllow []string
+
+	// BuildTags are the build tags for loading the target packages.
+	BuildTags []string
+}
+
+// Weave weaves aspect files to the target packages and emit the woven files to wovenGOPATH.
+// The target packages are type-checked at once, and the files are rewritten concurrently.
+// It returns the woven file names for each target, including the ones shared
+// with the other targets (e.g. the rewritten aspect file).
+// opts can be nil.
+func Weave(wovenGOPATH string, targets []string, af *parse.AspectFile, opts *Options) (map[string][]string, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	for _, target := range targets {
+		if af.Filename == "" && af.Program.Package(target) != nil {
+			return nil, fmt.Errorf("aspect package %s cannot import the target %s", af.ImportPath, target)
+		}
+	}
+	_, prog, err := loadTargets(targets, opts.BuildTags)
 	if err != nil {
 		return nil, err
 	}
-	matched, pointcutsByIdent, err := findMatchedThings(prog, af.Pointcuts)
+	pkgs := prog.InitialPackages()
+	if opts.Deep {
+		pkgs = append(pkgs, deepPackages(prog, af, opts.DeepAllow)...)
+	}
+	m := match.NewMatcher(prog)
+	matched, pointcutsByIdent, fieldPointcuts, stmts, cflowMarks, err := findMatchedThings(prog, pkgs, m, af)
 	if err != nil {
 		return nil, err
 	}
 	if util.DebugMode {
-		log.Printf("Found %d matches", len(matched))
+		log.Printf("Found %d matches", len(matched)+len(stmts))
 	}
-	if len(matched) != len(pointcutsByIdent) {
+	if len(matched) != len(pointcutsByIdent)+len(fieldPointcuts) {
 		log.Fatal("impl error")
 	}
-	if len(matched) == 0 {
-		return []string{}, nil
+	intros := findIntroductions(prog, m, af.Introductions)
+	result := make(map[string][]string)
+	for _, target := range targets {
+		result[target] = []string{}
+	}
+	if len(matched)+len(stmts)+len(intros) == 0 {
+		return result, nil
 	}
 
-	rewrittenFnames1, err := rewriteAspectFile(wovenGOPATH, af)
+	aspectFnames, err := rewriteAspectFile(wovenGOPATH, af)
 	if err != nil {
 		return nil, err
 	}
 	rw := &rewriter{
+		AspectFile:       af,
 		Program:          prog,
+		Packages:         packagesToRewrite(prog, pkgs, matched, stmts),
+		Matcher:          m,
 		Matched:          matched,
 		Aspects:          pointcutMapToAspectMap(af.Pointcuts),
+		Instantiations:   af.Instantiations,
 		PointcutsByIdent: pointcutsByIdent,
+		FieldPointcuts:   fieldPointcuts,
+		Stmts:            stmts,
+		CflowMarks:       cflowMarks,
+		Introductions:    intros,
 	}
-	rewrittenFnames2, err := rewriteProgram(wovenGOPATH, rw)
+	rewrittenFnames, err := rewriteProgram(wovenGOPATH, rw)
 	if err != nil {
 		return nil, err
 	}
-	return append(rewrittenFnames1, rewrittenFnames2...), nil
+	for _, target := range targets {
+		pkgInfo := prog.Imported[target]
+		fnames := append(append([]string{}, aspectFnames...), rewrittenFnames[pkgInfo.Pkg]...)
+		fname, err := writeIntroductions(wovenGOPATH, prog, pkgInfo, af, intros)
+		if err != nil {
+			return nil, err
+		}
+		if fname != "" {
+			fnames = append(fnames, fname)
+		}
+		// the woven dependency packages
+		deps := transitiveImports(pkgInfo.Pkg)
+		for _, dep := range rw.Packages {
+			if deps[dep.Pkg] {
+				fnames = append(fnames, rewrittenFnames[dep.Pkg]...)
+			}
+		}
+		result[target] = fnames
+	}
+	return result, nil
+}
+
+// transitiveImports returns the packages imported by pkg transitively.
+func transitiveImports(pkg *types.Package) map[*types.Package]bool {
+	seen := make(map[*types.Package]bool)
+	var visit func(*types.Package)
+	visit = func(p *types.Package) {
+		for _, imp := range p.Imports() {
+			if !seen[imp] {
+				seen[imp] = true
+				visit(imp)
+			}
+		}
+	}
+	visit(pkg)
+	return seen
 }
 
 func pointcutMapToAspectMap(pointcuts map[*types.Named]aspect.Pointcut) map[aspect.Pointcut]*types.Named {
@@ -65,17 +148,84 @@ func pointcutMapToAspectMap(pointcuts map[*types.Named]aspect.Pointcut) map[aspe
 	return aspects
 }
 
-func findMatchedThings(prog *loader.Program, pointcuts map[*types.Named]aspect.Pointcut) (map[*ast.Ident]types.Object, map[*ast.Ident]aspect.Pointcut, error) {
+// stmtMatch is a statement (or an expression) matched with a "go", "send", "recv", "select" or "close" pointcut.
+type stmtMatch struct {
+	Kind     aspect.JoinPointKind
+	Pointcut aspect.Pointcut
+	// Name is the full name of the enclosing function.
+	Name string
+	// ShortName is the name of the enclosing function qualified by the package name.
+	ShortName string
+}
+
+// validatePointcuts returns the error for the first invalid pointcut
+// or type pattern of the introductions, in the order of the aspect names.
+func validatePointcuts(m *match.Matcher, af *parse.AspectFile) error {
+	var names []*types.Named
+	for asp := range af.Pointcuts {
+		names = append(names, asp)
+	}
+	for intro := range af.Introductions {
+		names = append(names, intro)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i].Obj().Name() < names[j].Obj().Name() })
+	for _, named := range names {
+		if pointcut, ok := af.Pointcuts[named]; ok {
+			if err := m.Validate(pointcut); err != nil {
+				return fmt.Errorf("invalid pointcut %q of %s: %s", pointcut, named.Obj().Name(), err)
+			}
+		}
+		if pattern, ok := af.Introductions[named]; ok {
+			if err := m.ValidatePattern(pattern); err != nil {
+				return fmt.Errorf("invalid type pattern %q of %s: %s", pattern, named.Obj().Name(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// findMatchedThings returns the matched objects in pkgs.
+// "call" and "handler" pointcuts are recorded in pointcutsByIdent.
+// "get" and "set" pointcuts are recorded in fieldPointcuts, as they need the selector expressions.
+// The other pointcuts are recorded in stmts.
+//
+// The calls matched with the inner pointcuts of "cflow" terms are recorded in cflowMarks.
+// If such a call is not matched with any pointcut, the inner pointcut is recorded
+// in pointcutsByIdent as well, so that the call is woven without advice.
+func findMatchedThings(prog *loader.Program, pkgs []*loader.PackageInfo, m *match.Matcher, af *parse.AspectFile) (map[*ast.Ident]types.Object, map[*ast.Ident]aspect.Pointcut, map[*ast.SelectorExpr]map[aspect.JoinPointKind]aspect.Pointcut, map[ast.Node]*stmtMatch, map[*ast.Ident][]aspect.Pointcut, error) {
 	objs := make(map[*ast.Ident]types.Object)
 	pointcutsByIdent := make(map[*ast.Ident]aspect.Pointcut)
-	for _, pkgInfo := range prog.InitialPackages() {
+	fieldPointcuts := make(map[*ast.SelectorExpr]map[aspect.JoinPointKind]aspect.Pointcut)
+	stmts := make(map[ast.Node]*stmtMatch)
+	cflowMarks := make(map[*ast.Ident][]aspect.Pointcut)
+	if err := validatePointcuts(m, af); err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+	var callPointcuts, fieldAccessPointcuts, stmtPointcuts, cflowPointcuts []aspect.Pointcut
+	for _, pointcut := range af.Pointcuts {
+		switch kind := m.Kind(pointcut); {
+		case kind == aspect.KindCall, kind == aspect.KindHandler:
+			callPointcuts = append(callPointcuts, pointcut)
+			for _, c := range m.Cflows(pointcut) {
+				if !containsPointcut(cflowPointcuts, c.Pointcut) {
+					cflowPointcuts = append(cflowPointcuts, c.Pointcut)
+				}
+			}
+		case kind == aspect.KindGet, kind == aspect.KindSet:
+			fieldAccessPointcuts = append(fieldAccessPointcuts, pointcut)
+		case match.IsStmtKind(kind):
+			stmtPointcuts = append(stmtPointcuts, pointcut)
+		}
+	}
+	sort.Slice(cflowPointcuts, func(i, j int) bool { return cflowPointcuts[i] < cflowPointcuts[j] })
+	for _, pkgInfo := range pkgs {
 		for id, obj := range pkgInfo.Uses {
 			posn := prog.Fset.Position(id.Pos())
-			if strings.HasSuffix(posn.Filename, "_aspect.go") {
+			if af.IsAspectFile(posn.Filename) {
 				continue
 			}
-			for _, pointcut := range pointcuts {
-				matched := match.ObjMatchPointcut(prog, id, obj, pointcut)
+			for _, pointcut := range callPointcuts {
+				matched := m.ObjMatchPointcut(id, obj, pointcut)
 				if !matched {
 					continue
 				}
@@ -93,16 +243,196 @@ func findMatchedThings(prog *loader.Program, pointcuts map[*types.Named]aspect.P
 				}
 				pointcutsByIdent[id] = pointcut
 			}
+			for _, pointcut := range cflowPointcuts {
+				if !m.ObjMatchPointcut(id, obj, pointcut) {
+					continue
+				}
+				cflowMarks[id] = append(cflowMarks[id], pointcut)
+				if _, ok := pointcutsByIdent[id]; !ok {
+					objs[id] = obj
+					pointcutsByIdent[id] = pointcut
+				}
+			}
+		}
+		if len(stmtPointcuts) != 0 {
+			findMatchedStmts(prog, m, af, pkgInfo, stmtPointcuts, stmts)
+		}
+		if len(fieldAccessPointcuts) == 0 {
+			continue
+		}
+		for _, file := range pkgInfo.Files {
+			posn := prog.Fset.Position(file.Pos())
+			if af.IsAspectFile(posn.Filename) {
+				continue
+			}
+			ast.Inspect(file, func(node ast.Node) bool {
+				se, ok := node.(*ast.SelectorExpr)
+				if !ok {
+					return true
+				}
+				sel, ok := pkgInfo.Selections[se]
+				if !ok || sel.Kind() != types.FieldVal {
+					return true
+				}
+				for _, pointcut := range fieldAccessPointcuts {
+					if !m.ObjMatchPointcut(se.Sel, sel.Obj(), pointcut) {
+						continue
+					}
+					posn := prog.Fset.Position(se.Sel.Pos())
+					if util.DebugMode {
+						log.Printf("MATCHED %s:%d:%d: %s, pointcut=%s",
+							posn.Filename, posn.Line, posn.Column,
+							sel.Obj(), pointcut)
+					}
+					objs[se.Sel] = sel.Obj()
+					kinds, ok := fieldPointcuts[se]
+					if !ok {
+						kinds = make(map[aspect.JoinPointKind]aspect.Pointcut)
+						fieldPointcuts[se] = kinds
+					}
+					kind := m.Kind(pointcut)
+					xpt, ok := kinds[kind]
+					if ok {
+						log.Printf("OVERRIDE %s:%d:%d: %s, pointcut=%s vs old=%s",
+							posn.Filename, posn.Line, posn.Column,
+							sel.Obj(), pointcut, xpt)
+					}
+					kinds[kind] = pointcut
+				}
+				return true
+			})
+		}
+	}
+	return objs, pointcutsByIdent, fieldPointcuts, stmts, cflowMarks, nil
+}
+
+func containsPointcut(pointcuts []aspect.Pointcut, pointcut aspect.Pointcut) bool {
+	for _, pc := range pointcuts {
+		if pc == pointcut {
+			return true
+		}
+	}
+	return false
+}
+
+// findMatchedStmts records the statements (and the expressions) matched with stmtPointcuts to stmts.
+// The sends and the receives in the select cases are not matched.
+func findMatchedStmts(prog *loader.Program, m *match.Matcher, af *parse.AspectFile, pkgInfo *loader.PackageInfo, stmtPointcuts []aspect.Pointcut, stmts map[ast.Node]*stmtMatch) {
+	for _, file := range pkgInfo.Files {
+		posn := prog.Fset.Position(file.Pos())
+		if af.IsAspectFile(posn.Filename) {
+			continue
+		}
+		for _, decl := range file.Decls {
+			name, shortName := declFuncName(pkgInfo, decl)
+			inComm := make(map[ast.Node]bool)
+			ast.Inspect(decl, func(node ast.Node) bool {
+				var kind aspect.JoinPointKind
+				switch n := node.(type) {
+				case *ast.CommClause:
+					switch comm := n.Comm.(type) {
+					case *ast.SendStmt:
+						inComm[comm] = true
+					case *ast.ExprStmt:
+						inComm[unparen(comm.X)] = true
+					case *ast.AssignStmt:
+						inComm[unparen(comm.Rhs[0])] = true
+					}
+				case *ast.GoStmt:
+					kind = aspect.KindGo
+				case *ast.SendStmt:
+					kind = aspect.KindSend
+				case *ast.UnaryExpr:
+					if n.Op == token.ARROW {
+						kind = aspect.KindRecv
+					}
+				case *ast.SelectStmt:
+					kind = aspect.KindSelect
+				case *ast.CallExpr:
+					if isBuiltin(pkgInfo, n.Fun, "close") {
+						kind = aspect.KindClose
+					}
+				}
+				if kind == "" || inComm[node] {
+					return true
+				}
+				for _, pointcut := range stmtPointcuts {
+					if !m.StmtMatchPointcut(kind, name, pointcut) {
+						continue
+					}
+					posn := prog.Fset.Position(node.Pos())
+					if util.DebugMode {
+						log.Printf("MATCHED %s:%d:%d: %s in %s, pointcut=%s",
+							posn.Filename, posn.Line, posn.Column,
+							kind, name, pointcut)
+					}
+					if x, ok := stmts[node]; ok {
+						log.Printf("OVERRIDE %s:%d:%d: %s in %s, pointcut=%s vs old=%s",
+							posn.Filename, posn.Line, posn.Column,
+							kind, name, pointcut, x.Pointcut)
+					}
+					stmts[node] = &stmtMatch{Kind: kind, Pointcut: pointcut, Name: name, ShortName: shortName}
+				}
+				return true
+			})
+		}
+	}
+}
+
+// declFuncName returns the full name and the short name of the function declared by decl,
+// or of the package initializer if decl is not a function declaration.
+func declFuncName(pkgInfo *loader.PackageInfo, decl ast.Decl) (string, string) {
+	if fd, ok := decl.(*ast.FuncDecl); ok {
+		if fn, ok := pkgInfo.Defs[fd.Name].(*types.Func); ok {
+			return fn.FullName(), shortFuncName(fn)
+		}
+	}
+	return pkgInfo.Pkg.Path() + ".init", pkgInfo.Pkg.Name() + ".init"
+}
+
+// enclosingFuncName returns the full name of the function that contains pos in file.
+func enclosingFuncName(pkgInfo *loader.PackageInfo, file *ast.File, pos token.Pos) string {
+	for _, decl := range file.Decls {
+		if decl.Pos() <= pos && pos < decl.End() {
+			name, _ := declFuncName(pkgInfo, decl)
+			return name
 		}
 	}
-	return objs, pointcutsByIdent, nil
+	return pkgInfo.Pkg.Path() + ".init"
 }
 
-func loadTarget(target string) (*loader.Config, *loader.Program, error) {
+func unparen(e ast.Expr) ast.Expr {
+	for {
+		paren, ok := e.(*ast.ParenExpr)
+		if !ok {
+			return e
+		}
+		e = paren.X
+	}
+}
+
+// isBuiltin returns true if fun is the builtin function named name.
+func isBuiltin(pkgInfo *loader.PackageInfo, fun ast.Expr, name string) bool {
+	id, ok := unparen(fun).(*ast.Ident)
+	if !ok {
+		return false
+	}
+	b, ok := pkgInfo.Uses[id].(*types.Builtin)
+	return ok && b.Name() == name
+}
+
+func loadTargets(targets []string, buildTags []string) (*loader.Config, *loader.Program, error) {
 	conf := loader.Config{
 		ParserMode: parser.ParseComments,
 	}
-	conf.Import(target)
+	if len(buildTags) != 0 {
+		ctxt := build.Default
+		ctxt.BuildTags = buildTags
+		conf.Build = &ctxt
+	}
+	for _, target := range targets {
+		conf.Import(target)
+	}
 	prog, err := conf.Load()
 	if err != nil {
 		return nil, nil, err
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var fetches = 0
+
+// fetch fails twice before it succeeds.
+//
+//aspect:retry max=3
+func fetch(key string) (string, error) {
+	fetches++
+	if fetches < 3 {
+		return "", errors.New("transient")
+	}
+	return "value of " + key, nil
+}
+
+// greet is traced in the debug level.
+//
+//aspect:trace level=debug
+func greet(name string) string {
+	return "hello " + name
+}
+
+//aspect:trace
+func square(x int) int {
+	return x * x
+}
+
+// notAnnotated is not traced.
+func notAnnotated() {
+	fmt.Println("not annotated")
+}
+
+func main() {
+	v, err := fetch("foo")
+	fmt.Printf("fetch: %q, err=%v\n", v, err)
+	fmt.Println(greet("world"))
+	fmt.Println(square(42))
+	notAnnotated()
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	asp "golang.org/x/exp/aspectgo/aspect"
+	"golang.org/x/exp/aspectgo/aspects"
+)
+
+// TraceAspect traces the functions annotated with `//aspect:trace`.
+type TraceAspect struct {
+}
+
+func (a *TraceAspect) Pointcut() asp.Pointcut {
+	return asp.NewAnnotationPointcutFromRegexp("trace")
+}
+
+func (a *TraceAspect) Advice(ctx asp.Context) []interface{} {
+	jp := ctx.JoinPoint()
+	level := jp.Annotation("trace").Params["level"]
+	if level == "" {
+		level = "info"
+	}
+	fmt.Printf("[%s] %s args=%v\n", level, jp.Name, ctx.Args())
+	return ctx.Call(ctx.Args())
+}
+
+// RetryAspect retries the functions annotated with `//aspect:retry`.
+// The maximum number of the attempts is given by the "max" parameter.
+type RetryAspect struct {
+	aspects.Retry
+}
+
+func (a *RetryAspect) Pointcut() asp.Pointcut {
+	return asp.NewAnnotationPointcutFromRegexp("retry")
+}
+
+func (a *RetryAspect) Init() {
+	a.Sleep = func(d time.Duration) {
+		fmt.Printf("retrying after %s\n", d)
+	}
+}
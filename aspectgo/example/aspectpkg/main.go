@@ -0,0 +1,18 @@
+package main
+
+import (
+	"fmt"
+)
+
+func greet(name string) string {
+	return "hello, " + name
+}
+
+func farewell(name string) string {
+	return "bye, " + name
+}
+
+func main() {
+	fmt.Println(greet("world"))
+	fmt.Println(farewell("world"))
+}
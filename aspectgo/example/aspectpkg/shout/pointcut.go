@@ -0,0 +1,12 @@
+package shout
+
+import (
+	"regexp"
+
+	asp "golang.org/x/exp/aspectgo/aspect"
+)
+
+// Pointcut matches greet().
+func (a *ShoutAspect) Pointcut() asp.Pointcut {
+	return asp.NewCallPointcutFromRegexp(regexp.QuoteMeta("golang.org/x/exp/aspectgo/example/aspectpkg.greet"))
+}
@@ -0,0 +1,25 @@
+// Package shout is an aspect package for the aspectpkg example.
+// Unlike an aspect file, an aspect package can be split across files,
+// imported by other aspect packages, and unit-tested.
+package shout
+
+import (
+	"strings"
+
+	asp "golang.org/x/exp/aspectgo/aspect"
+)
+
+// ShoutAspect upper-cases the string results.
+type ShoutAspect struct {
+}
+
+// Advice upper-cases the string results of the call.
+func (a *ShoutAspect) Advice(ctx asp.Context) []interface{} {
+	res := ctx.Call(ctx.Args())
+	for i, r := range res {
+		if s, ok := r.(string); ok {
+			res[i] = strings.ToUpper(s)
+		}
+	}
+	return res
+}
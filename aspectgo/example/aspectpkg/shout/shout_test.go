@@ -0,0 +1,22 @@
+package shout
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/exp/aspectgo/aspect/rt"
+)
+
+func TestShoutAspect(t *testing.T) {
+	ctx := &rt.ContextImpl{
+		XArgs: []interface{}{"world"},
+		XFunc: func(args []interface{}) []interface{} {
+			return []interface{}{"hello, " + args[0].(string), 42}
+		},
+		XJoinPoint: &rt.JoinPoint{Name: "example.com/foo.greet", NumResults: 2},
+	}
+	res := (&ShoutAspect{}).Advice(ctx)
+	if expected := []interface{}{"HELLO, WORLD", 42}; !reflect.DeepEqual(res, expected) {
+		t.Fatalf("expected %v, got %v", expected, res)
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	asp "golang.org/x/exp/aspectgo/aspect"
+)
+
+func lookup(key string, n int) string {
+	return strings.Repeat(key, n)
+}
+
+func main() {
+	n := 0
+	for i := 1; i <= 3; i++ {
+		n += len(lookup("x", i))
+	}
+	// the events are handled in the background
+	asp.FlushAsync()
+	fmt.Printf("n=%d\n", n)
+}
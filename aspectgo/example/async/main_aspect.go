@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"runtime"
+	"strings"
+
+	asp "golang.org/x/exp/aspectgo/aspect"
+)
+
+// mainGoroutine is the goroutine running main(), which calls lookup().
+var mainGoroutine = goroutineID()
+
+// goroutineID returns the ID of the current goroutine, from the header of the stack trace
+// (e.g. "goroutine 1 [running]:").
+func goroutineID() string {
+	buf := make([]byte, 64)
+	buf = buf[:runtime.Stack(buf, false)]
+	return strings.Fields(string(buf))[1]
+}
+
+// AsyncTraceAspect prints the calls to lookup() on the background goroutine,
+// without slowing down the calls.
+type AsyncTraceAspect struct {
+}
+
+func (a *AsyncTraceAspect) Pointcut() asp.Pointcut {
+	s := regexp.QuoteMeta("golang.org/x/exp/aspectgo/example/async.lookup")
+	return asp.NewCallPointcutFromRegexp(s)
+}
+
+// Advice is not called for an AsyncAspect.
+func (a *AsyncTraceAspect) Advice(ctx asp.Context) []interface{} {
+	return ctx.Call(ctx.Args())
+}
+
+func (a *AsyncTraceAspect) AsyncOptions() asp.AsyncOptions {
+	return asp.AsyncOptions{BufferSize: 256, Drop: asp.DropOldest}
+}
+
+func (a *AsyncTraceAspect) HandleEvent(ev asp.Event) {
+	var params []string
+	for i, arg := range ev.Args {
+		params = append(params, fmt.Sprintf("%s=%#v", ev.JoinPoint.ParamNames[i], arg))
+	}
+	where := "the caller goroutine"
+	if goroutineID() != mainGoroutine {
+		where = "a background goroutine"
+	}
+	fmt.Printf("event: %s(%s) = %v on %s\n", ev.JoinPoint.Name, strings.Join(params, ", "), ev.Results, where)
+}
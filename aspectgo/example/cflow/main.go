@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+)
+
+// DB is a fake database.
+type DB struct {
+}
+
+func (db *DB) Query(q string) string {
+	return "rows for " + q
+}
+
+var db = &DB{}
+
+func handleUsers() string {
+	return db.Query("SELECT * FROM users")
+}
+
+func handleItems() string {
+	return listItems()
+}
+
+func listItems() string {
+	return db.Query("SELECT * FROM items")
+}
+
+func migrate() {
+	db.Query("CREATE TABLE users")
+}
+
+func fib(n int) int {
+	if n < 2 {
+		return n
+	}
+	return fib(n-1) + fib(n-2)
+}
+
+func main() {
+	migrate()
+	fmt.Println(handleUsers())
+	fmt.Println(handleItems())
+	fmt.Printf("fib(5)=%d\n", fib(5))
+}
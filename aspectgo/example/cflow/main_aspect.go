@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	asp "golang.org/x/exp/aspectgo/aspect"
+)
+
+var pkg = regexp.QuoteMeta("golang.org/x/exp/aspectgo/example/cflow")
+
+// QueryAspect logs DB.Query() only when called (transitively) from the handlers.
+type QueryAspect struct {
+}
+
+func (a *QueryAspect) Pointcut() asp.Pointcut {
+	query := asp.NewCallPointcutFromRegexp(regexp.QuoteMeta("(*golang.org/x/exp/aspectgo/example/cflow.DB).Query"))
+	handler := asp.NewCallPointcutFromRegexp(pkg + `\.handle.*`)
+	return query.And(asp.NewCflowPointcut(handler))
+}
+
+func (a *QueryAspect) Advice(ctx asp.Context) []interface{} {
+	fmt.Printf("query in a handler: %v\n", ctx.Args()[0])
+	return ctx.Call(ctx.Args())
+}
+
+// RecursionAspect logs the recursive calls of fib().
+// The outermost call is not logged.
+type RecursionAspect struct {
+}
+
+func (a *RecursionAspect) Pointcut() asp.Pointcut {
+	fib := asp.NewCallPointcutFromRegexp(pkg + `\.fib`)
+	return fib.And(asp.NewCflowBelowPointcut(fib))
+}
+
+func (a *RecursionAspect) Advice(ctx asp.Context) []interface{} {
+	fmt.Printf("recursive call: fib(%v)\n", ctx.Args()[0])
+	return ctx.Call(ctx.Args())
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+type Results struct {
+	mu  sync.Mutex
+	sum int
+}
+
+func (r *Results) Add(x int) {
+	r.mu.Lock()
+	r.sum += x
+	r.mu.Unlock()
+}
+
+func worker(jobs <-chan int, done chan<- bool, res *Results) {
+	for {
+		j, ok := <-jobs
+		if !ok {
+			done <- true
+			return
+		}
+		res.Add(j * j)
+	}
+}
+
+func main() {
+	jobs := make(chan int)
+	done := make(chan bool)
+	var res Results
+	go worker(jobs, done, &res)
+	for i := 1; i <= 3; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	select {
+	case <-done:
+		fmt.Printf("sum=%d\n", res.sum)
+	}
+}
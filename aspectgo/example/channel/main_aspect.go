@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	asp "golang.org/x/exp/aspectgo/aspect"
+)
+
+var target = regexp.QuoteMeta("golang.org/x/exp/aspectgo/example/channel.") + ".*"
+
+// logger logs the joinpoints.
+type logger struct {
+}
+
+func (a *logger) Advice(ctx asp.Context) []interface{} {
+	jp := ctx.JoinPoint()
+	fmt.Printf("%s: %s\n", jp.Kind, jp.Name)
+	return ctx.Call(ctx.Args())
+}
+
+type GoAspect struct {
+	logger
+}
+
+func (a *GoAspect) Pointcut() asp.Pointcut {
+	return asp.NewGoPointcutFromRegexp(target)
+}
+
+type SendAspect struct {
+	logger
+}
+
+func (a *SendAspect) Pointcut() asp.Pointcut {
+	return asp.NewSendPointcutFromRegexp(target)
+}
+
+type RecvAspect struct {
+	logger
+}
+
+func (a *RecvAspect) Pointcut() asp.Pointcut {
+	return asp.NewRecvPointcutFromRegexp(target)
+}
+
+type SelectAspect struct {
+}
+
+func (a *SelectAspect) Pointcut() asp.Pointcut {
+	return asp.NewSelectPointcutFromRegexp(target)
+}
+
+func (a *SelectAspect) Advice(ctx asp.Context) []interface{} {
+	res := ctx.Call(ctx.Args())
+	fmt.Printf("select in %s: chosen=%v\n", ctx.JoinPoint().Name, res[0])
+	return res
+}
+
+type CloseAspect struct {
+	logger
+}
+
+func (a *CloseAspect) Pointcut() asp.Pointcut {
+	return asp.NewClosePointcutFromRegexp(target)
+}
+
+// MutexAspect is a "call" aspect for sync.Mutex.
+type MutexAspect struct {
+	logger
+}
+
+func (a *MutexAspect) Pointcut() asp.Pointcut {
+	return asp.NewCallPointcutFromRegexp(regexp.QuoteMeta("(*sync.Mutex).") + "(Lock|Unlock)")
+}
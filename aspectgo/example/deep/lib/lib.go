@@ -0,0 +1,15 @@
+// Package lib is a dependency package of the deep example.
+package lib
+
+// Sum returns the sum of xs.
+func Sum(xs ...int) int {
+	s := 0
+	for _, x := range xs {
+		s = add(s, x)
+	}
+	return s
+}
+
+func add(x, y int) int {
+	return x + y
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"fmt"
+
+	"example.com/greet"
+
+	"golang.org/x/exp/aspectgo/example/deep/lib"
+)
+
+func main() {
+	fmt.Printf("sum: %d\n", lib.Sum(1, 2, 3))
+	fmt.Println(greet.Hello("world"))
+}
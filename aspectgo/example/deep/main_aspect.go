@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	asp "golang.org/x/exp/aspectgo/aspect"
+)
+
+// LibAspect logs the calls of lib.Sum() and lib.add().
+// The calls of lib.add() are in the dependency package, so they are logged only with -deep.
+type LibAspect struct {
+}
+
+func (a *LibAspect) Pointcut() asp.Pointcut {
+	return asp.NewCallPointcutFromRegexp(regexp.QuoteMeta("golang.org/x/exp/aspectgo/example/deep/lib.") + `(Sum|add)`)
+}
+
+func (a *LibAspect) Advice(ctx asp.Context) []interface{} {
+	res := ctx.Call(ctx.Args())
+	fmt.Printf("%s%v = %v\n", ctx.JoinPoint().Name, ctx.Args(), res[0])
+	return res
+}
+
+// CapitalizeAspect replaces the result of capitalize() in the vendored package.
+type CapitalizeAspect struct {
+}
+
+func (a *CapitalizeAspect) Pointcut() asp.Pointcut {
+	return asp.NewCallPointcutFromRegexp(`example\.com/greet\.capitalize`)
+}
+
+func (a *CapitalizeAspect) Advice(ctx asp.Context) []interface{} {
+	res := ctx.Call(ctx.Args())
+	return []interface{}{fmt.Sprintf("%s (woven)", res[0])}
+}
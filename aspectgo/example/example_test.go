@@ -1,14 +1,19 @@
 package example
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
+	"golang.org/x/exp/aspectgo/aspects/profile"
+	"golang.org/x/exp/aspectgo/aspects/span"
 	agcli "golang.org/x/exp/aspectgo/compiler/cli"
 )
 
@@ -29,23 +34,20 @@ func TestMain(m *testing.M) {
 	os.Exit(m.Run())
 }
 
-func execAspectGo(t *testing.T, wovenGOPATH, pkg, aspectFileBasename string, recursive bool) error {
-	pkgDir := filepath.Join(GOPATH, filepath.Join("src", pkg))
-	aspectFilename := filepath.Join(pkgDir, aspectFileBasename)
-	if recursive {
-		pkg += "/..."
-	}
-	args := []string{"aspectgo", "-w", wovenGOPATH, "-t", pkg}
+// runAspectGo runs `aspectgo args...`, and returns the output of the subcommand.
+func runAspectGo(t *testing.T, args ...string) ([]byte, error) {
+	args = append([]string{"aspectgo"}, args...)
 	if testing.Verbose() {
-		args = append(args, "-debug=true")
+		args = append(args, "-debug=true", "-keep")
 	}
-	args = append(args, []string{"--", aspectFilename}...)
 	t.Logf("Running AspectGo with: %s", args[1:])
-	exitCode := agcli.Main(args)
+	var out bytes.Buffer
+	exitCode := agcli.Run(args, &out, &out)
+	t.Logf("Test Result (woven):\n%s", out.String())
 	if exitCode != 0 {
-		return fmt.Errorf("Build failed with exit code %d", exitCode)
+		return out.Bytes(), fmt.Errorf("aspectgo failed with exit code %d", exitCode)
 	}
-	return nil
+	return out.Bytes(), nil
 }
 
 func execMainWithGOPATH(t *testing.T, gopath, pkg, mainFileBasename string) ([]byte, error) {
@@ -64,7 +66,9 @@ func execMainWithGOPATH(t *testing.T, gopath, pkg, mainFileBasename string) ([]b
 // textEx returns the output of the original test and the woven test suite if succeeds.
 // the output contains stderr.
 // if the woven test or aspectgo itself fails, testEx panics.
-func testEx(t *testing.T, dirname, mainFileBasename, aspectFileBasename string, recursive bool) ([]byte, []byte) {
+// aspectFileBasename can also be the import path of the aspect package, relative to pkg.
+// flags are passed to aspectgo.
+func testEx(t *testing.T, dirname, mainFileBasename, aspectFileBasename string, recursive bool, flags ...string) ([]byte, []byte) {
 	t.Parallel()
 	pkg := filepath.Join(exPackage, dirname)
 	out1, err := execMainWithGOPATH(t, "", pkg, mainFileBasename)
@@ -72,27 +76,17 @@ func testEx(t *testing.T, dirname, mainFileBasename, aspectFileBasename string,
 		t.Fatal(err)
 	}
 
-	wovenGOPATH, err := ioutil.TempDir("", "agtestwovengopath")
-	if err != nil {
-		t.Fatal(err)
+	aspect := filepath.Join(GOPATH, "src", pkg, aspectFileBasename)
+	if !strings.HasSuffix(aspectFileBasename, ".go") {
+		aspect = pkg + "/" + aspectFileBasename
 	}
-
-	err = execAspectGo(t, wovenGOPATH, pkg, aspectFileBasename, recursive)
-	if err != nil {
-		t.Fatal(err)
+	if recursive {
+		flags = append(flags, "-t", pkg+"/...")
 	}
-
-	out2, err := execMainWithGOPATH(t, wovenGOPATH, pkg, mainFileBasename)
+	out2, err := runAspectGo(t, append(append([]string{"run", "-aspect", aspect}, flags...), pkg)...)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	if testing.Verbose() {
-		t.Logf("Keeping woven directory %s", wovenGOPATH)
-	} else {
-		os.RemoveAll(wovenGOPATH)
-	}
-
 	return out1, out2
 }
 
@@ -124,6 +118,154 @@ func TestExDetreplay(t *testing.T) {
 	testEx(t, "detreplay", "main.go", "main_aspect.go", false)
 }
 
+func TestExInstance(t *testing.T) {
+	testEx(t, "instance", "main.go", "main_aspect.go", false)
+}
+
+func TestExField(t *testing.T) {
+	testEx(t, "field", "main.go", "main_aspect.go", false)
+}
+
+func TestExChannel(t *testing.T) {
+	testEx(t, "channel", "main.go", "main_aspect.go", false)
+}
+
+func TestExHandler(t *testing.T) {
+	testEx(t, "handler", "main.go", "main_aspect.go", false)
+}
+
+func TestExAnnotation(t *testing.T) {
+	testEx(t, "annotation", "main.go", "main_aspect.go", false)
+}
+
+func TestExCflow(t *testing.T) {
+	testEx(t, "cflow", "main.go", "main_aspect.go", false)
+}
+
+func TestExIntroduction(t *testing.T) {
+	testEx(t, "introduction", "main.go", "main_aspect.go", false)
+}
+
+func TestExAspectPackage(t *testing.T) {
+	_, out := testEx(t, "aspectpkg", "main.go", "shout", false)
+	if !strings.Contains(string(out), "HELLO, WORLD") {
+		t.Fatalf("the aspect package is not woven: %s", out)
+	}
+}
+
+func TestExDeep(t *testing.T) {
+	_, out := testEx(t, "deep", "main.go", "main_aspect.go", false,
+		"-deep", "-deep-allow", exPackage+"/deep/...")
+	for _, s := range []string{"lib.add[1 2] = 3", "World (woven)"} {
+		if !strings.Contains(string(out), s) {
+			t.Fatalf("%q not found in the woven output", s)
+		}
+	}
+}
+
+func TestExTrace(t *testing.T) {
+	testEx(t, "trace", "main.go", "main_aspect.go", false)
+}
+
+func TestExLatency(t *testing.T) {
+	testEx(t, "latency", "main.go", "main_aspect.go", false)
+}
+
+func TestExRecoverPanic(t *testing.T) {
+	testEx(t, "recoverpanic", "main.go", "main_aspect.go", false)
+}
+
+func TestExRetry(t *testing.T) {
+	testEx(t, "retry", "main.go", "main_aspect.go", false)
+}
+
+func TestExMemoize(t *testing.T) {
+	testEx(t, "memoize", "main.go", "main_aspect.go", false)
+}
+
+func TestExFaultInject(t *testing.T) {
+	testEx(t, "faultinject", "main.go", "main_aspect.go", false)
+}
+
+func TestExReplay(t *testing.T) {
+	testEx(t, "replay", "main.go", "main_aspect.go", false)
+}
+
+func TestExMock(t *testing.T) {
+	testEx(t, "mock", "main.go", "main_aspect.go", false)
+}
+
+func TestExAsync(t *testing.T) {
+	_, out := testEx(t, "async", "main.go", "main_aspect.go", false)
+	for i, s := range []string{`lookup(key="x", n=1) = [x]`, `lookup(key="x", n=2) = [xx]`, `lookup(key="x", n=3) = [xxx]`} {
+		if !strings.Contains(string(out), s+" on a background goroutine") {
+			t.Fatalf("event %d is not handled on a background goroutine: %s", i, out)
+		}
+	}
+	if !strings.Contains(string(out), "n=6") {
+		t.Fatalf("unexpected result: %s", out)
+	}
+}
+
+func TestExSpan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aspectgo-span")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	chromeFile, otlpFile := filepath.Join(dir, "trace.json"), filepath.Join(dir, "spans.jsonl")
+	// the woven program inherits the environment
+	os.Setenv(span.Env, "chrome:"+chromeFile)
+	defer os.Unsetenv(span.Env)
+	testEx(t, "span", "main.go", "main_aspect.go", false)
+	os.Setenv(span.Env, "otlp:"+otlpFile)
+	aspect := filepath.Join(GOPATH, "src", exPackage, "span", "main_aspect.go")
+	if _, err := runAspectGo(t, "run", "-aspect", aspect, exPackage+"/span"); err != nil {
+		t.Fatal(err)
+	}
+
+	for fname, expected := range map[string][]string{
+		chromeFile: {`"ph":"X"`, `"name":"main.handle"`, `"name":"main.fetch"`, `"name":"main.query"`},
+		otlpFile:   {`"resourceSpans"`, `"name":"main.handle"`, `"name":"main.fetch"`, `"name":"main.query"`},
+	} {
+		b, err := ioutil.ReadFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range expected {
+			if !strings.Contains(string(b), s) {
+				t.Fatalf("%s not found in %s:\n%s", s, fname, b)
+			}
+		}
+	}
+}
+
+func TestExProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aspectgo-profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "profile.json")
+	// the woven program inherits the environment
+	os.Setenv(profile.Env, fname)
+	defer os.Unsetenv(profile.Env)
+	testEx(t, "profile", "main.go", "main_aspect.go", false)
+
+	p, err := profile.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int64)
+	for _, e := range p.Edges {
+		counts[strings.TrimPrefix(e.Caller, exPackage+"/")+"->"+strings.TrimPrefix(e.Callee, exPackage+"/")] = e.Count
+	}
+	expected := map[string]int64{"profile.main->profile.parse": 1, "profile.main->profile.greet": 2}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Fatalf("unexpected counts: %v", counts)
+	}
+}
+
 func TestExRecursive(t *testing.T) {
 	testEx(t, "recursive", "main.go", "main_aspect.go", true)
 }
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+)
+
+type store struct {
+	m map[string]string
+}
+
+func (s *store) get(key string) (string, error) {
+	v, ok := s.m[key]
+	if !ok {
+		return "", fmt.Errorf("not found: %s", key)
+	}
+	return v, nil
+}
+
+func main() {
+	s := &store{m: map[string]string{"foo": "bar"}}
+	for i := 0; i < 8; i++ {
+		v, err := s.get("foo")
+		fmt.Printf("#%d: v=%q, err=%v\n", i, v, err)
+	}
+}
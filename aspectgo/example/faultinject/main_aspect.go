@@ -0,0 +1,40 @@
+package main
+
+import (
+	"regexp"
+
+	asp "golang.org/x/exp/aspectgo/aspect"
+	"golang.org/x/exp/aspectgo/aspects/faultinject"
+)
+
+// schedule is usually loaded from $ASPECTGO_FAULT_SCHEDULE,
+// but embedded here for reproducible output.
+const schedule = `{
+  "seed": 42,
+  "rules": [
+    {"joinpoint": "\\.get$", "fault": "error", "error": "disk failure", "probability": 0.5, "after": 2}
+  ]
+}`
+
+// FaultAspect injects errors to (*store).get.
+type FaultAspect struct {
+	faultinject.Injector
+}
+
+func (a *FaultAspect) Pointcut() asp.Pointcut {
+	s := regexp.QuoteMeta("(*golang.org/x/exp/aspectgo/example/faultinject.store).get")
+	return asp.NewCallPointcutFromRegexp(s)
+}
+
+// Instantiation returns asp.Singleton, as the schedule is stateful.
+func (a *FaultAspect) Instantiation() asp.Instantiation {
+	return asp.Singleton
+}
+
+func (a *FaultAspect) Init() {
+	s, err := faultinject.ParseSchedule([]byte(schedule))
+	if err != nil {
+		panic(err)
+	}
+	a.Schedule = s
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+)
+
+type Counter struct {
+	Name  string
+	Count int
+}
+
+func (c *Counter) Reset() {
+	c.Count = 0
+}
+
+type Stats struct {
+	Hits Counter
+}
+
+var counters = map[string]*Counter{}
+
+func counter(name string) *Counter {
+	c, ok := counters[name]
+	if !ok {
+		c = &Counter{Name: name}
+		counters[name] = c
+	}
+	return c
+}
+
+func main() {
+	c := &Counter{Name: "requests"}
+	c.Count = 40
+	c.Count += 1
+	c.Count++
+	fmt.Printf("%s=%d\n", c.Name, c.Count)
+
+	var s Stats
+	s.Hits.Count = 1
+	s.Hits.Reset()
+	fmt.Printf("hits=%d\n", s.Hits.Count)
+
+	// the base is evaluated once
+	counter("errors").Count += 2
+	counter("errors").Count++
+	fmt.Printf("errors=%d\n", counters["errors"].Count)
+
+	// the values on the right are evaluated before the assignments
+	a, b := &Counter{Name: "a", Count: 1}, &Counter{Name: "b", Count: 2}
+	a.Count, b.Count = b.Count, a.Count
+	fmt.Printf("a=%d b=%d\n", a.Count, b.Count)
+}
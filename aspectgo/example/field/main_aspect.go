@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	asp "golang.org/x/exp/aspectgo/aspect"
+)
+
+// GetAspect is applied to the reads of Counter.Count.
+type GetAspect struct {
+}
+
+func (a *GetAspect) Pointcut() asp.Pointcut {
+	s := regexp.QuoteMeta("golang.org/x/exp/aspectgo/example/field.Counter.Count")
+	return asp.NewGetPointcutFromRegexp(s)
+}
+
+func (a *GetAspect) Advice(ctx asp.Context) []interface{} {
+	res := ctx.Call(ctx.Args())
+	fmt.Printf("GET %s: %v\n", ctx.JoinPoint().Name, res[0])
+	return res
+}
+
+// SetAspect is applied to the writes of Counter.Count.
+type SetAspect struct {
+}
+
+func (a *SetAspect) Pointcut() asp.Pointcut {
+	s := regexp.QuoteMeta("golang.org/x/exp/aspectgo/example/field.Counter.Count")
+	return asp.NewSetPointcutFromRegexp(s)
+}
+
+func (a *SetAspect) Advice(ctx asp.Context) []interface{} {
+	fctx := ctx.(asp.FieldContext)
+	fmt.Printf("SET %s: %v -> %v\n", ctx.JoinPoint().Name, fctx.OldValue(), fctx.NewValue())
+	return ctx.Call(ctx.Args())
+}
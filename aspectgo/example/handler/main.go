@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var errNegative = errors.New("negative")
+
+func parse(s string) (int, error) {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if i < 0 {
+		return 0, errNegative
+	}
+	return i, nil
+}
+
+func index(xs []int, i int) (int, error) {
+	// panics if i is out of range
+	return xs[i], nil
+}
+
+func main() {
+	for _, s := range []string{"42", "-1", "foo"} {
+		i, err := parse(s)
+		fmt.Printf("parse(%q)=%d, err=%v\n", s, i, err)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Printf("recovered in main: %v\n", r)
+		}
+	}()
+	for _, i := range []int{1, 3} {
+		x, err := index([]int{1, 2, 3}, i)
+		fmt.Printf("index(%d)=%d, err=%v\n", i, x, err)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	asp "golang.org/x/exp/aspectgo/aspect"
+)
+
+// HandlerAspect annotates the errors returned from parse() and index()
+// with the joinpoint, and translates a panic in them into an error.
+// The advice is executed only on the error or panic path.
+type HandlerAspect struct {
+}
+
+func (a *HandlerAspect) Pointcut() asp.Pointcut {
+	pkg := regexp.QuoteMeta("golang.org/x/exp/aspectgo/example/handler")
+	return asp.NewHandlerPointcutFromRegexp(pkg + `\.(parse|index)`)
+}
+
+func (a *HandlerAspect) Advice(ctx asp.Context) []interface{} {
+	hctx := ctx.(asp.HandlerContext)
+	jp := ctx.JoinPoint()
+	if hctx.Panicked() {
+		return hctx.Return(fmt.Errorf("%s: recovered: %v", jp.Name, hctx.Recovered()))
+	}
+	return hctx.Return(fmt.Errorf("%s: %v", jp.Name, hctx.Err()))
+}
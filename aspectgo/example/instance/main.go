@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+)
+
+func sayHello(s string) {
+	fmt.Println("hello " + s)
+}
+
+func sayBye(s string) {
+	fmt.Println("bye " + s)
+}
+
+func main() {
+	for i := 0; i < 2; i++ {
+		sayHello("world")
+		sayHello("gopher")
+	}
+	sayBye("world")
+	sayBye("gopher")
+}
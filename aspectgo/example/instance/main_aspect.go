@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	asp "golang.org/x/exp/aspectgo/aspect"
+)
+
+// SiteAspect is instantiated once for each call site of sayHello.
+type SiteAspect struct {
+	calls int
+}
+
+func (a *SiteAspect) Pointcut() asp.Pointcut {
+	s := regexp.QuoteMeta("golang.org/x/exp/aspectgo/example/instance.sayHello")
+	return asp.NewCallPointcutFromRegexp(s)
+}
+
+func (a *SiteAspect) Instantiation() asp.Instantiation {
+	return asp.PerJoinPoint
+}
+
+func (a *SiteAspect) Advice(ctx asp.Context) []interface{} {
+	a.calls++
+	fmt.Printf("call #%d from this site\n", a.calls)
+	return ctx.Call(ctx.Args())
+}
+
+// TotalAspect is instantiated once for the whole program.
+type TotalAspect struct {
+	prefix string
+	calls  int
+}
+
+func (a *TotalAspect) Pointcut() asp.Pointcut {
+	s := regexp.QuoteMeta("golang.org/x/exp/aspectgo/example/instance.sayBye")
+	return asp.NewCallPointcutFromRegexp(s)
+}
+
+func (a *TotalAspect) Instantiation() asp.Instantiation {
+	return asp.Singleton
+}
+
+// Init is called only once, as TotalAspect is a singleton.
+func (a *TotalAspect) Init() {
+	a.prefix = "total"
+}
+
+func (a *TotalAspect) Advice(ctx asp.Context) []interface{} {
+	a.calls++
+	fmt.Printf("%s: call #%d\n", a.prefix, a.calls)
+	return ctx.Call(ctx.Args())
+}
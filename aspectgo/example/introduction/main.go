@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type User struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+type Item struct {
+	ID int `json:"id"`
+}
+
+// Config is not a target of the introductions.
+type Config struct {
+	Debug bool `json:"debug"`
+}
+
+func save(v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("saved %s\n", b)
+}
+
+func main() {
+	u := User{Name: "alice", Age: 42}
+	fmt.Println(u)
+	fmt.Println(&Item{ID: 1})
+	fmt.Println(Config{Debug: true})
+	save(u)
+	save(Config{Debug: true})
+}
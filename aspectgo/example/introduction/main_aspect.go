@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	asp "golang.org/x/exp/aspectgo/aspect"
+)
+
+var targets = asp.NewTypePatternFromRegexp(
+	regexp.QuoteMeta("golang.org/x/exp/aspectgo/example/introduction.") + "(User|Item)")
+
+// StringIntro introduces String() to User and Item.
+type StringIntro struct {
+}
+
+func (i *StringIntro) Targets() asp.TypePattern {
+	return targets
+}
+
+// String is introduced as `func (t T) String() string`.
+func (i *StringIntro) String(target interface{}) string {
+	v := reflect.ValueOf(target)
+	var fields []string
+	for j := 0; j < v.NumField(); j++ {
+		if f := v.Type().Field(j); f.PkgPath == "" && !f.Anonymous {
+			fields = append(fields, fmt.Sprintf("%s: %v", f.Name, v.Field(j)))
+		}
+	}
+	return fmt.Sprintf("%s{%s}", v.Type().Name(), strings.Join(fields, ", "))
+}
+
+// RevisionIntro introduces the Revision field to User and Item.
+type RevisionIntro struct {
+	Revision int `json:"revision"`
+}
+
+func (i *RevisionIntro) Targets() asp.TypePattern {
+	return targets
+}
+
+// SaveAspect logs save().
+type SaveAspect struct {
+}
+
+func (a *SaveAspect) Pointcut() asp.Pointcut {
+	return asp.NewCallPointcutFromRegexp(regexp.QuoteMeta("golang.org/x/exp/aspectgo/example/introduction.save"))
+}
+
+func (a *SaveAspect) Advice(ctx asp.Context) []interface{} {
+	fmt.Printf("saving %v\n", ctx.Args()[0])
+	return ctx.Call(ctx.Args())
+}
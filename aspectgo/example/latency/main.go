@@ -0,0 +1,20 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"golang.org/x/exp/aspectgo/aspects"
+)
+
+func sleep(ms int) {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+}
+
+func main() {
+	for i := 0; i < 5; i++ {
+		sleep(i * 3)
+	}
+	// prints nothing unless woven
+	aspects.DefaultHistograms.WritePrometheus(os.Stdout)
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"regexp"
+
+	asp "golang.org/x/exp/aspectgo/aspect"
+	"golang.org/x/exp/aspectgo/aspects"
+)
+
+// LatencyAspect records the latency of sleep() to aspects.DefaultHistograms.
+type LatencyAspect struct {
+	aspects.Latency
+}
+
+func (a *LatencyAspect) Pointcut() asp.Pointcut {
+	s := regexp.QuoteMeta("golang.org/x/exp/aspectgo/example/latency.sleep")
+	return asp.NewCallPointcutFromRegexp(s)
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+)
+
+var calls = 0
+
+func fib(n int) int {
+	calls++
+	if n < 2 {
+		return n
+	}
+	return fib(n-1) + fib(n-2)
+}
+
+func main() {
+	fmt.Printf("fib(25)=%d, calls=%d\n", fib(25), calls)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"regexp"
+
+	asp "golang.org/x/exp/aspectgo/aspect"
+	"golang.org/x/exp/aspectgo/aspects"
+)
+
+// MemoizeAspect memoizes fib().
+type MemoizeAspect struct {
+	aspects.Memoizer
+}
+
+func (a *MemoizeAspect) Pointcut() asp.Pointcut {
+	s := regexp.QuoteMeta("golang.org/x/exp/aspectgo/example/memoize.fib")
+	return asp.NewCallPointcutFromRegexp(s)
+}
+
+// Instantiation returns asp.Singleton so that the cache is shared among
+// all the call sites of fib().
+func (a *MemoizeAspect) Instantiation() asp.Instantiation {
+	return asp.Singleton
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/exp/aspectgo/aspect/mock"
+)
+
+func main() {
+	// usually done in a test.
+	// has no effect unless woven.
+	mock.On("os.Hostname").Return("mocked-host", nil).Once()
+
+	for i := 0; i < 2; i++ {
+		name, err := os.Hostname()
+		fmt.Printf("mocked=%t, err=%v\n", name == "mocked-host", err)
+	}
+}
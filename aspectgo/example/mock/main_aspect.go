@@ -0,0 +1,18 @@
+package main
+
+import (
+	"regexp"
+
+	asp "golang.org/x/exp/aspectgo/aspect"
+	"golang.org/x/exp/aspectgo/aspect/mock"
+)
+
+// MockAspect routes the calls to os.Hostname() to the stubs registered by mock.On().
+type MockAspect struct {
+	mock.Aspect
+}
+
+func (a *MockAspect) Pointcut() asp.Pointcut {
+	s := regexp.QuoteMeta("os.Hostname")
+	return asp.NewCallPointcutFromRegexp(s)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/exp/aspectgo/aspects/profile"
+)
+
+func parse(s string) []string {
+	return strings.Fields(s)
+}
+
+func greet(name string) string {
+	return "hello " + name
+}
+
+func shout(name string) string {
+	return strings.ToUpper(greet(name))
+}
+
+func main() {
+	for _, name := range parse("foo bar") {
+		fmt.Println(greet(name))
+	}
+	if len(os.Args) > 1 {
+		// not covered unless an argument is given
+		fmt.Println(shout(os.Args[1]))
+	}
+	// the counts are written to $ASPECTGO_PROFILE
+	if err := profile.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"regexp"
+
+	asp "golang.org/x/exp/aspectgo/aspect"
+	"golang.org/x/exp/aspectgo/aspects/profile"
+)
+
+// ProfileAspect counts the calls of parse(), greet() and shout().
+// Try:
+//	ASPECTGO_PROFILE=/tmp/profile.json go run main.go
+//	aspectgo callgraph -t golang.org/x/exp/aspectgo/example/profile -profile /tmp/profile.json main_aspect.go
+type ProfileAspect struct {
+	profile.Aspect
+}
+
+func (a *ProfileAspect) Pointcut() asp.Pointcut {
+	s := regexp.QuoteMeta("golang.org/x/exp/aspectgo/example/profile.") + "(parse|greet|shout)"
+	return asp.NewCallPointcutFromRegexp(s)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+)
+
+func divide(x, y int) (int, error) {
+	// panics if y == 0
+	return x / y, nil
+}
+
+func main() {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Printf("recovered in main: %v\n", r)
+		}
+	}()
+	for _, y := range []int{2, 0} {
+		q, err := divide(42, y)
+		fmt.Printf("divide(42, %d)=%d, err=%v\n", y, q, err)
+	}
+}
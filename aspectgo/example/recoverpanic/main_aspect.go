@@ -0,0 +1,18 @@
+package main
+
+import (
+	"regexp"
+
+	asp "golang.org/x/exp/aspectgo/aspect"
+	"golang.org/x/exp/aspectgo/aspects"
+)
+
+// RecoverAspect converts a panic in divide() into an error.
+type RecoverAspect struct {
+	aspects.PanicToError
+}
+
+func (a *RecoverAspect) Pointcut() asp.Pointcut {
+	s := regexp.QuoteMeta("golang.org/x/exp/aspectgo/example/recoverpanic.divide")
+	return asp.NewCallPointcutFromRegexp(s)
+}
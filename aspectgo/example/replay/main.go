@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+func work(id int) {
+	fmt.Printf("hello from %d\n", id)
+}
+
+func main() {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			time.Sleep(time.Duration(rand.Intn(8)) * time.Millisecond)
+			work(id)
+		}(i)
+	}
+	wg.Wait()
+}
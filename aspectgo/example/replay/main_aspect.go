@@ -0,0 +1,21 @@
+package main
+
+import (
+	"regexp"
+
+	asp "golang.org/x/exp/aspectgo/aspect"
+	"golang.org/x/exp/aspectgo/aspects/replay"
+)
+
+// ReplayAspect records or replays the interleaving of work().
+// Try:
+//	ASPECTGO_REPLAY=record:/tmp/trace.gob go run main.go
+//	ASPECTGO_REPLAY=replay:/tmp/trace.gob go run main.go
+type ReplayAspect struct {
+	replay.Aspect
+}
+
+func (a *ReplayAspect) Pointcut() asp.Pointcut {
+	s := regexp.QuoteMeta("golang.org/x/exp/aspectgo/example/replay.work")
+	return asp.NewCallPointcutFromRegexp(s)
+}
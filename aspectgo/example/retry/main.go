@@ -0,0 +1,21 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var attempts = 0
+
+func flakyFetch(key string) (string, error) {
+	attempts++
+	if attempts < 3 {
+		return "", errors.New("transient error")
+	}
+	return "value for " + key, nil
+}
+
+func main() {
+	v, err := flakyFetch("foo")
+	fmt.Printf("v=%q, err=%v, attempts=%d\n", v, err, attempts)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"regexp"
+	"time"
+
+	asp "golang.org/x/exp/aspectgo/aspect"
+	"golang.org/x/exp/aspectgo/aspects"
+)
+
+// RetryAspect retries flakyFetch().
+type RetryAspect struct {
+	aspects.Retry
+}
+
+func (a *RetryAspect) Pointcut() asp.Pointcut {
+	s := regexp.QuoteMeta("golang.org/x/exp/aspectgo/example/retry.flakyFetch")
+	return asp.NewCallPointcutFromRegexp(s)
+}
+
+func (a *RetryAspect) Init() {
+	a.MaxAttempts = 5
+	a.InitialBackoff = time.Millisecond
+}
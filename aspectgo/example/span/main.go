@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+func query(key string) string {
+	return strings.ToUpper(key)
+}
+
+func fetch(ctx context.Context, key string) string {
+	return "value of " + query(key)
+}
+
+func handle(ctx context.Context, keys []string) []string {
+	values := make([]string, len(keys))
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		go func(i int, key string) {
+			defer wg.Done()
+			// the span of handle is propagated to fetch through ctx
+			values[i] = fetch(ctx, key)
+		}(i, key)
+	}
+	wg.Wait()
+	return values
+}
+
+func main() {
+	fmt.Println(strings.Join(handle(context.Background(), []string{"foo", "bar"}), ", "))
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"regexp"
+
+	asp "golang.org/x/exp/aspectgo/aspect"
+	"golang.org/x/exp/aspectgo/aspects/span"
+)
+
+// SpanAspect creates the spans for handle(), fetch() and query().
+// Try:
+//	ASPECTGO_SPAN=chrome:/tmp/trace.json go run main.go
+//	ASPECTGO_SPAN=otlp:/tmp/spans.jsonl go run main.go
+type SpanAspect struct {
+	span.Aspect
+}
+
+func (a *SpanAspect) Pointcut() asp.Pointcut {
+	s := regexp.QuoteMeta("golang.org/x/exp/aspectgo/example/span.") + "(handle|fetch|query)"
+	return asp.NewCallPointcutFromRegexp(s)
+}
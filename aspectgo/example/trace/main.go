@@ -0,0 +1,14 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+func greet(name string, times int) string {
+	return strings.Repeat("hello "+name+" ", times)
+}
+
+func main() {
+	fmt.Println(greet("world", 2))
+}
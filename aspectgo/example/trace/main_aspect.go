@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"regexp"
+
+	asp "golang.org/x/exp/aspectgo/aspect"
+	"golang.org/x/exp/aspectgo/aspects"
+)
+
+// TraceAspect logs the calls to greet() to stdout.
+type TraceAspect struct {
+	aspects.Tracer
+}
+
+func (a *TraceAspect) Pointcut() asp.Pointcut {
+	s := regexp.QuoteMeta("golang.org/x/exp/aspectgo/example/trace.greet")
+	return asp.NewCallPointcutFromRegexp(s)
+}
+
+func (a *TraceAspect) Instantiation() asp.Instantiation {
+	return asp.Singleton
+}
+
+func (a *TraceAspect) Init() {
+	opts := &slog.HandlerOptions{
+		// omit the time for reproducible output
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey || a.Key == "duration" {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}
+	a.Logger = slog.New(slog.NewTextHandler(os.Stdout, opts))
+}
@@ -0,0 +1,27 @@
+// Package goid provides the goroutine ID.
+package goid
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+)
+
+var prefix = []byte("goroutine ")
+
+// ID returns the ID of the current goroutine.
+// ID is slow, and should be used only for debugging purposes.
+func ID() int64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	// b is like "goroutine 42 [running]:\n..."
+	b = bytes.TrimPrefix(b, prefix)
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+	id, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
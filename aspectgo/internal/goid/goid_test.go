@@ -0,0 +1,19 @@
+package goid
+
+import (
+	"testing"
+)
+
+func TestID(t *testing.T) {
+	id := ID()
+	if id <= 0 {
+		t.Fatalf("unexpected id %d", id)
+	}
+	ch := make(chan int64)
+	go func() {
+		ch <- ID()
+	}()
+	if id2 := <-ch; id2 == id || id2 <= 0 {
+		t.Fatalf("unexpected id %d (parent: %d)", id2, id)
+	}
+}
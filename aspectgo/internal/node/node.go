@@ -0,0 +1,497 @@
+// Package node parses the JSON files and the subset of the YAML files
+// into the nodes with the line numbers, so that the files can be validated
+// against a schema with the errors reported with the line numbers.
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Kind is the kind of a node.
+type Kind int
+
+const (
+	// ScalarNode is a string, a bool, a number or null.
+	ScalarNode Kind = iota
+	// MappingNode is a mapping (an object in JSON).
+	MappingNode
+	// SequenceNode is a sequence (an array in JSON).
+	SequenceNode
+)
+
+// ScalarType is the type of a scalar node.
+type ScalarType string
+
+// The types of the scalar nodes.
+const (
+	StringScalar ScalarType = "string"
+	BoolScalar   ScalarType = "bool"
+	NumberScalar ScalarType = "number"
+	NullScalar   ScalarType = "null"
+)
+
+// Node is a value in the file, along with the line number.
+// Both the JSON and the YAML files are parsed into nodes.
+type Node struct {
+	Kind Kind
+	Line int
+
+	// Type and Value are set for ScalarNode.
+	// Value is the string representation of the scalar, e.g. "true" for a bool.
+	Type  ScalarType
+	Value string
+
+	// Fields are set for MappingNode, in the order of the file.
+	Fields []*Field
+
+	// Items are set for SequenceNode.
+	Items []*Node
+}
+
+// Field is a field of a mapping.
+type Field struct {
+	Key   string
+	Line  int
+	Value *Node
+}
+
+// Describe returns the description of the kind of n, e.g. "a mapping", for the errors.
+func (n *Node) Describe() string {
+	switch n.Kind {
+	case MappingNode:
+		return "a mapping"
+	case SequenceNode:
+		return "a sequence"
+	}
+	return fmt.Sprintf("a %s", n.Type)
+}
+
+// Interface returns n as map[string]interface{}, []interface{}, string, bool,
+// int64, float64 or nil, which can be encoded with encoding/json.
+// A number is int64 if it is an integer in the range of int64.
+func (n *Node) Interface() interface{} {
+	switch n.Kind {
+	case MappingNode:
+		m := make(map[string]interface{}, len(n.Fields))
+		for _, f := range n.Fields {
+			m[f.Key] = f.Value.Interface()
+		}
+		return m
+	case SequenceNode:
+		s := make([]interface{}, len(n.Items))
+		for i, item := range n.Items {
+			s[i] = item.Interface()
+		}
+		return s
+	}
+	switch n.Type {
+	case BoolScalar:
+		return n.Value == "true"
+	case NumberScalar:
+		if i, err := strconv.ParseInt(n.Value, 10, 64); err == nil {
+			return i
+		}
+		f, _ := strconv.ParseFloat(n.Value, 64)
+		return f
+	case NullScalar:
+		return nil
+	}
+	return n.Value
+}
+
+// Error is a syntax error in the file.
+type Error struct {
+	Line int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// lineAt returns the line number of the offset in b.
+func lineAt(b []byte, offset int64) int {
+	if offset > int64(len(b)) {
+		offset = int64(len(b))
+	}
+	return bytes.Count(b[:offset], []byte("\n")) + 1
+}
+
+// ParseJSON parses the JSON file.
+func ParseJSON(b []byte) (*Node, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	n, err := parseJSONValue(dec, b)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, &Error{Line: lineAt(b, dec.InputOffset()), Msg: "unexpected data after the top-level value"}
+	}
+	return n, nil
+}
+
+func parseJSONValue(dec *json.Decoder, b []byte) (*Node, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, jsonError(err, dec, b)
+	}
+	n := &Node{Line: lineAt(b, dec.InputOffset())}
+	switch t := tok.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			n.Kind = MappingNode
+			for dec.More() {
+				tok, err := dec.Token()
+				if err != nil {
+					return nil, jsonError(err, dec, b)
+				}
+				f := &Field{Key: tok.(string), Line: lineAt(b, dec.InputOffset())}
+				if f.Value, err = parseJSONValue(dec, b); err != nil {
+					return nil, err
+				}
+				n.Fields = append(n.Fields, f)
+			}
+		case '[':
+			n.Kind = SequenceNode
+			for dec.More() {
+				item, err := parseJSONValue(dec, b)
+				if err != nil {
+					return nil, err
+				}
+				n.Items = append(n.Items, item)
+			}
+		}
+		// the closing delimiter
+		if _, err := dec.Token(); err != nil {
+			return nil, jsonError(err, dec, b)
+		}
+	case string:
+		n.Type, n.Value = StringScalar, t
+	case bool:
+		n.Type, n.Value = BoolScalar, strconv.FormatBool(t)
+	case json.Number:
+		n.Type, n.Value = NumberScalar, t.String()
+	case nil:
+		n.Type, n.Value = NullScalar, ""
+	}
+	return n, nil
+}
+
+func jsonError(err error, dec *json.Decoder, b []byte) error {
+	if err == io.EOF {
+		return &Error{Line: lineAt(b, int64(len(b))), Msg: "unexpected end of file"}
+	}
+	offset := dec.InputOffset()
+	if serr, ok := err.(*json.SyntaxError); ok {
+		// the error occurred after reading Offset bytes
+		offset = serr.Offset - 1
+	}
+	return &Error{Line: lineAt(b, offset), Msg: err.Error()}
+}
+
+// yamlLine is a non-empty line of the YAML file, without the comment.
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+// yamlParser parses the block-style subset of YAML:
+// mappings, sequences, plain and quoted scalars, flow sequences of scalars
+// (e.g. `[foo, bar]`), and comments.
+// Anchors, tags, multi-line scalars and multiple documents are not supported.
+type yamlParser struct {
+	lines []*yamlLine
+	pos   int
+}
+
+// ParseYAML parses the YAML file.
+func ParseYAML(b []byte) (*Node, error) {
+	p := &yamlParser{}
+	for i, s := range strings.Split(string(b), "\n") {
+		s = strings.TrimRight(stripComment(s), " \t\r")
+		text := strings.TrimLeft(s, " ")
+		if text == "" || text == "---" {
+			continue
+		}
+		if strings.HasPrefix(text, "\t") {
+			return nil, &Error{Line: i + 1, Msg: "tabs cannot be used for indentation"}
+		}
+		p.lines = append(p.lines, &yamlLine{num: i + 1, indent: len(s) - len(text), text: text})
+	}
+	if len(p.lines) == 0 {
+		return &Node{Kind: MappingNode, Line: 1}, nil
+	}
+	n, err := p.parseBlock(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		return nil, &Error{Line: l.num, Msg: "unexpected indentation"}
+	}
+	return n, nil
+}
+
+// stripComment removes the comment from s.
+// "#" starts a comment at the beginning of s or after a space, outside the quotes.
+func stripComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// parseBlock parses the mapping or the sequence at indent.
+func (p *yamlParser) parseBlock(indent int) (*Node, error) {
+	l := p.lines[p.pos]
+	if isSequenceItem(l.text) {
+		return p.parseSequence(indent)
+	}
+	return p.parseMapping(indent)
+}
+
+func isSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func (p *yamlParser) parseSequence(indent int) (*Node, error) {
+	n := &Node{Kind: SequenceNode, Line: p.lines[p.pos].num}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, &Error{Line: l.num, Msg: "unexpected indentation (expected a sequence item)"}
+		}
+		if !isSequenceItem(l.text) {
+			// the next key of the mapping that contains the sequence
+			break
+		}
+		rest := strings.TrimLeft(strings.TrimPrefix(l.text, "-"), " ")
+		if rest == "" {
+			// the item is the block on the following lines
+			p.pos++
+			item, err := p.parseNested(indent, l.num)
+			if err != nil {
+				return nil, err
+			}
+			n.Items = append(n.Items, item)
+			continue
+		}
+		if _, _, ok := splitKey(rest); ok {
+			// `- key: value` starts a mapping, whose keys are indented to `key`
+			l.indent += len(l.text) - len(rest)
+			l.text = rest
+			item, err := p.parseMapping(l.indent)
+			if err != nil {
+				return nil, err
+			}
+			n.Items = append(n.Items, item)
+			continue
+		}
+		item, err := parseFlow(rest, l.num)
+		if err != nil {
+			return nil, err
+		}
+		n.Items = append(n.Items, item)
+		p.pos++
+	}
+	return n, nil
+}
+
+func (p *yamlParser) parseMapping(indent int) (*Node, error) {
+	n := &Node{Kind: MappingNode, Line: p.lines[p.pos].num}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, &Error{Line: l.num, Msg: "unexpected indentation"}
+		}
+		key, rest, ok := splitKey(l.text)
+		if !ok {
+			return nil, &Error{Line: l.num, Msg: fmt.Sprintf("expected \"key: value\", got %q", l.text)}
+		}
+		f := &Field{Key: key, Line: l.num}
+		p.pos++
+		if rest == "" {
+			// the value is the block on the following lines, which can be
+			// a sequence at the same indentation as the key
+			var err error
+			if f.Value, err = p.parseNested(indent, l.num); err != nil {
+				return nil, err
+			}
+		} else {
+			var err error
+			if f.Value, err = parseFlow(rest, l.num); err != nil {
+				return nil, err
+			}
+		}
+		n.Fields = append(n.Fields, f)
+	}
+	return n, nil
+}
+
+// parseNested parses the block following the line numbered num at indent.
+// An empty block is null.
+func (p *yamlParser) parseNested(indent, num int) (*Node, error) {
+	if p.pos < len(p.lines) {
+		next := p.lines[p.pos]
+		if next.indent > indent || (next.indent == indent && isSequenceItem(next.text)) {
+			return p.parseBlock(next.indent)
+		}
+	}
+	return &Node{Kind: ScalarNode, Line: num, Type: NullScalar}, nil
+}
+
+// splitKey splits `key: value` into the key and the value.
+// The key can be quoted.
+func splitKey(text string) (string, string, bool) {
+	if text[0] == '"' || text[0] == '\'' {
+		end := closingQuote(text)
+		if end < 0 || !strings.HasPrefix(text[end+1:], ":") {
+			return "", "", false
+		}
+		key, err := unquote(text[:end+1])
+		if err != nil {
+			return "", "", false
+		}
+		rest := text[end+2:]
+		if rest != "" && rest[0] != ' ' {
+			return "", "", false
+		}
+		return key, strings.TrimSpace(rest), true
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == ':' && (i == len(text)-1 || text[i+1] == ' ') {
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), i > 0
+		}
+	}
+	return "", "", false
+}
+
+// closingQuote returns the index of the quote that closes the quoted string
+// at the beginning of s, or -1.
+func closingQuote(s string) int {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quote == '"':
+			i++
+		case s[i] == quote:
+			// '' is an escaped quote in a single-quoted string
+			if quote == '\'' && i+1 < len(s) && s[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+func unquote(s string) (string, error) {
+	if s[0] == '\'' {
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	}
+	return strconv.Unquote(s)
+}
+
+// parseFlow parses the value on the line numbered num: a scalar, or a flow sequence
+// of scalars, or an empty flow mapping.
+func parseFlow(s string, num int) (*Node, error) {
+	switch {
+	case s == "{}":
+		return &Node{Kind: MappingNode, Line: num}, nil
+	case strings.HasPrefix(s, "["):
+		if !strings.HasSuffix(s, "]") {
+			return nil, &Error{Line: num, Msg: fmt.Sprintf("unterminated flow sequence %q", s)}
+		}
+		n := &Node{Kind: SequenceNode, Line: num}
+		body := strings.TrimSpace(s[1 : len(s)-1])
+		for body != "" {
+			var elem string
+			if body[0] == '"' || body[0] == '\'' {
+				end := closingQuote(body)
+				if end < 0 {
+					return nil, &Error{Line: num, Msg: fmt.Sprintf("unterminated quoted string %q", body)}
+				}
+				elem, body = body[:end+1], strings.TrimSpace(body[end+1:])
+				if body != "" && body[0] != ',' {
+					return nil, &Error{Line: num, Msg: fmt.Sprintf("expected \",\", got %q", body)}
+				}
+			} else if i := strings.Index(body, ","); i >= 0 {
+				elem, body = strings.TrimSpace(body[:i]), body[i:]
+			} else {
+				elem, body = body, ""
+			}
+			body = strings.TrimSpace(strings.TrimPrefix(body, ","))
+			if elem == "" {
+				return nil, &Error{Line: num, Msg: fmt.Sprintf("empty element in flow sequence %q", s)}
+			}
+			item, err := parseScalar(elem, num)
+			if err != nil {
+				return nil, err
+			}
+			n.Items = append(n.Items, item)
+		}
+		return n, nil
+	case strings.HasPrefix(s, "{"):
+		return nil, &Error{Line: num, Msg: "flow mappings are not supported"}
+	}
+	return parseScalar(s, num)
+}
+
+func parseScalar(s string, num int) (*Node, error) {
+	if s == "" {
+		return &Node{Kind: ScalarNode, Line: num, Type: NullScalar}, nil
+	}
+	n := &Node{Kind: ScalarNode, Line: num, Type: StringScalar, Value: s}
+	if s[0] == '"' || s[0] == '\'' {
+		if closingQuote(s) != len(s)-1 {
+			return nil, &Error{Line: num, Msg: fmt.Sprintf("invalid quoted string %q", s)}
+		}
+		v, err := unquote(s)
+		if err != nil {
+			return nil, &Error{Line: num, Msg: fmt.Sprintf("invalid quoted string %q", s)}
+		}
+		n.Value = v
+		return n, nil
+	}
+	switch s {
+	case "true", "True", "TRUE":
+		n.Type, n.Value = BoolScalar, "true"
+	case "false", "False", "FALSE":
+		n.Type, n.Value = BoolScalar, "false"
+	case "null", "Null", "NULL", "~":
+		n.Type, n.Value = NullScalar, ""
+	default:
+		if _, err := strconv.ParseFloat(s, 64); err == nil {
+			n.Type = NumberScalar
+		}
+	}
+	return n, nil
+}
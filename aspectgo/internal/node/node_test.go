@@ -0,0 +1,65 @@
+package node
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	n, err := ParseYAML([]byte(`
+a:
+- "x # y"
+- 'it''s'
+-
+  b: ~
+  c: {}
+d: 1.5 # comment
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(n.Fields) != 2 || n.Fields[1].Key != "d" || n.Fields[1].Line != 8 || n.Fields[1].Value.Type != NumberScalar {
+		t.Fatalf("unexpected mapping: %+v", n)
+	}
+	items := n.Fields[0].Value.Items
+	if len(items) != 3 || items[0].Value != "x # y" || items[1].Value != "it's" {
+		t.Fatalf("unexpected sequence: %+v", n.Fields[0].Value)
+	}
+	m := items[2]
+	if m.Kind != MappingNode || m.Line != 6 || m.Fields[0].Value.Type != NullScalar || m.Fields[1].Value.Kind != MappingNode {
+		t.Fatalf("unexpected mapping: %+v", m)
+	}
+	if _, err := ParseYAML([]byte("a:\n\t- b\n")); err == nil || !strings.Contains(err.Error(), "line 2: tabs") {
+		t.Fatalf("expected an error for tabs, got %v", err)
+	}
+	for _, flow := range []string{"[a,,b]", "[,]", "[ , a]"} {
+		if _, err := ParseYAML([]byte("a: b\ntags: " + flow + "\n")); err == nil || !strings.Contains(err.Error(), "line 2: empty element") {
+			t.Fatalf("expected an error for %s, got %v", flow, err)
+		}
+	}
+	if n, err := ParseYAML([]byte("tags: [a, b,]\n")); err != nil || len(n.Fields[0].Value.Items) != 2 {
+		t.Fatalf("unexpected result for the trailing comma: %v", err)
+	}
+	if n, err := parseScalar("", 1); err != nil || n.Type != NullScalar {
+		t.Fatalf("unexpected result for the empty scalar: %+v, %v", n, err)
+	}
+}
+
+func TestInterface(t *testing.T) {
+	n, err := ParseYAML([]byte(`
+a: [x, 1, 1.5, true, ~]
+b:
+  c: 9007199254740993
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"a": []interface{}{"x", int64(1), 1.5, true, nil},
+		"b": map[string]interface{}{"c": int64(9007199254740993)},
+	}
+	if v := n.Interface(); !reflect.DeepEqual(v, expected) {
+		t.Fatalf("expected %v, got %v", expected, v)
+	}
+}